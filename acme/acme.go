@@ -24,9 +24,16 @@ import (
 
 var (
 	manager *autocert.Manager
+	shared  bool
 	l       = zlog.Module("acme")
 )
 
+// SetShared stores the certificates in the database, so that they're shared
+// between processes using the same database.
+//
+// This needs to be called before Setup().
+func SetShared(s bool) { shared = s }
+
 // cache is like autocert.DirCache, but ensures that certificates end with .pem.
 type cache struct{ dc autocert.DirCache }
 
@@ -54,6 +61,45 @@ func (d cache) Put(ctx context.Context, key string, data []byte) error {
 	return d.dc.Put(ctx, key, data)
 }
 
+// dbCache stores certificates in the store table, falling back to the
+// directory for certificates that were created before the database was used.
+type dbCache struct {
+	db  zdb.DB
+	dir autocert.Cache
+}
+
+func NewDBCache(db zdb.DB, dir string) dbCache {
+	return dbCache{db: db, dir: NewCache(dir)}
+}
+
+func (d dbCache) Get(ctx context.Context, key string) ([]byte, error) {
+	var data []byte
+	err := d.db.Get(ctx, &data, `select value from store where key=:k`, zdb.P{"k": "acme:" + key})
+	if err == nil {
+		return data, nil
+	}
+	if !zdb.ErrNoRows(err) {
+		return nil, err
+	}
+
+	data, err = d.dir.Get(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	return data, d.Put(ctx, key, data)
+}
+
+func (d dbCache) Delete(ctx context.Context, key string) error {
+	return d.db.Exec(ctx, `delete from store where key=:k`, zdb.P{"k": "acme:" + key})
+}
+
+func (d dbCache) Put(ctx context.Context, key string, data []byte) error {
+	l.Debugf("write to DB: %q", key)
+	return d.db.Exec(ctx, `insert into store (key, value) values (:k, :v)
+		on conflict (key) do update set value=:v`,
+		zdb.P{"k": "acme:" + key, "v": string(data)})
+}
+
 // Setup returns a tls.Config and http-01 verification based on the value of the
 // -tls cmdline flag.
 func Setup(db zdb.DB, flag string, dev bool) (*tls.Config, http.HandlerFunc, uint8, bool) {
@@ -117,9 +163,14 @@ func Setup(db zdb.DB, flag string, dev bool) (*tls.Config, http.HandlerFunc, uin
 				c.DirectoryURL = "https://acme-staging-v02.api.letsencrypt.org/directory"
 			}
 
+			var cache autocert.Cache = NewCache(dir)
+			if shared {
+				cache = NewDBCache(db, dir)
+			}
+
 			manager = &autocert.Manager{
 				Client: c,
-				Cache:  NewCache(dir),
+				Cache:  cache,
 				Prompt: autocert.AcceptTOS,
 				HostPolicy: func(ctx context.Context, host string) error {
 					// Note: don't use zgo.at/errors here, since it includes
@@ -5,8 +5,12 @@
 package acme_test
 
 import (
+	"os"
+	"path/filepath"
 	"testing"
 
+	"golang.org/x/crypto/acme/autocert"
+
 	. "zgo.at/goatcounter/v2/acme"
 	"zgo.at/goatcounter/v2/gctest"
 	"zgo.at/zdb"
@@ -67,3 +71,52 @@ func TestSetup(t *testing.T) {
 		})
 	}
 }
+
+func TestDBCache(t *testing.T) {
+	ctx := gctest.DB(t)
+	dir := t.TempDir()
+	c := NewDBCache(zdb.MustGetDB(ctx), dir)
+
+	_, err := c.Get(ctx, "example.com")
+	if err != autocert.ErrCacheMiss {
+		t.Fatalf("wrong error: %v", err)
+	}
+
+	err = c.Put(ctx, "example.com", []byte("cert"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = c.Put(ctx, "example.com", []byte("new cert"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	have, err := c.Get(ctx, "example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(have) != "new cert" {
+		t.Errorf("Get: %q", have)
+	}
+
+	err = c.Delete(ctx, "example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = c.Get(ctx, "example.com")
+	if err != autocert.ErrCacheMiss {
+		t.Fatalf("wrong error after Delete: %v", err)
+	}
+
+	// Read from directory if it's not in the DB.
+	err = os.WriteFile(filepath.Join(dir, "example.org.pem"), []byte("from dir"), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	have, err = c.Get(ctx, "example.org")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(have) != "from dir" {
+		t.Errorf("Get from dir: %q", have)
+	}
+}
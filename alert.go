@@ -0,0 +1,309 @@
+// Copyright © Martin Tournoij – This file is part of GoatCounter and published
+// under the terms of a slightly modified EUPL v1.2 license, which can be found
+// in the LICENSE file or at https://license.goatcounter.com
+
+package goatcounter
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"zgo.at/errors"
+	"zgo.at/zdb"
+	"zgo.at/zstd/zcrypto"
+	"zgo.at/zstd/ztime"
+)
+
+// Alert rule kinds.
+const (
+	AlertDrop = "drop" // Visitors dropped by more than Threshold percent.
+	AlertPath = "path" // Path gets more than Threshold visitors/hour.
+	AlertRef  = "ref"  // New referrer with more than Threshold visitors/hour.
+)
+
+var AlertKinds = []string{AlertDrop, AlertPath, AlertRef}
+
+// AlertRule is checked every hour against the statistics for the previous
+// hour; if it triggers a notification is sent by email and/or webhook.
+type AlertRule struct {
+	ID     int64 `db:"alert_rule_id" json:"id,readonly"`
+	SiteID int64 `db:"site_id" json:"site_id,readonly"`
+
+	// Name to display.
+	Name string `db:"name" json:"name"`
+
+	// Kind of rule:
+	//
+	//   drop   Visitors dropped by more than threshold percent compared to the
+	//          same hour last week.
+	//   path   Paths matching path got more than threshold visitors in an hour.
+	//   ref    A referrer not seen in the last 30 days got more than threshold
+	//          visitors in an hour.
+	Kind string `db:"kind" json:"kind"`
+
+	// Path to check for the "path" kind; * can be used as a wildcard.
+	// Matching is case-insensitive.
+	Path string `db:"path" json:"path"`
+
+	// Percentage for "drop", or number of visitors for "path" and "ref".
+	Threshold int `db:"threshold" json:"threshold"`
+
+	// Don't send another notification for this many minutes after it was
+	// triggered {default: 360}.
+	Cooldown int `db:"cooldown" json:"cooldown"`
+
+	// Send notifications to this email address.
+	Email string `db:"email" json:"email"`
+
+	// POST notifications to this URL, with the HMAC-SHA256 of the body in the
+	// X-Goatcounter-Signature header, signed with webhook_secret.
+	WebhookURL    string `db:"webhook_url" json:"webhook_url"`
+	WebhookSecret string `db:"webhook_secret" json:"webhook_secret,readonly"`
+
+	LastFiredAt *time.Time `db:"last_fired_at" json:"last_fired_at,readonly"`
+	CreatedAt   time.Time  `db:"created_at" json:"created_at,readonly"`
+}
+
+// Defaults sets fields to default values, unless they're already set.
+func (a *AlertRule) Defaults(ctx context.Context) {
+	if s := GetSite(ctx); s != nil && s.ID > 0 {
+		a.SiteID = s.ID
+	}
+	if a.CreatedAt.IsZero() {
+		a.CreatedAt = ztime.Now()
+	}
+	if a.Cooldown == 0 {
+		a.Cooldown = 360
+	}
+	a.Name = strings.TrimSpace(a.Name)
+	a.Path = strings.TrimSpace(a.Path)
+	a.Email = strings.TrimSpace(a.Email)
+	a.WebhookURL = strings.TrimSpace(a.WebhookURL)
+	if a.Kind != AlertPath {
+		a.Path = ""
+	}
+	if a.WebhookURL != "" && a.WebhookSecret == "" {
+		a.WebhookSecret = zcrypto.Secret256()
+	}
+}
+
+func (a *AlertRule) Validate(ctx context.Context) error {
+	v := NewValidate(ctx)
+	v.Required("site_id", a.SiteID)
+	v.Required("name", a.Name)
+	v.Len("name", a.Name, 0, 100)
+	v.UTF8("name", a.Name)
+	v.Include("kind", a.Kind, AlertKinds)
+	v.Range("cooldown", int64(a.Cooldown), 60, 60*24*30)
+
+	switch a.Kind {
+	case AlertDrop:
+		v.Range("threshold", int64(a.Threshold), 1, 100)
+	case AlertPath:
+		v.Required("path", a.Path)
+		v.Len("path", a.Path, 0, 2048)
+		v.UTF8("path", a.Path)
+		fallthrough
+	default:
+		v.Range("threshold", int64(a.Threshold), 1, 1_000_000_000)
+	}
+
+	if a.Email == "" && a.WebhookURL == "" {
+		v.Append("email", "must set email or webhook_url")
+	}
+	if a.Email != "" {
+		v.Email("email", a.Email)
+	}
+	if a.WebhookURL != "" {
+		v.URL("webhook_url", a.WebhookURL)
+		v.Len("webhook_url", a.WebhookURL, 0, 2048)
+		validatePublicURL(ctx, &v, "webhook_url", a.WebhookURL)
+	}
+	return v.ErrorOrNil()
+}
+
+// Insert a new row.
+func (a *AlertRule) Insert(ctx context.Context) error {
+	if a.ID > 0 {
+		return errors.New("ID > 0")
+	}
+
+	a.Defaults(ctx)
+	err := a.Validate(ctx)
+	if err != nil {
+		return err
+	}
+
+	a.ID, err = zdb.InsertID(ctx, "alert_rule_id",
+		`insert into alert_rules (site_id, name, kind, path, threshold, cooldown, email, webhook_url, webhook_secret, created_at) values (?)`,
+		zdb.L{a.SiteID, a.Name, a.Kind, a.Path, a.Threshold, a.Cooldown, a.Email, a.WebhookURL, a.WebhookSecret, a.CreatedAt})
+	return errors.Wrap(err, "AlertRule.Insert")
+}
+
+// Update the rule; this doesn't reset the cooldown.
+func (a *AlertRule) Update(ctx context.Context) error {
+	if a.ID == 0 {
+		return errors.New("ID == 0")
+	}
+
+	a.Defaults(ctx)
+	err := a.Validate(ctx)
+	if err != nil {
+		return err
+	}
+
+	err = zdb.Exec(ctx, `update alert_rules set
+			name=?, kind=?, path=?, threshold=?, cooldown=?, email=?, webhook_url=?, webhook_secret=?
+		where alert_rule_id=? and site_id=?`,
+		a.Name, a.Kind, a.Path, a.Threshold, a.Cooldown, a.Email, a.WebhookURL, a.WebhookSecret, a.ID, a.SiteID)
+	return errors.Wrap(err, "AlertRule.Update")
+}
+
+func (a *AlertRule) ByID(ctx context.Context, id int64) error {
+	return errors.Wrapf(zdb.Get(ctx, a, `/* AlertRule.ByID */
+		select * from alert_rules where alert_rule_id=$1 and site_id=$2`,
+		id, MustGetSite(ctx).ID), "AlertRule.ByID %d", id)
+}
+
+func (a *AlertRule) Delete(ctx context.Context) error {
+	err := zdb.Exec(ctx,
+		`/* AlertRule.Delete */ delete from alert_rules where alert_rule_id=$1 and site_id=$2`,
+		a.ID, MustGetSite(ctx).ID)
+	return errors.Wrapf(err, "AlertRule.Delete %d", a.ID)
+}
+
+// Fired records that the rule was triggered at the given time.
+func (a *AlertRule) Fired(ctx context.Context, t time.Time) error {
+	t = t.UTC().Truncate(time.Second)
+	err := zdb.Exec(ctx, `update alert_rules set last_fired_at=$1 where alert_rule_id=$2`, t, a.ID)
+	if err != nil {
+		return errors.Wrapf(err, "AlertRule.Fired %d", a.ID)
+	}
+	a.LastFiredAt = &t
+	return nil
+}
+
+// Cooling reports if this rule was triggered less than Cooldown minutes before
+// now.
+func (a AlertRule) Cooling(now time.Time) bool {
+	return a.LastFiredAt != nil &&
+		now.Before(a.LastFiredAt.Add(time.Duration(a.Cooldown)*time.Minute))
+}
+
+// Describe the rule in a human-readable way.
+func (a AlertRule) Describe() string {
+	switch a.Kind {
+	case AlertDrop:
+		return fmt.Sprintf("Visitors drop by more than %d%% compared to the same hour last week", a.Threshold)
+	case AlertPath:
+		return fmt.Sprintf("Paths matching %s get more than %d visitors in an hour", a.Path, a.Threshold)
+	case AlertRef:
+		return fmt.Sprintf("A new referrer gets more than %d visitors in an hour", a.Threshold)
+	}
+	return a.Kind
+}
+
+// Check the rule against the statistics for the last full hour before now.
+//
+// It returns a description of why the rule was triggered, or an empty string
+// if it wasn't.
+func (a AlertRule) Check(ctx context.Context, now time.Time) (string, error) {
+	var (
+		end   = now.UTC().Truncate(time.Hour)
+		start = end.Add(-time.Hour)
+		site  = MustGetSite(ctx).ID
+		when  = fmt.Sprintf("between %s and %s UTC", start.Format("15:04"), end.Format("15:04"))
+	)
+
+	// hit_counts and ref_counts only count the first pageview of every session,
+	// so these are all visitor counts and not pageviews.
+	switch a.Kind {
+	case AlertDrop:
+		var cur, prev int
+		err := zdb.Get(ctx, &cur, `/* AlertRule.Check */
+			select coalesce(sum(total), 0) from hit_counts where site_id=$1 and hour >= $2 and hour < $3`,
+			site, start, end)
+		if err != nil {
+			return "", errors.Wrap(err, "AlertRule.Check")
+		}
+		err = zdb.Get(ctx, &prev, `/* AlertRule.Check */
+			select coalesce(sum(total), 0) from hit_counts where site_id=$1 and hour >= $2 and hour < $3`,
+			site, start.AddDate(0, 0, -7), end.AddDate(0, 0, -7))
+		if err != nil {
+			return "", errors.Wrap(err, "AlertRule.Check")
+		}
+
+		if prev == 0 || cur*100 >= prev*(100-a.Threshold) {
+			return "", nil
+		}
+		return fmt.Sprintf("Visitors dropped by %d%% to %d %s, from %d in the same hour last week.",
+			(prev-cur)*100/prev, cur, when, prev), nil
+
+	case AlertPath:
+		var n int
+		err := zdb.Get(ctx, &n, `/* AlertRule.Check */
+			select coalesce(sum(total), 0) from hit_counts
+			where site_id=:site and hour >= :start and hour < :end and path_id in (
+				select path_id from paths where site_id=:site and lower(path) like :pattern escape '\'
+			)`,
+			zdb.P{"site": site, "start": start, "end": end, "pattern": globToLike(a.Path)})
+		if err != nil {
+			return "", errors.Wrap(err, "AlertRule.Check")
+		}
+
+		if n <= a.Threshold {
+			return "", nil
+		}
+		return fmt.Sprintf("Paths matching %s got %d visitors %s.", a.Path, n, when), nil
+
+	case AlertRef:
+		var refs []struct {
+			Ref   string `db:"ref"`
+			Total int    `db:"total"`
+		}
+		err := zdb.Select(ctx, &refs, `/* AlertRule.Check */
+			select refs.ref, sum(total) as total from ref_counts
+			join refs using (ref_id)
+			where
+				ref_counts.site_id=:site and hour >= :start and hour < :end and refs.ref != '' and
+				ref_counts.ref_id not in (
+					select ref_id from ref_counts where site_id=:site and hour >= :seen and hour < :start
+				)
+			group by refs.ref
+			having sum(total) > :threshold
+			order by total desc, refs.ref`,
+			zdb.P{"site": site, "start": start, "end": end,
+				"seen": start.AddDate(0, 0, -30), "threshold": a.Threshold})
+		if err != nil {
+			return "", errors.Wrap(err, "AlertRule.Check")
+		}
+
+		if len(refs) == 0 {
+			return "", nil
+		}
+		l := make([]string, 0, len(refs))
+		for _, r := range refs {
+			l = append(l, fmt.Sprintf("%s (%d)", r.Ref, r.Total))
+		}
+		return fmt.Sprintf("New referrers %s: %s.", when, strings.Join(l, ", ")), nil
+	}
+
+	return "", errors.Errorf("AlertRule.Check: unknown kind %q", a.Kind)
+}
+
+type AlertRules []AlertRule
+
+// List all alert rules for this site.
+func (a *AlertRules) List(ctx context.Context) error {
+	return errors.Wrap(zdb.Select(ctx, a,
+		`select * from alert_rules where site_id=$1 order by alert_rule_id`,
+		MustGetSite(ctx).ID), "AlertRules.List")
+}
+
+// UnscopedList lists all alert rules for all sites.
+func (a *AlertRules) UnscopedList(ctx context.Context) error {
+	return errors.Wrap(zdb.Select(ctx, a,
+		`select * from alert_rules order by site_id, alert_rule_id`), "AlertRules.UnscopedList")
+}
@@ -0,0 +1,118 @@
+// Copyright © Martin Tournoij – This file is part of GoatCounter and published
+// under the terms of a slightly modified EUPL v1.2 license, which can be found
+// in the LICENSE file or at https://license.goatcounter.com
+
+package goatcounter_test
+
+import (
+	"testing"
+	"time"
+
+	. "zgo.at/goatcounter/v2"
+	"zgo.at/goatcounter/v2/gctest"
+	"zgo.at/zstd/zint"
+	"zgo.at/zstd/ztest"
+)
+
+func TestAlertRuleValidate(t *testing.T) {
+	ctx := gctest.DB(t)
+
+	tests := []struct {
+		in      AlertRule
+		wantErr string
+	}{
+		{AlertRule{Name: "x", Kind: "drop", Threshold: 50, Email: "a@example.com"}, ""},
+		{AlertRule{Name: "x", Kind: "ref", Threshold: 10, WebhookURL: "https://example.com/hook"}, ""},
+		{AlertRule{Name: "x", Kind: "path", Path: "/blog/*", Threshold: 10, Email: "a@example.com"}, ""},
+		{AlertRule{Name: "x", Kind: "nope", Threshold: 10, Email: "a@example.com"}, "kind: must be one of"},
+		{AlertRule{Name: "x", Kind: "drop", Threshold: 150, Email: "a@example.com"}, "threshold: must be 100 or lower"},
+		{AlertRule{Name: "x", Kind: "path", Threshold: 10, Email: "a@example.com"}, "path: must be set"},
+		{AlertRule{Name: "x", Kind: "ref", Threshold: 10}, "email: must set email or webhook_url"},
+		{AlertRule{Name: "x", Kind: "ref", Threshold: 10, Cooldown: 5, Email: "a@example.com"}, "cooldown: must be 60 or higher"},
+		{AlertRule{Name: "x", Kind: "ref", Threshold: 10, WebhookURL: "http://127.0.0.1:8080/hook"}, "webhook_url: must not be a loopback"},
+		{AlertRule{Name: "x", Kind: "ref", Threshold: 10, WebhookURL: "http://[fd00::1]/hook"}, "must not be a loopback"},
+		{AlertRule{Name: "x", Kind: "ref", Threshold: 10, WebhookURL: "http://localhost/hook"}, "must not be a loopback"},
+	}
+
+	for _, tt := range tests {
+		t.Run("", func(t *testing.T) {
+			err := tt.in.Insert(ctx)
+			if !ztest.ErrorContains(err, tt.wantErr) {
+				t.Fatalf("wrong error\nhave: %v\nwant: %s", err, tt.wantErr)
+			}
+			if err == nil && tt.in.WebhookURL != "" && len(tt.in.WebhookSecret) < 20 {
+				t.Errorf("webhook secret not set: %q", tt.in.WebhookSecret)
+			}
+		})
+	}
+}
+
+func TestAlertRuleCheck(t *testing.T) {
+	ctx := gctest.DB(t)
+
+	var (
+		now      = time.Date(2019, 8, 31, 15, 12, 0, 0, time.UTC)
+		hour     = time.Date(2019, 8, 31, 14, 20, 0, 0, time.UTC)
+		lastWeek = hour.AddDate(0, 0, -7)
+		hits     []Hit
+	)
+	for i := 0; i < 10; i++ {
+		hits = append(hits, Hit{CreatedAt: lastWeek, Session: zint.Uint128{1, uint64(i)}, Path: "/", FirstVisit: true})
+	}
+	for i := 0; i < 4; i++ {
+		hits = append(hits, Hit{CreatedAt: hour, Session: zint.Uint128{2, uint64(i)}, Path: "/blog/post", FirstVisit: true,
+			Ref: "https://news.example.com/item"})
+	}
+	hits = append(hits,
+		Hit{CreatedAt: lastWeek, Session: zint.Uint128{3, 1}, Path: "/", FirstVisit: true, Ref: "https://old.example.com"},
+		Hit{CreatedAt: hour, Session: zint.Uint128{3, 2}, Path: "/", FirstVisit: true, Ref: "https://old.example.com"},
+		Hit{CreatedAt: hour, Session: zint.Uint128{3, 3}, Path: "/", FirstVisit: true, Ref: "https://old.example.com"},
+	)
+	// More pageviews from the same visitors shouldn't change anything.
+	for i := 0; i < 4; i++ {
+		hits = append(hits, Hit{CreatedAt: hour, Session: zint.Uint128{2, uint64(i)}, Path: "/"})
+	}
+	gctest.StoreHits(ctx, t, false, hits...)
+
+	tests := []struct {
+		rule AlertRule
+		want string
+	}{
+		{AlertRule{Kind: AlertDrop, Threshold: 40},
+			"Visitors dropped by 45% to 6 between 14:00 and 15:00 UTC, from 11 in the same hour last week."},
+		{AlertRule{Kind: AlertDrop, Threshold: 50}, ""},
+		{AlertRule{Kind: AlertPath, Path: "/BLOG/*", Threshold: 3},
+			"Paths matching /BLOG/* got 4 visitors between 14:00 and 15:00 UTC."},
+		{AlertRule{Kind: AlertPath, Path: "/blog/*", Threshold: 4}, ""},
+		{AlertRule{Kind: AlertRef, Threshold: 1},
+			"New referrers between 14:00 and 15:00 UTC: news.example.com/item (4)."},
+		{AlertRule{Kind: AlertRef, Threshold: 4}, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run("", func(t *testing.T) {
+			have, err := tt.rule.Check(ctx, now)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if have != tt.want {
+				t.Errorf("\nhave: %s\nwant: %s", have, tt.want)
+			}
+		})
+	}
+
+	t.Run("cooling", func(t *testing.T) {
+		r := AlertRule{Cooldown: 60}
+		if r.Cooling(now) {
+			t.Error("cooling without LastFiredAt")
+		}
+		fired := now.Add(-30 * time.Minute)
+		r.LastFiredAt = &fired
+		if !r.Cooling(now) {
+			t.Error("not cooling after 30 minutes")
+		}
+		if r.Cooling(now.Add(31 * time.Minute)) {
+			t.Error("still cooling after 61 minutes")
+		}
+	})
+}
@@ -0,0 +1,186 @@
+// Copyright © Martin Tournoij – This file is part of GoatCounter and published
+// under the terms of a slightly modified EUPL v1.2 license, which can be found
+// in the LICENSE file or at https://license.goatcounter.com
+
+package goatcounter
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"zgo.at/errors"
+	"zgo.at/zdb"
+	"zgo.at/zstd/ztime"
+)
+
+// Annotation is a marker on the charts, for example "v2.3 deployed".
+type Annotation struct {
+	ID     int64 `db:"annotation_id" json:"id,readonly"`
+	SiteID int64 `db:"site_id" json:"site_id,readonly"`
+
+	// Time to display the annotation at {datetime, default: current time}.
+	At time.Time `db:"at" json:"at"`
+
+	// Text to display.
+	Text string `db:"text" json:"text"`
+
+	// Only display on the charts for paths matching this; * can be used as a
+	// wildcard. Matching is case-insensitive. The annotation is displayed on
+	// the totals chart and all paths if this is empty.
+	Path string `db:"path" json:"path"`
+
+	CreatedAt time.Time `db:"created_at" json:"created_at,readonly"`
+}
+
+// Defaults sets fields to default values, unless they're already set.
+func (a *Annotation) Defaults(ctx context.Context) {
+	if s := GetSite(ctx); s != nil && s.ID > 0 {
+		a.SiteID = s.ID
+	}
+	if a.CreatedAt.IsZero() {
+		a.CreatedAt = ztime.Now()
+	}
+	if a.At.IsZero() {
+		a.At = ztime.Now()
+	}
+	a.At = a.At.UTC().Truncate(time.Second)
+	a.Text = strings.TrimSpace(a.Text)
+	a.Path = strings.TrimSpace(a.Path)
+}
+
+func (a *Annotation) Validate(ctx context.Context) error {
+	v := NewValidate(ctx)
+	v.Required("site_id", a.SiteID)
+	v.Required("text", a.Text)
+	v.Len("text", a.Text, 0, 200)
+	v.Len("path", a.Path, 0, 2048)
+	v.UTF8("text", a.Text)
+	v.UTF8("path", a.Path)
+	return v.ErrorOrNil()
+}
+
+// Insert a new row.
+func (a *Annotation) Insert(ctx context.Context) error {
+	if a.ID > 0 {
+		return errors.New("ID > 0")
+	}
+
+	a.Defaults(ctx)
+	err := a.Validate(ctx)
+	if err != nil {
+		return err
+	}
+
+	a.ID, err = zdb.InsertID(ctx, "annotation_id",
+		`insert into annotations (site_id, at, text, path, created_at) values (?)`,
+		zdb.L{a.SiteID, a.At, a.Text, a.Path, a.CreatedAt})
+	return errors.Wrap(err, "Annotation.Insert")
+}
+
+// Update the time, text, and path.
+func (a *Annotation) Update(ctx context.Context) error {
+	if a.ID == 0 {
+		return errors.New("ID == 0")
+	}
+
+	a.Defaults(ctx)
+	err := a.Validate(ctx)
+	if err != nil {
+		return err
+	}
+
+	err = zdb.Exec(ctx, `update annotations set at=?, text=?, path=? where annotation_id=? and site_id=?`,
+		a.At, a.Text, a.Path, a.ID, a.SiteID)
+	return errors.Wrap(err, "Annotation.Update")
+}
+
+func (a *Annotation) ByID(ctx context.Context, id int64) error {
+	return errors.Wrapf(zdb.Get(ctx, a, `/* Annotation.ByID */
+		select * from annotations where annotation_id=$1 and site_id=$2`,
+		id, MustGetSite(ctx).ID), "Annotation.ByID %d", id)
+}
+
+func (a *Annotation) Delete(ctx context.Context) error {
+	err := zdb.Exec(ctx,
+		`/* Annotation.Delete */ delete from annotations where annotation_id=$1 and site_id=$2`,
+		a.ID, MustGetSite(ctx).ID)
+	return errors.Wrapf(err, "Annotation.Delete %d", a.ID)
+}
+
+// Matches reports if this annotation should be displayed on the chart for path.
+func (a Annotation) Matches(path string) bool {
+	if a.Path == "" {
+		return true
+	}
+	return matchGlob(strings.ToLower(a.Path), strings.ToLower(path))
+}
+
+// matchGlob reports if s matches pattern, where * matches any number of
+// characters, including none.
+func matchGlob(pattern, s string) bool {
+	parts := strings.Split(pattern, "*")
+	if len(parts) == 1 {
+		return pattern == s
+	}
+	if !strings.HasPrefix(s, parts[0]) {
+		return false
+	}
+	s = s[len(parts[0]):]
+	for _, p := range parts[1 : len(parts)-1] {
+		i := strings.Index(s, p)
+		if i == -1 {
+			return false
+		}
+		s = s[i+len(p):]
+	}
+	return strings.HasSuffix(s, parts[len(parts)-1])
+}
+
+type Annotations []Annotation
+
+// List all annotations for this site.
+func (a *Annotations) List(ctx context.Context) error {
+	return errors.Wrap(zdb.Select(ctx, a,
+		`select * from annotations where site_id=$1 order by at, annotation_id`,
+		MustGetSite(ctx).ID), "Annotations.List")
+}
+
+// ListRange lists all annotations for this site in the given time period.
+func (a *Annotations) ListRange(ctx context.Context, rng ztime.Range) error {
+	return errors.Wrap(zdb.Select(ctx, a, `/* Annotations.ListRange */
+		select * from annotations where site_id=$1 and at >= $2 and at <= $3
+		order by at, annotation_id`,
+		MustGetSite(ctx).ID, rng.Start, rng.End), "Annotations.ListRange")
+}
+
+// ForPath gets all annotations that should be displayed on the chart for path;
+// an empty path only gets the annotations for all paths, for the totals chart.
+func (a Annotations) ForPath(path string) Annotations {
+	r := make(Annotations, 0, len(a))
+	for _, aa := range a {
+		if (path == "" && aa.Path == "") || (path != "" && aa.Matches(path)) {
+			r = append(r, aa)
+		}
+	}
+	return r
+}
+
+// AnnotationMark is the position of an annotation on the chart.
+type AnnotationMark struct {
+	Day  string `json:"day"`  // Day in the user's timezone, as 2006-01-02.
+	Hour int    `json:"hour"` // Hour in the user's timezone.
+	Text string `json:"text"`
+}
+
+// Marks gets the positions to display the annotations on the chart at, in the
+// user's timezone.
+func (a Annotations) Marks(u *User) []AnnotationMark {
+	loc := u.Settings.Timezone.Loc()
+	m := make([]AnnotationMark, 0, len(a))
+	for _, aa := range a {
+		t := aa.At.In(loc)
+		m = append(m, AnnotationMark{Day: t.Format("2006-01-02"), Hour: t.Hour(), Text: aa.Text})
+	}
+	return m
+}
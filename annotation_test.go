@@ -0,0 +1,88 @@
+// Copyright © Martin Tournoij – This file is part of GoatCounter and published
+// under the terms of a slightly modified EUPL v1.2 license, which can be found
+// in the LICENSE file or at https://license.goatcounter.com
+
+package goatcounter_test
+
+import (
+	"testing"
+	"time"
+
+	. "zgo.at/goatcounter/v2"
+	"zgo.at/goatcounter/v2/gctest"
+	"zgo.at/tz"
+	"zgo.at/zstd/zjson"
+	"zgo.at/zstd/ztest"
+	"zgo.at/zstd/ztime"
+)
+
+func TestAnnotations(t *testing.T) {
+	ctx := gctest.DB(t)
+
+	now := time.Date(2019, 8, 31, 14, 42, 0, 0, time.UTC)
+	for _, a := range []Annotation{
+		{At: now, Text: "v2.3 deployed"},
+		{At: now.Add(-2 * time.Hour), Text: "Blog post", Path: "/blog/*"},
+		{At: now.Add(-48 * time.Hour), Text: "Old"},
+	} {
+		err := a.Insert(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	{
+		var a Annotation
+		err := a.Insert(ctx)
+		if !ztest.ErrorContains(err, "text: must be set") {
+			t.Fatalf("wrong error: %v", err)
+		}
+	}
+
+	var list Annotations
+	err := list.ListRange(ctx, ztime.NewRange(now.Add(-24*time.Hour)).To(now))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	texts := func(a Annotations) []string {
+		t := make([]string, 0, len(a))
+		for _, aa := range a {
+			t = append(t, aa.Text)
+		}
+		return t
+	}
+	if have, want := zjson.MustMarshalString(texts(list)), `["Blog post","v2.3 deployed"]`; have != want {
+		t.Errorf("\nhave: %s\nwant: %s", have, want)
+	}
+
+	tests := []struct {
+		path, want string
+	}{
+		{"", `["v2.3 deployed"]`},
+		{"/", `["v2.3 deployed"]`},
+		{"/blog/post", `["Blog post","v2.3 deployed"]`},
+		{"/BLOG/post", `["Blog post","v2.3 deployed"]`},
+		{"/blog", `["v2.3 deployed"]`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			have := zjson.MustMarshalString(texts(list.ForPath(tt.path)))
+			if have != tt.want {
+				t.Errorf("\nhave: %s\nwant: %s", have, tt.want)
+			}
+		})
+	}
+
+	t.Run("marks", func(t *testing.T) {
+		u := &User{Settings: UserSettings{Timezone: tz.MustNew("ID", "Asia/Makassar")}}
+		have := zjson.MustMarshalString(list.Marks(u))
+		want := `[
+			{"day": "2019-08-31", "hour": 20, "text": "Blog post"},
+			{"day": "2019-08-31", "hour": 22, "text": "v2.3 deployed"}
+		]`
+		if d := ztest.Diff(have, want, ztest.DiffJSON); d != "" {
+			t.Error(d)
+		}
+	})
+}
@@ -21,13 +21,15 @@ import (
 //
 // DO NOT change the values of these constants; they're stored in the database.
 const (
-	APIPermNothing    zint.Bitflag64 = 1 << iota
-	APIPermCount                     // 2
-	APIPermExport                    // 4
-	APIPermSiteRead                  // 8
-	APIPermSiteCreate                // 16
-	APIPermSiteUpdate                // 32
-	APIPermStats                     // 64
+	APIPermNothing     zint.Bitflag64 = 1 << iota
+	APIPermCount                      // 2
+	APIPermExport                     // 4
+	APIPermSiteRead                   // 8
+	APIPermSiteCreate                 // 16
+	APIPermSiteUpdate                 // 32
+	APIPermStats                      // 64
+	APIPermAnnotations                // 128
+	APIPermAlerts                     // 256
 )
 
 type APIToken struct {
@@ -67,6 +69,16 @@ func (t APIToken) PermissionFlags(only ...zint.Bitflag64) []PermissionFlag {
 			Help:  "Get statistics out of GoatCounter",
 			Flag:  APIPermStats,
 		},
+		{
+			Label: "Annotations",
+			Help:  "Manage chart annotations with /api/v0/annotations",
+			Flag:  APIPermAnnotations,
+		},
+		{
+			Label: "Alerts",
+			Help:  "Manage alert rules with /api/v0/alerts",
+			Flag:  APIPermAlerts,
+		},
 		{
 			Label: "Export",
 			Help:  "Export data with /api/v0/export",
@@ -117,6 +129,12 @@ func (t APIToken) FormatPermissions() string {
 	if t.Permissions.Has(APIPermSiteUpdate) {
 		all = append(all, "site-update")
 	}
+	if t.Permissions.Has(APIPermAnnotations) {
+		all = append(all, "annotations")
+	}
+	if t.Permissions.Has(APIPermAlerts) {
+		all = append(all, "alerts")
+	}
 	return "'" + strings.Join(all, "', '") + "'"
 }
 
@@ -154,6 +154,8 @@ create and update commands:
                         site_read    Reading site information.
                         site_create  Creating new sites.
                         site_update  Updating existing sites.
+                        annotations  Managing chart annotations.
+                        alerts       Managing alert rules.
 
 migrate command:
 
@@ -1056,6 +1058,8 @@ func getPerm(permFlag string) (zint.Bitflag64, error) {
 			"site_read":   goatcounter.APIPermSiteRead,
 			"site_create": goatcounter.APIPermSiteCreate,
 			"site_update": goatcounter.APIPermSiteUpdate,
+			"annotations": goatcounter.APIPermAnnotations,
+			"alerts":      goatcounter.APIPermAlerts,
 		}[p]
 		if !ok {
 			return 0, fmt.Errorf("-perm: invalid value %q", p)
@@ -5,8 +5,8 @@
 package main
 
 import (
+	"bufio"
 	"bytes"
-	"compress/gzip"
 	"context"
 	"fmt"
 	"io"
@@ -14,6 +14,7 @@ import (
 	"net"
 	"net/http"
 	"os"
+	"slices"
 	"strconv"
 	"strings"
 	"time"
@@ -26,7 +27,7 @@ import (
 	"zgo.at/zli"
 	"zgo.at/zlog"
 	"zgo.at/zstd/znet"
-	"zgo.at/zstd/zstring"
+	"zgo.at/zstd/ztype"
 )
 
 const usageImport = `
@@ -44,7 +45,8 @@ Overview:
     You can create an API key with "goatcounter db create apikey -count", or
     from the web interface in "User → API" from the top-right menu.
 
-    You must give one filename to import; use - to read from stdin:
+    You must give one filename to import; use - to read from stdin. Files
+    ending in .gz or .zst are decompressed:
 
         $ goatcounter import -site=.. export.csv.gz
 
@@ -52,6 +54,25 @@ Overview:
 
         $ goatcounter import -site=.. -follow /var/log/nginx/access.log
 
+    Log files can also be given as more than one filename, a glob pattern, or
+    a directory to read all files in it. The files are read oldest first (by
+    modification time), so rotated logs are read in the right order:
+
+        $ goatcounter import -site=.. '/var/log/nginx/access.log*'
+
+    This is also useful for logs from CDNs that are delivered as many small
+    files:
+
+        $ aws s3 sync s3://my-logs/cloudfront ./logs
+        $ goatcounter import -site=.. -format=cloudfront ./logs
+
+    Use -checkpoint to resume from where the last import stopped; see
+    -checkpoint below.
+
+    Or to receive logs over syslog with -listen, instead of reading a file:
+
+        $ goatcounter import -site=.. -format=combined -listen=udp://127.0.0.1:5514
+
     If you're self-hosting GoatCounter it may be useful to (temporarily)
     increase the ratelimit when importing large files:
 
@@ -65,19 +86,93 @@ Flags:
   -silent      Don't show progress information.
 
   -site        Site to import to, as an URL (e.g. "https://stats.example.com")
+               This is optional with -sites, in which case it's used for hosts
+               that aren't listed.
+
+  -sites       Send pageviews to different sites based on the host in the
+               logfile, for logs that contain more than one virtual host (e.g.
+               -format=combined-vhost). Every line in the file is:
+
+                   host  site  [key]
+
+               For example:
+
+                   # Two hosts to the same site.
+                   example.com      https://stats.example.com
+                   www.example.com  https://stats.example.com
+
+                   # All subdomains, with the key from $OTHER_KEY.
+                   *.example.net    https://other.goatcounter.com  $OTHER_KEY
+
+                   # Everything else.
+                   *                https://misc.goatcounter.com   api-key
+
+               The host is matched case-insensitive and without port. The key
+               is read from the environment variable if it starts with $, and
+               GOATCOUNTER_API_KEY is used if it's omitted. Pageviews for hosts
+               that aren't listed are skipped if there is no * or -site.
+
+               Every site is sent in its own batches, with its own key.
 
   -follow      Watch a file for new lines and import them. Existing lines are
-               not processed.
+               not processed, unless there is a -checkpoint from a previous
+               run. For glob patterns and directories new files are also
+               imported.
+
+  -checkpoint  Record the position in every log file in this file, and resume
+               from there on the next run. Files are recognized by their inode,
+               or by the first 1K of data if the inode changed (such as when
+               logrotate compresses a file). This means lines aren't skipped or
+               imported twice if goatcounter is restarted or the logs are
+               rotated, although if goatcounter is stopped while sending lines
+               the last batch of 100 lines may be sent twice.
+
+               The last line of a file is only imported once it ends with a
+               newline, except for compressed files.
+
+  -listen      Receive log lines as syslog messages, instead of reading a file.
+               This is given as udp://[host]:port or tcp://[host]:port; for
+               example udp://127.0.0.1:5514. Both RFC 3164 and RFC 5424 messages
+               are accepted, and the syslog header is removed before the message
+               is parsed with -format.
+
+               For example for nginx:
+
+                   access_log syslog:server=127.0.0.1:5514,tag=nginx combined;
+
+               Or HAProxy, with a log-format that matches -format:
+
+                   log 127.0.0.1:5514 local0
 
   -format      Log format; currently accepted values:
 
                    csv             GoatCounter CSV export (default)
+                   jsonl           GoatCounter JSON Lines export
+                   sqlite          GoatCounter SQLite export
+                   aggregates      GoatCounter aggregated statistics export;
+                                   this is imported directly in to the
+                                   statistics tables, replacing existing
+                                   values.
+                   plausible       Plausible CSV export, as a zip file or a
+                                   single CSV file; Plausible only exports
+                                   daily totals, which are imported in to
+                                   the statistics tables.
+                   matomo          Matomo visits log, as JSON from the
+                                   Live.getLastVisitsDetails API.
+                   ga4             Google Analytics 4 BigQuery export, as JSON
+                                   Lines or a JSON array.
                    combined        NCSA Combined Log
                    combined-vhost  NCSA Combined Log with virtual host
                    common          Common Log Format (CLF)
                    common-vhost    Common Log Format (CLF) with virtual host
+                   caddy           Caddy JSON access log
+                   traefik         Traefik JSON access log
+                   cloudfront      Amazon CloudFront standard log
+                   cloudflare      Cloudflare Logpush HTTP requests
                    log:[fmt]       Custom log format; see "goatcounter help
                                    logfile" for details.
+                   json:[fields]   Custom JSON log format; see "goatcounter
+                                   help logfile" for details.
 
   -date, -time, -datetime
                Format of date and time for log imports; set automatically when
@@ -151,22 +246,84 @@ List of format specifiers:
                    address.
 
     method         Request method.
-    status         Status code sent to the client.
+    status         Status code sent to the client; used for the "Error pages"
+                   report.
     http           HTTP request protocol (i.e. HTTP/1.1).
     path           URL path; this may contain the query string.
     query          Query string; only needed if not included in $path.
     referrer       "Referrer" request header.
     user_agent     User-Agent request header.
+    host           Server name of the server serving the request; used for
+                   -sites.
 
-Some format specifiers that are not (yet) used anywhere:
-
-    host           Server name of the server serving the request.
-    content_type   Content-Type header of the response.
     timing_sec     Time to serve the request in seconds, with possible decimal.
     timing_milli   Time to serve the request in milliseconds.
     timing_micro   Time to serve the request in microseconds.
+                   The timing is used for the "Response times" report.
+
+Some format specifiers that are not (yet) used anywhere:
+
+    content_type   Content-Type header of the response.
     size           Size of the object returned to the client.
 
+JSON logs:
+
+    JSON logs are read with -format=json:[fields], where [fields] is a
+    comma-separated list of field=path. The fields are the same as the format
+    specifiers above, and the path is the location of the value in the JSON
+    object: use "." to get an object key and [n] to get an array element. The
+    path field is required; lines that aren't JSON objects or don't have the
+    path are skipped.
+
+    For example, for Caddy:
+
+        json:datetime=ts,path=request.uri,user_agent=request.headers.User-Agent[0]
+
+    Object keys are matched case-insensitive if there is no exact match.
+
+    This also works for nginx with "log_format escape=json"; for example:
+
+        log_format goatcounter escape=json '{"time":"$time_iso8601",'
+            '"addr":"$remote_addr","host":"$host","method":"$request_method",'
+            '"uri":"$request_uri","status":$status,"ref":"$http_referer",'
+            '"ua":"$http_user_agent","type":"$sent_http_content_type"}';
+
+    Which can be imported with:
+
+        -format='json:datetime=time,remote_addr=addr,host=host,method=method,path=uri,status=status,referrer=ref,user_agent=ua,content_type=type'
+        -datetime=rfc3339
+
+    The "caddy" and "traefik" formats are predefined; for Traefik the
+    User-Agent and Referer headers need to be kept with:
+
+        --accesslog.fields.headers.names.User-Agent=keep
+        --accesslog.fields.headers.names.Referer=keep
+
+    The "cloudflare" format reads the HTTP requests dataset from Logpush, and
+    requires at least the ClientRequestURI and EdgeStartTimestamp fields.
+    Timestamps are read as unixnano; use -datetime=rfc3339 if the Logpush job
+    uses RFC 3339 timestamps.
+
+    Fastly real-time logs can be sent as JSON; for example with the format:
+
+        {"time":"%{strftime(\{"%Y-%m-%dT%H:%M:%SZ"\}, time.start)}V",
+         "addr":"%h","host":"%{req.http.Host}V","method":"%m",
+         "uri":"%{json.escape(req.url)}V","status":%>s,
+         "ref":"%{json.escape(req.http.Referer)}V",
+         "ua":"%{json.escape(req.http.User-Agent)}V",
+         "type":"%{json.escape(resp.http.Content-Type)}V"}
+
+    Which can be imported with the same -format and -datetime as the nginx
+    example above.
+
+CloudFront logs:
+
+    The "cloudfront" format reads CloudFront standard logs (the W3C extended
+    log format). The fields are read from the "#Fields:" header, so it doesn't
+    matter which fields are logged or in which order, but cs-uri-stem, date,
+    and time are required. The x-host-header is used as the host if it's
+    logged, and cs(Host) otherwise.
+
 Date and time parsing:
 
     Parsing the date and time is done with Go's time package; the following
@@ -194,6 +351,12 @@ Date and time parsing:
         rfc3339        2006-01-02T15:04:05Z07:00
         rfc3339nano    2006-01-02T15:04:05.999999999Z07:00
 
+    And for Unix timestamps:
+
+        unix_sec       Seconds since 1970, optionally with a fraction.
+        unix_milli     Milliseconds since 1970.
+        unix_nano      Nanoseconds since 1970.
+
     The full documentation is available at https://pkg.go.dev/time
 `
 
@@ -208,92 +371,146 @@ func cmdImport(f zli.Flags, ready chan<- struct{}, stop chan struct{}) error {
 		silent   = f.Bool(false, "silent").Pointer()
 		follow   = f.Bool(false, "follow").Pointer()
 		exclude  = f.StringList(nil, "exclude").Pointer()
+		chkpoint = f.String("", "checkpoint").Pointer()
+		listen   = f.String("", "listen").Pointer()
+		sites    = f.String("", "sites").Pointer()
 	)
 	err := f.Parse()
 	if err != nil {
 		return err
 	}
 
-	return func(debug, site, format, date, tyme, datetime string, silent, follow bool, exclude []string) error {
+	return func(debug, site, format, date, tyme, datetime, chkpoint, listen, sites string, silent, follow bool, exclude []string) error {
 		files := f.Args
+		if listen != "" {
+			if len(files) > 0 {
+				return fmt.Errorf("cannot use -listen with a filename")
+			}
+			if !isLogFormat(format) {
+				return fmt.Errorf("cannot use -listen with -format=%s", format)
+			}
+			if chkpoint != "" {
+				return fmt.Errorf("cannot use -listen with -checkpoint")
+			}
+			files = []string{""}
+		}
 		if len(files) == 0 {
 			return fmt.Errorf("need a filename")
 		}
-		if len(files) > 1 {
-			return fmt.Errorf("can only specify one filename")
+		if chkpoint != "" && !isLogFormat(format) {
+			return fmt.Errorf("cannot use -checkpoint with -format=%s", format)
+		}
+		if sites != "" && !isLogFormat(format) {
+			return fmt.Errorf("cannot use -sites with -format=%s", format)
 		}
 
-		var fp io.ReadCloser
-		if files[0] == "-" {
-			fp = io.NopCloser(os.Stdin)
-		} else {
-			file, err := os.Open(files[0])
+		var (
+			fp  io.ReadCloser
+			chk *checkpoint
+		)
+		switch {
+		case listen != "":
+		case isLogFormat(format) && (chkpoint != "" || len(files) > 1 || isPattern(files[0])):
+			if slices.Contains(files, "-") {
+				return fmt.Errorf("cannot read from stdin with more than one file or -checkpoint")
+			}
+			tail, err := newLogTail(files, chkpoint, follow)
 			if err != nil {
 				return err
 			}
-			defer file.Close()
-
-			fp = file
-			if strings.HasSuffix(files[0], ".gz") {
-				fp, err = gzip.NewReader(file)
-				if err != nil {
-					return errors.Errorf("could not read as gzip: %w", err)
-				}
+			defer tail.Close()
+			fp, chk = tail, tail.chk
+		case len(files) > 1 || isPattern(files[0]):
+			return fmt.Errorf("can only specify one filename with -format=%s", format)
+		case files[0] == "-":
+			fp = io.NopCloser(os.Stdin)
+		default:
+			fp, err = openFile(files[0])
+			if err != nil {
+				return err
 			}
 			defer fp.Close()
 		}
 
 		zlog.Config.SetDebug(debug)
 
-		url := strings.TrimRight(site, "/")
-		if !zstring.HasPrefixes(url, "http://", "https://") {
-			url = "https://" + url
-		}
-		key := os.Getenv("GOATCOUNTER_API_KEY")
-		if key == "" {
-			return errors.New("GOATCOUNTER_API_KEY must be set")
+		var (
+			url   = siteURL(site)
+			key   = os.Getenv("GOATCOUNTER_API_KEY")
+			route *importSites
+		)
+		if sites != "" {
+			route, err = loadImportSites(sites, site, key)
+			if err != nil {
+				return err
+			}
+		} else {
+			if key == "" {
+				return errors.New("GOATCOUNTER_API_KEY must be set")
+			}
+			route = newImportSites(url, key)
 		}
 
-		err = checkSite(url, key, goatcounter.APIPermCount)
-		if err != nil {
-			return err
+		for _, s := range route.sites {
+			err = checkSite(s.url, s.key, goatcounter.APIPermCount)
+			if err != nil {
+				return err
+			}
 		}
 
 		switch format {
 		default:
-			err = importLog(fp, ready, stop, url, key, files[0], format, date, tyme, datetime, follow, silent, exclude)
-		case "csv":
+			err = importLog(fp, ready, stop, route, files[0], listen, format, date, tyme, datetime, follow, silent, exclude, chk)
+		case goatcounter.ExportCSV, goatcounter.ExportJSONL, goatcounter.ExportSQLite:
 			ready <- struct{}{}
 			if follow {
-				return fmt.Errorf("cannot use -follow with -format=csv")
+				return fmt.Errorf("cannot use -follow with -format=%s", format)
 			}
 			if len(exclude) > 0 {
-				return fmt.Errorf("cannot use -exclude with -format=csv")
+				return fmt.Errorf("cannot use -exclude with -format=%s", format)
 			}
-			err = importCSV(fp, url, key, silent)
+			err = importExport(fp, format, url, key, silent)
+		case goatcounter.ImportPlausible, goatcounter.ImportMatomo, goatcounter.ImportGA4:
+			ready <- struct{}{}
+			if follow {
+				return fmt.Errorf("cannot use -follow with -format=%s", format)
+			}
+			if len(exclude) > 0 {
+				return fmt.Errorf("cannot use -exclude with -format=%s", format)
+			}
+			err = importExternal(fp, format, url, key, silent)
+		case goatcounter.ExportModeAggregates:
+			ready <- struct{}{}
+			if follow {
+				return fmt.Errorf("cannot use -follow with -format=%s", format)
+			}
+			if len(exclude) > 0 {
+				return fmt.Errorf("cannot use -exclude with -format=%s", format)
+			}
+			err = importAggregates(fp, url, key, silent)
 		}
 		return err
-	}(*debug, *site, *format, *date, *tyme, *datetime, *silent, *follow, *exclude)
+	}(*debug, *site, *format, *date, *tyme, *datetime, *chkpoint, *listen, *sites, *silent, *follow, *exclude)
+}
+
+// isLogFormat reports if this is a log format, rather than an export.
+func isLogFormat(format string) bool {
+	switch format {
+	case goatcounter.ExportCSV, goatcounter.ExportJSONL, goatcounter.ExportSQLite,
+		goatcounter.ImportPlausible, goatcounter.ImportMatomo, goatcounter.ImportGA4,
+		goatcounter.ExportModeAggregates:
+		return false
+	}
+	return true
 }
 
-func importCSV(fp io.ReadCloser, url, key string, silent bool) error {
+func importExport(fp io.ReadCloser, format, url, key string, silent bool) error {
 	n := 0
 	ctx := goatcounter.WithSite(context.Background(), &goatcounter.Site{})
 	hits := make([]handlers.APICountRequestHit, 0, 500)
-	_, err := goatcounter.Import(ctx, fp, false, false, func(hit goatcounter.Hit, final bool) {
+	_, err := goatcounter.Import(ctx, fp, format, false, false, func(hit goatcounter.Hit, final bool) {
 		if !final {
-			hits = append(hits, handlers.APICountRequestHit{
-				Path:      hit.Path,
-				Title:     hit.Title,
-				Event:     hit.Event,
-				Ref:       hit.Ref,
-				Size:      hit.Size,
-				Bot:       hit.Bot,
-				UserAgent: hit.UserAgentHeader,
-				Location:  hit.Location,
-				CreatedAt: hit.CreatedAt,
-				Session:   hit.Session.String(),
-			})
+			hits = append(hits, importHit(hit))
 		}
 
 		if len(hits) >= 500 || final {
@@ -305,7 +522,7 @@ func importCSV(fp io.ReadCloser, url, key string, silent bool) error {
 
 			n += len(hits)
 			if !silent {
-				zli.ReplaceLinef("Imported %d rows", n)
+				zli.ReplaceLinef("Imported %d rows; skipped %d duplicates", n-int(nDuplicates), nDuplicates)
 			}
 
 			hits = make([]handlers.APICountRequestHit, 0, 500)
@@ -314,26 +531,197 @@ func importCSV(fp io.ReadCloser, url, key string, silent bool) error {
 	return err
 }
 
+func importHit(hit goatcounter.Hit) handlers.APICountRequestHit {
+	return handlers.APICountRequestHit{
+		Path:           hit.Path,
+		Title:          hit.Title,
+		Event:          hit.Event,
+		Ref:            hit.Ref,
+		Size:           hit.Size,
+		Query:          hit.Query,
+		Bot:            hit.Bot,
+		UserAgent:      hit.UserAgentHeader,
+		Browser:        hit.BrowserName,
+		BrowserVersion: hit.BrowserVersion,
+		System:         hit.SystemName,
+		SystemVersion:  hit.SystemVersion,
+		Location:       hit.Location,
+		CreatedAt:      hit.CreatedAt,
+		Session:        hit.Session.String(),
+		ImportHash:     hit.ImportHash,
+	}
+}
+
+// importExternal imports an export from another analytics service; this sends
+// either pageviews or aggregates, depending on what's in the export.
+func importExternal(fp io.Reader, format, url, key string, silent bool) error {
+	if format == goatcounter.ImportGA4 {
+		goatcounter.InitGeoDB("")
+	}
+
+	var (
+		n    = 0
+		hits = make([]handlers.APICountRequestHit, 0, 500)
+		rows = make(goatcounter.ExportAggregates, 0, 500)
+	)
+	send := func() error {
+		var err error
+		switch {
+		case len(hits) > 0:
+			err = importSend(url, key, silent, false, hits)
+			n += len(hits)
+			hits = hits[:0]
+		case len(rows) > 0:
+			err = importSendAggregates(url, key, silent, rows)
+			n += len(rows)
+			rows = rows[:0]
+		}
+		if err != nil {
+			return err
+		}
+		if !silent {
+			zli.ReplaceLinef("Imported %d rows; skipped %d duplicates", n-int(nDuplicates), nDuplicates)
+		}
+		return nil
+	}
+
+	err := goatcounter.ImportExternal(fp, format,
+		func(hit goatcounter.Hit) error {
+			hits = append(hits, importHit(hit))
+			if len(hits) == cap(hits) {
+				return send()
+			}
+			return nil
+		},
+		func(row goatcounter.ExportAggregate) error {
+			rows = append(rows, row)
+			if len(rows) == cap(rows) {
+				return send()
+			}
+			return nil
+		})
+	if err != nil {
+		return err
+	}
+	return send()
+}
+
+func importAggregates(fp io.Reader, url, key string, silent bool) error {
+	var (
+		n    = 0
+		rows = make(goatcounter.ExportAggregates, 0, 500)
+		scan = bufio.NewScanner(fp)
+	)
+	scan.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	send := func() error {
+		if len(rows) == 0 {
+			return nil
+		}
+		err := importSendAggregates(url, key, silent, rows)
+		if err != nil {
+			return err
+		}
+		n += len(rows)
+		if !silent {
+			zli.ReplaceLinef("Imported %d rows", n)
+		}
+		rows = rows[:0]
+		return nil
+	}
+
+	for scan.Scan() {
+		line := bytes.TrimSpace(scan.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		var row goatcounter.ExportAggregate
+		err := json.Unmarshal(line, &row)
+		if err != nil {
+			return fmt.Errorf("not an aggregates export: %w", err)
+		}
+		rows = append(rows, row)
+		if len(rows) == cap(rows) {
+			err := send()
+			if err != nil {
+				return err
+			}
+		}
+	}
+	if err := scan.Err(); err != nil {
+		return err
+	}
+	return send()
+}
+
+func importSendAggregates(url, key string, silent bool, rows goatcounter.ExportAggregates) error {
+	body, err := json.Marshal(map[string]any{"rows": rows})
+	if err != nil {
+		return err
+	}
+
+	r, err := newRequest("POST", url+"/api/v0/import/aggregates", key, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	resp, err := importClient.Do(r)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case 200:
+		return nil
+	case http.StatusTooManyRequests:
+		s, _ := strconv.Atoi(resp.Header.Get("X-Rate-Limit-Reset"))
+		if !silent {
+			fmt.Fprintf(zli.Stdout, "\nwaiting %d seconds for the ratelimiter\n", s)
+		}
+		time.Sleep(time.Duration(s) * time.Second)
+		return importSendAggregates(url, key, silent, rows)
+	case 400:
+		// Some rows couldn't be imported; report but continue.
+		b, _ := io.ReadAll(resp.Body)
+		fmt.Fprintf(zli.Stderr, "%s: %s: %s\n", url, resp.Status, b)
+		return nil
+	default:
+		b, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("%s: %s: %s", url, resp.Status, b)
+	}
+}
+
 func importLog(
 	fp io.ReadCloser,
 	ready chan<- struct{}, stop <-chan struct{},
-	url, key, file, format, date, tyme, datetime string, follow, silent bool, exclude []string,
+	sites *importSites,
+	file, listen, format, date, tyme, datetime string, follow, silent bool, exclude []string,
+	chk *checkpoint,
 ) error {
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		<-stop
+		cancel()
+	}()
+
 	var (
 		scan *logscan.Scanner
 		err  error
 	)
-	if follow && file != "-" {
+	switch {
+	case listen != "":
+		follow = true
+		scan, err = logscan.NewSyslog(ctx, listen, format, date, tyme, datetime, exclude)
+	case follow && file != "-" && chk == nil:
 		fp.Close()
 		scan, err = logscan.NewFollow(context.Background(), file, format, date, tyme, datetime, exclude)
-	} else {
+	default:
 		scan, err = logscan.New(fp, format, date, tyme, datetime, exclude)
 	}
 	if err != nil {
 		return err
 	}
 
-	hits := make(chan handlers.APICountRequestHit, 100)
+	send := &logSender{sites: sites, chk: chk, silent: silent, follow: follow}
 
 	// Persist every 10 seconds because it may take a while for 100 pageviews to
 	// arrive when using -follow.
@@ -343,25 +731,18 @@ func importLog(
 	go func() {
 		for {
 			<-t.C
-			persistLog(hits, url, key, silent, follow)
+			send.send(false)
 		}
 	}()
 
-	ctx, cancel := context.WithCancel(context.Background())
-	go func() {
-		<-stop
-		cancel()
-	}()
-
-	defer persistLog(hits, url, key, silent, follow)
 	ready <- struct{}{}
-	n := 0
 	for {
 		line, err := scan.Line(ctx)
 		if err == io.EOF {
 			break
 		}
 		if err != nil {
+			send.send(true)
 			fmt.Fprintln(zli.Stdout)
 			return err
 		}
@@ -374,6 +755,12 @@ func importLog(
 			Query:     line.Query(),
 			UserAgent: line.UserAgent(),
 		}
+		if s := line.Status(); s >= 100 && s <= 599 {
+			hit.Status = s
+		}
+		if line.HasTiming() {
+			hit.ResponseTime = ztype.Ptr(float64(line.Timing()) / float64(time.Millisecond))
+		}
 
 		hit.CreatedAt, err = line.Datetime(scan)
 		if err != nil {
@@ -394,40 +781,17 @@ func importLog(
 			hit.IP = znet.RemovePort(line.RemoteAddr())
 		}
 
-		hits <- hit
-		if len(hits) >= cap(hits) {
-			n += len(hits)
-			t.Reset(d)
-			persistLog(hits, url, key, silent, follow)
-			if !silent && !follow {
-				zli.ReplaceLinef("Imported %d rows", n)
-			}
-		}
-	}
-	return nil
-}
-
-// Send everything off if we have 100 entries or if 10 seconds expired,
-// whichever happens first.
-func persistLog(hits <-chan handlers.APICountRequestHit, url, key string, silent, follow bool) {
-	l := len(hits)
-	if l == 0 {
-		return
-	}
-	collect := make([]handlers.APICountRequestHit, l)
-	for i := 0; i < l; i++ {
-		collect[i] = <-hits
+		send.add(line.Host(), hit)
 	}
 
-	err := importSend(url, key, silent, follow, collect)
-	if err != nil {
-		zlog.Error(err)
-	}
+	// Also commit any excluded or invalid lines at the end.
+	return send.send(true)
 }
 
 var (
 	importClient = http.Client{Timeout: 5 * time.Second}
 	nSent        int64
+	nDuplicates  int64 // Pageviews skipped by the server as already imported.
 )
 
 func importSend(url, key string, silent, follow bool, hits []handlers.APICountRequestHit) error {
@@ -485,7 +849,9 @@ retry:
 
 	switch resp.StatusCode {
 	case 200, 202:
-		// Success, do nothing.
+		if d := resp.Header.Get("X-Goatcounter-Duplicates"); d != "" {
+			nDuplicates += int64(strings.Count(d, ",") + 1)
+		}
 	case http.StatusTooManyRequests:
 		s, _ := strconv.Atoi(resp.Header.Get("X-Rate-Limit-Reset"))
 		if !silent {
@@ -493,6 +859,16 @@ retry:
 		}
 		time.Sleep(time.Duration(s) * time.Second)
 		return importSend(url, key, silent, follow, hits)
+	case http.StatusServiceUnavailable:
+		s, err := strconv.Atoi(resp.Header.Get("Retry-After"))
+		if err != nil {
+			return showError(true)
+		}
+		if !silent {
+			fmt.Fprintf(zli.Stdout, "\nserver is busy; waiting %d seconds\n", s)
+		}
+		time.Sleep(time.Duration(s) * time.Second)
+		return importSend(url, key, silent, follow, hits)
 	case 400:
 		return showError(false)
 	default:
@@ -0,0 +1,236 @@
+// Copyright © Martin Tournoij – This file is part of GoatCounter and published
+// under the terms of a slightly modified EUPL v1.2 license, which can be found
+// in the LICENSE file or at https://license.goatcounter.com
+
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"zgo.at/errors"
+	"zgo.at/json"
+)
+
+// Number of bytes at the start of a file that's used to recognize it if the
+// inode changed, such as when logrotate compresses or copies a file.
+const headLen = 1024
+
+// checkpoint records how far we've read in every log file, so that an import
+// can resume after a restart or logrotate.
+//
+// Files are recognized by their inode, and by a hash of the first bytes if the
+// inode changed. The offset is only updated after the lines are sent, so lines
+// are never skipped, but a batch of lines may be sent twice if goatcounter is
+// stopped in between sending the lines and writing the checkpoint.
+type checkpoint struct {
+	Files []*checkpointFile `json:"files"`
+
+	path    string
+	existed bool
+
+	mu      sync.Mutex
+	pending []pendingLine // Lines read but not yet committed.
+	lineno  uint64        // Line number of pending[0].
+}
+
+type checkpointFile struct {
+	Path    string    `json:"path"`     // Path we last saw the file at.
+	Inode   uint64    `json:"inode"`    // Inode; 0 if not supported on this system.
+	Offset  int64     `json:"offset"`   // Bytes imported; for compressed files after decompressing.
+	Size    int64     `json:"size"`     // File size if all lines are imported; 0 if not done.
+	HeadLen int       `json:"head_len"` // Number of bytes hashed in Head.
+	Head    string    `json:"head"`     // SHA-256 of the first HeadLen bytes.
+	Seen    time.Time `json:"seen"`     // Last time the file was seen.
+
+	read int64 // Bytes read, which may not be committed yet.
+	size int64 // File size if all lines are read.
+}
+
+type pendingLine struct {
+	file   *checkpointFile
+	offset int64
+}
+
+// loadCheckpoint loads the checkpoint from path. The file is created once lines
+// are committed. If path is "" then the checkpoint is only kept in memory.
+func loadCheckpoint(path string) (*checkpoint, error) {
+	c := &checkpoint{path: path, lineno: 1}
+	if path == "" {
+		return c, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return c, nil
+	}
+	if err != nil {
+		return nil, errors.Errorf("loadCheckpoint: %w", err)
+	}
+	err = json.Unmarshal(data, c)
+	if err != nil {
+		return nil, errors.Errorf("loadCheckpoint: %s: %w", path, err)
+	}
+	for _, f := range c.Files {
+		f.read, f.size = f.Offset, f.Size
+	}
+	c.existed = true
+	return c, nil
+}
+
+// done returns the file if it's the same inode and size as a file we've
+// already read to the end; this avoids reading the start of every rotated file
+// again.
+func (c *checkpoint) done(path string, inode uint64, size int64) *checkpointFile {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if inode == 0 {
+		return nil
+	}
+	for _, f := range c.Files {
+		if f.Inode == inode && f.size == size {
+			f.Path, f.Seen = path, time.Now()
+			return f
+		}
+	}
+	return nil
+}
+
+// match finds the file in the checkpoint, or nil if it's a new file.
+func (c *checkpoint) match(path string, inode uint64, head []byte, size int64, compressed bool) *checkpointFile {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	found := func(f *checkpointFile) *checkpointFile {
+		f.Path, f.Inode, f.Seen = path, inode, time.Now()
+		return f
+	}
+
+	// Same inode and start: the same file, possibly renamed.
+	if inode != 0 {
+		for _, f := range c.Files {
+			if f.Inode == inode && f.hasHead(head) && (compressed || size >= f.read) {
+				return found(f)
+			}
+		}
+	}
+	// Same start with a different inode: copied or compressed by logrotate.
+	for _, f := range c.Files {
+		if f.HeadLen > 0 && f.hasHead(head) {
+			return found(f)
+		}
+	}
+	return nil
+}
+
+func (f *checkpointFile) hasHead(head []byte) bool {
+	if len(head) < f.HeadLen {
+		return false
+	}
+	h := sha256.Sum256(head[:f.HeadLen])
+	return hex.EncodeToString(h[:]) == f.Head
+}
+
+func (c *checkpoint) add(path string, inode uint64, head []byte) *checkpointFile {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	h := sha256.Sum256(head)
+	f := &checkpointFile{
+		Path:    path,
+		Inode:   inode,
+		HeadLen: len(head),
+		Head:    hex.EncodeToString(h[:]),
+		Seen:    time.Now(),
+	}
+	c.Files = append(c.Files, f)
+	return f
+}
+
+// push records that the next line of n bytes was read from f.
+func (c *checkpoint) push(f *checkpointFile, n int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	f.read += n
+	c.pending = append(c.pending, pendingLine{file: f, offset: f.read})
+}
+
+// skip everything in f up to offset, without importing it.
+func (c *checkpoint) skip(f *checkpointFile, offset int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	f.read, f.Offset = offset, offset
+}
+
+// eof records that f was read to the end.
+func (c *checkpoint) eof(f *checkpointFile, size int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	f.size = size
+}
+
+// commit all lines up to and including lineno, and write the checkpoint file.
+func (c *checkpoint) commit(lineno uint64) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if lineno >= c.lineno {
+		n := len(c.pending)
+		if lineno-c.lineno < uint64(n) {
+			n = int(lineno-c.lineno) + 1
+		}
+		for _, p := range c.pending[:n] {
+			p.file.Offset = p.offset
+		}
+		c.pending = c.pending[n:]
+		c.lineno += uint64(n)
+	}
+	return c.save()
+}
+
+// Remove files from the checkpoint if we haven't seen them for a week.
+const checkpointExpire = 7 * 24 * time.Hour
+
+func (c *checkpoint) save() error {
+	if c.path == "" {
+		return nil
+	}
+
+	files := make([]*checkpointFile, 0, len(c.Files))
+	for _, f := range c.Files {
+		if time.Since(f.Seen) < checkpointExpire {
+			f.Size = 0
+			if f.Offset == f.read {
+				f.Size = f.size
+			}
+			files = append(files, f)
+		}
+	}
+	c.Files = files
+
+	data, err := json.MarshalIndent(c, "", "\t")
+	if err != nil {
+		return errors.Errorf("checkpoint.save: %w", err)
+	}
+
+	// Write to a temporary file and rename, so it's never partially written.
+	tmp, err := os.CreateTemp(filepath.Dir(c.path), ".goatcounter-checkpoint-*")
+	if err != nil {
+		return errors.Errorf("checkpoint.save: %w", err)
+	}
+	_, err = tmp.Write(append(bytes.TrimSpace(data), '\n'))
+	if err2 := tmp.Close(); err == nil {
+		err = err2
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), c.path)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return errors.Errorf("checkpoint.save: %w", err)
+	}
+	return nil
+}
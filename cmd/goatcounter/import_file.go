@@ -0,0 +1,401 @@
+// Copyright © Martin Tournoij – This file is part of GoatCounter and published
+// under the terms of a slightly modified EUPL v1.2 license, which can be found
+// in the LICENSE file or at https://license.goatcounter.com
+
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+	"zgo.at/errors"
+)
+
+// openFile opens a file for importing, decompressing it if the name ends in .gz
+// or .zst.
+func openFile(path string) (io.ReadCloser, error) {
+	fp, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	r, err := decompress(path, fp)
+	if err != nil {
+		fp.Close()
+		return nil, err
+	}
+	return r, nil
+}
+
+func isCompressed(path string) bool {
+	return strings.HasSuffix(path, ".gz") || strings.HasSuffix(path, ".zst")
+}
+
+// decompress fp if the path ends in .gz or .zst; closing the returned reader
+// also closes fp.
+func decompress(path string, fp *os.File) (io.ReadCloser, error) {
+	switch {
+	case strings.HasSuffix(path, ".gz"):
+		gz, err := gzip.NewReader(fp)
+		if err != nil {
+			return nil, errors.Errorf("could not read %q as gzip: %w", path, err)
+		}
+		return &compressedFile{Reader: gz, close: gz.Close, fp: fp}, nil
+	case strings.HasSuffix(path, ".zst"):
+		zr, err := zstd.NewReader(fp)
+		if err != nil {
+			return nil, errors.Errorf("could not read %q as zstd: %w", path, err)
+		}
+		return &compressedFile{Reader: zr, close: func() error { zr.Close(); return nil }, fp: fp}, nil
+	}
+	return fp, nil
+}
+
+type compressedFile struct {
+	io.Reader
+	close func() error
+	fp    *os.File
+}
+
+func (f *compressedFile) Close() error {
+	err := f.close()
+	if err2 := f.fp.Close(); err == nil {
+		err = err2
+	}
+	return err
+}
+
+// isPattern reports if this is a glob pattern or directory, rather than a
+// single file.
+func isPattern(path string) bool {
+	if strings.ContainsAny(path, "*?[") {
+		return true
+	}
+	st, err := os.Stat(path)
+	return err == nil && st.IsDir()
+}
+
+// logTail reads a set of log files as if they're one file, keeping track of
+// the position in every file in a checkpoint.
+//
+// The files are given as filenames, glob patterns, or directories (which read
+// all files in the directory), and are read in order of modification time so
+// that rotated files such as access.log.2.gz, access.log.1, and access.log are
+// read oldest first.
+//
+// Lines in the files that are still being written to are only read once
+// they're complete. If follow is set the files are checked for new data every
+// second, and new files matching the patterns are picked up.
+type logTail struct {
+	patterns []string
+	follow   bool
+	chk      *checkpoint
+	closed   atomic.Bool
+
+	pass    int      // Number of times we've listed the files.
+	queue   []string // Files left to read in this pass.
+	cur     io.ReadCloser
+	curFile *checkpointFile
+	curComp bool
+	curSize int64
+	chunk   []byte
+	partial []byte // Incomplete line at the end of the last chunk.
+	buf     []byte // Complete lines waiting to be read.
+}
+
+func newLogTail(patterns []string, checkpointFile string, follow bool) (*logTail, error) {
+	chk, err := loadCheckpoint(checkpointFile)
+	if err != nil {
+		return nil, err
+	}
+	t := &logTail{patterns: patterns, follow: follow, chk: chk, chunk: make([]byte, 256*1024)}
+
+	files, err := t.list()
+	if err != nil {
+		return nil, err
+	}
+	if len(files) == 0 && !follow {
+		return nil, fmt.Errorf("no files matching %s", strings.Join(patterns, " "))
+	}
+	return t, nil
+}
+
+// list all files matching the patterns, sorted by modification time.
+func (t *logTail) list() ([]string, error) {
+	type file struct {
+		path  string
+		mtime time.Time
+	}
+	var (
+		files []file
+		seen  = make(map[string]struct{})
+	)
+	for _, p := range t.patterns {
+		var matches []string
+		if st, err := os.Stat(p); err == nil && st.IsDir() {
+			matches, err = filepath.Glob(filepath.Join(p, "*"))
+			if err != nil {
+				return nil, err
+			}
+		} else if strings.ContainsAny(p, "*?[") {
+			matches, err = filepath.Glob(p)
+			if err != nil {
+				return nil, fmt.Errorf("invalid pattern %q: %w", p, err)
+			}
+		} else {
+			if err != nil && (t.pass == 0 && !t.follow) {
+				return nil, err
+			}
+			matches = []string{p}
+		}
+
+		for _, m := range matches {
+			if _, ok := seen[m]; ok || strings.HasPrefix(filepath.Base(m), ".") {
+				continue
+			}
+			if t.chk.path != "" && filepath.Clean(m) == filepath.Clean(t.chk.path) {
+				continue
+			}
+			st, err := os.Stat(m)
+			if err != nil || st.IsDir() {
+				continue
+			}
+			seen[m] = struct{}{}
+			files = append(files, file{path: m, mtime: st.ModTime()})
+		}
+	}
+
+	sort.SliceStable(files, func(i, j int) bool {
+		if files[i].mtime.Equal(files[j].mtime) {
+			return files[i].path < files[j].path
+		}
+		return files[i].mtime.Before(files[j].mtime)
+	})
+	l := make([]string, 0, len(files))
+	for _, f := range files {
+		l = append(l, f.path)
+	}
+	return l, nil
+}
+
+func (t *logTail) Close() error {
+	t.closed.Store(true)
+	if t.cur != nil {
+		return t.cur.Close()
+	}
+	return nil
+}
+
+func (t *logTail) Read(p []byte) (int, error) {
+	for len(t.buf) == 0 {
+		if t.closed.Load() {
+			return 0, io.EOF
+		}
+
+		if t.cur == nil {
+			if len(t.queue) == 0 {
+				if t.pass > 0 {
+					if !t.follow {
+						return 0, io.EOF
+					}
+					time.Sleep(time.Second)
+				}
+
+				var err error
+				t.queue, err = t.list()
+				if err != nil {
+					return 0, err
+				}
+				t.pass++
+				continue
+			}
+
+			err := t.open(t.queue[0])
+			t.queue = t.queue[1:]
+			if err != nil {
+				return 0, err
+			}
+			continue
+		}
+
+		n, err := t.cur.Read(t.chunk)
+		t.lines(t.chunk[:n])
+		if err == nil {
+			continue
+		}
+
+		// A compressed file may still be written to by logrotate when
+		// following; try again on the next pass.
+		if err == io.ErrUnexpectedEOF && t.curComp && t.follow {
+			t.close(false)
+			continue
+		}
+		if err != io.EOF {
+			path := t.curFile.Path
+			t.close(false)
+			return 0, fmt.Errorf("reading %s: %w", path, err)
+		}
+
+		// Compressed files are never appended to, so always read the last line.
+		// For other files the last line is read once it's complete, unless
+		// we're just reading a file once.
+		if len(t.partial) > 0 && (t.curComp || (!t.follow && t.chk.path == "")) {
+			t.buf = append(append(t.buf, t.partial...), '\n')
+			t.chk.push(t.curFile, int64(len(t.partial)))
+			t.partial = t.partial[:0]
+		}
+		t.close(true)
+	}
+
+	n := copy(p, t.buf)
+	t.buf = t.buf[n:]
+	return n, nil
+}
+
+// close the current file; eof is set if everything was read.
+func (t *logTail) close(eof bool) {
+	if eof && len(t.partial) == 0 {
+		size := t.curSize
+		if !t.curComp { // May have been appended to while reading.
+			size = t.curFile.read
+		}
+		t.chk.eof(t.curFile, size)
+	}
+	t.partial = t.partial[:0]
+	t.cur.Close()
+	t.cur, t.curFile = nil, nil
+}
+
+// lines adds all complete lines in data to the buffer, and records their
+// position in the checkpoint.
+func (t *logTail) lines(data []byte) {
+	for len(data) > 0 {
+		i := bytes.IndexByte(data, '\n')
+		if i == -1 {
+			t.partial = append(t.partial, data...)
+			return
+		}
+
+		n := len(t.partial) + i + 1
+		t.buf = append(t.buf, t.partial...)
+		t.buf = append(t.buf, data[:i+1]...)
+		t.partial = t.partial[:0]
+		data = data[i+1:]
+		t.chk.push(t.curFile, int64(n))
+	}
+}
+
+// open a file and seek to the position from the checkpoint.
+func (t *logTail) open(path string) error {
+	st, err := os.Stat(path)
+	if err != nil { // Removed since we listed it.
+		return nil
+	}
+	if t.chk.done(path, inode(st), st.Size()) != nil {
+		return nil
+	}
+
+	fp, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	comp := isCompressed(path)
+	r, err := decompress(path, fp)
+	if err != nil {
+		fp.Close()
+		if t.follow { // May still be written to.
+			return nil
+		}
+		return err
+	}
+
+	head := make([]byte, headLen)
+	n, err := io.ReadFull(r, head)
+	head = head[:n]
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		r.Close()
+		return fmt.Errorf("reading %s: %w", path, err)
+	}
+	if n == 0 {
+		r.Close()
+		return nil
+	}
+
+	f := t.chk.match(path, inode(st), head, st.Size(), comp)
+	if f == nil {
+		f = t.chk.add(path, inode(st), head)
+
+		// Don't import existing lines when following, unless there is a
+		// checkpoint from a previous run.
+		if t.follow && t.pass == 1 && !t.chk.existed {
+			var end int64
+			if comp {
+				n, _ := io.Copy(io.Discard, r)
+				end = int64(len(head)) + n
+			} else {
+				end, err = lastLineEnd(fp, st.Size())
+				if err != nil {
+					r.Close()
+					return fmt.Errorf("reading %s: %w", path, err)
+				}
+			}
+			t.chk.skip(f, end)
+			r.Close()
+			return nil
+		}
+	}
+
+	if comp {
+		if f.read < int64(len(head)) {
+			r = readCloser{io.MultiReader(bytes.NewReader(head[f.read:]), r), r}
+		} else if _, err := io.CopyN(io.Discard, r, f.read-int64(len(head))); err != nil {
+			r.Close()
+			if t.follow {
+				return nil
+			}
+			return fmt.Errorf("reading %s: %w", path, err)
+		}
+	} else {
+		if f.read >= st.Size() {
+			r.Close()
+			return nil
+		}
+		if _, err := fp.Seek(f.read, io.SeekStart); err != nil {
+			r.Close()
+			return err
+		}
+	}
+
+	t.cur, t.curFile, t.curComp, t.curSize = r, f, comp, st.Size()
+	return nil
+}
+
+// lastLineEnd gets the offset after the last newline in the file.
+func lastLineEnd(fp *os.File, size int64) (int64, error) {
+	buf := make([]byte, 4096)
+	for end := size; end > 0; {
+		start := max(end-int64(len(buf)), 0)
+		n, err := fp.ReadAt(buf[:end-start], start)
+		if err != nil && err != io.EOF {
+			return 0, err
+		}
+		if i := bytes.LastIndexByte(buf[:n], '\n'); i > -1 {
+			return start + int64(i) + 1, nil
+		}
+		end = start
+	}
+	return 0, nil
+}
+
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
@@ -0,0 +1,12 @@
+// Copyright © Martin Tournoij – This file is part of GoatCounter and published
+// under the terms of a slightly modified EUPL v1.2 license, which can be found
+// in the LICENSE file or at https://license.goatcounter.com
+
+//go:build !unix
+
+package main
+
+import "os"
+
+// Files are recognized by the first bytes only on systems without inodes.
+func inode(st os.FileInfo) uint64 { return 0 }
@@ -0,0 +1,273 @@
+// Copyright © Martin Tournoij – This file is part of GoatCounter and published
+// under the terms of a slightly modified EUPL v1.2 license, which can be found
+// in the LICENSE file or at https://license.goatcounter.com
+
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"zgo.at/goatcounter/v2/handlers"
+	"zgo.at/zli"
+	"zgo.at/zlog"
+	"zgo.at/zstd/zstring"
+)
+
+// importSite is a site to send pageviews to.
+type importSite struct {
+	url, key string
+	hits     []handlers.APICountRequestHit
+}
+
+// importSites routes pageviews from a logfile to a site, based on the host.
+type importSites struct {
+	sites    []*importSite
+	hosts    map[string]*importSite // example.com
+	suffixes map[string]*importSite // *.example.com, stored as ".example.com"
+	def      *importSite            // * or -site; may be nil.
+}
+
+// newImportSites sends everything to one site.
+func newImportSites(url, key string) *importSites {
+	s := &importSite{url: siteURL(url), key: key}
+	return &importSites{sites: []*importSite{s}, def: s}
+}
+
+func siteURL(site string) string {
+	url := strings.TrimRight(site, "/")
+	if !zstring.HasPrefixes(url, "http://", "https://") {
+		url = "https://" + url
+	}
+	return url
+}
+
+// loadImportSites loads the host → site mapping from a file; every line is:
+//
+//	host  site  [key]
+//
+// The host can be "*.example.com" to match all subdomains, or "*" to match
+// everything else. If the key starts with "$" it's read from that environment
+// variable, and if it's omitted defKey is used. Blank lines and lines starting
+// with "#" are ignored.
+//
+// If defSite isn't "" it's used for hosts that don't match anything.
+func loadImportSites(file, defSite, defKey string) (*importSites, error) {
+	fp, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer fp.Close()
+	s, err := parseImportSites(fp, defSite, defKey)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", file, err)
+	}
+	return s, nil
+}
+
+func parseImportSites(r io.Reader, defSite, defKey string) (*importSites, error) {
+	var (
+		s = &importSites{
+			hosts:    make(map[string]*importSite),
+			suffixes: make(map[string]*importSite),
+		}
+		// Share the batch between hosts that go to the same site.
+		seen = make(map[[2]string]*importSite)
+		get  = func(url, key string) *importSite {
+			url = siteURL(url)
+			site, ok := seen[[2]string{url, key}]
+			if !ok {
+				site = &importSite{url: url, key: key}
+				seen[[2]string{url, key}] = site
+				s.sites = append(s.sites, site)
+			}
+			return site
+		}
+	)
+
+	scan := bufio.NewScanner(r)
+	for i := 1; scan.Scan(); i++ {
+		line := strings.TrimSpace(scan.Text())
+		if line == "" || line[0] == '#' {
+			continue
+		}
+
+		f := strings.Fields(line)
+		if len(f) < 2 || len(f) > 3 {
+			return nil, fmt.Errorf("line %d: need two or three fields (host, site, key), but have %d", i, len(f))
+		}
+		key := defKey
+		if len(f) == 3 {
+			key = f[2]
+			if strings.HasPrefix(key, "$") {
+				key = os.Getenv(key[1:])
+				if key == "" {
+					return nil, fmt.Errorf("line %d: environment variable %s is not set", i, f[2])
+				}
+			}
+		}
+		if key == "" {
+			return nil, fmt.Errorf("line %d: no key and GOATCOUNTER_API_KEY is not set", i)
+		}
+
+		var (
+			host = normalizeHost(f[0])
+			site = get(f[1], key)
+			dup  bool
+		)
+		switch {
+		case host == "*":
+			dup, s.def = s.def != nil, site
+		case strings.HasPrefix(host, "*."):
+			_, dup = s.suffixes[host[1:]]
+			s.suffixes[host[1:]] = site
+		default:
+			_, dup = s.hosts[host]
+			s.hosts[host] = site
+		}
+		if dup {
+			return nil, fmt.Errorf("line %d: host %q is listed more than once", i, f[0])
+		}
+	}
+	if err := scan.Err(); err != nil {
+		return nil, err
+	}
+
+	if defSite != "" && s.def == nil {
+		if defKey == "" {
+			return nil, fmt.Errorf("no key for -site and GOATCOUNTER_API_KEY is not set")
+		}
+		s.def = get(defSite, defKey)
+	}
+	if len(s.sites) == 0 {
+		return nil, fmt.Errorf("no sites")
+	}
+	return s, nil
+}
+
+func normalizeHost(host string) string {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	return strings.TrimSuffix(strings.ToLower(host), ".")
+}
+
+// get the site for this host, or nil if there is no site for this host.
+func (s *importSites) get(host string) *importSite {
+	if len(s.sites) == 1 && s.def != nil {
+		return s.def
+	}
+
+	host = normalizeHost(host)
+	if site, ok := s.hosts[host]; ok {
+		return site
+	}
+	for h := host; h != ""; {
+		i := strings.IndexByte(h, '.')
+		if i == -1 {
+			break
+		}
+		h = h[i:]
+		if site, ok := s.suffixes[h]; ok {
+			return site
+		}
+		h = h[1:]
+	}
+	return s.def
+}
+
+// logSender sends pageviews from logfiles in batches of 100 per site.
+type logSender struct {
+	sites          *importSites
+	chk            *checkpoint
+	silent, follow bool
+
+	mu      sync.Mutex
+	n       int    // Pageviews sent.
+	lineno  uint64 // Last line added.
+	skipped int    // Pageviews without a site.
+	fails   int    // Number of sends that failed in a row.
+}
+
+// add a pageview for this host, and send the batch for this site if it's full.
+func (s *logSender) add(host string, hit handlers.APICountRequestHit) {
+	s.mu.Lock()
+	s.lineno = hit.LineNo
+	site := s.sites.get(host)
+	if site == nil {
+		s.skipped++
+		s.mu.Unlock()
+		return
+	}
+	site.hits = append(site.hits, hit)
+	full := len(site.hits) >= 100
+	s.mu.Unlock()
+
+	if full {
+		s.send(false)
+	}
+}
+
+// send the pending pageviews for all sites.
+//
+// All sites are sent at the same time, so that the lines can be committed to
+// the checkpoint. If final is set then all lines are committed, rather than
+// just the lines up to the last pageview.
+//
+// Pageviews that failed to send are kept and sent again on the next call, and
+// nothing is committed to the checkpoint until they're sent. This waits a bit
+// longer before every retry; as add() calls this, reading the log is paused
+// while the server can't be reached.
+func (s *logSender) send(final bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.fails > 0 {
+		time.Sleep(min(time.Duration(s.fails*s.fails)*time.Second, time.Minute))
+	}
+
+	var sendErr error
+	for _, site := range s.sites.sites {
+		if len(site.hits) == 0 {
+			continue
+		}
+		err := importSend(site.url, site.key, s.silent, s.follow, site.hits)
+		if err != nil {
+			zlog.Error(err)
+			sendErr = err
+			continue
+		}
+		s.n += len(site.hits)
+		site.hits = site.hits[:0]
+	}
+	if !s.silent && !s.follow {
+		zli.ReplaceLinef("Imported %d rows", s.n)
+	}
+	if final && s.skipped > 0 {
+		fmt.Fprintf(zli.Stderr, "\nSkipped %d pageviews for hosts without a site\n", s.skipped)
+	}
+	if sendErr != nil {
+		s.fails++
+		return sendErr
+	}
+	s.fails = 0
+
+	if s.chk != nil {
+		l := s.lineno
+		if final {
+			l = math.MaxUint64
+		}
+		err := s.chk.commit(l)
+		if err != nil {
+			zlog.Error(err)
+		}
+	}
+	return nil
+}
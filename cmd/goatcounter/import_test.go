@@ -5,16 +5,29 @@
 package main
 
 import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
 	"context"
 	"fmt"
+	"io"
+	"math"
+	"net"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"path/filepath"
+	"reflect"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 
+	"github.com/klauspost/compress/zstd"
 	"zgo.at/goatcounter/v2"
 	"zgo.at/goatcounter/v2/cron"
+	"zgo.at/goatcounter/v2/handlers"
+	"zgo.at/json"
 	"zgo.at/zdb"
 	"zgo.at/zli"
 	"zgo.at/zstd/zslice"
@@ -243,3 +256,336 @@ func TestImport(t *testing.T) {
 	stopServer <- struct{}{}
 	mainDone.Wait()
 }
+
+func TestLogTail(t *testing.T) {
+	var (
+		dir   = t.TempDir()
+		mtime = time.Now().Add(-time.Hour)
+	)
+	write := func(name string, data []byte) {
+		t.Helper()
+		name = filepath.Join(dir, name)
+		os.MkdirAll(filepath.Dir(name), 0o755)
+		err := os.WriteFile(name, data, 0o644)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// Make sure the files are read in the order they're written.
+		mtime = mtime.Add(time.Second)
+		os.Chtimes(name, mtime, mtime)
+	}
+	appendTo := func(name, data string) {
+		t.Helper()
+		fp, err := os.OpenFile(filepath.Join(dir, name), os.O_APPEND|os.O_WRONLY, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		fp.WriteString(data)
+		fp.Close()
+		mtime = mtime.Add(time.Second)
+		os.Chtimes(filepath.Join(dir, name), mtime, mtime)
+	}
+	read := func(chk string, patterns ...string) (string, *logTail) {
+		t.Helper()
+		for i := range patterns {
+			patterns[i] = filepath.Join(dir, patterns[i])
+		}
+		if chk != "" {
+			chk = filepath.Join(dir, chk)
+		}
+		tail, err := newLogTail(patterns, chk, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer tail.Close()
+		have, err := io.ReadAll(tail)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(have), tail
+	}
+	compress := func(data string, zst bool) []byte {
+		buf := new(bytes.Buffer)
+		var w io.WriteCloser = gzip.NewWriter(buf)
+		if zst {
+			w, _ = zstd.NewWriter(buf)
+		}
+		w.Write([]byte(data))
+		w.Close()
+		return buf.Bytes()
+	}
+
+	t.Run("dir", func(t *testing.T) {
+		write("dir/c.log", []byte("a1\na2\n"))
+		write("dir/b.log.gz", compress("b1\nb2", false))
+		write("dir/a.log.zst", compress("c1\n", true))
+		write("dir/.hidden", []byte("x\n"))
+		write("dir/sub/d.log", []byte("x\n"))
+		write("dir/empty", nil)
+
+		have, _ := read("", "dir")
+		if want := "a1\na2\nb1\nb2\nc1\n"; have != want {
+			t.Errorf("\nhave: %q\nwant: %q", have, want)
+		}
+	})
+
+	t.Run("checkpoint", func(t *testing.T) {
+		test := func(want string, commit uint64) {
+			t.Helper()
+			have, tail := read("chk.json", "access.log*")
+			if have != want {
+				t.Errorf("\nhave: %q\nwant: %q", have, want)
+			}
+			err := tail.chk.commit(commit)
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+		rename := func(from, to string) {
+			t.Helper()
+			err := os.Rename(filepath.Join(dir, from), filepath.Join(dir, to))
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		// Incomplete last line isn't read, and only the first line is sent.
+		write("access.log", []byte("l1\nl2\npart"))
+		test("l1\nl2\n", 1)
+		test("l2\n", math.MaxUint64)
+
+		// Rotated: continue in the old file and read the new one.
+		appendTo("access.log", "ial\nl3\n")
+		rename("access.log", "access.log.1")
+		write("access.log", []byte("n1\n"))
+		test("partial\nl3\nn1\n", math.MaxUint64)
+		test("", math.MaxUint64)
+
+		// Old file is compressed, which gives it a new inode.
+		old, _ := os.ReadFile(filepath.Join(dir, "access.log.1"))
+		os.Remove(filepath.Join(dir, "access.log.1"))
+		write("access.log.2.gz", compress(string(old), false))
+		appendTo("access.log", "n2\n")
+		test("n2\n", math.MaxUint64)
+
+		// Copied and truncated with copytruncate.
+		cp, _ := os.ReadFile(filepath.Join(dir, "access.log"))
+		appendTo("access.log", "n3\n")
+		write("access.log.1", append(cp, "n3\n"...))
+		os.Truncate(filepath.Join(dir, "access.log"), 0)
+		appendTo("access.log", "t1\n")
+		test("n3\nt1\n", math.MaxUint64)
+
+		// Restarting doesn't read anything.
+		test("", math.MaxUint64)
+	})
+
+	t.Run("follow", func(t *testing.T) {
+		write("follow/log", []byte("existing\n"))
+		tail, err := newLogTail([]string{filepath.Join(dir, "follow", "*")}, "", true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer tail.Close()
+
+		lines := make(chan string)
+		go func() {
+			s := bufio.NewScanner(tail)
+			for s.Scan() {
+				lines <- s.Text()
+			}
+		}()
+
+		time.Sleep(100 * time.Millisecond)
+		appendTo("follow/log", "new\n")
+		write("follow/log.new", []byte("new file\n"))
+
+		for _, want := range []string{"new", "new file"} {
+			select {
+			case have := <-lines:
+				if have != want {
+					t.Errorf("have %q; want %q", have, want)
+				}
+			case <-time.After(5 * time.Second):
+				t.Fatalf("timeout waiting for %q", want)
+			}
+		}
+	})
+}
+
+func TestImportSites(t *testing.T) {
+	t.Setenv("SITE_B_KEY", "key-b")
+	sites, err := parseImportSites(strings.NewReader(`
+		# host           site              key
+		example.com      a.example.com
+		www.example.com  a.example.com
+		*.example.net    https://b.example.com/  $SITE_B_KEY
+		other.com        c.example.com     key-c
+	`), "", "key-a")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct{ host, want string }{
+		{"example.com", "https://a.example.com key-a"},
+		{"WWW.example.com.", "https://a.example.com key-a"},
+		{"example.com:8080", "https://a.example.com key-a"},
+		{"x.example.net", "https://b.example.com key-b"},
+		{"x.y.example.net", "https://b.example.com key-b"},
+		{"example.net", "<nil>"},
+		{"other.com", "https://c.example.com key-c"},
+		{"x.example.com", "<nil>"},
+		{"", "<nil>"},
+	}
+	for _, tt := range tests {
+		have := "<nil>"
+		if s := sites.get(tt.host); s != nil {
+			have = s.url + " " + s.key
+		}
+		if have != tt.want {
+			t.Errorf("%q\nhave: %s\nwant: %s", tt.host, have, tt.want)
+		}
+	}
+	if len(sites.sites) != 3 {
+		t.Errorf("len(sites) = %d", len(sites.sites))
+	}
+
+	t.Run("default", func(t *testing.T) {
+		sites, err := parseImportSites(strings.NewReader("example.com a.example.com\n"), "d.example.com", "key")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if s := sites.get("other.com"); s == nil || s.url != "https://d.example.com" {
+			t.Errorf("%v", s)
+		}
+	})
+
+	t.Run("errors", func(t *testing.T) {
+		for _, tt := range [][2]string{
+			{"example.com\n", "line 1: need two or three fields"},
+			{"example.com a b c\n", "line 1: need two or three fields"},
+			{"\nexample.com a\n", "line 2: no key"},
+			{"example.com a $SITE_X_KEY\n", "environment variable $SITE_X_KEY is not set"},
+			{"example.com a k\nexample.com b k\n", `line 2: host "example.com" is listed more than once`},
+			{"# comment\n", "no sites"},
+		} {
+			_, err := parseImportSites(strings.NewReader(tt[0]), "", "")
+			if !ztest.ErrorContains(err, tt[1]) {
+				t.Errorf("%q: %v", tt[0], err)
+			}
+		}
+	})
+}
+
+func TestLogSender(t *testing.T) {
+	var (
+		mu   sync.Mutex
+		reqs []string
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body handlers.APICountRequest
+		json.NewDecoder(r.Body).Decode(&body)
+		mu.Lock()
+		reqs = append(reqs, fmt.Sprintf("%s %s %d", r.Host[:1], r.Header.Get("Authorization"), len(body.Hits)))
+		mu.Unlock()
+		w.WriteHeader(202)
+	}))
+	defer srv.Close()
+
+	// Use different hostnames to tell the sites apart.
+	a := strings.Replace(srv.URL, "127.0.0.1", "a.localhost", 1)
+	b := strings.Replace(srv.URL, "127.0.0.1", "b.localhost", 1)
+	sites, err := parseImportSites(strings.NewReader(
+		"a.com "+a+" key-a\n"+
+			"b.com "+b+" key-b\n"), "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	importClient.Transport = &http.Transport{
+		DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, network, srv.Listener.Addr().String())
+		},
+	}
+	defer func() { importClient.Transport = nil }()
+
+	chk, _ := loadCheckpoint("")
+	f := chk.add("log", 1, []byte("x"))
+	send := &logSender{sites: sites, chk: chk, silent: true}
+	for i := 1; i <= 250; i++ {
+		chk.push(f, 1)
+		host := "a.com"
+		if i%5 == 0 {
+			host = "b.com"
+		} else if i%7 == 0 {
+			host = "unknown.com"
+		}
+		send.add(host, handlers.APICountRequestHit{LineNo: uint64(i), Path: "/"})
+	}
+	if f.Offset != 144 {
+		t.Errorf("offset after 100 lines for site a: %d", f.Offset)
+	}
+	send.send(true)
+	if f.Offset != 250 {
+		t.Errorf("offset at end: %d", f.Offset)
+	}
+
+	want := []string{
+		"a Bearer key-a 100", "b Bearer key-b 28",
+		"a Bearer key-a 72", "b Bearer key-b 22",
+	}
+	if !reflect.DeepEqual(reqs, want) {
+		t.Errorf("\nhave: %v\nwant: %v", reqs, want)
+	}
+	if send.skipped != 28 {
+		t.Errorf("skipped: %d", send.skipped)
+	}
+}
+
+func TestLogSenderRetry(t *testing.T) {
+	var (
+		mu   sync.Mutex
+		reqs []string
+		fail = true
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body handlers.APICountRequest
+		json.NewDecoder(r.Body).Decode(&body)
+		mu.Lock()
+		defer mu.Unlock()
+		if fail {
+			fail = false
+			reqs = append(reqs, fmt.Sprintf("fail %d", len(body.Hits)))
+			w.WriteHeader(500)
+			return
+		}
+		reqs = append(reqs, fmt.Sprintf("ok %d", len(body.Hits)))
+		w.WriteHeader(202)
+	}))
+	defer srv.Close()
+
+	chk, _ := loadCheckpoint("")
+	f := chk.add("log", 1, []byte("x"))
+	send := &logSender{sites: newImportSites(srv.URL, "key"), chk: chk, silent: true}
+	for i := 1; i <= 150; i++ {
+		chk.push(f, 1)
+		send.add("a.com", handlers.APICountRequestHit{LineNo: uint64(i), Path: "/"})
+		if i == 100 && f.Offset != 0 {
+			t.Errorf("offset after failed send: %d", f.Offset)
+		}
+		if i == 101 && f.Offset != 101 {
+			t.Errorf("offset after retry: %d", f.Offset)
+		}
+	}
+	err := send.send(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.Offset != 150 {
+		t.Errorf("offset at end: %d", f.Offset)
+	}
+
+	want := []string{"fail 100", "ok 101", "ok 49"}
+	if !reflect.DeepEqual(reqs, want) {
+		t.Errorf("\nhave: %v\nwant: %v", reqs, want)
+	}
+}
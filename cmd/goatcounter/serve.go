@@ -14,6 +14,7 @@ import (
 	"os/exec"
 	"os/signal"
 	"os/user"
+	"path/filepath"
 	"runtime/debug"
 	"strings"
 	"syscall"
@@ -144,6 +145,51 @@ Flags:
                Higher values will give better performance, but it will take a
                bit longer for pageviews to show. The default is 10 seconds.
 
+  -queue       Write pageviews to this file before they're persisted to the
+               database, so they're not lost if GoatCounter is stopped
+               unexpectedly or if the database is unavailable; they're loaded
+               again on startup. The default is to only keep them in memory.
+
+               Pageviews that fail to be stored 5 times in a row are moved to
+               the same file with ".failed" appended; append this file to the
+               queue while GoatCounter isn't running to try them again.
+
+  -queue-max   Maximum number of pageviews waiting to be persisted; /count and
+               /api/v0/count will return "503 Service Unavailable" if there are
+               more than this. Default: 0 (no limit).
+
+  -shared      Run several "goatcounter serve" processes with the same
+               database, for example behind a load balancer:
+
+                 - sessions are stored in the database rather than in memory,
+                   so that unique visitors are counted correctly no matter
+                   which process a pageview is sent to;
+                 - tasks such as email reports and data retention are run by
+                   only one process;
+                 - ACME certificates are stored in the database; the -tls
+                   acme:cache directory is only read for existing
+                   certificates.
+
+               The "Right now" widget on the dashboard only shows visitors
+               whose pageviews were sent to the same process.
+
+               Exports from Settings → Export are written to the temporary
+               directory of the process that created them, and can only be
+               downloaded from that process; configure the load balancer to
+               use sticky sessions if you use exports. Scheduled exports to an
+               S3-compatible bucket work with any process.
+
+  -export-dir  Allow scheduled exports to be stored in directories below this
+               directory. Scheduled exports can only be stored in S3-compatible
+               buckets if this isn't set, which is the default.
+
+  -allow-private-urls
+               Allow webhooks, alert webhooks, and scheduled exports to S3 to
+               connect to loopback, private, and link-local addresses (e.g.
+               localhost or 192.168.1.1). This is disabled by default as it
+               would allow anyone who can add a webhook to send requests to
+               services on the internal network.
+
   -dev         Start in "dev mode".
 
   -debug       Modules to debug, comma-separated or 'all' for all modules.
@@ -163,6 +209,8 @@ func cmdServe(f zli.Flags, ready chan<- struct{}, stop chan struct{}) error {
 		// TODO(depr): -port is for compat with <2.0
 		port         = f.Int(0, "public-port", "port").Pointer()
 		domainStatic = f.String("", "static").Pointer()
+		allowPrivate = f.Bool(false, "allow-private-urls").Pointer()
+		exportDir    = f.String("", "export-dir").Pointer()
 	)
 	dbConnect, dbConn, dev, automigrate, listen, flagTLS, from, websocket, apiMax, err := flagsServe(f, &v)
 	if err != nil {
@@ -187,6 +235,9 @@ func cmdServe(f zli.Flags, ready chan<- struct{}, stop chan struct{}) error {
 
 		//from := flagFrom(from, "cfg.Domain", &v)
 		from := flagFrom(from, "", &v)
+		if *exportDir != "" && !filepath.IsAbs(*exportDir) {
+			v.Append("-export-dir", "must be an absolute path")
+		}
 		if v.HasErrors() {
 			return v
 		}
@@ -206,6 +257,8 @@ func cmdServe(f zli.Flags, ready chan<- struct{}, stop chan struct{}) error {
 		c.URLStatic = urlStatic
 		c.DomainCount = domainCount
 		c.Websocket = websocket
+		c.AllowPrivateURLs = *allowPrivate
+		c.ExportDir = *exportDir
 
 		// Set up HTTP handler and servers.
 		hosts := map[string]http.Handler{
@@ -319,6 +372,9 @@ func flagsServe(f zli.Flags, v *zvalidate.Validator) (string, string, bool, bool
 		ratelimit   = f.String("", "ratelimit").Pointer()
 		apiMax      = f.Int(0, "api-max").Pointer()
 		storeEvery  = f.Int(10, "store-every").Pointer()
+		queue       = f.String("", "queue").Pointer()
+		queueMax    = f.Int(0, "queue-max").Pointer()
+		shared      = f.Bool(false, "shared").Pointer()
 		websocket   = f.Bool(false, "websocket").Pointer()
 	)
 	err := f.Parse()
@@ -342,6 +398,13 @@ func flagsServe(f zli.Flags, v *zvalidate.Validator) (string, string, bool, bool
 	v.Range("-store-every", int64(*storeEvery), 1, 0)
 	cron.SetPersistInterval(time.Duration(*storeEvery) * time.Second)
 
+	v.Range("-queue-max", int64(*queueMax), 0, 0)
+	goatcounter.Memstore.SetQueue(*queue, *queueMax)
+
+	goatcounter.Memstore.SetShared(*shared)
+	cron.SetShared(*shared)
+	acme.SetShared(*shared)
+
 	goatcounter.InitGeoDB(*geodb)
 
 	if *ratelimit != "" {
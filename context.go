@@ -49,6 +49,14 @@ type GlobalConfig struct {
 	Websocket      bool
 	EmailFrom      string
 	BcryptMinCost  bool
+
+	// Allow webhooks, alerts, and scheduled exports to connect to loopback,
+	// private, and link-local addresses.
+	AllowPrivateURLs bool
+
+	// Scheduled exports can only be stored in directories below this
+	// directory; local directories can't be used if this is empty.
+	ExportDir string
 }
 
 // WithSite adds the site to the context.
@@ -0,0 +1,135 @@
+// Copyright © Martin Tournoij – This file is part of GoatCounter and published
+// under the terms of a slightly modified EUPL v1.2 license, which can be found
+// in the LICENSE file or at https://license.goatcounter.com
+
+package cron
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"zgo.at/blackmail"
+	"zgo.at/errors"
+	"zgo.at/goatcounter/v2"
+	"zgo.at/zlog"
+	"zgo.at/zstd/zjson"
+	"zgo.at/zstd/ztime"
+)
+
+var (
+	al          = zlog.Module("alerts")
+	alertClient = http.Client{Timeout: 10 * time.Second, Transport: goatcounter.PublicTransport}
+)
+
+// alerts checks all alert rules against the previous hour, and sends a
+// notification for every rule that triggers.
+func alerts(ctx context.Context) error {
+	var rules goatcounter.AlertRules
+	err := rules.UnscopedList(ctx)
+	if err != nil {
+		return errors.Errorf("cron.alerts: %w", err)
+	}
+
+	var (
+		now  = ztime.Now().UTC()
+		site goatcounter.Site
+	)
+	for _, r := range rules {
+		if r.Cooling(now) {
+			continue
+		}
+		if site.ID != r.SiteID {
+			err := site.ByID(ctx, r.SiteID)
+			if err != nil {
+				al.Field("rule", r.ID).Error(err)
+				continue
+			}
+		}
+		ctx := goatcounter.WithSite(ctx, &site)
+
+		msg, err := r.Check(ctx, now)
+		if err != nil {
+			al.Field("rule", r.ID).Error(err)
+			continue
+		}
+		if msg == "" {
+			continue
+		}
+
+		// Record this first, so that a failing webhook doesn't result in
+		// sending the email every hour.
+		err = r.Fired(ctx, now)
+		if err != nil {
+			al.Field("rule", r.ID).Error(err)
+			continue
+		}
+		if r.Email != "" {
+			err := alertEmail(ctx, site, r, msg)
+			if err != nil {
+				al.Field("rule", r.ID).Error(err)
+			}
+		}
+		if r.WebhookURL != "" {
+			err := alertWebhook(ctx, site, r, msg, now)
+			if err != nil {
+				al.Field("rule", r.ID).Error(err)
+			}
+		}
+	}
+	return nil
+}
+
+func alertEmail(ctx context.Context, site goatcounter.Site, r goatcounter.AlertRule, msg string) error {
+	text := fmt.Sprintf("Alert ‘%s’ for %s was triggered:\n\n%s\n\nRule: %s.\n\nEdit alerts: %s/settings/alerts\n",
+		r.Name, site.Display(ctx), msg, r.Describe(), site.URL(ctx))
+
+	err := blackmail.Send(fmt.Sprintf("GoatCounter alert for %s: %s", site.Display(ctx), r.Name),
+		blackmail.From("GoatCounter alerts", goatcounter.Config(ctx).EmailFrom),
+		blackmail.To(r.Email),
+		blackmail.BodyText([]byte(text)))
+	return errors.Wrap(err, "alertEmail")
+}
+
+// AlertPayload is the body sent to the webhook of an alert rule.
+type AlertPayload struct {
+	Site    string    `json:"site"`
+	RuleID  int64     `json:"rule_id"`
+	Name    string    `json:"name"`
+	Kind    string    `json:"kind"`
+	Message string    `json:"message"`
+	FiredAt time.Time `json:"fired_at"`
+}
+
+func alertWebhook(ctx context.Context, site goatcounter.Site, r goatcounter.AlertRule, msg string, now time.Time) error {
+	body := zjson.MustMarshal(AlertPayload{
+		Site:    site.URL(ctx),
+		RuleID:  r.ID,
+		Name:    r.Name,
+		Kind:    r.Kind,
+		Message: msg,
+		FiredAt: now,
+	})
+
+	req, err := http.NewRequestWithContext(ctx, "POST", r.WebhookURL, bytes.NewReader(body))
+	if err != nil {
+		return errors.Wrap(err, "alertWebhook")
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "GoatCounter")
+	req.Header.Set("X-Goatcounter-Signature", goatcounter.SignWebhook(r.WebhookSecret, body))
+
+	resp, err := alertClient.Do(req)
+	if err != nil {
+		return errors.Wrap(err, "alertWebhook")
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode >= 300 {
+		return errors.Errorf("alertWebhook: %s: %s", r.WebhookURL, resp.Status)
+	}
+	return nil
+}
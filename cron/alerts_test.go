@@ -0,0 +1,114 @@
+// Copyright © Martin Tournoij – This file is part of GoatCounter and published
+// under the terms of a slightly modified EUPL v1.2 license, which can be found
+// in the LICENSE file or at https://license.goatcounter.com
+
+package cron_test
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"zgo.at/blackmail"
+	"zgo.at/goatcounter/v2"
+	"zgo.at/goatcounter/v2/cron"
+	"zgo.at/goatcounter/v2/gctest"
+	"zgo.at/zstd/zjson"
+	"zgo.at/zstd/ztime"
+)
+
+func TestAlerts(t *testing.T) {
+	ctx := gctest.DB(t)
+	goatcounter.Config(ctx).EmailFrom = "test@goatcounter.localhost.com"
+	goatcounter.Config(ctx).AllowPrivateURLs = true // httptest server is on localhost.
+
+	now := time.Date(2019, 6, 17, 15, 1, 0, 0, time.UTC)
+	ztime.Now = func() time.Time { return now }
+	t.Cleanup(func() { ztime.Now = func() time.Time { return time.Now().UTC() } })
+
+	var (
+		hooks [][]byte
+		sigs  []string
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		hooks = append(hooks, b)
+		sigs = append(sigs, r.Header.Get("X-Goatcounter-Signature"))
+	}))
+	defer srv.Close()
+
+	rule := goatcounter.AlertRule{
+		Name:       "Blog traffic",
+		Kind:       goatcounter.AlertPath,
+		Path:       "/blog/*",
+		Threshold:  1,
+		Cooldown:   120,
+		Email:      "alerts@example.com",
+		WebhookURL: srv.URL,
+	}
+	err := rule.Insert(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	gctest.StoreHits(ctx, t, false,
+		goatcounter.Hit{FirstVisit: true, Path: "/blog/a", CreatedAt: now.Add(-30 * time.Minute)},
+		goatcounter.Hit{FirstVisit: true, Path: "/blog/b", CreatedAt: now.Add(-30 * time.Minute)},
+	)
+
+	buf := new(bytes.Buffer)
+	blackmail.DefaultMailer = blackmail.NewMailer(blackmail.ConnectWriter, blackmail.MailerOut(buf))
+
+	run := func() {
+		t.Helper()
+		err := cron.TaskAlerts()
+		if err != nil {
+			t.Fatal(err)
+		}
+		cron.WaitAlerts()
+	}
+	run()
+
+	mail := buf.String()
+	for _, w := range []string{
+		"Subject: GoatCounter alert for",
+		"To: <alerts@example.com>",
+		"Paths matching /blog/* got 2 visitors between 14:00 and 15:00 UTC.",
+	} {
+		if !strings.Contains(mail, w) {
+			t.Errorf("email doesn't contain %q:\n%s", w, mail)
+		}
+	}
+
+	if len(hooks) != 1 {
+		t.Fatalf("len(hooks) = %d", len(hooks))
+	}
+	var p cron.AlertPayload
+	zjson.MustUnmarshal(hooks[0], &p)
+	if p.RuleID != rule.ID || p.Kind != "path" || !strings.Contains(p.Message, "got 2 visitors") {
+		t.Errorf("wrong payload: %s", hooks[0])
+	}
+	if want := goatcounter.SignWebhook(rule.WebhookSecret, hooks[0]); sigs[0] != want {
+		t.Errorf("wrong signature\nhave: %s\nwant: %s", sigs[0], want)
+	}
+
+	// Don't send again before the cooldown expires.
+	buf.Reset()
+	now = now.Add(time.Hour)
+	run()
+	if buf.Len() > 0 || len(hooks) != 1 {
+		t.Errorf("sent during cooldown")
+	}
+
+	err = rule.ByID(ctx, rule.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rule.LastFiredAt == nil || !rule.LastFiredAt.Equal(now.Add(-time.Hour)) {
+		t.Errorf("wrong LastFiredAt: %v", rule.LastFiredAt)
+	}
+}
@@ -33,7 +33,7 @@ func TestBrowserStats(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	want := `{false [{ Firefox 1 <nil>}]}`
+	want := `{false [{ Firefox 1 <nil> <nil>}]}`
 	out := fmt.Sprintf("%v", stats)
 	if want != out {
 		t.Errorf("\nwant: %s\nout:  %s", want, out)
@@ -54,7 +54,7 @@ func TestBrowserStats(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	want = `{false [{ Firefox 2 <nil>} { Chrome 1 <nil>}]}`
+	want = `{false [{ Firefox 2 <nil> <nil>} { Chrome 1 <nil> <nil>}]}`
 	out = fmt.Sprintf("%v", stats)
 	if want != out {
 		t.Errorf("\nwant: %s\nout:  %s", want, out)
@@ -67,7 +67,7 @@ func TestBrowserStats(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	want = `{false [{ Firefox 68 1 <nil>} { Firefox 69 1 <nil>}]}`
+	want = `{false [{ Firefox 68 1 <nil> <nil>} { Firefox 69 1 <nil> <nil>}]}`
 	out = fmt.Sprintf("%v", stats)
 	if want != out {
 		t.Errorf("\nwant: %s\nout:  %s", want, out)
@@ -21,6 +21,7 @@ type Task struct {
 	Desc   string
 	Fun    func(context.Context) error
 	Period time.Duration
+	Local  bool // Run in every process, rather than once with SetShared().
 }
 
 func (t Task) ID() string {
@@ -28,13 +29,16 @@ func (t Task) ID() string {
 }
 
 var Tasks = []Task{
-	{"vacuum pageviews (data retention)", dataRetention, 1 * time.Hour},
-	{"renew ACME certs", renewACME, 2 * time.Hour},
-	{"vacuum soft-deleted sites", vacuumDeleted, 12 * time.Hour},
-	{"rm old exports", oldExports, 1 * time.Hour},
-	{"cycle sessions", sessions, 1 * time.Minute},
-	{"send email reports", emailReports, 1 * time.Hour},
-	{"persist hits", persistAndStat, time.Duration(persistInterval.Load())},
+	{"vacuum pageviews (data retention)", dataRetention, 1 * time.Hour, false},
+	{"renew ACME certs", renewACME, 2 * time.Hour, false},
+	{"vacuum soft-deleted sites", vacuumDeleted, 12 * time.Hour, false},
+	{"rm old exports", oldExports, 1 * time.Hour, true},
+	{"cycle sessions", sessions, 1 * time.Minute, true},
+	{"send email reports", emailReports, 1 * time.Hour, false},
+	{"check alert rules", alerts, 1 * time.Hour, false},
+	{"retry webhooks", webhooks, 1 * time.Minute, false},
+	{"run scheduled exports", scheduledExports, 1 * time.Hour, false},
+	{"persist hits", persistAndStat, time.Duration(persistInterval.Load()), true},
 }
 
 var (
@@ -51,6 +55,11 @@ func SetPersistInterval(d time.Duration) {
 	persistInterval.Store(int64(d))
 }
 
+// PersistInterval gets the interval pageviews are persisted on.
+func PersistInterval() time.Duration {
+	return time.Duration(persistInterval.Load())
+}
+
 // Start running tasks in the background.
 func Start(ctx context.Context) {
 	if started.Value() == 1 {
@@ -86,6 +95,16 @@ func Start(ctx context.Context) {
 				if stopped.Value() == 1 {
 					return
 				}
+				if shared.Value() == 1 && !t.Local {
+					ok, err := Lease(ctx, id, t.Period-t.Period/10)
+					if err != nil {
+						l.Error(err)
+						continue
+					}
+					if !ok {
+						continue
+					}
+				}
 
 				err := bgrun.RunTask("cron:" + id)
 				if err != nil {
@@ -104,17 +123,23 @@ func Stop() error {
 	return nil
 }
 
-func TaskOldExports() error     { return bgrun.RunTask("cron:oldExports") }
-func TaskDataRetention() error  { return bgrun.RunTask("cron:dataRetention") }
-func TaskVacuumOldSites() error { return bgrun.RunTask("cron:vacuumDeleted") }
-func TaskACME() error           { return bgrun.RunTask("cron:renewACME") }
-func TaskSessions() error       { return bgrun.RunTask("cron:sessions") }
-func TaskEmailReports() error   { return bgrun.RunTask("cron:emailReports") }
-func TaskPersistAndStat() error { return bgrun.RunTask("cron:persistAndStat") }
-func WaitOldExports()           { bgrun.Wait("cron:oldExports") }
-func WaitDataRetention()        { bgrun.Wait("cron:dataRetention") }
-func WaitVacuumOldSites()       { bgrun.Wait("cron:vacuumDeleted") }
-func WaitACME()                 { bgrun.Wait("cron:renewACME") }
-func WaitSessions()             { bgrun.Wait("cron:sessions") }
-func WaitEmailReports()         { bgrun.Wait("cron:emailReports") }
-func WaitPersistAndStat()       { bgrun.Wait("cron:persistAndStat") }
+func TaskOldExports() error       { return bgrun.RunTask("cron:oldExports") }
+func TaskDataRetention() error    { return bgrun.RunTask("cron:dataRetention") }
+func TaskVacuumOldSites() error   { return bgrun.RunTask("cron:vacuumDeleted") }
+func TaskACME() error             { return bgrun.RunTask("cron:renewACME") }
+func TaskSessions() error         { return bgrun.RunTask("cron:sessions") }
+func TaskEmailReports() error     { return bgrun.RunTask("cron:emailReports") }
+func TaskPersistAndStat() error   { return bgrun.RunTask("cron:persistAndStat") }
+func TaskAlerts() error           { return bgrun.RunTask("cron:alerts") }
+func TaskWebhooks() error         { return bgrun.RunTask("cron:webhooks") }
+func TaskScheduledExports() error { return bgrun.RunTask("cron:scheduledExports") }
+func WaitOldExports()             { bgrun.Wait("cron:oldExports") }
+func WaitDataRetention()          { bgrun.Wait("cron:dataRetention") }
+func WaitVacuumOldSites()         { bgrun.Wait("cron:vacuumDeleted") }
+func WaitACME()                   { bgrun.Wait("cron:renewACME") }
+func WaitSessions()               { bgrun.Wait("cron:sessions") }
+func WaitEmailReports()           { bgrun.Wait("cron:emailReports") }
+func WaitPersistAndStat()         { bgrun.Wait("cron:persistAndStat") }
+func WaitAlerts()                 { bgrun.Wait("cron:alerts") }
+func WaitWebhooks()               { bgrun.Wait("cron:webhooks") }
+func WaitScheduledExports()       { bgrun.Wait("cron:scheduledExports") }
@@ -0,0 +1,159 @@
+// Copyright © Martin Tournoij – This file is part of GoatCounter and published
+// under the terms of a slightly modified EUPL v1.2 license, which can be found
+// in the LICENSE file or at https://license.goatcounter.com
+
+package cron
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"zgo.at/blackmail"
+	"zgo.at/errors"
+	"zgo.at/goatcounter/v2"
+	"zgo.at/zlog"
+	"zgo.at/zstd/ztime"
+)
+
+// scheduledExports runs all export schedules that are due, and removes old
+// exports from the destination.
+func scheduledExports(ctx context.Context) error {
+	l := zlog.Module("export")
+
+	var scheds goatcounter.ExportSchedules
+	err := scheds.UnscopedList(ctx)
+	if err != nil {
+		return errors.Errorf("cron.scheduledExports: %w", err)
+	}
+
+	var (
+		now  = ztime.Now().UTC()
+		site goatcounter.Site
+	)
+	for _, s := range scheds {
+		if !s.Due(now) {
+			continue
+		}
+		if site.ID != s.SiteID {
+			err := site.ByID(ctx, s.SiteID)
+			if err != nil {
+				l.Field("schedule", s.ID).Error(err)
+				continue
+			}
+		}
+		ctx := goatcounter.WithSite(ctx, &site)
+
+		prevErr := s.LastError
+		lastHitID, err := runScheduledExport(ctx, site, s, now)
+		var errMsg string
+		if err != nil {
+			l.Field("schedule", s.ID).Error(err)
+			errMsg = err.Error()
+		}
+
+		err = s.Ran(ctx, now, lastHitID, errMsg)
+		if err != nil {
+			l.Field("schedule", s.ID).Error(err)
+		}
+
+		// Only send an email if it started failing, rather than on every
+		// run.
+		if errMsg != "" && prevErr == "" && s.Email != "" {
+			err := exportFailedEmail(ctx, site, s)
+			if err != nil {
+				l.Field("schedule", s.ID).Error(err)
+			}
+		}
+	}
+	return nil
+}
+
+// Name of an export file after the site code, as created by Export.Create.
+// Site codes are always lower case, so the date never matches another site.
+var reExportName = regexp.MustCompile(`^[0-9]{8}T[0-9]{6}Z-[0-9a-z]+\.[a-z.]+$`)
+
+// runScheduledExport exports everything since the last run, uploads it, and
+// removes expired files. It returns the last exported hit ID.
+func runScheduledExport(ctx context.Context, site goatcounter.Site, s goatcounter.ExportSchedule, now time.Time) (int64, error) {
+	store, err := s.Store(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	export := goatcounter.Export{Format: s.Format}
+	fp, err := export.Create(ctx, s.LastHitID)
+	if err != nil {
+		return 0, err
+	}
+	defer os.Remove(export.Path)
+	export.Run(ctx, fp, false)
+
+	err = export.ByID(ctx, export.ID)
+	if err != nil {
+		return 0, err
+	}
+	if export.Error != nil {
+		return 0, errors.New(*export.Error)
+	}
+	if export.FinishedAt == nil || export.LastHitID == nil {
+		return 0, errors.New("export didn't finish")
+	}
+
+	if export.NumRows != nil && *export.NumRows > 0 {
+		fp, err := os.Open(export.Path)
+		if err != nil {
+			return 0, err
+		}
+		defer fp.Close()
+		st, err := fp.Stat()
+		if err != nil {
+			return 0, err
+		}
+		err = store.Put(ctx, filepath.Base(export.Path), fp, st.Size())
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	if s.KeepDays > 0 {
+		prefix := "goatcounter-export-" + site.Code + "-"
+		objs, err := store.List(ctx, prefix)
+		if err != nil {
+			return 0, err
+		}
+		expire := now.Add(-time.Duration(s.KeepDays) * 24 * time.Hour)
+		for _, o := range objs {
+			// The prefix also matches sites with a code that starts with
+			// this site's code, such as "foo-bar" for "foo".
+			if !reExportName.MatchString(strings.TrimPrefix(o.Name, prefix)) {
+				continue
+			}
+			if o.Modified.Before(expire) {
+				err := store.Delete(ctx, o.Name)
+				if err != nil {
+					return 0, err
+				}
+			}
+		}
+	}
+
+	return *export.LastHitID, nil
+}
+
+func exportFailedEmail(ctx context.Context, site goatcounter.Site, s goatcounter.ExportSchedule) error {
+	text := fmt.Sprintf("The scheduled export for %s to %s failed:\n\n%s\n\n"+
+		"It will be retried on the next run; no further emails will be sent while it keeps failing.\n\n"+
+		"Edit scheduled exports: %s/settings/export\n",
+		site.Display(ctx), s.Display(), s.LastError, site.URL(ctx))
+
+	err := blackmail.Send(fmt.Sprintf("GoatCounter scheduled export for %s failed", site.Display(ctx)),
+		blackmail.From("GoatCounter export", goatcounter.Config(ctx).EmailFrom),
+		blackmail.To(s.Email),
+		blackmail.BodyText([]byte(text)))
+	return errors.Wrap(err, "exportFailedEmail")
+}
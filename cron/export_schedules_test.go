@@ -0,0 +1,171 @@
+// Copyright © Martin Tournoij – This file is part of GoatCounter and published
+// under the terms of a slightly modified EUPL v1.2 license, which can be found
+// in the LICENSE file or at https://license.goatcounter.com
+
+package cron_test
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/csv"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"zgo.at/blackmail"
+	"zgo.at/goatcounter/v2"
+	"zgo.at/goatcounter/v2/cron"
+	"zgo.at/goatcounter/v2/gctest"
+	"zgo.at/goatcounter/v2/objstore"
+	"zgo.at/zdb"
+	"zgo.at/zstd/ztime"
+)
+
+func TestScheduledExports(t *testing.T) {
+	ctx := gctest.DB(t)
+	goatcounter.Config(ctx).EmailFrom = "test@goatcounter.localhost.com"
+	goatcounter.Config(ctx).GoatcounterCom = false
+
+	now := time.Date(2019, 6, 17, 15, 1, 0, 0, time.UTC)
+	ztime.Now = func() time.Time { return now }
+	t.Cleanup(func() { ztime.Now = func() time.Time { return time.Now().UTC() } })
+
+	buf := new(bytes.Buffer)
+	blackmail.DefaultMailer = blackmail.NewMailer(blackmail.ConnectWriter, blackmail.MailerOut(buf))
+
+	dir := t.TempDir()
+	goatcounter.Config(ctx).ExportDir = filepath.Dir(dir)
+	sched := goatcounter.ExportSchedule{Dest: dir, Period: goatcounter.ExportDaily, KeepDays: 7, Email: "ops@example.com"}
+	err := sched.Insert(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	run := func() {
+		t.Helper()
+		err := cron.TaskScheduledExports()
+		if err != nil {
+			t.Fatal(err)
+		}
+		cron.WaitScheduledExports()
+		err = sched.ByID(ctx, sched.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// An old export that should be removed, and an unrelated file and an
+	// export for a site with a longer code that should be kept.
+	var (
+		site     = goatcounter.MustGetSite(ctx)
+		old      = filepath.Join(dir, "goatcounter-export-"+site.Code+"-20190601T000000Z-0.csv.gz")
+		otherExp = filepath.Join(dir, "goatcounter-export-"+site.Code+"-bar-20190601T000000Z-0.csv.gz")
+		empty    = new(bytes.Buffer)
+	)
+	gz := gzip.NewWriter(empty)
+	gz.Write([]byte("path\n"))
+	gz.Close()
+	for _, f := range []string{old, otherExp, filepath.Join(dir, "other")} {
+		err := os.WriteFile(f, empty.Bytes(), 0o644)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = os.Chtimes(f, now.AddDate(0, 0, -10), now.AddDate(0, 0, -10))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	gctest.StoreHits(ctx, t, false,
+		goatcounter.Hit{Path: "/a", CreatedAt: now.Add(-2 * time.Hour)},
+		goatcounter.Hit{Path: "/b", CreatedAt: now.Add(-time.Hour)})
+	run()
+
+	if sched.LastError != "" || sched.LastRunAt == nil || sched.LastHitID != 2 {
+		t.Fatalf("wrong schedule after first run: %#v", sched)
+	}
+	if have := exportedPaths(t, dir); have != "/a /b" {
+		t.Errorf("wrong paths: %q", have)
+	}
+	if _, err := os.Stat(old); !os.IsNotExist(err) {
+		t.Errorf("old export not removed: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "other")); err != nil {
+		t.Errorf("other file removed: %v", err)
+	}
+	if _, err := os.Stat(otherExp); err != nil {
+		t.Errorf("export for other site removed: %v", err)
+	}
+
+	// Not due yet.
+	gctest.StoreHits(ctx, t, false, goatcounter.Hit{Path: "/c", CreatedAt: now})
+	now = now.Add(time.Hour)
+	run()
+	if sched.LastHitID != 2 {
+		t.Fatalf("ran before it was due: %#v", sched)
+	}
+
+	// Next day: only the new pageview is exported.
+	now = now.Add(24 * time.Hour)
+	run()
+	if sched.LastError != "" || sched.LastHitID != 3 {
+		t.Fatalf("wrong schedule after second run: %#v", sched)
+	}
+	if have := exportedPaths(t, dir); have != "/a /b /c" {
+		t.Errorf("wrong paths: %q", have)
+	}
+
+	// Send an email on failure, but only once; the destination is a file here
+	// rather than a directory.
+	err = zdb.Exec(ctx, `update export_schedules set dest=$1`, filepath.Join(dir, "other"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		gctest.StoreHits(ctx, t, false, goatcounter.Hit{Path: "/d", CreatedAt: now})
+		now = now.Add(24 * time.Hour)
+		run()
+		if sched.LastError == "" || sched.LastHitID != 3 {
+			t.Fatalf("no error: %#v", sched)
+		}
+	}
+	if n := strings.Count(buf.String(), "Subject: GoatCounter scheduled export for"); n != 1 {
+		t.Errorf("sent %d emails:\n%s", n, buf.String())
+	}
+	if !strings.Contains(buf.String(), "To: <ops@example.com>") {
+		t.Errorf("wrong email:\n%s", buf.String())
+	}
+}
+
+// exportedPaths gets all paths from the exports in dir.
+func exportedPaths(t *testing.T, dir string) string {
+	t.Helper()
+	ls, err := objstore.Dir(dir).List(context.Background(), "goatcounter-export-")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var paths []string
+	for _, o := range ls {
+		fp, err := os.Open(filepath.Join(dir, o.Name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer fp.Close()
+		gz, err := gzip.NewReader(fp)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rows, err := csv.NewReader(gz).ReadAll()
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, r := range rows[1:] {
+			paths = append(paths, r[0])
+		}
+	}
+	return strings.Join(paths, " ")
+}
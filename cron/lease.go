@@ -0,0 +1,59 @@
+// Copyright © Martin Tournoij – This file is part of GoatCounter and published
+// under the terms of a slightly modified EUPL v1.2 license, which can be found
+// in the LICENSE file or at https://license.goatcounter.com
+
+package cron
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	"zgo.at/errors"
+	"zgo.at/zdb"
+	"zgo.at/zstd/zcrypto"
+	"zgo.at/zstd/zsync"
+	"zgo.at/zstd/ztime"
+)
+
+var (
+	shared = zsync.NewAtomicInt(0)
+	holder = func() string {
+		h, _ := os.Hostname()
+		return fmt.Sprintf("%s-%d-%s", h, os.Getpid(), zcrypto.Secret64())
+	}()
+)
+
+// SetShared runs tasks that aren't Local only once if several processes use the
+// same database; every time a task is run the process needs to take a lease on
+// it first, and other processes will skip it until the lease expires.
+//
+// This only applies to tasks that are run periodically, and not to the Task*()
+// functions.
+func SetShared(s bool) {
+	if s {
+		shared.Set(1)
+	} else {
+		shared.Set(0)
+	}
+}
+
+// Lease takes the lease on a task for the duration d, and reports if we got
+// it.
+//
+// The lease is never released, so that a task is only run once during d even
+// if it's quick: it's slightly shorter than the task period so that the
+// process that ran it last will usually get it again.
+func Lease(ctx context.Context, task string, d time.Duration) (bool, error) {
+	now := ztime.Now().Round(time.Second)
+	n, err := zdb.NumRows(ctx, `
+		insert into cron_leases (task, holder, expires_at) values (:task, :holder, :expires)
+		on conflict (task) do update set holder=:holder, expires_at=:expires
+		where cron_leases.expires_at <= :now`,
+		zdb.P{"task": task, "holder": holder, "expires": now.Add(d), "now": now})
+	if err != nil {
+		return false, errors.Wrap(err, "cron.Lease")
+	}
+	return n > 0, nil
+}
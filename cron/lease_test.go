@@ -0,0 +1,41 @@
+// Copyright © Martin Tournoij – This file is part of GoatCounter and published
+// under the terms of a slightly modified EUPL v1.2 license, which can be found
+// in the LICENSE file or at https://license.goatcounter.com
+
+package cron_test
+
+import (
+	"testing"
+	"time"
+
+	"zgo.at/goatcounter/v2/cron"
+	"zgo.at/goatcounter/v2/gctest"
+	"zgo.at/zstd/ztime"
+)
+
+func TestLease(t *testing.T) {
+	ctx := gctest.DB(t)
+	ztime.SetNow(t, "2020-06-18 12:00:00")
+
+	lease := func(task string, want bool) {
+		t.Helper()
+		have, err := cron.Lease(ctx, task, time.Hour)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if have != want {
+			t.Errorf("Lease(%q) = %t; want %t", task, have, want)
+		}
+	}
+
+	lease("emailReports", true)
+	lease("emailReports", false)
+	lease("dataRetention", true)
+
+	ztime.SetNow(t, "2020-06-18 12:59:59")
+	lease("emailReports", false)
+
+	ztime.SetNow(t, "2020-06-18 13:00:00")
+	lease("emailReports", true)
+	lease("emailReports", false)
+}
@@ -32,7 +32,7 @@ func TestLocationStats(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	want := `{false [{ET Ethiopia 1 <nil>}]}`
+	want := `{false [{ET Ethiopia 1 <nil> <nil>}]}`
 	out := fmt.Sprintf("%v", stats)
 	if want != out {
 		t.Errorf("\nwant: %s\nout:  %s", want, out)
@@ -55,7 +55,7 @@ func TestLocationStats(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	want = `{false [{ET Ethiopia 3 <nil>} {ID Indonesia 1 <nil>} {NZ New Zealand 1 <nil>}]}`
+	want = `{false [{ET Ethiopia 3 <nil> <nil>} {ID Indonesia 1 <nil> <nil>} {NZ New Zealand 1 <nil> <nil>}]}`
 	out = fmt.Sprintf("%v", stats)
 	if want != out {
 		t.Errorf("\nwant: %s\nout:  %s", want, out)
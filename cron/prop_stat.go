@@ -0,0 +1,81 @@
+// Copyright © Martin Tournoij – This file is part of GoatCounter and published
+// under the terms of a slightly modified EUPL v1.2 license, which can be found
+// in the LICENSE file or at https://license.goatcounter.com
+
+package cron
+
+import (
+	"context"
+	"strconv"
+
+	"zgo.at/errors"
+	"zgo.at/goatcounter/v2"
+	"zgo.at/zdb"
+)
+
+func updatePropStats(ctx context.Context, hits []goatcounter.Hit) error {
+	return errors.Wrap(zdb.TX(ctx, func(ctx context.Context) error {
+		site := goatcounter.MustGetSite(ctx)
+		ss := site.Settings
+		ss.Defaults(ctx)
+
+		var known goatcounter.PropValues
+		err := known.List(ctx)
+		if err != nil {
+			return err
+		}
+
+		type gt struct {
+			count  int
+			day    string
+			name   string
+			value  string
+			pathID int64
+		}
+		grouped := map[string]gt{}
+		for _, h := range hits {
+			if h.Bot > 0 || len(h.Props) == 0 {
+				continue
+			}
+
+			day := h.CreatedAt.Format("2006-01-02")
+			for name, value := range h.Props {
+				name, value = known.Limit(ss, name, value)
+				if name == "" {
+					continue
+				}
+
+				k := day + strconv.FormatInt(h.PathID, 10) + "\x00" + name + "\x00" + value
+				v := grouped[k]
+				if v.count == 0 {
+					v.day = day
+					v.name = name
+					v.value = value
+					v.pathID = h.PathID
+				}
+
+				if h.FirstVisit {
+					v.count += 1
+				}
+				grouped[k] = v
+			}
+		}
+
+		ins := zdb.NewBulkInsert(ctx, "prop_stats", []string{"site_id", "day",
+			"path_id", "name", "value", "count"})
+		if zdb.SQLDialect(ctx) == zdb.DialectPostgreSQL {
+			ins.OnConflict(`on conflict on constraint "prop_stats#site_id#path_id#day#name#value" do update set
+				count = prop_stats.count + excluded.count`)
+		} else {
+			ins.OnConflict(`on conflict(site_id, path_id, day, name, value) do update set
+				count = prop_stats.count + excluded.count`)
+		}
+
+		for _, v := range grouped {
+			if v.count > 0 {
+				ins.Values(site.ID, v.day, v.pathID, v.name, v.value, v.count)
+			}
+		}
+		return ins.Finish()
+	}), "cron.updatePropStats")
+}
@@ -0,0 +1,118 @@
+package cron_test
+
+import (
+	"testing"
+	"time"
+
+	"zgo.at/goatcounter/v2"
+	"zgo.at/goatcounter/v2/gctest"
+	"zgo.at/zdb"
+	"zgo.at/zstd/zjson"
+	"zgo.at/zstd/ztest"
+	"zgo.at/zstd/ztime"
+)
+
+func TestPropStats(t *testing.T) {
+	ctx := gctest.DB(t)
+
+	site := goatcounter.MustGetSite(ctx)
+	site.Settings.PropKeys = 2
+	site.Settings.PropValues = 2
+	err := site.Update(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Date(2019, 8, 31, 14, 42, 0, 0, time.UTC)
+
+	gctest.StoreHits(ctx, t, false, []goatcounter.Hit{
+		{Site: site.ID, CreatedAt: now, Props: goatcounter.Props{"plan": "free"}, FirstVisit: true},
+		{Site: site.ID, CreatedAt: now, Props: goatcounter.Props{"plan": "free"}, FirstVisit: true},
+		{Site: site.ID, CreatedAt: now, Props: goatcounter.Props{"plan": "pro"}, FirstVisit: true},
+		{Site: site.ID, CreatedAt: now, Props: goatcounter.Props{"plan": "pro"}},
+		{Site: site.ID, CreatedAt: now, Props: goatcounter.Props{"plan": "team"}, FirstVisit: true},
+		{Site: site.ID, CreatedAt: now, Props: goatcounter.Props{"theme": "dark"}, FirstVisit: true},
+		{Site: site.ID, CreatedAt: now, Props: goatcounter.Props{"lang": "nl"}, FirstVisit: true},
+	}...)
+
+	{
+		var have goatcounter.HitStats
+		err := have.ListProps(ctx, ztime.NewRange(now).To(now), nil, 10, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		want := `{
+			"more": false,
+			"stats": [
+				{"count": 4, "id": "plan", "name": "plan"},
+				{"count": 1, "id": "theme", "name": "theme"}
+			]
+		}`
+		if d := ztest.Diff(zjson.MustMarshalString(have), want, ztest.DiffJSON); d != "" {
+			t.Error(d)
+		}
+	}
+
+	{
+		var have goatcounter.HitStats
+		err := have.ListProp(ctx, "plan", ztime.NewRange(now).To(now), nil, 10, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		want := `{
+			"more": false,
+			"stats": [
+				{"count": 2, "name": "free"},
+				{"count": 1, "name": "(other)"},
+				{"count": 1, "name": "pro"}
+			]
+		}`
+		if d := ztest.Diff(zjson.MustMarshalString(have), want, ztest.DiffJSON); d != "" {
+			t.Error(d)
+		}
+	}
+}
+
+func TestPropStatsMerge(t *testing.T) {
+	ctx := gctest.DB(t)
+	now := time.Date(2019, 8, 31, 14, 42, 0, 0, time.UTC)
+
+	hits := gctest.StoreHits(ctx, t, false, []goatcounter.Hit{
+		{Path: "/a", CreatedAt: now, Props: goatcounter.Props{"plan": "free"}, FirstVisit: true},
+		{Path: "/b", CreatedAt: now, Props: goatcounter.Props{"plan": "free"}, FirstVisit: true},
+		{Path: "/b", CreatedAt: now, Props: goatcounter.Props{"plan": "pro"}, FirstVisit: true},
+	}...)
+
+	var merge goatcounter.Hits
+	err := merge.Merge(ctx, hits[0].PathID, []int64{hits[1].PathID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	gctest.StoreHits(ctx, t, false)
+
+	var have goatcounter.HitStats
+	err = have.ListProp(ctx, "plan", ztime.NewRange(now).To(now), nil, 10, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `{
+		"more": false,
+		"stats": [
+			{"count": 2, "name": "free"},
+			{"count": 1, "name": "pro"}
+		]
+	}`
+	if d := ztest.Diff(zjson.MustMarshalString(have), want, ztest.DiffJSON); d != "" {
+		t.Error(d)
+	}
+
+	var paths []int64
+	err = zdb.Select(ctx, &paths, `select distinct path_id from prop_stats`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(paths) != 1 || paths[0] != hits[0].PathID {
+		t.Errorf("path_id: %v", paths)
+	}
+}
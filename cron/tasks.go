@@ -134,6 +134,7 @@ func UpdateStats(ctx context.Context, site *goatcounter.Site, siteID int64, hits
 		updateLanguageStats,
 		updateSizeStats,
 		updateCampaignStats,
+		updatePropStats,
 	}
 
 	for _, f := range funs {
@@ -199,7 +200,9 @@ func vacuumDeleted(ctx context.Context) error {
 			for _, t := range []string{"hits", "paths",
 				"hit_counts", "ref_counts",
 				"browser_stats", "system_stats", "hit_stats", "location_stats", "language_stats", "size_stats",
-				"campaign_stats", "exports", "api_tokens", "users", "sites"} {
+				"campaign_stats", "prop_stats",
+				"exports", "api_tokens", "goals", "funnels", "annotations", "alert_rules",
+				"webhooks", "webhook_deliveries", "export_schedules", "import_hashes", "users", "sites"} {
 
 				err := zdb.Exec(ctx, fmt.Sprintf(`delete from %s where site_id=%d`, t, s.ID))
 				if err != nil {
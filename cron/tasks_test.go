@@ -12,6 +12,7 @@ import (
 	"zgo.at/goatcounter/v2"
 	"zgo.at/goatcounter/v2/cron"
 	"zgo.at/goatcounter/v2/gctest"
+	"zgo.at/zdb"
 	"zgo.at/zstd/zbool"
 	"zgo.at/zstd/ztime"
 )
@@ -35,6 +36,13 @@ func TestDataRetention(t *testing.T) {
 		{Site: site.ID, CreatedAt: past, Path: "/a", FirstVisit: zbool.Bool(true)},
 		{Site: site.ID, CreatedAt: past, Path: "/a", FirstVisit: zbool.Bool(false)},
 	}...)
+	_, err = goatcounter.ClaimImportHashes(ctx, []goatcounter.Hit{
+		{ImportHash: "new", CreatedAt: now},
+		{ImportHash: "old", CreatedAt: past},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
 
 	err = cron.TaskDataRetention()
 	if err != nil {
@@ -51,6 +59,15 @@ func TestDataRetention(t *testing.T) {
 		t.Errorf("len(hits) is %d\n%v", len(hits), hits)
 	}
 
+	var hashes []string
+	err = zdb.Select(ctx, &hashes, `select hash from import_hashes`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(hashes) != "[new]" {
+		t.Errorf("import_hashes: %v", hashes)
+	}
+
 	var stats goatcounter.HitLists
 	display, more, err := stats.List(ctx,
 		ztime.NewRange(past.Add(-1*24*time.Hour)).To(now),
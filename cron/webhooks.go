@@ -0,0 +1,60 @@
+// Copyright © Martin Tournoij – This file is part of GoatCounter and published
+// under the terms of a slightly modified EUPL v1.2 license, which can be found
+// in the LICENSE file or at https://license.goatcounter.com
+
+package cron
+
+import (
+	"context"
+	"time"
+
+	"zgo.at/errors"
+	"zgo.at/goatcounter/v2"
+	"zgo.at/zdb"
+	"zgo.at/zlog"
+	"zgo.at/zstd/ztime"
+)
+
+// webhooks retries failed webhook deliveries, and removes deliveries older than
+// 30 days from the log.
+func webhooks(ctx context.Context) error {
+	l := zlog.Module("webhook")
+
+	now := ztime.Now()
+	var retry goatcounter.WebhookDeliveries
+	err := retry.UnscopedListRetry(ctx, now)
+	if err != nil {
+		return errors.Errorf("cron.webhooks: %w", err)
+	}
+
+	var site goatcounter.Site
+	for _, d := range retry {
+		if site.ID != d.SiteID {
+			err := site.ByID(ctx, d.SiteID)
+			if err != nil {
+				l.Field("delivery", d.ID).Error(err)
+				continue
+			}
+		}
+		ctx := goatcounter.WithSite(ctx, &site)
+
+		var hook goatcounter.Webhook
+		err := hook.ByID(ctx, d.WebhookID)
+		if err != nil {
+			l.Field("delivery", d.ID).Error(err)
+			continue
+		}
+
+		err = d.Deliver(ctx, hook)
+		if err != nil {
+			l.Field("delivery", d.ID).Field("attempts", d.Attempts).Print(err)
+		}
+	}
+
+	err = zdb.Exec(ctx, `delete from webhook_deliveries where created_at < $1`,
+		now.Add(-30*24*time.Hour))
+	if err != nil {
+		return errors.Errorf("cron.webhooks: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,81 @@
+// Copyright © Martin Tournoij – This file is part of GoatCounter and published
+// under the terms of a slightly modified EUPL v1.2 license, which can be found
+// in the LICENSE file or at https://license.goatcounter.com
+
+package cron_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"zgo.at/goatcounter/v2"
+	"zgo.at/goatcounter/v2/cron"
+	"zgo.at/goatcounter/v2/gctest"
+	"zgo.at/zstd/ztime"
+)
+
+func TestWebhooks(t *testing.T) {
+	ctx := gctest.DB(t)
+	goatcounter.Config(ctx).AllowPrivateURLs = true // httptest server is on localhost.
+
+	now := time.Date(2020, 6, 18, 12, 13, 14, 0, time.UTC)
+	ztime.Now = func() time.Time { return now }
+	t.Cleanup(func() { ztime.Now = func() time.Time { return time.Now().UTC() } })
+
+	status := 500
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(status)
+	}))
+	defer srv.Close()
+
+	hook := goatcounter.Webhook{URL: srv.URL, Events: goatcounter.WebhookEvents{goatcounter.WebhookExportDone}}
+	err := hook.Insert(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := goatcounter.WebhookDelivery{WebhookID: hook.ID, SiteID: hook.SiteID, Event: "export.done", Payload: "{}"}
+	err = d.Insert(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	run := func() goatcounter.WebhookDelivery {
+		t.Helper()
+		err := cron.TaskWebhooks()
+		if err != nil {
+			t.Fatal(err)
+		}
+		cron.WaitWebhooks()
+
+		var log goatcounter.WebhookDeliveries
+		err = log.List(ctx, hook.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(log) != 1 {
+			t.Fatalf("len(log) = %d", len(log))
+		}
+		return log[0]
+	}
+
+	// Not due yet.
+	if have := run(); have.Attempts != 0 {
+		t.Fatalf("attempts = %d", have.Attempts)
+	}
+
+	// Fails, and schedules a retry with a longer delay.
+	now = now.Add(time.Minute)
+	have := run()
+	if have.Attempts != 1 || have.NextAttemptAt == nil || !have.NextAttemptAt.Equal(now.Add(5*time.Minute)) {
+		t.Fatalf("wrong delivery: attempts=%d next=%v", have.Attempts, have.NextAttemptAt)
+	}
+
+	status = 202
+	now = now.Add(5 * time.Minute)
+	have = run()
+	if have.Attempts != 2 || have.NextAttemptAt != nil || have.DeliveredAt == nil || have.Status != 202 {
+		t.Fatalf("wrong delivery: %#v", have)
+	}
+}
@@ -0,0 +1,6 @@
+select session, path_id from hits
+where
+	site_id = :site and bot = 0 and session is not null and
+	created_at >= :start and created_at <= :end and
+	path_id in (:paths)
+order by session, created_at, hit_id
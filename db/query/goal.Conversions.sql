@@ -0,0 +1,14 @@
+with sessions as (
+	select distinct session from hits
+	where
+		site_id = :site and bot = 0 and session is not null and
+		created_at >= :start and created_at <= :end
+		{{:filter and path_id in (:filter)}}
+)
+select count(distinct hits.session) from hits
+{{:goal join paths on paths.path_id = hits.path_id}}
+where
+	hits.site_id = :site and hits.bot = 0 and
+	hits.created_at >= :start and hits.created_at <= :end and
+	hits.session in (select session from sessions)
+	{{:goal and paths.event = :event and lower(paths.path) like :pattern escape '\'}}
@@ -0,0 +1,5 @@
+select day, stats
+from hit_stats
+where
+	site_id = :site and day >= :start and day <= :end
+	{{:filter and path_id in (:filter)}}
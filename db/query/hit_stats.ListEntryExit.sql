@@ -0,0 +1,28 @@
+with x as (
+	select path_id, count(*) as count from (
+		select
+			hits.path_id,
+			row_number() over (
+				partition by hits.session
+				order by hits.created_at {{:exit desc}}, hits.hit_id {{:exit desc}}
+			) as n
+		from hits
+		join paths on paths.path_id = hits.path_id
+		where
+			hits.site_id = :site and hits.bot = 0 and hits.session != :nosession and
+			paths.event = 0 and
+			hits.created_at >= :start and hits.created_at <= :end
+	) sessions
+	where n = 1
+		{{:filter and path_id in (:filter)}}
+	group by path_id
+	order by count desc, path_id
+	limit :limit offset :offset
+)
+select
+	x.path_id   as id,
+	paths.path  as name,
+	x.count     as count
+from x
+join paths using (path_id)
+order by count desc, name asc
@@ -0,0 +1,11 @@
+select
+	value      as name,
+	sum(count) as count
+from prop_stats
+where
+	site_id = :site and day >= :start and day <= :end and
+	{{:filter path_id in (:filter) and}}
+	name = :name
+group by value
+order by count desc, value asc
+limit :limit offset :offset
@@ -0,0 +1,11 @@
+select
+	name       as id,
+	name       as name,
+	sum(count) as count
+from prop_stats
+where
+	site_id = :site and day >= :start and day <= :end
+	{{:filter and path_id in (:filter)}}
+group by name
+order by count desc, name asc
+limit :limit offset :offset
@@ -0,0 +1,19 @@
+with x as (
+	select path_id, status, count(*) as count
+	from hits
+	where
+		site_id = :site and bot = 0 and status >= 400 and
+		created_at >= :start and created_at <= :end
+		{{:filter and path_id in (:filter)}}
+	group by path_id, status
+	order by count desc, path_id, status
+	limit :limit offset :offset
+)
+select
+	x.path_id,
+	paths.path,
+	x.status,
+	x.count
+from x
+join paths using (path_id)
+order by count desc, path asc, status asc
@@ -0,0 +1,32 @@
+with x as (
+	select
+		path_id,
+		response_time,
+		row_number() over (partition by path_id order by response_time) as n,
+		count(*)     over (partition by path_id)                        as total
+	from hits
+	where
+		site_id = :site and bot = 0 and response_time is not null and
+		created_at >= :start and created_at <= :end
+		{{:filter and path_id in (:filter)}}
+), p as (
+	-- Nearest-rank percentiles, which work the same on PostgreSQL and SQLite.
+	select
+		path_id,
+		max(total)                                                         as count,
+		min(case when n >= (total * 50 + 99) / 100 then response_time end) as p50,
+		min(case when n >= (total * 95 + 99) / 100 then response_time end) as p95
+	from x
+	group by path_id
+	order by p95 desc, path_id
+	limit :limit offset :offset
+)
+select
+	p.path_id,
+	paths.path,
+	p.count,
+	p.p50,
+	p.p95
+from p
+join paths using (path_id)
+order by p95 desc, path asc
@@ -5,13 +5,14 @@
 package goatcounter
 
 import (
-	"compress/gzip"
 	"context"
-	"encoding/csv"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
 	"io"
 	"os"
 	"reflect"
+	"strconv"
 	"strings"
 	"time"
 
@@ -37,6 +38,17 @@ type Export struct {
 	// Last hit ID that was exported; can be used as start_from_hit_id.
 	LastHitID *int64 `db:"last_hit_id" json:"last_hit_id,readonly"`
 
+	// File format: csv, jsonl, or sqlite.
+	Format string `db:"format" json:"format"`
+
+	// What to export: "hits" for all pageviews, or "aggregates" for the
+	// statistics. Aggregates are always exported as JSON Lines.
+	Mode string `db:"mode" json:"mode"`
+
+	// Date range for aggregates; defaults to everything.
+	RangeStart *time.Time `db:"range_start" json:"range_start"`
+	RangeEnd   *time.Time `db:"range_end" json:"range_end"`
+
 	Path      string    `db:"path" json:"path,readonly"` // {omitdoc}
 	CreatedAt time.Time `db:"created_at" json:"created_at,readonly"`
 
@@ -62,21 +74,57 @@ func (e *Export) ByID(ctx context.Context, id int64) error {
 // Create a new export.
 //
 // Inserts a row in exports table and returns open file pointer to the
-// destination file.
+// destination file. The Format is used if set, or ExportCSV if it's not.
+//
+// For ExportModeAggregates the startFrom is ignored and the Format is always
+// ExportJSONL.
 func (e *Export) Create(ctx context.Context, startFrom int64) (*os.File, error) {
 	site := MustGetSite(ctx)
 
+	if e.Mode == "" {
+		e.Mode = ExportModeHits
+	}
+	if e.Mode == ExportModeAggregates && e.Format == "" {
+		e.Format = ExportJSONL
+	}
+	if e.Format == "" {
+		e.Format = ExportCSV
+	}
+	v := NewValidate(ctx)
+	v.Include("mode", e.Mode, ExportModes)
+	v.Include("format", e.Format, ExportFormats)
+	if e.Mode == ExportModeAggregates {
+		if e.Format != ExportJSONL {
+			v.Append("format", "aggregates can only be exported as jsonl")
+		}
+		if e.RangeStart != nil && e.RangeEnd != nil && e.RangeEnd.Before(*e.RangeStart) {
+			v.Append("range_end", "must be after range_start")
+		}
+		startFrom = 0
+	} else if e.RangeStart != nil || e.RangeEnd != nil {
+		v.Append("mode", "date range can only be used with aggregates")
+	}
+	if v.HasErrors() {
+		return nil, v
+	}
+
 	e.SiteID = site.ID
 	e.CreatedAt = ztime.Now()
 	e.StartFromHitID = startFrom
-	e.Path = fmt.Sprintf("%s%sgoatcounter-export-%s-%s-%d.csv.gz",
+	name := strconv.FormatInt(startFrom, 10)
+	if e.Mode == ExportModeAggregates {
+		name = ExportModeAggregates
+	}
+	// This is local to the process, so with "serve -shared" the download needs
+	// to be sent to the same process.
+	e.Path = fmt.Sprintf("%s%sgoatcounter-export-%s-%s-%s.%s",
 		os.TempDir(), string(os.PathSeparator), site.Code,
-		e.CreatedAt.Format("20060102T150405Z"), startFrom)
+		e.CreatedAt.Format("20060102T150405Z"), name, exportExt(e.Format))
 
 	var err error
 	e.ID, err = zdb.InsertID(ctx, "export_id",
-		`insert into exports (site_id, path, created_at, start_from_hit_id) values (?, ?, ?, ?)`,
-		e.SiteID, e.Path, e.CreatedAt, e.StartFromHitID)
+		`insert into exports (site_id, path, created_at, start_from_hit_id, format, mode, range_start, range_end) values (?, ?, ?, ?, ?, ?, ?, ?)`,
+		e.SiteID, e.Path, e.CreatedAt, e.StartFromHitID, e.Format, e.Mode, exportDate(e.RangeStart), exportDate(e.RangeEnd))
 	if err != nil {
 		return nil, errors.Wrap(err, "Export.Create")
 	}
@@ -85,87 +133,60 @@ func (e *Export) Create(ctx context.Context, startFrom int64) (*os.File, error)
 	return fp, errors.Wrap(err, "Export.Create")
 }
 
-// Export all data to a CSV file.
+// Export all data to a file in the export's format.
 func (e *Export) Run(ctx context.Context, fp *os.File, mailUser bool) {
 	l := zlog.Module("export").Field("id", e.ID)
 	l.Print("export started")
 
-	gzfp := gzip.NewWriter(fp)
 	defer fp.Close() // No need to error-check; just for safety.
-	defer gzfp.Close()
 
-	c := csv.NewWriter(gzfp)
-	c.Write([]string{ExportVersion + "Path", "Title", "Event", "UserAgent",
-		"Browser", "System", "Session", "Bot", "Referrer", "Referrer scheme",
-		"Screen size", "Location", "FirstVisit", "Date"})
-
-	var exportErr error
 	e.LastHitID = &e.StartFromHitID
 	var z int
 	e.NumRows = &z
-	for {
-		var (
-			hits ExportRows
-			last int64
-		)
-		last, exportErr = hits.Export(ctx, 5000, *e.LastHitID)
-		e.LastHitID = &last
-		if len(hits) == 0 {
-			break
-		}
-		if exportErr != nil {
-			break
-		}
-
-		*e.NumRows += len(hits)
 
-		for _, hit := range hits {
-			c.Write([]string{hit.Path, hit.Title, hit.Event, hit.UserAgent,
-				hit.Browser, hit.System, hit.Session.String(), hit.Bot, hit.Ref,
-				hit.RefScheme, hit.Size, hit.Location, hit.FirstVisit,
-				hit.CreatedAt})
-		}
-
-		c.Flush()
-		exportErr = c.Error()
-		if exportErr != nil {
-			break
-		}
-
-		// Small amount of breathing space.
-		if !Config(ctx).Dev {
-			time.Sleep(500 * time.Millisecond)
+	var (
+		w         io.Closer
+		exportErr error
+	)
+	if e.Mode == ExportModeAggregates {
+		aw := newAggregateWriter(fp)
+		w, exportErr = aw, e.exportAggregates(ctx, aw)
+	} else {
+		var hw exportWriter
+		hw, exportErr = newExportWriter(e.Format, fp)
+		if exportErr == nil {
+			w, exportErr = hw, e.exportHits(ctx, hw)
 		}
 	}
 
 	if exportErr != nil {
 		l.Field("export", e).Error(exportErr)
 
+		errMsg := exportErr.Error()
+		e.Error = &errMsg
 		err := zdb.Exec(ctx,
 			`update exports set error=$1 where export_id=$2`,
-			exportErr.Error(), e.ID)
+			errMsg, e.ID)
 		if err != nil {
 			zlog.Error(err)
 		}
+		SendWebhook(ctx, WebhookExportDone, e)
 
-		_ = gzfp.Close()
+		if w != nil {
+			_ = w.Close()
+		}
 		_ = fp.Close()
-		_ = os.Remove(fp.Name())
+		_ = os.Remove(e.Path)
 		return
 	}
 
-	err := gzfp.Close()
-	if err != nil {
-		l.Error(err)
-		return
-	}
-	err = fp.Sync() // Ensure stat is correct.
+	err := w.Close()
 	if err != nil {
 		l.Error(err)
 		return
 	}
 
-	stat, err := fp.Stat()
+	stat, err := os.Stat(e.Path)
 	size := "0"
 	if err == nil {
 		size = fmt.Sprintf("%.1f", float64(stat.Size())/1024/1024)
@@ -175,12 +196,6 @@ func (e *Export) Run(ctx context.Context, fp *os.File, mailUser bool) {
 	}
 	e.Size = &size
 
-	err = fp.Close()
-	if err != nil {
-		l.Error(err)
-		return
-	}
-
 	hash, err := zcrypto.HashFile(e.Path)
 	e.Hash = &hash
 	if err != nil {
@@ -196,6 +211,9 @@ func (e *Export) Run(ctx context.Context, fp *os.File, mailUser bool) {
 	if err != nil {
 		zlog.Error(err)
 	}
+	done := *e
+	done.FinishedAt = &now
+	SendWebhook(ctx, WebhookExportDone, done)
 
 	if mailUser {
 		site := MustGetSite(ctx)
@@ -210,6 +228,48 @@ func (e *Export) Run(ctx context.Context, fp *os.File, mailUser bool) {
 	}
 }
 
+func (e *Export) exportHits(ctx context.Context, w exportWriter) error {
+	for {
+		var hits ExportRows
+		last, err := hits.Export(ctx, 5000, *e.LastHitID)
+		e.LastHitID = &last
+		if err != nil {
+			return err
+		}
+		if len(hits) == 0 {
+			return nil
+		}
+
+		*e.NumRows += len(hits)
+
+		err = w.Write(hits)
+		if err != nil {
+			return err
+		}
+
+		// Small amount of breathing space.
+		if !Config(ctx).Dev {
+			time.Sleep(500 * time.Millisecond)
+		}
+	}
+}
+
+func exportDate(t *time.Time) *string {
+	if t == nil {
+		return nil
+	}
+	s := t.Format("2006-01-02")
+	return &s
+}
+
+// ContentType gets the MIME type of the export file.
+func (e Export) ContentType() string {
+	if e.Format == ExportSQLite {
+		return "application/vnd.sqlite3"
+	}
+	return "application/gzip"
+}
+
 func (e Export) Exists() bool {
 	if e.Path == "" {
 		return false
@@ -227,7 +287,8 @@ func (e *Exports) List(ctx context.Context) error {
 		MustGetSite(ctx).ID), "Exports.List")
 }
 
-// Import data from an export.
+// Import data from an export in the given format; fp should already be
+// decompressed.
 //
 // The persist() callback will be called for every hit; you usually want to
 // collect a bunch of them and then persist them.
@@ -235,25 +296,19 @@ func (e *Exports) List(ctx context.Context) error {
 // After everything is done, this will be called once more with an empty hit and
 // final set to true, to persist the last batch.
 func Import(
-	ctx context.Context, fp io.Reader, replace, email bool,
+	ctx context.Context, fp io.Reader, format string, replace, email bool,
 	persist func(Hit, bool),
 ) (*time.Time, error) {
 	site := MustGetSite(ctx)
 
-	l := zlog.Module("import").Field("site", site.ID).Field("replace", replace)
+	l := zlog.Module("import").Field("site", site.ID).Field("replace", replace).Field("format", format)
 	l.Print("import started")
 
-	c := csv.NewReader(fp)
-	header, err := c.Read()
+	rows, err := newExportReader(format, fp)
 	if err != nil {
-		return nil, errors.Wrap(err, "goatcounter.Import")
-	}
-
-	if len(header) == 0 || !strings.HasPrefix(header[0], ExportVersion) {
-		return nil, errors.Errorf(
-			"goatcounter.Import: wrong version of CSV database: %s (expected: %s)",
-			header[0][:1], ExportVersion)
+		return nil, errors.Errorf("goatcounter.Import: %w", err)
 	}
+	defer rows.Close()
 
 	if replace {
 		err := site.DeleteAll(ctx)
@@ -266,11 +321,37 @@ func Import(
 	var (
 		sessions   = make(map[zint.Uint128]zint.Uint128)
 		n          = 0
+		dupes      = 0
 		errs       = errors.NewGroup(50)
 		firstHitAt = site.FirstHitAt
+		seen       = make(map[string]int)
+		batch      = make([]Hit, 0, 500)
+		_, hasDB   = zdb.GetDB(ctx)
 	)
+	// Skip hits that were already imported; if there's no database (e.g. when
+	// importing over the API) then the hashes are checked by /api/v0/count.
+	flush := func() error {
+		var dupe []bool
+		if hasDB {
+			var err error
+			dupe, err = ClaimImportHashes(ctx, batch)
+			if err != nil {
+				return err
+			}
+		}
+		for i, h := range batch {
+			if dupe != nil && dupe[i] {
+				dupes++
+				continue
+			}
+			persist(h, false)
+			n++
+		}
+		batch = batch[:0]
+		return nil
+	}
 	for {
-		line, err := c.Read()
+		row, err := rows.Read()
 		if err == io.EOF {
 			break
 		}
@@ -278,12 +359,6 @@ func Import(
 			continue
 		}
 
-		var row ExportRow
-		err = row.Read(line)
-		if errs.Append(err) {
-			continue
-		}
-
 		hit, err := row.Hit(ctx, site.ID)
 		if errs.Append(err) {
 			continue
@@ -292,6 +367,10 @@ func Import(
 			firstHitAt = hit.CreatedAt
 		}
 
+		k := row.importHash(0)
+		hit.ImportHash = row.importHash(seen[k])
+		seen[k]++
+
 		// Map session IDs to new session IDs.
 		s, ok := sessions[row.Session]
 		if !ok {
@@ -300,12 +379,21 @@ func Import(
 		}
 		hit.Session = s
 
-		persist(hit, false)
-		n++
+		batch = append(batch, hit)
+		if len(batch) == cap(batch) {
+			err := flush()
+			if err != nil {
+				return nil, errors.Wrap(err, "goatcounter.Import")
+			}
+		}
+	}
+	err = flush()
+	if err != nil {
+		return nil, errors.Wrap(err, "goatcounter.Import")
 	}
 	persist(Hit{}, true)
 
-	l.Printf("imported %d rows", n)
+	l.Printf("imported %d rows; skipped %d duplicates", n, dupes)
 	if errs.Len() > 0 {
 		l.Error(errs)
 	}
@@ -317,11 +405,12 @@ func Import(
 		err = blackmail.Send("GoatCounter import ready",
 			blackmail.From("GoatCounter import", Config(ctx).EmailFrom),
 			blackmail.To(GetUser(ctx).Email),
-			blackmail.BodyMustText(TplEmailImportDone{ctx, *site, n, errs}.Render))
+			blackmail.BodyMustText(TplEmailImportDone{ctx, *site, n, errs, dupes, false}.Render))
 		if err != nil {
 			l.Error(err)
 		}
 	}
+	SendWebhook(ctx, WebhookImportDone, map[string]int{"rows": n, "errors": errs.Len(), "duplicates": dupes})
 
 	if firstHitAt.Equal(site.FirstHitAt) {
 		return nil, nil
@@ -343,25 +432,25 @@ func Import(
 // https://github.com/jszwec/csvutil
 
 type ExportRow struct { // Fields in order!
-	ID     int64 `db:"hit_id"`
-	SiteID int64 `db:"site_id"`
-
-	Path  string `db:"path"`
-	Title string `db:"title"`
-	Event string `db:"event"`
-
-	UserAgent string `db:"ua"`
-	Browser   string `db:"browser"`
-	System    string `db:"system"`
-
-	Session    zint.Uint128 `db:"session"`
-	Bot        string       `db:"bot"`
-	Ref        string       `db:"ref"`
-	RefScheme  string       `db:"ref_s"`
-	Size       string       `db:"size"`
-	Location   string       `db:"loc"`
-	FirstVisit string       `db:"first"`
-	CreatedAt  string       `db:"created_at"`
+	ID     int64 `db:"hit_id" json:"hit_id"`
+	SiteID int64 `db:"site_id" json:"-"`
+
+	Path  string `db:"path" json:"path"`
+	Title string `db:"title" json:"title"`
+	Event string `db:"event" json:"event"`
+
+	UserAgent string `db:"ua" json:"user_agent"`
+	Browser   string `db:"browser" json:"browser"`
+	System    string `db:"system" json:"system"`
+
+	Session    zint.Uint128 `db:"session" json:"session"`
+	Bot        string       `db:"bot" json:"bot"`
+	Ref        string       `db:"ref" json:"ref"`
+	RefScheme  string       `db:"ref_s" json:"ref_scheme"`
+	Size       string       `db:"size" json:"size"`
+	Location   string       `db:"loc" json:"location"`
+	FirstVisit string       `db:"first" json:"first_visit"`
+	CreatedAt  string       `db:"created_at" json:"created_at"`
 }
 
 func (row *ExportRow) Read(line []string) error {
@@ -425,6 +514,72 @@ func (row ExportRow) Hit(ctx context.Context, siteID int64) (Hit, error) {
 	return hit, v.ErrorOrNil()
 }
 
+// importHash gets a hash of the row's contents, to detect rows that were
+// already imported. The n is the number of times the same row was already seen
+// in this import, so that identical rows aren't seen as duplicates of each
+// other.
+func (row ExportRow) importHash(n int) string {
+	h := sha256.New()
+	for _, f := range []string{row.Path, row.Title, row.Event, row.UserAgent, row.Browser,
+		row.System, row.Session.String(), row.Bot, row.Ref, row.RefScheme, row.Size,
+		row.Location, row.FirstVisit, row.CreatedAt, strconv.Itoa(n)} {
+		h.Write([]byte(f))
+		h.Write([]byte{0})
+	}
+	return hex.EncodeToString(h.Sum(nil)[:16])
+}
+
+// ClaimImportHashes records the ImportHash of the hits as imported, and reports
+// which hits were already imported before.
+//
+// This is done when the pageviews are received rather than when they're
+// persisted, so that sending the same pageviews again before they're persisted
+// (e.g. when a client retries after a timeout) doesn't count them twice. The
+// hashes are stored with the pageview's created_at, so they're removed with the
+// pageviews by the data retention.
+func ClaimImportHashes(ctx context.Context, hits []Hit) ([]bool, error) {
+	var (
+		dupe   = make([]bool, len(hits))
+		uniq   = make(map[string]struct{}, len(hits))
+		values = make([]string, 0, len(hits))
+		params = make([]any, 0, len(hits)*3)
+		siteID = MustGetSite(ctx).ID
+	)
+	for i, h := range hits {
+		if h.ImportHash == "" {
+			continue
+		}
+		if _, ok := uniq[h.ImportHash]; ok {
+			dupe[i] = true
+			continue
+		}
+		uniq[h.ImportHash] = struct{}{}
+		values = append(values, "(?, ?, ?)")
+		params = append(params, siteID, h.ImportHash, h.CreatedAt.Round(time.Second))
+	}
+	if len(values) == 0 {
+		return dupe, nil
+	}
+
+	var claimed []string
+	err := zdb.Select(ctx, &claimed, `/* ClaimImportHashes */
+		insert into import_hashes (site_id, hash, created_at) values `+strings.Join(values, ", ")+`
+		on conflict do nothing returning hash`, params...)
+	if err != nil {
+		return nil, errors.Wrap(err, "ClaimImportHashes")
+	}
+
+	for _, h := range claimed {
+		delete(uniq, h)
+	}
+	for i, h := range hits {
+		if _, ok := uniq[h.ImportHash]; ok && h.ImportHash != "" {
+			dupe[i] = true
+		}
+	}
+	return dupe, nil
+}
+
 type ExportRows []ExportRow
 
 // Export all hits for a site, including bot requests.
@@ -0,0 +1,527 @@
+// Copyright © Martin Tournoij – This file is part of GoatCounter and published
+// under the terms of a slightly modified EUPL v1.2 license, which can be found
+// in the LICENSE file or at https://license.goatcounter.com
+
+package goatcounter
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"zgo.at/blackmail"
+	"zgo.at/errors"
+	"zgo.at/json"
+	"zgo.at/zdb"
+	"zgo.at/zlog"
+	"zgo.at/zstd/zbool"
+	"zgo.at/zstd/zjson"
+	"zgo.at/zstd/ztime"
+)
+
+// Export modes.
+const (
+	ExportModeHits       = "hits"       // All pageviews.
+	ExportModeAggregates = "aggregates" // Statistics from the stats tables.
+)
+
+var ExportModes = []string{ExportModeHits, ExportModeAggregates}
+
+// ExportAggregate is a single row from one of the stats tables, with all IDs
+// resolved to their names.
+//
+// Which fields are set depends on the table; hit_counts and ref_counts have an
+// hour, everything else a day.
+type ExportAggregate struct {
+	Table string `db:"-" json:"table"`
+
+	Path  string     `db:"path" json:"path"`
+	Title string     `db:"title" json:"title"`
+	Event zbool.Bool `db:"event" json:"event"`
+
+	At   time.Time `db:"t" json:"-"`
+	Hour string    `db:"-" json:"hour,omitempty"`
+	Day  string    `db:"-" json:"day,omitempty"`
+
+	Ref       string          `db:"ref" json:"ref,omitempty"`               // ref_counts, campaign_stats
+	RefScheme string          `db:"ref_scheme" json:"ref_scheme,omitempty"` // ref_counts
+	Name      string          `db:"name" json:"name,omitempty"`             // browser_stats, system_stats, prop_stats
+	Version   string          `db:"version" json:"version,omitempty"`       // browser_stats, system_stats
+	Location  string          `db:"location" json:"location,omitempty"`     // location_stats
+	Language  string          `db:"language" json:"language,omitempty"`     // language_stats
+	Width     *int            `db:"width" json:"width,omitempty"`           // size_stats
+	Campaign  string          `db:"campaign" json:"campaign,omitempty"`     // campaign_stats
+	Value     string          `db:"value" json:"value,omitempty"`           // prop_stats
+	Stats     json.RawMessage `db:"stats" json:"stats,omitempty"`           // hit_stats; visitors per hour.
+	Count     int             `db:"count" json:"count,omitempty"`
+}
+
+type ExportAggregates []ExportAggregate
+
+type aggregateTable struct {
+	name   string
+	hourly bool     // Has an hour column, rather than a day.
+	key    []string // Columns in the unique constraint, in order.
+	cols   []string // Columns to insert.
+	set    string   // Column to set on conflict.
+	query  string
+}
+
+const aggregatePath = `paths.path, paths.title, paths.event`
+
+// All tables to export; for the queries $1 is the site, and $2 and $3 the start
+// (inclusive) and end (exclusive).
+var aggregateTables = []aggregateTable{
+	{
+		name: "hit_counts", hourly: true,
+		key:  []string{"site_id", "path_id", "hour"},
+		cols: []string{"site_id", "path_id", "hour", "total"},
+		set:  "total",
+		query: `select ` + aggregatePath + `, hit_counts.hour as t, hit_counts.total as count
+			from hit_counts
+			join paths using (path_id)
+			where hit_counts.site_id=$1 and hit_counts.hour>=$2 and hit_counts.hour<$3
+			order by hit_counts.hour, paths.path`,
+	},
+	{
+		name: "ref_counts", hourly: true,
+		key:  []string{"site_id", "path_id", "ref_id", "hour"},
+		cols: []string{"site_id", "path_id", "hour", "ref_id", "total"},
+		set:  "total",
+		query: `select ` + aggregatePath + `, ref_counts.hour as t, ref_counts.total as count,
+				refs.ref, coalesce(refs.ref_scheme, '') as ref_scheme
+			from ref_counts
+			join paths using (path_id)
+			join refs  using (ref_id)
+			where ref_counts.site_id=$1 and ref_counts.hour>=$2 and ref_counts.hour<$3
+			order by ref_counts.hour, paths.path, refs.ref`,
+	},
+	{
+		name: "hit_stats",
+		key:  []string{"site_id", "path_id", "day"},
+		cols: []string{"site_id", "path_id", "day", "stats"},
+		set:  "stats",
+		query: `select ` + aggregatePath + `, hit_stats.day as t, hit_stats.stats
+			from hit_stats
+			join paths using (path_id)
+			where hit_stats.site_id=$1 and hit_stats.day>=$2 and hit_stats.day<$3
+			order by hit_stats.day, paths.path`,
+	},
+	{
+		name: "browser_stats",
+		key:  []string{"site_id", "path_id", "day", "browser_id"},
+		cols: []string{"site_id", "path_id", "day", "browser_id", "count"},
+		set:  "count",
+		query: `select ` + aggregatePath + `, browser_stats.day as t, browser_stats.count,
+				coalesce(browsers.name, '') as name, coalesce(browsers.version, '') as version
+			from browser_stats
+			join paths    using (path_id)
+			join browsers using (browser_id)
+			where browser_stats.site_id=$1 and browser_stats.day>=$2 and browser_stats.day<$3
+			order by browser_stats.day, paths.path, name, version`,
+	},
+	{
+		name: "system_stats",
+		key:  []string{"site_id", "path_id", "day", "system_id"},
+		cols: []string{"site_id", "path_id", "day", "system_id", "count"},
+		set:  "count",
+		query: `select ` + aggregatePath + `, system_stats.day as t, system_stats.count,
+				coalesce(systems.name, '') as name, coalesce(systems.version, '') as version
+			from system_stats
+			join paths   using (path_id)
+			join systems using (system_id)
+			where system_stats.site_id=$1 and system_stats.day>=$2 and system_stats.day<$3
+			order by system_stats.day, paths.path, name, version`,
+	},
+	{
+		name: "location_stats",
+		key:  []string{"site_id", "path_id", "day", "location"},
+		cols: []string{"site_id", "path_id", "day", "location", "count"},
+		set:  "count",
+		query: `select ` + aggregatePath + `, location_stats.day as t, location_stats.count,
+				location_stats.location
+			from location_stats
+			join paths using (path_id)
+			where location_stats.site_id=$1 and location_stats.day>=$2 and location_stats.day<$3
+			order by location_stats.day, paths.path, location_stats.location`,
+	},
+	{
+		name: "size_stats",
+		key:  []string{"site_id", "path_id", "day", "width"},
+		cols: []string{"site_id", "path_id", "day", "width", "count"},
+		set:  "count",
+		query: `select ` + aggregatePath + `, size_stats.day as t, size_stats.count,
+				size_stats.width
+			from size_stats
+			join paths using (path_id)
+			where size_stats.site_id=$1 and size_stats.day>=$2 and size_stats.day<$3
+			order by size_stats.day, paths.path, size_stats.width`,
+	},
+	{
+		name: "language_stats",
+		key:  []string{"site_id", "path_id", "day", "language"},
+		cols: []string{"site_id", "path_id", "day", "language", "count"},
+		set:  "count",
+		query: `select ` + aggregatePath + `, language_stats.day as t, language_stats.count,
+				language_stats.language
+			from language_stats
+			join paths using (path_id)
+			where language_stats.site_id=$1 and language_stats.day>=$2 and language_stats.day<$3
+			order by language_stats.day, paths.path, language_stats.language`,
+	},
+	{
+		name: "campaign_stats",
+		key:  []string{"site_id", "path_id", "campaign_id", "ref", "day"},
+		cols: []string{"site_id", "path_id", "day", "campaign_id", "ref", "count"},
+		set:  "count",
+		query: `select ` + aggregatePath + `, campaign_stats.day as t, campaign_stats.count,
+				campaigns.name as campaign, campaign_stats.ref
+			from campaign_stats
+			join paths     using (path_id)
+			join campaigns using (campaign_id)
+			where campaign_stats.site_id=$1 and campaign_stats.day>=$2 and campaign_stats.day<$3
+			order by campaign_stats.day, paths.path, campaigns.name, campaign_stats.ref`,
+	},
+	{
+		name: "prop_stats",
+		key:  []string{"site_id", "path_id", "day", "name", "value"},
+		cols: []string{"site_id", "path_id", "day", "name", "value", "count"},
+		set:  "count",
+		query: `select ` + aggregatePath + `, prop_stats.day as t, prop_stats.count,
+				prop_stats.name, prop_stats.value
+			from prop_stats
+			join paths using (path_id)
+			where prop_stats.site_id=$1 and prop_stats.day>=$2 and prop_stats.day<$3
+			order by prop_stats.day, paths.path, prop_stats.name, prop_stats.value`,
+	},
+}
+
+func getAggregateTable(name string) (aggregateTable, bool) {
+	for _, t := range aggregateTables {
+		if t.name == name {
+			return t, true
+		}
+	}
+	return aggregateTable{}, false
+}
+
+// onConflict replaces the existing value, so that importing the same data twice
+// doesn't double the counts.
+func (t aggregateTable) onConflict(ctx context.Context) string {
+	if zdb.SQLDialect(ctx) == zdb.DialectPostgreSQL {
+		return `on conflict on constraint "` + t.name + "#" + strings.Join(t.key, "#") +
+			`" do update set ` + t.set + ` = excluded.` + t.set
+	}
+	return `on conflict(` + strings.Join(t.key, ", ") + `) do update set ` + t.set + ` = excluded.` + t.set
+}
+
+type aggregateWriter struct {
+	fp  *os.File
+	gz  *gzip.Writer
+	enc *json.Encoder
+}
+
+func newAggregateWriter(fp *os.File) *aggregateWriter {
+	gz := gzip.NewWriter(fp)
+	return &aggregateWriter{fp: fp, gz: gz, enc: json.NewEncoder(gz)}
+}
+
+func (w *aggregateWriter) Write(rows ExportAggregates) error {
+	for _, r := range rows {
+		err := w.enc.Encode(r)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (w *aggregateWriter) Close() error {
+	err := w.gz.Close()
+	if err != nil {
+		w.fp.Close()
+		return err
+	}
+	return w.fp.Close()
+}
+
+// exportAggregates writes all the stats tables for the export's date range.
+func (e *Export) exportAggregates(ctx context.Context, w *aggregateWriter) error {
+	site := MustGetSite(ctx)
+
+	start := site.FirstHitAt.UTC().Truncate(24 * time.Hour)
+	if e.RangeStart != nil {
+		start = *e.RangeStart
+	}
+	end := ztime.Now().UTC().Truncate(24 * time.Hour)
+	if e.RangeEnd != nil {
+		end = *e.RangeEnd
+	}
+	end = end.AddDate(0, 0, 1)
+
+	for _, t := range aggregateTables {
+		// Get it per month to keep the memory usage reasonable.
+		for from := start; from.Before(end); from = from.AddDate(0, 1, 0) {
+			to := from.AddDate(0, 1, 0)
+			if to.After(end) {
+				to = end
+			}
+
+			layout := "2006-01-02"
+			if t.hourly {
+				layout = "2006-01-02 15:04:05"
+			}
+			var rows ExportAggregates
+			err := zdb.Select(ctx, &rows, "/* Export.exportAggregates "+t.name+" */\n"+t.query,
+				site.ID, from.Format(layout), to.Format(layout))
+			if err != nil {
+				return errors.Wrap(err, "Export.exportAggregates "+t.name)
+			}
+			for i := range rows {
+				rows[i].Table = t.name
+				if t.hourly {
+					rows[i].Hour = rows[i].At.UTC().Format(time.RFC3339)
+				} else {
+					rows[i].Day = rows[i].At.UTC().Format("2006-01-02")
+				}
+			}
+
+			*e.NumRows += len(rows)
+			err = w.Write(rows)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// values gets the values to insert for this row, looking up or creating paths,
+// refs, etc. as needed.
+func (row ExportAggregate) values(ctx context.Context, t aggregateTable) ([]any, time.Time, error) {
+	v := NewValidate(ctx)
+	v.Required("path", row.Path)
+	var at time.Time
+	if t.hourly {
+		v.Required("hour", row.Hour)
+		at = v.Date("hour", row.Hour, time.RFC3339)
+	} else {
+		v.Required("day", row.Day)
+		at = v.Date("day", row.Day, "2006-01-02")
+	}
+	v.Range("count", int64(row.Count), 0, 0)
+	var stats []int
+	switch t.name {
+	case "hit_stats":
+		err := json.Unmarshal(row.Stats, &stats)
+		if err != nil || len(stats) != 24 {
+			v.Append("stats", "must be an array of 24 numbers")
+		}
+	case "size_stats":
+		if row.Width == nil {
+			v.Append("width", "must be set")
+		}
+	case "campaign_stats":
+		v.Required("campaign", row.Campaign)
+	case "prop_stats":
+		v.Required("name", row.Name)
+	}
+	if v.HasErrors() {
+		return nil, at, v
+	}
+
+	p := Path{Path: row.Path, Title: row.Title, Event: row.Event}
+	err := p.GetOrInsert(ctx)
+	if err != nil {
+		return nil, at, err
+	}
+
+	vals := []any{MustGetSite(ctx).ID, p.ID}
+	if t.hourly {
+		at = at.UTC().Truncate(time.Hour)
+		vals = append(vals, at.Format("2006-01-02 15:04:05"))
+	} else {
+		vals = append(vals, at.Format("2006-01-02"))
+	}
+
+	switch t.name {
+	case "hit_counts":
+		vals = append(vals, row.Count)
+	case "ref_counts":
+		r := Ref{Ref: row.Ref}
+		if row.RefScheme != "" {
+			r.RefScheme = &row.RefScheme
+		}
+		err = r.GetOrInsert(ctx)
+		vals = append(vals, r.ID, row.Count)
+	case "hit_stats":
+		vals = append(vals, zjson.MustMarshal(stats))
+	case "browser_stats":
+		var b Browser
+		err = b.GetOrInsert(ctx, row.Name, row.Version)
+		vals = append(vals, b.ID, row.Count)
+	case "system_stats":
+		var s System
+		err = s.GetOrInsert(ctx, row.Name, row.Version)
+		vals = append(vals, s.ID, row.Count)
+	case "location_stats":
+		vals = append(vals, row.Location, row.Count)
+	case "size_stats":
+		vals = append(vals, *row.Width, row.Count)
+	case "language_stats":
+		vals = append(vals, row.Language, row.Count)
+	case "campaign_stats":
+		c := Campaign{Name: row.Campaign}
+		err = c.ByName(ctx, c.Name)
+		if zdb.ErrNoRows(err) {
+			err = c.Insert(ctx)
+		}
+		vals = append(vals, c.ID, row.Ref, row.Count)
+	case "prop_stats":
+		vals = append(vals, row.Name, row.Value, row.Count)
+	}
+	return vals, at, err
+}
+
+// Import the rows in to the stats tables, replacing any existing values.
+//
+// Errors for individual rows are added to errs and the row is skipped; the
+// returned error is only set if inserting failed.
+func (a ExportAggregates) Import(ctx context.Context, errs *errors.Group) (int, error) {
+	var (
+		site  = MustGetSite(ctx)
+		first = site.FirstHitAt
+		ins   = make(map[string]*zdb.BulkInsert)
+		n     int
+	)
+	for _, row := range a {
+		t, ok := getAggregateTable(row.Table)
+		if !ok {
+			errs.Append(errors.Errorf("unknown table: %q", row.Table))
+			continue
+		}
+		vals, at, err := row.values(ctx, t)
+		if errs.Append(err) {
+			continue
+		}
+		if at.Before(first) {
+			first = at
+		}
+
+		b, ok := ins[t.name]
+		if !ok {
+			bi := zdb.NewBulkInsert(ctx, t.name, t.cols)
+			bi.OnConflict(t.onConflict(ctx))
+			b = &bi
+			ins[t.name] = b
+		}
+		b.Values(vals...)
+		n++
+	}
+
+	for _, t := range aggregateTables {
+		if b, ok := ins[t.name]; ok {
+			err := b.Finish()
+			if err != nil {
+				return 0, errors.Wrap(err, "ExportAggregates.Import "+t.name)
+			}
+		}
+	}
+
+	if first.Before(site.FirstHitAt) {
+		err := site.UpdateFirstHitAt(ctx, first)
+		if err != nil {
+			return n, errors.Wrap(err, "ExportAggregates.Import")
+		}
+	}
+	return n, nil
+}
+
+// IsAggregatesExport reports if the data looks like an aggregates export, based
+// on the first line.
+func IsAggregatesExport(r *bufio.Reader) bool {
+	line, _ := r.Peek(64)
+	line = bytes.TrimSpace(line)
+	return bytes.HasPrefix(line, []byte(`{"table":`))
+}
+
+// ImportAggregates imports an aggregates export; fp should already be
+// decompressed.
+//
+// If replace is set then all existing pageviews and statistics are removed
+// first.
+func ImportAggregates(ctx context.Context, fp io.Reader, replace, email bool) error {
+	site := MustGetSite(ctx)
+
+	l := zlog.Module("import").Field("site", site.ID).Field("replace", replace).Field("mode", ExportModeAggregates)
+	l.Print("import started")
+
+	if replace {
+		err := site.DeleteAll(ctx)
+		if err != nil {
+			l.Error(err)
+			return errors.Wrap(err, "goatcounter.ImportAggregates")
+		}
+	}
+
+	var (
+		s    = bufio.NewScanner(fp)
+		n    = 0
+		errs = errors.NewGroup(50)
+		rows = make(ExportAggregates, 0, 1000)
+	)
+	s.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	flush := func() error {
+		i, err := rows.Import(ctx, errs)
+		n += i
+		rows = rows[:0]
+		return err
+	}
+	for s.Scan() {
+		line := bytes.TrimSpace(s.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+
+		var row ExportAggregate
+		err := json.Unmarshal(line, &row)
+		if errs.Append(err) {
+			continue
+		}
+		rows = append(rows, row)
+		if len(rows) == cap(rows) {
+			err := flush()
+			if err != nil {
+				return errors.Wrap(err, "goatcounter.ImportAggregates")
+			}
+		}
+	}
+	if err := s.Err(); err != nil {
+		return errors.Wrap(err, "goatcounter.ImportAggregates")
+	}
+	if err := flush(); err != nil {
+		return errors.Wrap(err, "goatcounter.ImportAggregates")
+	}
+
+	l.Printf("imported %d rows", n)
+	if errs.Len() > 0 {
+		l.Error(errs)
+	}
+
+	if email {
+		err := blackmail.Send("GoatCounter import ready",
+			blackmail.From("GoatCounter import", Config(ctx).EmailFrom),
+			blackmail.To(GetUser(ctx).Email),
+			blackmail.BodyMustText(TplEmailImportDone{ctx, *site, n, errs, 0, true}.Render))
+		if err != nil {
+			l.Error(err)
+		}
+	}
+	SendWebhook(ctx, WebhookImportDone, map[string]int{"rows": n, "errors": errs.Len()})
+	return nil
+}
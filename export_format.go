@@ -0,0 +1,192 @@
+// Copyright © Martin Tournoij – This file is part of GoatCounter and published
+// under the terms of a slightly modified EUPL v1.2 license, which can be found
+// in the LICENSE file or at https://license.goatcounter.com
+
+package goatcounter
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/csv"
+	"io"
+	"os"
+	"strings"
+
+	"zgo.at/errors"
+	"zgo.at/json"
+)
+
+// Export formats.
+const (
+	ExportCSV    = "csv"    // Gzipped CSV file.
+	ExportJSONL  = "jsonl"  // Gzipped JSON Lines file, with one object per hit.
+	ExportSQLite = "sqlite" // SQLite database with hits and dimension tables.
+)
+
+var ExportFormats = []string{ExportCSV, ExportJSONL, ExportSQLite}
+
+// ExportFormatFromName gets the export format from a filename, based on the
+// extension. This defaults to ExportCSV.
+func ExportFormatFromName(name string) string {
+	name = strings.ToLower(strings.TrimSuffix(name, ".gz"))
+	switch {
+	case strings.HasSuffix(name, ".jsonl"), strings.HasSuffix(name, ".ndjson"):
+		return ExportJSONL
+	case strings.HasSuffix(name, ".sqlite"), strings.HasSuffix(name, ".sqlite3"), strings.HasSuffix(name, ".db"):
+		return ExportSQLite
+	}
+	return ExportCSV
+}
+
+func exportExt(format string) string {
+	switch format {
+	case ExportJSONL:
+		return "jsonl.gz"
+	case ExportSQLite:
+		return "sqlite"
+	}
+	return "csv.gz"
+}
+
+// exportWriter writes rows for an export; Close() closes the underlying file.
+type exportWriter interface {
+	Write(ExportRows) error
+	Close() error
+}
+
+func newExportWriter(format string, fp *os.File) (exportWriter, error) {
+	switch format {
+	case ExportCSV, "":
+		gz := gzip.NewWriter(fp)
+		c := csv.NewWriter(gz)
+		err := c.Write([]string{ExportVersion + "Path", "Title", "Event", "UserAgent",
+			"Browser", "System", "Session", "Bot", "Referrer", "Referrer scheme",
+			"Screen size", "Location", "FirstVisit", "Date"})
+		return &csvExportWriter{fp: fp, gz: gz, c: c}, err
+	case ExportJSONL:
+		gz := gzip.NewWriter(fp)
+		return &jsonlExportWriter{fp: fp, gz: gz, enc: json.NewEncoder(gz)}, nil
+	case ExportSQLite:
+		return newSQLiteExportWriter(fp)
+	}
+	return nil, errors.Errorf("unknown export format: %q", format)
+}
+
+type csvExportWriter struct {
+	fp *os.File
+	gz *gzip.Writer
+	c  *csv.Writer
+}
+
+func (w *csvExportWriter) Write(rows ExportRows) error {
+	for _, hit := range rows {
+		w.c.Write([]string{hit.Path, hit.Title, hit.Event, hit.UserAgent,
+			hit.Browser, hit.System, hit.Session.String(), hit.Bot, hit.Ref,
+			hit.RefScheme, hit.Size, hit.Location, hit.FirstVisit,
+			hit.CreatedAt})
+	}
+	w.c.Flush()
+	return w.c.Error()
+}
+
+func (w *csvExportWriter) Close() error {
+	err := w.gz.Close()
+	if err != nil {
+		w.fp.Close()
+		return err
+	}
+	return w.fp.Close()
+}
+
+type jsonlExportWriter struct {
+	fp  *os.File
+	gz  *gzip.Writer
+	enc *json.Encoder
+}
+
+func (w *jsonlExportWriter) Write(rows ExportRows) error {
+	for _, hit := range rows {
+		err := w.enc.Encode(hit)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (w *jsonlExportWriter) Close() error {
+	err := w.gz.Close()
+	if err != nil {
+		w.fp.Close()
+		return err
+	}
+	return w.fp.Close()
+}
+
+// exportReader reads rows from an export; Read() returns io.EOF if there are no
+// more rows.
+type exportReader interface {
+	Read() (ExportRow, error)
+	Close() error
+}
+
+func newExportReader(format string, fp io.Reader) (exportReader, error) {
+	switch format {
+	case ExportCSV, "":
+		c := csv.NewReader(fp)
+		header, err := c.Read()
+		if err != nil {
+			return nil, err
+		}
+		if len(header) == 0 || !strings.HasPrefix(header[0], ExportVersion) {
+			return nil, errors.Errorf("wrong version of CSV database: %s (expected: %s)",
+				header[0][:1], ExportVersion)
+		}
+		return &csvExportReader{c: c}, nil
+	case ExportJSONL:
+		s := bufio.NewScanner(fp)
+		s.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+		return &jsonlExportReader{s: s}, nil
+	case ExportSQLite:
+		return newSQLiteExportReader(fp)
+	}
+	return nil, errors.Errorf("unknown export format: %q", format)
+}
+
+type csvExportReader struct{ c *csv.Reader }
+
+func (r *csvExportReader) Close() error { return nil }
+func (r *csvExportReader) Read() (ExportRow, error) {
+	var row ExportRow
+	line, err := r.c.Read()
+	if err != nil {
+		return row, err
+	}
+	return row, row.Read(line)
+}
+
+type jsonlExportReader struct {
+	s    *bufio.Scanner
+	done bool
+}
+
+func (r *jsonlExportReader) Close() error { return nil }
+func (r *jsonlExportReader) Read() (ExportRow, error) {
+	var row ExportRow
+	for {
+		if r.done || !r.s.Scan() {
+			// The scanner can't continue after an error, so report it once
+			// and then stop.
+			if err := r.s.Err(); err != nil && !r.done {
+				r.done = true
+				return row, err
+			}
+			return row, io.EOF
+		}
+		line := bytes.TrimSpace(r.s.Bytes())
+		if len(line) > 0 {
+			return row, json.Unmarshal(line, &row)
+		}
+	}
+}
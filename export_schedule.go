@@ -0,0 +1,233 @@
+// Copyright © Martin Tournoij – This file is part of GoatCounter and published
+// under the terms of a slightly modified EUPL v1.2 license, which can be found
+// in the LICENSE file or at https://license.goatcounter.com
+
+package goatcounter
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"zgo.at/errors"
+	"zgo.at/goatcounter/v2/objstore"
+	"zgo.at/zdb"
+	"zgo.at/zstd/ztime"
+)
+
+// Export schedule periods.
+const (
+	ExportHourly = "hourly"
+	ExportDaily  = "daily"
+	ExportWeekly = "weekly"
+)
+
+var ExportPeriods = []string{ExportHourly, ExportDaily, ExportWeekly}
+
+// ExportSchedule periodically runs an incremental export and stores the file in
+// a directory or S3-compatible bucket.
+type ExportSchedule struct {
+	ID     int64 `db:"export_schedule_id" json:"id,readonly"`
+	SiteID int64 `db:"site_id" json:"site_id,readonly"`
+
+	// Destination; either an absolute path to a local directory or an URL in
+	// the form of s3://key:secret@host/bucket/prefix (see objstore.New).
+	//
+	// Local directories must be below the ExportDir from the config.
+	Dest string `db:"dest" json:"dest"`
+
+	// How often to run the export: hourly, daily, or weekly.
+	Period string `db:"period" json:"period"`
+
+	// File format: csv, jsonl, or sqlite.
+	Format string `db:"format" json:"format"`
+
+	// Remove exports older than this many days from the destination; 0 keeps
+	// them forever.
+	KeepDays int `db:"keep_days" json:"keep_days"`
+
+	// Send an email to this address if the export fails.
+	Email string `db:"email" json:"email"`
+
+	// Hit ID of the last exported pageview; the next export will start from
+	// here.
+	LastHitID int64      `db:"last_hit_id" json:"last_hit_id"`
+	LastRunAt *time.Time `db:"last_run_at" json:"last_run_at,readonly"`
+	LastError string     `db:"last_error" json:"last_error,readonly"`
+	CreatedAt time.Time  `db:"created_at" json:"created_at,readonly"`
+}
+
+// Defaults sets fields to default values, unless they're already set.
+func (e *ExportSchedule) Defaults(ctx context.Context) {
+	if s := GetSite(ctx); s != nil && s.ID > 0 {
+		e.SiteID = s.ID
+	}
+	if e.CreatedAt.IsZero() {
+		e.CreatedAt = ztime.Now()
+	}
+	if e.Period == "" {
+		e.Period = ExportDaily
+	}
+	if e.Format == "" {
+		e.Format = ExportCSV
+	}
+	e.Dest = strings.TrimSpace(e.Dest)
+	e.Email = strings.TrimSpace(e.Email)
+}
+
+func (e *ExportSchedule) Validate(ctx context.Context) error {
+	v := NewValidate(ctx)
+	v.Required("site_id", e.SiteID)
+	v.Required("dest", e.Dest)
+	v.Len("dest", e.Dest, 0, 2048)
+	v.Include("period", e.Period, ExportPeriods)
+	v.Include("format", e.Format, ExportFormats)
+	v.Range("keep_days", int64(e.KeepDays), 0, 3650)
+	if e.Email != "" {
+		v.Email("email", e.Email)
+	}
+
+	if e.Dest != "" {
+		_, err := objstore.New(e.Dest)
+		switch {
+		case err != nil:
+			v.Append("dest", strings.TrimPrefix(err.Error(), "objstore.New: "))
+		case strings.HasPrefix(e.Dest, "s3://"):
+			validatePublicURL(ctx, &v, "dest", e.Dest)
+		case Config(ctx).GoatcounterCom:
+			v.Append("dest", "must be an s3:// URL")
+		default:
+			err := e.checkDir(ctx)
+			if err != nil {
+				v.Append("dest", err.Error())
+			}
+		}
+	}
+	return v.ErrorOrNil()
+}
+
+// checkDir checks that a local directory is below the configured ExportDir.
+func (e ExportSchedule) checkDir(ctx context.Context) error {
+	root := Config(ctx).ExportDir
+	if root == "" {
+		return errors.New("must be an s3:// URL; local directories aren't enabled on this server")
+	}
+	rel, err := filepath.Rel(filepath.Clean(root), filepath.Clean(e.Dest))
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return fmt.Errorf("must be a directory below %s", root)
+	}
+	return nil
+}
+
+// Insert a new row.
+func (e *ExportSchedule) Insert(ctx context.Context) error {
+	if e.ID > 0 {
+		return errors.New("ID > 0")
+	}
+
+	e.Defaults(ctx)
+	err := e.Validate(ctx)
+	if err != nil {
+		return err
+	}
+
+	e.ID, err = zdb.InsertID(ctx, "export_schedule_id",
+		`insert into export_schedules (site_id, dest, period, format, keep_days, email, last_hit_id, created_at) values (?)`,
+		zdb.L{e.SiteID, e.Dest, e.Period, e.Format, e.KeepDays, e.Email, e.LastHitID, e.CreatedAt})
+	return errors.Wrap(err, "ExportSchedule.Insert")
+}
+
+func (e *ExportSchedule) ByID(ctx context.Context, id int64) error {
+	return errors.Wrapf(zdb.Get(ctx, e, `/* ExportSchedule.ByID */
+		select * from export_schedules where export_schedule_id=$1 and site_id=$2`,
+		id, MustGetSite(ctx).ID), "ExportSchedule.ByID %d", id)
+}
+
+func (e *ExportSchedule) Delete(ctx context.Context) error {
+	err := zdb.Exec(ctx,
+		`/* ExportSchedule.Delete */ delete from export_schedules where export_schedule_id=$1 and site_id=$2`,
+		e.ID, MustGetSite(ctx).ID)
+	return errors.Wrapf(err, "ExportSchedule.Delete %d", e.ID)
+}
+
+// Ran records that the export was run at the given time; lastHitID is only
+// updated if exportErr is empty.
+func (e *ExportSchedule) Ran(ctx context.Context, t time.Time, lastHitID int64, exportErr string) error {
+	t = t.UTC().Truncate(time.Second)
+	if exportErr != "" {
+		lastHitID = e.LastHitID
+	}
+	err := zdb.Exec(ctx, `update export_schedules set last_run_at=$1, last_hit_id=$2, last_error=$3
+		where export_schedule_id=$4`, t, lastHitID, exportErr, e.ID)
+	if err != nil {
+		return errors.Wrapf(err, "ExportSchedule.Ran %d", e.ID)
+	}
+	e.LastRunAt, e.LastHitID, e.LastError = &t, lastHitID, exportErr
+	return nil
+}
+
+// Due reports if the export should run at the given time.
+func (e ExportSchedule) Due(now time.Time) bool {
+	if e.LastRunAt == nil {
+		return true
+	}
+	last := e.LastRunAt.UTC()
+	switch e.Period {
+	case ExportHourly:
+		return !now.Before(last.Truncate(time.Hour).Add(time.Hour))
+	case ExportWeekly:
+		return !now.Before(ztime.StartOf(last, ztime.Day).AddDate(0, 0, 7))
+	default:
+		return !now.Before(ztime.StartOf(last, ztime.Day).AddDate(0, 0, 1))
+	}
+}
+
+// Store gets the destination store.
+//
+// Local directories are checked against the config again, in case the
+// ExportDir changed since the schedule was added.
+func (e ExportSchedule) Store(ctx context.Context) (objstore.Store, error) {
+	s, err := objstore.New(e.Dest)
+	if err != nil {
+		return nil, errors.Wrap(err, "ExportSchedule.Store")
+	}
+	switch ss := s.(type) {
+	case *objstore.S3:
+		ss.Client = &http.Client{Timeout: 10 * time.Minute, Transport: PublicTransport}
+	case objstore.Dir:
+		err := e.checkDir(ctx)
+		if err != nil {
+			return nil, errors.Wrapf(err, "ExportSchedule.Store: %s", e.Dest)
+		}
+	}
+	return s, nil
+}
+
+// Display gets the destination without any credentials.
+func (e ExportSchedule) Display() string {
+	u, err := url.Parse(e.Dest)
+	if err != nil || u.Scheme == "" {
+		return e.Dest
+	}
+	u.User = nil
+	return u.String()
+}
+
+type ExportSchedules []ExportSchedule
+
+// List all export schedules for this site.
+func (e *ExportSchedules) List(ctx context.Context) error {
+	return errors.Wrap(zdb.Select(ctx, e,
+		`select * from export_schedules where site_id=$1 order by export_schedule_id`,
+		MustGetSite(ctx).ID), "ExportSchedules.List")
+}
+
+// UnscopedList lists all export schedules for all sites.
+func (e *ExportSchedules) UnscopedList(ctx context.Context) error {
+	return errors.Wrap(zdb.Select(ctx, e,
+		`select * from export_schedules order by site_id, export_schedule_id`), "ExportSchedules.UnscopedList")
+}
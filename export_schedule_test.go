@@ -0,0 +1,89 @@
+// Copyright © Martin Tournoij – This file is part of GoatCounter and published
+// under the terms of a slightly modified EUPL v1.2 license, which can be found
+// in the LICENSE file or at https://license.goatcounter.com
+
+package goatcounter_test
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	. "zgo.at/goatcounter/v2"
+	"zgo.at/goatcounter/v2/gctest"
+	"zgo.at/zstd/ztest"
+)
+
+func TestExportScheduleDue(t *testing.T) {
+	last := time.Date(2020, 6, 18, 12, 13, 14, 0, time.UTC)
+	tests := []struct {
+		period string
+		now    time.Time
+		want   bool
+	}{
+		{ExportHourly, last.Add(30 * time.Minute), false},
+		{ExportHourly, time.Date(2020, 6, 18, 13, 0, 0, 0, time.UTC), true},
+		{ExportDaily, time.Date(2020, 6, 18, 23, 59, 0, 0, time.UTC), false},
+		{ExportDaily, time.Date(2020, 6, 19, 0, 0, 0, 0, time.UTC), true},
+		{ExportWeekly, time.Date(2020, 6, 24, 23, 0, 0, 0, time.UTC), false},
+		{ExportWeekly, time.Date(2020, 6, 25, 0, 0, 0, 0, time.UTC), true},
+	}
+
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("%s %s", tt.period, tt.now), func(t *testing.T) {
+			e := ExportSchedule{Period: tt.period}
+			if !e.Due(tt.now) {
+				t.Error("not due without LastRunAt")
+			}
+			e.LastRunAt = &last
+			if have := e.Due(tt.now); have != tt.want {
+				t.Errorf("have %t; want %t", have, tt.want)
+			}
+		})
+	}
+}
+
+func TestExportScheduleDisplay(t *testing.T) {
+	tests := []struct{ in, want string }{
+		{"/var/exports", "/var/exports"},
+		{"s3://key:secret@minio:9000/bucket/prefix?http=1", "s3://minio:9000/bucket/prefix?http=1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			if have := (ExportSchedule{Dest: tt.in}).Display(); have != tt.want {
+				t.Errorf("\nhave: %s\nwant: %s", have, tt.want)
+			}
+		})
+	}
+}
+
+func TestExportScheduleValidate(t *testing.T) {
+	ctx := gctest.DB(t)
+	Config(ctx).GoatcounterCom = false
+
+	tests := []struct {
+		dest, exportDir, wantErr string
+	}{
+		{"s3://key:secret@s3.example.com/bucket", "", ""},
+		{"s3://key:secret@127.0.0.1:9000/bucket", "", "dest: must not be a loopback"},
+		{"s3://key:secret@[::1]:9000/bucket", "", "dest: must not be a loopback"},
+		{"/var/exports", "", "local directories aren't enabled"},
+		{"/var/exports", "/var/exports", ""},
+		{"/var/exports/site", "/var/exports", ""},
+		{"/var/exports/../other", "/var/exports", "must be a directory below /var/exports"},
+		{"/etc", "/var/exports", "must be a directory below /var/exports"},
+		{"/var/exports-other", "/var/exports", "must be a directory below /var/exports"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.dest, func(t *testing.T) {
+			Config(ctx).ExportDir = tt.exportDir
+			e := ExportSchedule{Dest: tt.dest}
+			e.Defaults(ctx)
+			err := e.Validate(ctx)
+			if !ztest.ErrorContains(err, tt.wantErr) {
+				t.Fatalf("wrong error\nhave: %v\nwant: %s", err, tt.wantErr)
+			}
+		})
+	}
+}
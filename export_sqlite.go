@@ -0,0 +1,262 @@
+// Copyright © Martin Tournoij – This file is part of GoatCounter and published
+// under the terms of a slightly modified EUPL v1.2 license, which can be found
+// in the LICENSE file or at https://license.goatcounter.com
+
+//go:build cgo
+// +build cgo
+
+package goatcounter
+
+import (
+	"context"
+	"database/sql"
+	"io"
+	"os"
+	"strconv"
+
+	"zgo.at/errors"
+	"zgo.at/zstd/zint"
+	"zgo.at/zstd/ztime"
+)
+
+// Schema for SQLite exports; this is deliberately simpler than our own
+// schema, so it's easy to use from other tools.
+const sqliteExportSchema = `
+	create table export_info (
+		version     varchar not null,
+		created_at  varchar not null
+	);
+	create table paths (
+		path_id     integer primary key,
+		path        varchar not null,
+		title       varchar not null,
+		event       integer not null
+	);
+	create table refs (
+		ref_id      integer primary key,
+		ref         varchar not null,
+		ref_scheme  varchar not null
+	);
+	create table browsers (
+		browser_id  integer primary key,
+		name        varchar not null
+	);
+	create table systems (
+		system_id   integer primary key,
+		name        varchar not null
+	);
+	create table sizes (
+		size_id     integer primary key,
+		size        varchar not null
+	);
+	create table hits (
+		hit_id      integer primary key,
+		path_id     integer not null references paths(path_id),
+		ref_id      integer not null references refs(ref_id),
+		browser_id  integer not null references browsers(browser_id),
+		system_id   integer not null references systems(system_id),
+		size_id     integer not null references sizes(size_id),
+		user_agent  varchar not null,
+		session     varchar not null,
+		bot         integer not null,
+		location    varchar not null,
+		first_visit integer not null,
+		created_at  varchar not null
+	);
+	create index "hits#path_id" on hits(path_id);
+	create index "hits#created_at" on hits(created_at);
+`
+
+type sqliteExportWriter struct {
+	db *sql.DB
+
+	// Dimension tables: table → value → ID.
+	dims map[string]map[string]int64
+}
+
+func newSQLiteExportWriter(fp *os.File) (exportWriter, error) {
+	// We need a path rather than a file descriptor.
+	path := fp.Name()
+	fp.Close()
+
+	db, err := sql.Open("sqlite3", "file:"+path)
+	if err != nil {
+		return nil, errors.Wrap(err, "newSQLiteExportWriter")
+	}
+	_, err = db.Exec(sqliteExportSchema)
+	if err == nil {
+		_, err = db.Exec(`insert into export_info (version, created_at) values (?, ?)`,
+			ExportVersion, ztime.Now().UTC().Format("2006-01-02T15:04:05Z"))
+	}
+	if err != nil {
+		db.Close()
+		return nil, errors.Wrap(err, "newSQLiteExportWriter")
+	}
+	return &sqliteExportWriter{db: db, dims: make(map[string]map[string]int64)}, nil
+}
+
+// dim gets the ID for a dimension value, inserting a new row if needed.
+func (w *sqliteExportWriter) dim(tx *sql.Tx, table, key string, insert string, args ...any) (int64, error) {
+	m, ok := w.dims[table]
+	if !ok {
+		m = make(map[string]int64)
+		w.dims[table] = m
+	}
+	if id, ok := m[key]; ok {
+		return id, nil
+	}
+
+	id := int64(len(m) + 1)
+	_, err := tx.Exec(insert, append([]any{id}, args...)...)
+	if err != nil {
+		return 0, err
+	}
+	m[key] = id
+	return id, nil
+}
+
+func (w *sqliteExportWriter) Write(rows ExportRows) error {
+	tx, err := w.db.BeginTx(context.Background(), nil)
+	if err != nil {
+		return errors.Wrap(err, "sqliteExportWriter.Write")
+	}
+	defer tx.Rollback()
+
+	for _, r := range rows {
+		var (
+			event, _ = strconv.ParseBool(r.Event)
+			first, _ = strconv.ParseBool(r.FirstVisit)
+			bot, _   = strconv.Atoi(r.Bot)
+			ids      [5]int64
+		)
+		ids[0], err = w.dim(tx, "paths", r.Path+"\x00"+r.Title+"\x00"+strconv.FormatBool(event),
+			`insert into paths (path_id, path, title, event) values (?, ?, ?, ?)`, r.Path, r.Title, event)
+		if err == nil {
+			ids[1], err = w.dim(tx, "refs", r.Ref+"\x00"+r.RefScheme,
+				`insert into refs (ref_id, ref, ref_scheme) values (?, ?, ?)`, r.Ref, r.RefScheme)
+		}
+		if err == nil {
+			ids[2], err = w.dim(tx, "browsers", r.Browser,
+				`insert into browsers (browser_id, name) values (?, ?)`, r.Browser)
+		}
+		if err == nil {
+			ids[3], err = w.dim(tx, "systems", r.System,
+				`insert into systems (system_id, name) values (?, ?)`, r.System)
+		}
+		if err == nil {
+			ids[4], err = w.dim(tx, "sizes", r.Size,
+				`insert into sizes (size_id, size) values (?, ?)`, r.Size)
+		}
+		if err == nil {
+			_, err = tx.Exec(`insert into hits (hit_id, path_id, ref_id, browser_id, system_id, size_id,
+				user_agent, session, bot, location, first_visit, created_at) values (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+				r.ID, ids[0], ids[1], ids[2], ids[3], ids[4],
+				r.UserAgent, r.Session.String(), bot, r.Location, first, r.CreatedAt)
+		}
+		if err != nil {
+			return errors.Wrap(err, "sqliteExportWriter.Write")
+		}
+	}
+	return errors.Wrap(tx.Commit(), "sqliteExportWriter.Write")
+}
+
+func (w *sqliteExportWriter) Close() error {
+	return errors.Wrap(w.db.Close(), "sqliteExportWriter.Close")
+}
+
+type sqliteExportReader struct {
+	tmp  string
+	db   *sql.DB
+	rows *sql.Rows
+}
+
+func newSQLiteExportReader(fp io.Reader) (exportReader, error) {
+	// SQLite can only read from files, so copy it to a temporary file if it's
+	// not a file already.
+	tmp, err := os.CreateTemp("", "goatcounter-import-*.sqlite")
+	if err != nil {
+		return nil, errors.Wrap(err, "newSQLiteExportReader")
+	}
+	_, err = io.Copy(tmp, fp)
+	if err == nil {
+		err = tmp.Close()
+	} else {
+		tmp.Close()
+	}
+
+	r := &sqliteExportReader{tmp: tmp.Name()}
+	if err == nil {
+		r.db, err = sql.Open("sqlite3", "file:"+tmp.Name()+"?mode=ro")
+	}
+	if err != nil {
+		r.Close()
+		return nil, errors.Wrap(err, "newSQLiteExportReader")
+	}
+
+	var version string
+	err = r.db.QueryRow(`select version from export_info`).Scan(&version)
+	if err != nil {
+		r.Close()
+		return nil, errors.Errorf("not a GoatCounter SQLite export: %w", err)
+	}
+	if version != ExportVersion {
+		r.Close()
+		return nil, errors.Errorf("wrong version of SQLite export: %s (expected: %s)", version, ExportVersion)
+	}
+
+	r.rows, err = r.db.Query(`
+		select
+			hits.hit_id, paths.path, paths.title, paths.event, hits.user_agent,
+			browsers.name, systems.name, hits.session, hits.bot, refs.ref,
+			refs.ref_scheme, sizes.size, hits.location, hits.first_visit,
+			hits.created_at
+		from hits
+		join paths    using (path_id)
+		join refs     using (ref_id)
+		join browsers using (browser_id)
+		join systems  using (system_id)
+		join sizes    using (size_id)
+		order by hit_id asc`)
+	if err != nil {
+		r.Close()
+		return nil, errors.Wrap(err, "newSQLiteExportReader")
+	}
+	return r, nil
+}
+
+func (r *sqliteExportReader) Read() (ExportRow, error) {
+	var row ExportRow
+	if !r.rows.Next() {
+		if r.rows.Err() != nil {
+			return row, r.rows.Err()
+		}
+		return row, io.EOF
+	}
+
+	var (
+		event, first bool
+		bot          int
+		session      string
+	)
+	err := r.rows.Scan(&row.ID, &row.Path, &row.Title, &event, &row.UserAgent,
+		&row.Browser, &row.System, &session, &bot, &row.Ref,
+		&row.RefScheme, &row.Size, &row.Location, &first,
+		&row.CreatedAt)
+	if err != nil {
+		return row, err
+	}
+	row.Event, row.FirstVisit = strconv.FormatBool(event), strconv.FormatBool(first)
+	row.Bot = strconv.Itoa(bot)
+	row.Session, _ = zint.ParseUint128(session, 16)
+	return row, nil
+}
+
+func (r *sqliteExportReader) Close() error {
+	if r.rows != nil {
+		r.rows.Close()
+	}
+	if r.db != nil {
+		r.db.Close()
+	}
+	return os.Remove(r.tmp)
+}
@@ -0,0 +1,20 @@
+// Copyright © Martin Tournoij – This file is part of GoatCounter and published
+// under the terms of a slightly modified EUPL v1.2 license, which can be found
+// in the LICENSE file or at https://license.goatcounter.com
+
+//go:build !cgo
+// +build !cgo
+
+package goatcounter
+
+import (
+	"io"
+	"os"
+
+	"zgo.at/errors"
+)
+
+var errNoSQLite = errors.New("SQLite exports are not supported: GoatCounter was compiled without cgo")
+
+func newSQLiteExportWriter(fp *os.File) (exportWriter, error)  { return nil, errNoSQLite }
+func newSQLiteExportReader(fp io.Reader) (exportReader, error) { return nil, errNoSQLite }
@@ -6,12 +6,15 @@ package goatcounter_test
 
 import (
 	"compress/gzip"
+	"context"
+	"io"
 	"os"
 	"strings"
 	"testing"
 	"time"
 
 	"zgo.at/blackmail"
+	"zgo.at/errors"
 	"zgo.at/goatcounter/v2"
 	"zgo.at/goatcounter/v2/gctest"
 	"zgo.at/zdb"
@@ -22,52 +25,9 @@ import (
 func TestExport(t *testing.T) {
 	blackmail.DefaultMailer = blackmail.NewMailer(blackmail.ConnectWriter)
 	ctx := gctest.DB(t)
+	dump := func() string { return dumpExport(ctx) }
 
-	dump := func() string {
-		return zdb.DumpString(ctx, `
-		select
-			hits.site_id,
-
-			paths.path,
-			paths.title,
-			paths.event,
-
-			browsers.name || ' ' || browsers.version as browser,
-			systems.name  || ' ' || systems.version  as system,
-
-			-- hits.session,
-			hits.bot,
-			hits.ref,
-			hits.ref_scheme as ref_s,
-			hits.size,
-			hits.location as loc,
-			hits.first_visit as first,
-			hits.created_at
-		from hits
-		join paths       using (path_id)
-		join browsers    using (browser_id)
-		join systems     using (system_id)
-		order by hit_id asc`)
-	}
-
-	d1 := time.Date(2019, 6, 18, 0, 0, 0, 0, time.UTC)
-	d2 := time.Date(2019, 6, 19, 0, 0, 0, 0, time.UTC)
-	gctest.StoreHits(ctx, t, false, []goatcounter.Hit{
-		{Path: "/asd", CreatedAt: d1, UserAgentHeader: "Mozilla/5.0 (X11; Linux x86_64; rv:80.0) Gecko/20100101 Firefox/80.0", Title: "Page asd"},
-		{Path: "/zxc", CreatedAt: d1, UserAgentHeader: "Mozilla/5.0 (X11; Linux x86_64; rv:80.0) Gecko/20100101 Firefox/80.0", Title: "Page zxc"},
-		{Path: "event", CreatedAt: d2, Event: true},
-		{Path: "bot-event", CreatedAt: d2, Event: true, Bot: 1},
-		{
-			Path:            "/asd",
-			CreatedAt:       d2,
-			UserAgentHeader: "Mozilla/5.0 (X11; Linux x86_64; rv:79.0) Gecko/20100101 Firefox/79.0",
-			Title:           "Other",
-			Location:        "ID",
-			Size:            goatcounter.Floats{1024, 768, 1},
-			Ref:             "https://example.com/p",
-		},
-	}...)
-
+	storeExportHits(ctx, t)
 	initial := dump()
 
 	var export goatcounter.Export
@@ -90,6 +50,10 @@ func TestExport(t *testing.T) {
 			"site_id": 1,
 			"start_from_hit_id": 0,
 			"last_hit_id": 5,
+			"format": "csv",
+			"mode": "hits",
+			"range_start": null,
+			"range_end": null,
 			"path": "%(ANY)goatcounter-export-gctest-%(YEAR)%(MONTH)%(DAY)T%(ANY)Z-0.csv.gz",
 			"created_at": "%(YEAR)-%(MONTH)-%(DAY)T%(ANY)Z",
 			"finished_at": null,
@@ -126,7 +90,7 @@ func TestExport(t *testing.T) {
 		}
 		defer gzfp.Close()
 
-		goatcounter.Import(ctx, gzfp, true, false, func(hit goatcounter.Hit, final bool) {
+		goatcounter.Import(ctx, gzfp, goatcounter.ExportCSV, true, false, func(hit goatcounter.Hit, final bool) {
 			if !final {
 				goatcounter.Memstore.Append(hit)
 			}
@@ -143,3 +107,321 @@ func TestExport(t *testing.T) {
 		}
 	})
 }
+
+func TestExportFormats(t *testing.T) {
+	blackmail.DefaultMailer = blackmail.NewMailer(blackmail.ConnectWriter)
+
+	for _, f := range goatcounter.ExportFormats {
+		t.Run(f, func(t *testing.T) {
+			ctx := gctest.DB(t)
+			storeExportHits(ctx, t)
+			initial := dumpExport(ctx)
+
+			export := goatcounter.Export{Format: f}
+			fp, err := export.Create(ctx, 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.Remove(export.Path)
+			export.Run(ctx, fp, false)
+
+			if export.Error != nil || *export.NumRows != 5 {
+				t.Fatalf("export failed: %#v", export)
+			}
+			if !strings.HasSuffix(export.Path, "-0."+map[string]string{
+				"csv": "csv.gz", "jsonl": "jsonl.gz", "sqlite": "sqlite"}[f]) {
+				t.Errorf("wrong path: %s", export.Path)
+			}
+			if have := goatcounter.ExportFormatFromName(export.Path); have != f {
+				t.Errorf("ExportFormatFromName: %s", have)
+			}
+
+			rfp, err := os.Open(export.Path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer rfp.Close()
+			var r io.Reader = rfp
+			if f != goatcounter.ExportSQLite {
+				r, err = gzip.NewReader(rfp)
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			_, err = goatcounter.Import(ctx, r, f, true, false, func(hit goatcounter.Hit, final bool) {
+				if !final {
+					goatcounter.Memstore.Append(hit)
+				}
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, err = goatcounter.Memstore.Persist(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if d := ztest.Diff(dumpExport(ctx), initial); d != "" {
+				t.Error(d)
+			}
+		})
+	}
+
+	t.Run("jsonl fields", func(t *testing.T) {
+		ctx := gctest.DB(t)
+		line := `{"path":"/x","title":"X","event":"false","bot":"0","first_visit":"true","created_at":"2019-06-18T00:00:00Z"}` + "\n\n"
+		var hits []goatcounter.Hit
+		_, err := goatcounter.Import(ctx, strings.NewReader(line+line), goatcounter.ExportJSONL, false, false,
+			func(hit goatcounter.Hit, final bool) {
+				if !final {
+					hits = append(hits, hit)
+				}
+			})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(hits) != 2 || hits[0].Path != "/x" || hits[0].Title != "X" || !hits[0].FirstVisit {
+			t.Errorf("%#v", hits)
+		}
+	})
+}
+
+func TestExportReimport(t *testing.T) {
+	blackmail.DefaultMailer = blackmail.NewMailer(blackmail.ConnectWriter)
+	ctx := gctest.DB(t)
+	storeExportHits(ctx, t)
+	initial := dumpExport(ctx)
+
+	export := goatcounter.Export{Format: goatcounter.ExportJSONL}
+	fp, err := export.Create(ctx, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(export.Path)
+	export.Run(ctx, fp, false)
+
+	imp := func(replace bool) int {
+		t.Helper()
+		fp, err := os.Open(export.Path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer fp.Close()
+		gzfp, err := gzip.NewReader(fp)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer gzfp.Close()
+
+		var n int
+		_, err = goatcounter.Import(ctx, gzfp, goatcounter.ExportJSONL, replace, false, func(hit goatcounter.Hit, final bool) {
+			if !final {
+				goatcounter.Memstore.Append(hit)
+				n++
+			}
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = goatcounter.Memstore.Persist(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return n
+	}
+
+	if n := imp(true); n != 5 {
+		t.Fatalf("imported %d hits on first import", n)
+	}
+
+	// Importing the same file again, e.g. after a crash, should skip
+	// everything.
+	if n := imp(false); n != 0 {
+		t.Fatalf("imported %d hits on second import", n)
+	}
+	if d := ztest.Diff(dumpExport(ctx), initial); d != "" {
+		t.Error(d)
+	}
+
+	// Replacing clears the hashes.
+	if n := imp(true); n != 5 {
+		t.Fatalf("imported %d hits after replace", n)
+	}
+	if d := ztest.Diff(dumpExport(ctx), initial); d != "" {
+		t.Error(d)
+	}
+}
+
+func storeExportHits(ctx context.Context, t *testing.T) {
+	d1 := time.Date(2019, 6, 18, 0, 0, 0, 0, time.UTC)
+	d2 := time.Date(2019, 6, 19, 0, 0, 0, 0, time.UTC)
+	gctest.StoreHits(ctx, t, false, []goatcounter.Hit{
+		{Path: "/asd", CreatedAt: d1, UserAgentHeader: "Mozilla/5.0 (X11; Linux x86_64; rv:80.0) Gecko/20100101 Firefox/80.0", Title: "Page asd"},
+		{Path: "/zxc", CreatedAt: d1, UserAgentHeader: "Mozilla/5.0 (X11; Linux x86_64; rv:80.0) Gecko/20100101 Firefox/80.0", Title: "Page zxc"},
+		{Path: "event", CreatedAt: d2, Event: true},
+		{Path: "bot-event", CreatedAt: d2, Event: true, Bot: 1},
+		{
+			Path:            "/asd",
+			CreatedAt:       d2,
+			UserAgentHeader: "Mozilla/5.0 (X11; Linux x86_64; rv:79.0) Gecko/20100101 Firefox/79.0",
+			Title:           "Other",
+			Location:        "ID",
+			Size:            goatcounter.Floats{1024, 768, 1},
+			Ref:             "https://example.com/p",
+		},
+	}...)
+}
+
+func dumpExport(ctx context.Context) string {
+	return zdb.DumpString(ctx, `
+		select
+			hits.site_id,
+
+			paths.path,
+			paths.title,
+			paths.event,
+
+			browsers.name || ' ' || browsers.version as browser,
+			systems.name  || ' ' || systems.version  as system,
+
+			-- hits.session,
+			hits.bot,
+			hits.ref,
+			hits.ref_scheme as ref_s,
+			hits.size,
+			hits.location as loc,
+			hits.first_visit as first,
+			hits.created_at
+		from hits
+		join paths       using (path_id)
+		join browsers    using (browser_id)
+		join systems     using (system_id)
+		order by hit_id asc`)
+}
+
+func TestExportAggregates(t *testing.T) {
+	ctx := gctest.DB(t)
+	d1 := time.Date(2019, 6, 18, 14, 0, 0, 0, time.UTC)
+	d2 := time.Date(2019, 6, 19, 0, 0, 0, 0, time.UTC)
+	gctest.StoreHits(ctx, t, false, []goatcounter.Hit{
+		{Path: "/asd", Title: "Page asd", CreatedAt: d1, FirstVisit: true, Location: "NL", Size: goatcounter.Floats{1920, 1080, 1},
+			UserAgentHeader: "Mozilla/5.0 (X11; Linux x86_64; rv:80.0) Gecko/20100101 Firefox/80.0"},
+		{Path: "/asd", Title: "Page asd", CreatedAt: d1, FirstVisit: true, Query: "utm_campaign=summer", Ref: "https://example.com/p",
+			UserAgentHeader: "Mozilla/5.0 (X11; Linux x86_64; rv:80.0) Gecko/20100101 Firefox/80.0"},
+		{Path: "/zxc", Title: "Page zxc", CreatedAt: d2, FirstVisit: true, Location: "ID", Ref: "https://example.com/p",
+			UserAgentHeader: "Mozilla/5.0 (X11; Linux x86_64; rv:79.0) Gecko/20100101 Firefox/79.0"},
+		{Path: "event", Event: true, CreatedAt: d2, FirstVisit: true, Props: goatcounter.Props{"x": "y"}},
+	}...)
+	err := goatcounter.MustGetSite(ctx).UpdateFirstHitAt(ctx, d1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	export := func(start, end *time.Time) string {
+		t.Helper()
+		e := goatcounter.Export{Mode: goatcounter.ExportModeAggregates, RangeStart: start, RangeEnd: end}
+		fp, err := e.Create(ctx, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.Remove(e.Path)
+		e.Run(ctx, fp, false)
+		if e.Error != nil {
+			t.Fatal(*e.Error)
+		}
+		if e.Format != goatcounter.ExportJSONL || !strings.HasSuffix(e.Path, "-aggregates.jsonl.gz") {
+			t.Errorf("wrong format or path: %s; %s", e.Format, e.Path)
+		}
+
+		rfp, err := os.Open(e.Path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer rfp.Close()
+		gz, err := gzip.NewReader(rfp)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err := io.ReadAll(gz)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n := strings.Count(string(b), "\n"); n != *e.NumRows {
+			t.Errorf("NumRows is %d, but have %d lines", *e.NumRows, n)
+		}
+		return string(b)
+	}
+	restore := func(data string) {
+		t.Helper()
+		err := goatcounter.ImportAggregates(ctx, strings.NewReader(data), true, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	all := export(nil, nil)
+	for _, table := range []string{"hit_counts", "ref_counts", "hit_stats", "browser_stats",
+		"system_stats", "location_stats", "size_stats", "language_stats", "campaign_stats", "prop_stats"} {
+		if !strings.Contains(all, `{"table":"`+table+`"`) {
+			t.Errorf("no rows for %s in:\n%s", table, all)
+		}
+	}
+	for _, want := range []string{`"name":"Firefox","version":"79"`, `"ref":"example.com/p"`, `"location":"ID"`,
+		`"hour":"2019-06-18T14:00:00Z"`, `"day":"2019-06-18"`, `"campaign":"summer"`, `"width":1920`} {
+		if !strings.Contains(all, want) {
+			t.Errorf("%q not in:\n%s", want, all)
+		}
+	}
+
+	t.Run("range", func(t *testing.T) {
+		d := time.Date(2019, 6, 18, 0, 0, 0, 0, time.UTC)
+		have := export(&d, &d)
+		if have == "" || strings.Contains(have, "2019-06-19") {
+			t.Errorf("wrong range:\n%s", have)
+		}
+	})
+
+	t.Run("restore", func(t *testing.T) {
+		restore(all)
+		var n int
+		err = zdb.Get(ctx, &n, `select count(*) from hits`)
+		if err != nil || n != 0 {
+			t.Errorf("hits not removed: %d %v", n, err)
+		}
+		if d := ztest.Diff(export(nil, nil), all); d != "" {
+			t.Error(d)
+		}
+
+		// Import again without removing everything; shouldn't double the
+		// counts.
+		err = goatcounter.ImportAggregates(ctx, strings.NewReader(all), false, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if d := ztest.Diff(export(nil, nil), all); d != "" {
+			t.Error(d)
+		}
+	})
+
+	t.Run("errors", func(t *testing.T) {
+		errs := errors.NewGroup(10)
+		n, err := goatcounter.ExportAggregates{
+			{Table: "nope", Path: "/x", Day: "2019-06-18"},
+			{Table: "hit_counts", Path: "/x"},
+			{Table: "hit_stats", Path: "/x", Day: "2019-06-18", Stats: []byte(`[1]`)},
+			{Table: "hit_counts", Path: "/x", Hour: "2019-06-18T13:00:00Z", Count: 2},
+		}.Import(ctx, errs)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n != 1 || errs.Len() != 3 {
+			t.Errorf("n=%d; %s", n, errs)
+		}
+		for _, want := range []string{`unknown table: "nope"`, "hour: must be set", "stats: must be an array of 24 numbers"} {
+			if !strings.Contains(errs.Error(), want) {
+				t.Errorf("%q not in:\n%s", want, errs)
+			}
+		}
+	})
+}
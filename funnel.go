@@ -0,0 +1,278 @@
+// Copyright © Martin Tournoij – This file is part of GoatCounter and published
+// under the terms of a slightly modified EUPL v1.2 license, which can be found
+// in the LICENSE file or at https://license.goatcounter.com
+
+package goatcounter
+
+import (
+	"context"
+	"database/sql/driver"
+	"fmt"
+	"strings"
+	"time"
+
+	"zgo.at/errors"
+	"zgo.at/json"
+	"zgo.at/zdb"
+	"zgo.at/zstd/zint"
+	"zgo.at/zstd/ztime"
+)
+
+// Funnel is an ordered list of paths a visitor is expected to go through.
+type Funnel struct {
+	ID     int64 `db:"funnel_id" json:"id,readonly"`
+	SiteID int64 `db:"site_id" json:"site_id,readonly"`
+
+	// Name to display.
+	Name string `db:"name" json:"name"`
+
+	// Paths or event names for every step, in order; * can be used as a
+	// wildcard. Matching is case-insensitive.
+	Steps FunnelSteps `db:"steps" json:"steps"`
+
+	CreatedAt time.Time `db:"created_at" json:"created_at,readonly"`
+}
+
+// FunnelSteps are the steps in a funnel; this is stored as JSON in the
+// database.
+type FunnelSteps []string
+
+func (f FunnelSteps) Value() (driver.Value, error) { return json.Marshal([]string(f)) }
+func (f *FunnelSteps) Scan(v any) error {
+	// Unmarshal to []string, as UnmarshalText() is used for JSON strings.
+	switch vv := v.(type) {
+	case []byte:
+		return json.Unmarshal(vv, (*[]string)(f))
+	case string:
+		return json.Unmarshal([]byte(vv), (*[]string)(f))
+	default:
+		return fmt.Errorf("FunnelSteps.Scan: unsupported type: %T", v)
+	}
+}
+
+// UnmarshalText reads the steps from a newline-separated list, for forms.
+func (f *FunnelSteps) UnmarshalText(v []byte) error {
+	*f = nil
+	for _, l := range strings.Split(string(v), "\n") {
+		if l = strings.TrimSpace(l); l != "" {
+			*f = append(*f, l)
+		}
+	}
+	return nil
+}
+
+func (f FunnelSteps) String() string { return strings.Join(f, "\n") }
+
+// Defaults sets fields to default values, unless they're already set.
+func (f *Funnel) Defaults(ctx context.Context) {
+	if s := GetSite(ctx); s != nil && s.ID > 0 {
+		f.SiteID = s.ID
+	}
+	if f.CreatedAt.IsZero() {
+		f.CreatedAt = ztime.Now()
+	}
+	f.Name = strings.TrimSpace(f.Name)
+	for i := range f.Steps {
+		f.Steps[i] = strings.TrimSpace(f.Steps[i])
+	}
+}
+
+func (f *Funnel) Validate(ctx context.Context) error {
+	v := NewValidate(ctx)
+	v.Required("site_id", f.SiteID)
+	v.Required("name", f.Name)
+	v.Len("name", f.Name, 0, 100)
+	v.UTF8("name", f.Name)
+	if len(f.Steps) < 2 || len(f.Steps) > 10 {
+		v.Append("steps", "must have between 2 and 10 steps")
+	}
+	for _, s := range f.Steps {
+		v.Required("steps", s)
+		v.Len("steps", s, 0, 2048)
+		v.UTF8("steps", s)
+	}
+	return v.ErrorOrNil()
+}
+
+// Insert a new row.
+func (f *Funnel) Insert(ctx context.Context) error {
+	if f.ID > 0 {
+		return errors.New("ID > 0")
+	}
+
+	f.Defaults(ctx)
+	err := f.Validate(ctx)
+	if err != nil {
+		return err
+	}
+
+	f.ID, err = zdb.InsertID(ctx, "funnel_id",
+		`insert into funnels (site_id, name, steps, created_at) values (?)`,
+		zdb.L{f.SiteID, f.Name, f.Steps, f.CreatedAt})
+	return errors.Wrap(err, "Funnel.Insert")
+}
+
+// Update the name and steps.
+func (f *Funnel) Update(ctx context.Context) error {
+	if f.ID == 0 {
+		return errors.New("ID == 0")
+	}
+
+	f.Defaults(ctx)
+	err := f.Validate(ctx)
+	if err != nil {
+		return err
+	}
+
+	err = zdb.Exec(ctx, `update funnels set name=?, steps=? where funnel_id=? and site_id=?`,
+		f.Name, f.Steps, f.ID, f.SiteID)
+	return errors.Wrap(err, "Funnel.Update")
+}
+
+func (f *Funnel) ByID(ctx context.Context, id int64) error {
+	return errors.Wrapf(zdb.Get(ctx, f, `/* Funnel.ByID */
+		select * from funnels where funnel_id=$1 and site_id=$2`,
+		id, MustGetSite(ctx).ID), "Funnel.ByID %d", id)
+}
+
+func (f *Funnel) Delete(ctx context.Context) error {
+	err := zdb.Exec(ctx,
+		`/* Funnel.Delete */ delete from funnels where funnel_id=$1 and site_id=$2`,
+		f.ID, MustGetSite(ctx).ID)
+	return errors.Wrapf(err, "Funnel.Delete %d", f.ID)
+}
+
+type Funnels []Funnel
+
+// List all funnels for this site.
+func (f *Funnels) List(ctx context.Context) error {
+	return errors.Wrap(zdb.Select(ctx, f,
+		`select * from funnels where site_id=$1 order by funnel_id`,
+		MustGetSite(ctx).ID), "Funnels.List")
+}
+
+type (
+	FunnelStep struct {
+		Step     string  `json:"step"`     // Path or event name for this step.
+		Sessions int     `json:"sessions"` // Number of sessions that reached this step.
+		DropOff  int     `json:"drop_off"` // Number of sessions that didn't continue to the next step.
+		Rate     float64 `json:"rate"`     // Percentage of sessions from the first step that reached this step.
+
+		// Number of sessions that reached this step in the period this is
+		// compared to; only set when comparing.
+		Prev *int `json:"prev,omitempty"`
+	}
+
+	FunnelStats struct {
+		ID    int64        `json:"id"`
+		Name  string       `json:"name"`
+		Steps []FunnelStep `json:"steps"`
+	}
+)
+
+// Get the statistics for the funnel for the given time period.
+//
+// A session "reaches" a step if it visited the path for that step after it
+// reached the previous step. This requires session collection to be enabled.
+func (f *FunnelStats) Get(ctx context.Context, funnel Funnel, rng ztime.Range) error {
+	f.ID, f.Name = funnel.ID, funnel.Name
+	f.Steps = make([]FunnelStep, len(funnel.Steps))
+	for i, s := range funnel.Steps {
+		f.Steps[i].Step = s
+	}
+	if len(funnel.Steps) == 0 {
+		return nil
+	}
+
+	// Get all path IDs for every step first, so we don't need to do pattern
+	// matching on every hit.
+	var (
+		site    = MustGetSite(ctx)
+		stepIDs = make([]map[int64]struct{}, len(funnel.Steps))
+		allIDs  = make([]int64, 0, 16)
+	)
+	for i, s := range funnel.Steps {
+		var ids []int64
+		err := zdb.Select(ctx, &ids, `/* FunnelStats.Get */
+			select path_id from paths where site_id=:site and lower(path) like :pattern escape '\'`,
+			zdb.P{"site": site.ID, "pattern": globToLike(s)})
+		if err != nil {
+			return errors.Wrap(err, "FunnelStats.Get")
+		}
+		stepIDs[i] = make(map[int64]struct{}, len(ids))
+		for _, id := range ids {
+			stepIDs[i][id] = struct{}{}
+		}
+		allIDs = append(allIDs, ids...)
+	}
+	if len(allIDs) == 0 {
+		return nil
+	}
+
+	var hits []struct {
+		Session zint.Uint128 `db:"session"`
+		PathID  int64        `db:"path_id"`
+	}
+	err := zdb.Select(ctx, &hits, "load:funnel.Get", zdb.P{
+		"site":  site.ID,
+		"start": rng.Start,
+		"end":   rng.End,
+		"paths": allIDs,
+	})
+	if err != nil {
+		return errors.Wrap(err, "FunnelStats.Get")
+	}
+
+	var (
+		cur     zint.Uint128
+		reached int
+		count   = func(n int) {
+			for i := 0; i < n; i++ {
+				f.Steps[i].Sessions++
+			}
+		}
+	)
+	for _, h := range hits {
+		if h.Session != cur {
+			count(reached)
+			cur, reached = h.Session, 0
+		}
+		if reached < len(stepIDs) {
+			if _, ok := stepIDs[reached][h.PathID]; ok {
+				reached++
+			}
+		}
+	}
+	count(reached)
+
+	for i := range f.Steps {
+		if i < len(f.Steps)-1 {
+			f.Steps[i].DropOff = f.Steps[i].Sessions - f.Steps[i+1].Sessions
+		}
+		if f.Steps[0].Sessions > 0 {
+			f.Steps[i].Rate = float64(f.Steps[i].Sessions) / float64(f.Steps[0].Sessions) * 100
+		}
+	}
+	return nil
+}
+
+// Compare sets Prev on all steps to the number of sessions in prev, which
+// should be the stats for the same funnel.
+func (f *FunnelStats) Compare(prev FunnelStats) {
+	for i := range f.Steps {
+		var n int
+		if i < len(prev.Steps) {
+			n = prev.Steps[i].Sessions
+		}
+		f.Steps[i].Prev = &n
+	}
+}
+
+// HitStats gets the stats as HitStats, for display in horizontal_chart.
+func (f FunnelStats) HitStats() HitStats {
+	h := HitStats{Stats: make([]HitStat, 0, len(f.Steps))}
+	for _, s := range f.Steps {
+		h.Stats = append(h.Stats, HitStat{Name: s.Step, Count: s.Sessions, Prev: s.Prev})
+	}
+	return h
+}
@@ -0,0 +1,65 @@
+// Copyright © Martin Tournoij – This file is part of GoatCounter and published
+// under the terms of a slightly modified EUPL v1.2 license, which can be found
+// in the LICENSE file or at https://license.goatcounter.com
+
+package goatcounter_test
+
+import (
+	"testing"
+	"time"
+
+	. "zgo.at/goatcounter/v2"
+	"zgo.at/goatcounter/v2/gctest"
+	"zgo.at/zstd/zint"
+	"zgo.at/zstd/zjson"
+	"zgo.at/zstd/ztest"
+	"zgo.at/zstd/ztime"
+)
+
+func TestFunnelStats(t *testing.T) {
+	ctx := gctest.DB(t)
+
+	f := Funnel{Name: "Signup", Steps: FunnelSteps{"/pricing", "/signup*", "/welcome"}}
+	err := f.Insert(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var (
+		now = time.Date(2019, 8, 31, 14, 42, 0, 0, time.UTC)
+		s1  = zint.Uint128{1, 1}
+		s2  = zint.Uint128{1, 2}
+		s3  = zint.Uint128{1, 3}
+		s4  = zint.Uint128{1, 4}
+	)
+	gctest.StoreHits(ctx, t, false,
+		Hit{CreatedAt: now, Session: s1, Path: "/pricing"},
+		Hit{CreatedAt: now, Session: s1, Path: "/signup/form"},
+		Hit{CreatedAt: now, Session: s1, Path: "/welcome"},
+		Hit{CreatedAt: now, Session: s2, Path: "/pricing"},
+		Hit{CreatedAt: now, Session: s2, Path: "/signup"},
+		Hit{CreatedAt: now, Session: s3, Path: "/pricing"},
+		Hit{CreatedAt: now, Session: s3, Path: "/other"},
+		Hit{CreatedAt: now, Session: s4, Path: "/signup"}, // Not in order.
+		Hit{CreatedAt: now, Session: s4, Path: "/pricing"},
+		Hit{CreatedAt: now, Session: s4, Path: "/welcome"},
+	)
+
+	var have FunnelStats
+	err = have.Get(ctx, f, ztime.NewRange(now).To(now))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `{
+		"id": 1,
+		"name": "Signup",
+		"steps": [
+			{"step": "/pricing",  "sessions": 4, "drop_off": 2, "rate": 100},
+			{"step": "/signup*",  "sessions": 2, "drop_off": 1, "rate": 50},
+			{"step": "/welcome",  "sessions": 1, "drop_off": 0, "rate": 25}
+		]
+	}`
+	if d := ztest.Diff(zjson.MustMarshalString(have), want, ztest.DiffJSON); d != "" {
+		t.Error(d)
+	}
+}
@@ -0,0 +1,192 @@
+// Copyright © Martin Tournoij – This file is part of GoatCounter and published
+// under the terms of a slightly modified EUPL v1.2 license, which can be found
+// in the LICENSE file or at https://license.goatcounter.com
+
+package goatcounter
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"zgo.at/errors"
+	"zgo.at/zdb"
+	"zgo.at/zstd/zbool"
+	"zgo.at/zstd/ztime"
+)
+
+// Goal is a conversion goal; a visitor "converts" if they visit a path matching
+// Pattern, or if they trigger an event matching Pattern if Event is set.
+type Goal struct {
+	ID     int64 `db:"goal_id" json:"id,readonly"`
+	SiteID int64 `db:"site_id" json:"site_id,readonly"`
+
+	// Name to display.
+	Name string `db:"name" json:"name"`
+
+	// Match events rather than paths.
+	Event zbool.Bool `db:"event" json:"event"`
+
+	// Path or event name to match; * can be used as a wildcard, for example
+	// "/thanks*". Matching is case-insensitive.
+	Pattern string `db:"pattern" json:"pattern"`
+
+	CreatedAt time.Time `db:"created_at" json:"created_at,readonly"`
+}
+
+// Defaults sets fields to default values, unless they're already set.
+func (g *Goal) Defaults(ctx context.Context) {
+	if s := GetSite(ctx); s != nil && s.ID > 0 {
+		g.SiteID = s.ID
+	}
+	if g.CreatedAt.IsZero() {
+		g.CreatedAt = ztime.Now()
+	}
+	g.Name = strings.TrimSpace(g.Name)
+	g.Pattern = strings.TrimSpace(g.Pattern)
+	if g.Event {
+		g.Pattern = strings.TrimLeft(g.Pattern, "/")
+	}
+}
+
+func (g *Goal) Validate(ctx context.Context) error {
+	v := NewValidate(ctx)
+	v.Required("site_id", g.SiteID)
+	v.Required("name", g.Name)
+	v.Required("pattern", g.Pattern)
+	v.Len("name", g.Name, 0, 100)
+	v.Len("pattern", g.Pattern, 0, 2048)
+	v.UTF8("name", g.Name)
+	v.UTF8("pattern", g.Pattern)
+	return v.ErrorOrNil()
+}
+
+// Insert a new row.
+func (g *Goal) Insert(ctx context.Context) error {
+	if g.ID > 0 {
+		return errors.New("ID > 0")
+	}
+
+	g.Defaults(ctx)
+	err := g.Validate(ctx)
+	if err != nil {
+		return err
+	}
+
+	g.ID, err = zdb.InsertID(ctx, "goal_id",
+		`insert into goals (site_id, name, event, pattern, created_at) values (?)`,
+		zdb.L{g.SiteID, g.Name, g.Event, g.Pattern, g.CreatedAt})
+	return errors.Wrap(err, "Goal.Insert")
+}
+
+func (g *Goal) ByID(ctx context.Context, id int64) error {
+	return errors.Wrapf(zdb.Get(ctx, g, `/* Goal.ByID */
+		select * from goals where goal_id=$1 and site_id=$2`,
+		id, MustGetSite(ctx).ID), "Goal.ByID %d", id)
+}
+
+func (g *Goal) Delete(ctx context.Context) error {
+	err := zdb.Exec(ctx,
+		`/* Goal.Delete */ delete from goals where goal_id=$1 and site_id=$2`,
+		g.ID, MustGetSite(ctx).ID)
+	return errors.Wrapf(err, "Goal.Delete %d", g.ID)
+}
+
+// Like gets the pattern as a SQL LIKE pattern.
+func (g Goal) Like() string { return globToLike(g.Pattern) }
+
+// globToLike converts a pattern with * wildcards to a lower-case SQL LIKE
+// pattern, to be used with "escape '\'".
+func globToLike(s string) string {
+	r := strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`, `*`, `%`)
+	return strings.ToLower(r.Replace(s))
+}
+
+type Goals []Goal
+
+// List all goals for this site.
+func (g *Goals) List(ctx context.Context) error {
+	return errors.Wrap(zdb.Select(ctx, g,
+		`select * from goals where site_id=$1 order by goal_id`,
+		MustGetSite(ctx).ID), "Goals.List")
+}
+
+type (
+	GoalStat struct {
+		ID          int64   `json:"id"`
+		Name        string  `json:"name"`
+		Conversions int     `json:"conversions"` // Number of visitors who reached this goal.
+		Rate        float64 `json:"rate"`        // Conversion rate as a percentage of Visitors.
+
+		// Number of conversions in the period this is compared to; only set
+		// when comparing.
+		Prev *int `json:"prev,omitempty"`
+	}
+
+	GoalStats struct {
+		Visitors int        `json:"visitors"` // Number of unique visitors in this period.
+		Stats    []GoalStat `json:"stats"`
+	}
+)
+
+// List the conversion statistics for all goals for the given time period.
+//
+// Unique visitors are determined by the session, so this requires session
+// collection to be enabled. If pathFilter is given then only visitors who
+// visited one of those paths are included.
+func (g *GoalStats) List(ctx context.Context, rng ztime.Range, pathFilter []int64) error {
+	var goals Goals
+	err := goals.List(ctx)
+	if err != nil {
+		return errors.Wrap(err, "GoalStats.List")
+	}
+
+	p := zdb.P{
+		"site":   MustGetSite(ctx).ID,
+		"start":  rng.Start,
+		"end":    rng.End,
+		"filter": pathFilter,
+		"goal":   false,
+	}
+	err = zdb.Get(ctx, &g.Visitors, "load:goal.Conversions", p)
+	if err != nil {
+		return errors.Wrap(err, "GoalStats.List")
+	}
+
+	g.Stats = make([]GoalStat, 0, len(goals))
+	for _, goal := range goals {
+		p["goal"], p["event"], p["pattern"] = true, goal.Event, goal.Like()
+
+		s := GoalStat{ID: goal.ID, Name: goal.Name}
+		err := zdb.Get(ctx, &s.Conversions, "load:goal.Conversions", p)
+		if err != nil {
+			return errors.Wrapf(err, "GoalStats.List: goal %d", goal.ID)
+		}
+		if g.Visitors > 0 {
+			s.Rate = float64(s.Conversions) / float64(g.Visitors) * 100
+		}
+		g.Stats = append(g.Stats, s)
+	}
+	return nil
+}
+
+// Compare sets Prev on all stats to the number of conversions in prev.
+func (g *GoalStats) Compare(prev GoalStats) {
+	counts := make(map[int64]int, len(prev.Stats))
+	for _, s := range prev.Stats {
+		counts[s.ID] = s.Conversions
+	}
+	for i := range g.Stats {
+		n := counts[g.Stats[i].ID]
+		g.Stats[i].Prev = &n
+	}
+}
+
+// HitStats gets the stats as HitStats, for display in horizontal_chart.
+func (g GoalStats) HitStats() HitStats {
+	h := HitStats{Stats: make([]HitStat, 0, len(g.Stats))}
+	for _, s := range g.Stats {
+		h.Stats = append(h.Stats, HitStat{Name: s.Name, Count: s.Conversions, Prev: s.Prev})
+	}
+	return h
+}
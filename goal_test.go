@@ -0,0 +1,95 @@
+// Copyright © Martin Tournoij – This file is part of GoatCounter and published
+// under the terms of a slightly modified EUPL v1.2 license, which can be found
+// in the LICENSE file or at https://license.goatcounter.com
+
+package goatcounter_test
+
+import (
+	"testing"
+	"time"
+
+	. "zgo.at/goatcounter/v2"
+	"zgo.at/goatcounter/v2/gctest"
+	"zgo.at/zstd/zint"
+	"zgo.at/zstd/zjson"
+	"zgo.at/zstd/ztest"
+	"zgo.at/zstd/ztime"
+)
+
+func TestGoalStats(t *testing.T) {
+	ctx := gctest.DB(t)
+
+	for _, g := range []Goal{
+		{Name: "Thanks", Pattern: "/thanks*"},
+		{Name: "Signup", Pattern: "signup", Event: true},
+		{Name: "Literal", Pattern: "/100%_done"},
+	} {
+		err := g.Insert(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var (
+		now = time.Date(2019, 8, 31, 14, 42, 0, 0, time.UTC)
+		s1  = zint.Uint128{1, 1}
+		s2  = zint.Uint128{1, 2}
+		s3  = zint.Uint128{1, 3}
+		s4  = zint.Uint128{1, 4}
+	)
+	gctest.StoreHits(ctx, t, false,
+		Hit{CreatedAt: now, Session: s1, Path: "/pricing", FirstVisit: true},
+		Hit{CreatedAt: now, Session: s1, Path: "/thanks", FirstVisit: true},
+		Hit{CreatedAt: now, Session: s1, Path: "/thanks/again", FirstVisit: true},
+		Hit{CreatedAt: now, Session: s2, Path: "/pricing", FirstVisit: true},
+		Hit{CreatedAt: now, Session: s2, Path: "signup", Event: true, FirstVisit: true},
+		Hit{CreatedAt: now, Session: s3, Path: "/THANKS-2", FirstVisit: true},
+		Hit{CreatedAt: now, Session: s4, Path: "/100x_done", FirstVisit: true},
+	)
+
+	rng := ztime.NewRange(now).To(now)
+
+	{
+		var have GoalStats
+		err := have.List(ctx, rng, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := `{
+			"visitors": 4,
+			"stats": [
+				{"id": 1, "name": "Thanks", "conversions": 2, "rate": 50},
+				{"id": 2, "name": "Signup", "conversions": 1, "rate": 25},
+				{"id": 3, "name": "Literal", "conversions": 0, "rate": 0}
+			]
+		}`
+		if d := ztest.Diff(zjson.MustMarshalString(have), want, ztest.DiffJSON); d != "" {
+			t.Error(d)
+		}
+	}
+
+	{
+		p := Path{Path: "/pricing"}
+		err := p.GetOrInsert(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var have GoalStats
+		err = have.List(ctx, rng, []int64{p.ID})
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := `{
+			"visitors": 2,
+			"stats": [
+				{"id": 1, "name": "Thanks", "conversions": 1, "rate": 50},
+				{"id": 2, "name": "Signup", "conversions": 1, "rate": 50},
+				{"id": 3, "name": "Literal", "conversions": 0, "rate": 0}
+			]
+		}`
+		if d := ztest.Diff(zjson.MustMarshalString(have), want, ztest.DiffJSON); d != "" {
+			t.Error(d)
+		}
+	}
+}
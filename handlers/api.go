@@ -9,10 +9,10 @@ import (
 	"crypto/subtle"
 	"encoding/base64"
 	"fmt"
+	"math"
 	"net/http"
 	"os"
 	"path/filepath"
-	"slices"
 	"strconv"
 	"strings"
 	"sync"
@@ -27,6 +27,7 @@ import (
 	"zgo.at/goatcounter/v2/metrics"
 	"zgo.at/guru"
 	"zgo.at/isbot"
+	"zgo.at/json"
 	"zgo.at/zdb"
 	"zgo.at/zhttp"
 	"zgo.at/zhttp/header"
@@ -36,6 +37,7 @@ import (
 	"zgo.at/zstd/zint"
 	"zgo.at/zstd/zslice"
 	"zgo.at/zstd/ztime"
+	"zgo.at/zstd/ztype"
 	"zgo.at/zvalidate"
 )
 
@@ -100,13 +102,21 @@ func (h api) mount(r chi.Router, db zdb.DB) {
 	a.Get("/api/v0/export/{id}/download", zhttp.Wrap(h.exportDownload))
 
 	a.Post("/api/v0/count", zhttp.Wrap(h.count))
+	a.Post("/api/v0/import/aggregates", zhttp.Wrap(h.importAggregates))
 
 	a.Get("/api/v0/paths", zhttp.Wrap(h.paths))
 	a.Get("/api/v0/stats/total", zhttp.Wrap(h.countTotal))
 	a.Get("/api/v0/stats/hits", zhttp.Wrap(h.hits))
 	a.Get("/api/v0/stats/hits/{path_id}", zhttp.Wrap(h.refs))
+	a.Get("/api/v0/stats/heatmap", zhttp.Wrap(h.heatmap))
+	a.Get("/api/v0/stats/timing", zhttp.Wrap(h.timing))
+	a.Get("/api/v0/stats/errors", zhttp.Wrap(h.errorPages))
+	a.Get("/api/v0/stats/goals", zhttp.Wrap(h.goals))
+	a.Get("/api/v0/stats/funnels", zhttp.Wrap(h.funnels))
+	a.Get("/api/v0/stats/funnels/{id}", zhttp.Wrap(h.funnels))
 	a.Get("/api/v0/stats/{page}", zhttp.Wrap(h.stats))
 	a.Get("/api/v0/stats/{page}/{id}", zhttp.Wrap(h.statsDetail))
+	a.Get("/api/v0/live", zhttp.Wrap(h.live))
 
 	// Note: DELETE not supported for sites and users intentionally, since it's
 	// such a dangerous operation.
@@ -115,6 +125,20 @@ func (h api) mount(r chi.Router, db zdb.DB) {
 	a.Get("/api/v0/sites/{id}", zhttp.Wrap(h.siteGet))
 	a.Post("/api/v0/sites/{id}", zhttp.Wrap(h.siteUpdate))  // Update all
 	a.Patch("/api/v0/sites/{id}", zhttp.Wrap(h.siteUpdate)) // Update just fields given
+
+	a.Get("/api/v0/annotations", zhttp.Wrap(h.annotationList))
+	a.Put("/api/v0/annotations", zhttp.Wrap(h.annotationCreate))
+	a.Get("/api/v0/annotations/{id}", zhttp.Wrap(h.annotationGet))
+	a.Post("/api/v0/annotations/{id}", zhttp.Wrap(h.annotationUpdate))  // Update all
+	a.Patch("/api/v0/annotations/{id}", zhttp.Wrap(h.annotationUpdate)) // Update just fields given
+	a.Delete("/api/v0/annotations/{id}", zhttp.Wrap(h.annotationDelete))
+
+	a.Get("/api/v0/alerts", zhttp.Wrap(h.alertList))
+	a.Put("/api/v0/alerts", zhttp.Wrap(h.alertCreate))
+	a.Get("/api/v0/alerts/{id}", zhttp.Wrap(h.alertGet))
+	a.Post("/api/v0/alerts/{id}", zhttp.Wrap(h.alertUpdate))  // Update all
+	a.Patch("/api/v0/alerts/{id}", zhttp.Wrap(h.alertUpdate)) // Update just fields given
+	a.Delete("/api/v0/alerts/{id}", zhttp.Wrap(h.alertDelete))
 }
 
 func tokenFromHeader(r *http.Request, w http.ResponseWriter) (string, error) {
@@ -223,6 +247,30 @@ func (h api) auth(r *http.Request, w http.ResponseWriter, require zint.Bitflag64
 type apiExportRequest struct {
 	// Pagination cursor; only export hits with an ID greater than this.
 	StartFromHitID int64 `json:"start_from_hit_id"`
+
+	// File format: csv (default), jsonl, or sqlite. The csv and jsonl formats
+	// are compressed with gzip.
+	Format string `json:"format"`
+
+	// What to export: hits (default) for all pageviews, or aggregates for the
+	// statistics shown on the dashboard. Aggregates are always exported as
+	// jsonl.
+	Mode string `json:"mode"`
+
+	// Date range for aggregates, as year-month-day; defaults to everything.
+	RangeStart string `json:"range_start"`
+	RangeEnd   string `json:"range_end"`
+}
+
+type apiImportAggregatesRequest struct {
+	// Rows from an aggregates export, as they appear in the file. Existing
+	// statistics are replaced.
+	Rows goatcounter.ExportAggregates `json:"rows"`
+}
+
+type apiImportAggregatesResponse struct {
+	// Number of imported rows.
+	Rows int `json:"rows"`
 }
 
 // For testing various generic properties about the API.
@@ -322,7 +370,20 @@ func (h api) export(w http.ResponseWriter, r *http.Request) error {
 		return err
 	}
 
-	var export goatcounter.Export
+	export := goatcounter.Export{Format: req.Format, Mode: req.Mode}
+	v := goatcounter.NewValidate(r.Context())
+	if req.RangeStart != "" {
+		t := v.Date("range_start", req.RangeStart, "2006-01-02")
+		export.RangeStart = &t
+	}
+	if req.RangeEnd != "" {
+		t := v.Date("range_end", req.RangeEnd, "2006-01-02")
+		export.RangeEnd = &t
+	}
+	if v.HasErrors() {
+		return v
+	}
+
 	fp, err := export.Create(r.Context(), req.StartFromHitID)
 	if err != nil {
 		return err
@@ -369,7 +430,7 @@ func (h api) exportGet(w http.ResponseWriter, r *http.Request) error {
 // Export files are kept for 24 hours, after which they're deleted. This will
 // return a 400 Gone status code if the export has been deleted.
 //
-// Response 200 (text/csv): {data}
+// Response 200 (application/gzip): {data}
 // Response 202: zgo.at/goatcounter/v2/handlers.apiError
 // Response 400: zgo.at/goatcounter/v2/handlers.apiError
 func (h api) exportDownload(w http.ResponseWriter, r *http.Request) error {
@@ -414,10 +475,55 @@ func (h api) exportDownload(w http.ResponseWriter, r *http.Request) error {
 		return err
 	}
 
-	w.Header().Set("Content-Type", "application/gzip")
+	w.Header().Set("Content-Type", export.ContentType())
 	return zhttp.Stream(w, fp)
 }
 
+// POST /api/v0/import/aggregates export
+// Import aggregated statistics.
+//
+// This imports rows from an export created with the "aggregates" mode directly
+// in to the statistics tables, replacing any existing values for the same
+// path, date, etc.
+//
+// The maximum amount of rows per request is 1000. Rows with errors are skipped
+// and reported; all other rows are still imported.
+//
+// Request body: apiImportAggregatesRequest
+// Response 200: apiImportAggregatesResponse
+// Response 400: zgo.at/goatcounter/v2/handlers.apiError
+func (h api) importAggregates(w http.ResponseWriter, r *http.Request) error {
+	err := h.auth(r, w, goatcounter.APIPermCount)
+	if err != nil {
+		return err
+	}
+
+	var req apiImportAggregatesRequest
+	_, err = h.dec.Decode(r, &req)
+	if err != nil {
+		return err
+	}
+	if len(req.Rows) == 0 {
+		w.WriteHeader(400)
+		return zhttp.JSON(w, apiError{Error: "no rows"})
+	}
+	if len(req.Rows) > 1000 {
+		w.WriteHeader(400)
+		return zhttp.JSON(w, apiError{Error: "maximum amount of rows in one batch is 1000"})
+	}
+
+	errs := errors.NewGroup(50)
+	n, err := req.Rows.Import(r.Context(), errs)
+	if err != nil {
+		return err
+	}
+	if errs.Len() > 0 {
+		w.WriteHeader(400)
+		return zhttp.JSON(w, apiError{Error: errs.Error()})
+	}
+	return zhttp.JSON(w, apiImportAggregatesResponse{Rows: n})
+}
+
 type APICountRequest struct {
 	// By default it's an error to send pageviews that don't have either a
 	// Session or UserAgent and IP set. This avoids accidental errors.
@@ -428,10 +534,12 @@ type APICountRequest struct {
 
 	// Filter pageviews; accepted values:
 	//
-	//   ip     Ignore requests coming from IP addresses listed in "Settings → Ignore IP". Requires the IP field to be set.
+	//   ip       Ignore requests coming from IP addresses or ranges listed in "Settings → Ignore IPs". Requires the IP field to be set.
+	//   ignore   Ignore requests matching the patterns in "Settings → Ignore user agents" and "Settings → Ignore paths".
 	//
-	// ["ip"] is used if this field isn't sent; send an empty array ([]) to not
-	// filter anything.
+	// ["ip", "ignore"] is used if this field isn't sent. Pageviews matching the
+	// ignore rules are never stored; this only controls if they're reported in
+	// X-Goatcounter-Filter. Send an empty array ([]) to not report them.
 	//
 	// The X-Goatcounter-Filter header will be set to a list of indexes if any
 	// pageviews are filtered; for example:
@@ -465,6 +573,10 @@ type APICountRequestHit struct {
 	// Query parameters for this pageview, used to get campaign parameters.
 	Query string `json:"query" query:"q"`
 
+	// Custom properties as key/value pairs, for example {"plan": "pro"}; at
+	// most 10 properties can be sent.
+	Props goatcounter.Props `json:"props" query:"props"`
+
 	// Hint if this should be considered a bot; should be one of the JSBot*`
 	// constants from isbot; note the backend may override this if it
 	// detects a bot using another method.
@@ -474,6 +586,14 @@ type APICountRequestHit struct {
 	// User-Agent header.
 	UserAgent string `json:"user_agent"`
 
+	// Browser and system name and version; only used if user_agent is empty.
+	// This is useful when importing from services that don't store the
+	// User-Agent header.
+	Browser        string `json:"browser"`
+	BrowserVersion string `json:"browser_version"`
+	System         string `json:"system"`
+	SystemVersion  string `json:"system_version"`
+
 	// Location as ISO-3166-1 alpha2 string (e.g. NL, ID, etc.)
 	Location string `json:"location"`
 
@@ -494,6 +614,22 @@ type APICountRequestHit struct {
 	// identifier.
 	Session string `json:"session"`
 
+	// Hash of the pageview's contents when importing; pageviews with a hash
+	// that was already imported before are skipped, so that importing the same
+	// file twice doesn't count everything twice.
+	//
+	// The X-Goatcounter-Duplicates header will be set to a list of indexes of
+	// skipped pageviews, in the same format as X-Goatcounter-Filter.
+	ImportHash string `json:"import_hash"`
+
+	// HTTP status code the server sent for this pageview; this is set when
+	// importing from logfiles, and used for the 4xx/5xx report.
+	Status int `json:"status"`
+
+	// Time it took the server to send the response, in milliseconds; this is
+	// set when importing from logfiles, and used for the response time report.
+	ResponseTime *float64 `json:"response_time"`
+
 	// {omitdoc}
 	Host string `json:"-"`
 
@@ -505,8 +641,8 @@ type APICountRequestHit struct {
 
 func (h APICountRequestHit) String() string {
 	return fmt.Sprintf(
-		`{Path: %q, Title: %q, Event: %t, Ref: %q, Size: "%s", Query: %q, Bot: %d, UserAgent: %q, Location: %q, IP: %q, CreatedAt: %q, Session: %q, Host: %q}`,
-		h.Path, h.Title, h.Event, h.Ref, h.Size, h.Query, h.Bot, h.UserAgent, h.Location, h.IP, h.CreatedAt, h.Session, h.Host)
+		`{Path: %q, Title: %q, Event: %t, Ref: %q, Size: "%s", Query: %q, Props: %q, Bot: %d, UserAgent: %q, Location: %q, IP: %q, CreatedAt: %q, Session: %q, Host: %q}`,
+		h.Path, h.Title, h.Event, h.Ref, h.Size, h.Query, h.Props, h.Bot, h.UserAgent, h.Location, h.IP, h.CreatedAt, h.Session, h.Host)
 }
 
 // POST /api/v0/count count
@@ -520,6 +656,9 @@ func (h APICountRequestHit) String() string {
 // Errors will have the key set to the index of the pageview. Any pageviews not
 // listed have been processed and shouldn't be sent again.
 //
+// This returns 503 Service Unavailable if too many pageviews are waiting to be
+// stored; the request should be sent again after the Retry-After header.
+//
 // Request body: APICountRequest
 // Response 202: {empty}
 func (h api) count(w http.ResponseWriter, r *http.Request) error {
@@ -541,14 +680,20 @@ func (h api) count(w http.ResponseWriter, r *http.Request) error {
 		w.WriteHeader(400)
 		return zhttp.JSON(w, apiError{Error: "no hits"})
 	}
+	if goatcounter.Memstore.Full() {
+		w.Header().Set("Retry-After", retryAfter())
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return zhttp.JSON(w, apiError{Error: "too many pageviews waiting to be stored; try again later"})
+	}
 	if len(args.Hits) > 500 {
 		w.WriteHeader(400)
 		return zhttp.JSON(w, apiError{Error: "maximum amount of pageviews in one batch is 500"})
 	}
 	if args.Filter == nil {
-		args.Filter = []string{"ip"}
+		args.Filter = []string{"ip", "ignore"}
 	}
 	filterIP := zslice.Remove(&args.Filter, "ip")
+	filterIgnore := zslice.Remove(&args.Filter, "ignore")
 	if len(args.Filter) > 0 {
 		return zhttp.JSON(w, apiError{Error: fmt.Sprintf("unknown value in Filter: %v", args.Filter)})
 	}
@@ -556,15 +701,21 @@ func (h api) count(w http.ResponseWriter, r *http.Request) error {
 	var (
 		errs       = make(map[int]string)
 		filter     []int
+		dupes      []int
 		site       = Site(r.Context())
 		firstHitAt = site.FirstHitAt
+		hits       = make([]goatcounter.Hit, 0, len(args.Hits))
+		hitIdx     = make([]int, 0, len(args.Hits))
 	)
 	for i, a := range args.Hits {
-		if filterIP && a.IP != "" && slices.Contains(site.Settings.IgnoreIPs, a.IP) {
+		if filterIP && a.IP != "" && site.Settings.Ignored(a.IP, "", "") != "" {
+			filter = append(filter, i)
+			continue
+		}
+		if filterIgnore && site.Settings.Ignored("", a.UserAgent, a.Path) != "" {
 			filter = append(filter, i)
 			continue
 		}
-
 		if a.Location == "" && a.IP != "" {
 			a.Location = (goatcounter.Location{}).LookupIP(r.Context(), a.IP)
 		}
@@ -576,11 +727,21 @@ func (h api) count(w http.ResponseWriter, r *http.Request) error {
 			Event:           a.Event,
 			Size:            a.Size,
 			Query:           a.Query,
+			Props:           a.Props,
 			Bot:             a.Bot,
 			CreatedAt:       a.CreatedAt.UTC(),
 			UserAgentHeader: a.UserAgent,
 			Location:        a.Location,
 			RemoteAddr:      a.IP,
+			ImportHash:      a.ImportHash,
+			BrowserName:     a.Browser,
+			BrowserVersion:  a.BrowserVersion,
+			SystemName:      a.System,
+			SystemVersion:   a.SystemVersion,
+			Status:          a.Status,
+		}
+		if a.ResponseTime != nil {
+			hit.ResponseTime = ztype.Ptr(int64(math.Round(*a.ResponseTime * 1000)))
 		}
 
 		if a.UserAgent != "" {
@@ -600,12 +761,23 @@ func (h api) count(w http.ResponseWriter, r *http.Request) error {
 		}
 
 		hit.Defaults(r.Context(), true) // don't get UA/Path; memstore will do that.
-		err = hit.Validate(r.Context(), true)
+		err := hit.Validate(r.Context(), true)
 		if err != nil {
 			errs[i] = err.Error()
 			continue
 		}
+		hits, hitIdx = append(hits, hit), append(hitIdx, i)
+	}
 
+	dupe, err := goatcounter.ClaimImportHashes(r.Context(), hits)
+	if err != nil {
+		return err
+	}
+	for i, hit := range hits {
+		if dupe[i] {
+			dupes = append(dupes, hitIdx[i])
+			continue
+		}
 		if hit.CreatedAt.Before(firstHitAt) {
 			firstHitAt = hit.CreatedAt
 		}
@@ -615,6 +787,9 @@ func (h api) count(w http.ResponseWriter, r *http.Request) error {
 	if len(filter) > 0 {
 		w.Header().Set("X-Goatcounter-Filter", zint.Join(filter, ", "))
 	}
+	if len(dupes) > 0 {
+		w.Header().Set("X-Goatcounter-Duplicates", zint.Join(dupes, ", "))
+	}
 	if len(errs) > 0 {
 		w.WriteHeader(400)
 		return zhttp.JSON(w, map[string]any{
@@ -729,6 +904,7 @@ func (h api) siteCreate(w http.ResponseWriter, r *http.Request) error {
 	if err != nil {
 		return err
 	}
+	goatcounter.SendWebhook(r.Context(), goatcounter.WebhookSiteCreated, site)
 
 	return zhttp.JSON(w, site)
 }
@@ -783,6 +959,316 @@ func (h api) siteUpdate(w http.ResponseWriter, r *http.Request) error {
 	return zhttp.JSON(w, site)
 }
 
+type (
+	apiAnnotationsRequest struct {
+		// Only get annotations after this time {datetime}.
+		Start time.Time `json:"start" query:"start"`
+
+		// Only get annotations before this time {datetime}.
+		End time.Time `json:"end" query:"end"`
+	}
+	apiAnnotationsResponse struct {
+		// List of annotations, sorted by time.
+		Annotations goatcounter.Annotations `json:"annotations"`
+	}
+)
+
+// GET /api/v0/annotations annotations
+// List annotations.
+//
+// All annotations are returned if start and end are not given.
+//
+// Query: apiAnnotationsRequest
+// Response 200: apiAnnotationsResponse
+func (h api) annotationList(w http.ResponseWriter, r *http.Request) error {
+	err := h.auth(r, w, goatcounter.APIPermAnnotations)
+	if err != nil {
+		return err
+	}
+
+	var args apiAnnotationsRequest
+	if _, err := h.dec.Decode(r, &args); err != nil {
+		return err
+	}
+
+	var annotations goatcounter.Annotations
+	if args.Start.IsZero() && args.End.IsZero() {
+		err = annotations.List(r.Context())
+	} else {
+		if args.End.IsZero() {
+			args.End = ztime.Now()
+		}
+		err = annotations.ListRange(r.Context(), ztime.NewRange(args.Start).To(args.End))
+	}
+	if err != nil {
+		return err
+	}
+
+	return zhttp.JSON(w, apiAnnotationsResponse{annotations})
+}
+
+func (h api) annotationFind(r *http.Request) (*goatcounter.Annotation, error) {
+	v := goatcounter.NewValidate(r.Context())
+	id := v.Integer("id", chi.URLParam(r, "id"))
+	if v.HasErrors() {
+		return nil, v
+	}
+
+	var a goatcounter.Annotation
+	err := a.ByID(r.Context(), id)
+	if err != nil {
+		return nil, err
+	}
+	return &a, nil
+}
+
+// GET /api/v0/annotations/{id} annotations
+// Get an annotation.
+//
+// Response 200: goatcounter.Annotation
+func (h api) annotationGet(w http.ResponseWriter, r *http.Request) error {
+	err := h.auth(r, w, goatcounter.APIPermAnnotations)
+	if err != nil {
+		return err
+	}
+
+	a, err := h.annotationFind(r)
+	if err != nil {
+		return err
+	}
+	return zhttp.JSON(w, a)
+}
+
+// PUT /api/v0/annotations annotations
+// Create a new annotation.
+//
+// This can be used to add a marker from a deploy script, for example:
+//
+//	curl -X PUT "$api/annotations" --data '{"text": "v2.3 deployed"}'
+//
+// Request body: goatcounter.Annotation
+// Response 200: goatcounter.Annotation
+func (h api) annotationCreate(w http.ResponseWriter, r *http.Request) error {
+	err := h.auth(r, w, goatcounter.APIPermAnnotations)
+	if err != nil {
+		return err
+	}
+
+	var a goatcounter.Annotation
+	_, err = h.dec.Decode(r, &a)
+	if err != nil {
+		return err
+	}
+
+	err = a.Insert(r.Context())
+	if err != nil {
+		return err
+	}
+	return zhttp.JSON(w, a)
+}
+
+// POST /api/v0/annotations/{id} annotations
+// PATCH /api/v0/annotations/{id} annotations
+// Update an annotation.
+//
+// A POST request will *replace* the entire annotation with what's sent,
+// blanking out any existing fields that may exist. A PATCH request will only
+// update the fields that are sent.
+//
+// Request body: goatcounter.Annotation
+// Response 200: goatcounter.Annotation
+func (h api) annotationUpdate(w http.ResponseWriter, r *http.Request) error {
+	err := h.auth(r, w, goatcounter.APIPermAnnotations)
+	if err != nil {
+		return err
+	}
+
+	a, err := h.annotationFind(r)
+	if err != nil {
+		return err
+	}
+
+	args := goatcounter.Annotation{ID: a.ID, SiteID: a.SiteID, CreatedAt: a.CreatedAt}
+	if r.Method == http.MethodPatch {
+		args = *a
+	}
+	_, err = h.dec.Decode(r, &args)
+	if err != nil {
+		return err
+	}
+
+	a.At, a.Text, a.Path = args.At, args.Text, args.Path
+	err = a.Update(r.Context())
+	if err != nil {
+		return err
+	}
+	return zhttp.JSON(w, a)
+}
+
+// DELETE /api/v0/annotations/{id} annotations
+// Delete an annotation.
+//
+// Response 200: goatcounter.Annotation
+func (h api) annotationDelete(w http.ResponseWriter, r *http.Request) error {
+	err := h.auth(r, w, goatcounter.APIPermAnnotations)
+	if err != nil {
+		return err
+	}
+
+	a, err := h.annotationFind(r)
+	if err != nil {
+		return err
+	}
+
+	err = a.Delete(r.Context())
+	if err != nil {
+		return err
+	}
+	return zhttp.JSON(w, a)
+}
+
+type apiAlertsResponse struct {
+	Alerts goatcounter.AlertRules `json:"alerts"`
+}
+
+// GET /api/v0/alerts alerts
+// List alert rules.
+//
+// Response 200: apiAlertsResponse
+func (h api) alertList(w http.ResponseWriter, r *http.Request) error {
+	err := h.auth(r, w, goatcounter.APIPermAlerts)
+	if err != nil {
+		return err
+	}
+
+	var rules goatcounter.AlertRules
+	err = rules.List(r.Context())
+	if err != nil {
+		return err
+	}
+	return zhttp.JSON(w, apiAlertsResponse{rules})
+}
+
+func (h api) alertFind(r *http.Request) (*goatcounter.AlertRule, error) {
+	v := goatcounter.NewValidate(r.Context())
+	id := v.Integer("id", chi.URLParam(r, "id"))
+	if v.HasErrors() {
+		return nil, v
+	}
+
+	var a goatcounter.AlertRule
+	err := a.ByID(r.Context(), id)
+	if err != nil {
+		return nil, err
+	}
+	return &a, nil
+}
+
+// GET /api/v0/alerts/{id} alerts
+// Get an alert rule.
+//
+// Response 200: goatcounter.AlertRule
+func (h api) alertGet(w http.ResponseWriter, r *http.Request) error {
+	err := h.auth(r, w, goatcounter.APIPermAlerts)
+	if err != nil {
+		return err
+	}
+
+	a, err := h.alertFind(r)
+	if err != nil {
+		return err
+	}
+	return zhttp.JSON(w, a)
+}
+
+// PUT /api/v0/alerts alerts
+// Create a new alert rule.
+//
+// The webhook_secret is generated if webhook_url is set; the webhook body is
+// signed with it.
+//
+// Request body: goatcounter.AlertRule
+// Response 200: goatcounter.AlertRule
+func (h api) alertCreate(w http.ResponseWriter, r *http.Request) error {
+	err := h.auth(r, w, goatcounter.APIPermAlerts)
+	if err != nil {
+		return err
+	}
+
+	var a goatcounter.AlertRule
+	_, err = h.dec.Decode(r, &a)
+	if err != nil {
+		return err
+	}
+
+	err = a.Insert(r.Context())
+	if err != nil {
+		return err
+	}
+	return zhttp.JSON(w, a)
+}
+
+// POST /api/v0/alerts/{id} alerts
+// PATCH /api/v0/alerts/{id} alerts
+// Update an alert rule.
+//
+// A POST request will *replace* the entire rule with what's sent, blanking out
+// any existing fields that may exist. A PATCH request will only update the
+// fields that are sent.
+//
+// Request body: goatcounter.AlertRule
+// Response 200: goatcounter.AlertRule
+func (h api) alertUpdate(w http.ResponseWriter, r *http.Request) error {
+	err := h.auth(r, w, goatcounter.APIPermAlerts)
+	if err != nil {
+		return err
+	}
+
+	a, err := h.alertFind(r)
+	if err != nil {
+		return err
+	}
+
+	args := goatcounter.AlertRule{}
+	if r.Method == http.MethodPatch {
+		args = *a
+	}
+	_, err = h.dec.Decode(r, &args)
+	if err != nil {
+		return err
+	}
+
+	a.Name, a.Kind, a.Path, a.Threshold, a.Cooldown = args.Name, args.Kind, args.Path, args.Threshold, args.Cooldown
+	a.Email, a.WebhookURL = args.Email, args.WebhookURL
+	err = a.Update(r.Context())
+	if err != nil {
+		return err
+	}
+	return zhttp.JSON(w, a)
+}
+
+// DELETE /api/v0/alerts/{id} alerts
+// Delete an alert rule.
+//
+// Response 200: goatcounter.AlertRule
+func (h api) alertDelete(w http.ResponseWriter, r *http.Request) error {
+	err := h.auth(r, w, goatcounter.APIPermAlerts)
+	if err != nil {
+		return err
+	}
+
+	a, err := h.alertFind(r)
+	if err != nil {
+		return err
+	}
+
+	err = a.Delete(r.Context())
+	if err != nil {
+		return err
+	}
+	return zhttp.JSON(w, a)
+}
+
 type (
 	apiPathsRequest struct {
 		// Limit number of returned results {range: 1-200, default: 20}
@@ -1034,6 +1520,272 @@ func (h api) countTotal(w http.ResponseWriter, r *http.Request) error {
 	return zhttp.JSON(w, tc)
 }
 
+// GET /api/v0/stats/heatmap stats
+// Get the number of visitors by day of the week and hour of the day.
+//
+// The hours are in the user's timezone, and the list of days starts on Sunday
+// or Monday depending on the user's settings.
+//
+// Query: apiCountTotalRequest
+// Response 200: goatcounter.Heatmap
+func (h api) heatmap(w http.ResponseWriter, r *http.Request) error {
+	m := metrics.Start("/api/v0/stats/*")
+	defer m.Done()
+
+	err := h.auth(r, w, goatcounter.APIPermStats)
+	if err != nil {
+		return err
+	}
+
+	var args apiCountTotalRequest
+	if _, err := h.dec.Decode(r, &args); err != nil {
+		return err
+	}
+	if args.Start.IsZero() {
+		args.Start = ztime.AddPeriod(ztime.Now(), -7, ztime.Day)
+	}
+	if args.End.IsZero() {
+		args.End = ztime.Now()
+	}
+
+	var hm goatcounter.Heatmap
+	err = hm.Get(r.Context(), ztime.NewRange(args.Start).To(args.End), args.IncludePaths)
+	if err != nil {
+		return err
+	}
+	return zhttp.JSON(w, hm)
+}
+
+// GET /api/v0/stats/timing stats
+// Get the server response time for paths, slowest first.
+//
+// This is only available for pageviews imported from logfiles that include the
+// response time. The p50 and p95 percentiles are in milliseconds. The compare
+// parameter is ignored.
+//
+// Query: apiStatsRequest
+// Response 200: goatcounter.ResponseTimes
+func (h api) timing(w http.ResponseWriter, r *http.Request) error {
+	m := metrics.Start("/api/v0/stats/*")
+	defer m.Done()
+
+	args, err := h.responseArgs(w, r)
+	if err != nil {
+		return err
+	}
+
+	var rt goatcounter.ResponseTimes
+	err = rt.List(r.Context(), ztime.NewRange(args.Start).To(args.End), args.IncludePaths, args.Limit, args.Offset)
+	if err != nil {
+		return err
+	}
+	return zhttp.JSON(w, rt)
+}
+
+// GET /api/v0/stats/errors stats
+// Get the number of 4xx and 5xx responses by path and status code.
+//
+// This is only available for pageviews imported from logfiles. The compare
+// parameter is ignored.
+//
+// Query: apiStatsRequest
+// Response 200: goatcounter.ErrorPages
+func (h api) errorPages(w http.ResponseWriter, r *http.Request) error {
+	m := metrics.Start("/api/v0/stats/*")
+	defer m.Done()
+
+	args, err := h.responseArgs(w, r)
+	if err != nil {
+		return err
+	}
+
+	var ep goatcounter.ErrorPages
+	err = ep.List(r.Context(), ztime.NewRange(args.Start).To(args.End), args.IncludePaths, args.Limit, args.Offset)
+	if err != nil {
+		return err
+	}
+	return zhttp.JSON(w, ep)
+}
+
+func (h api) responseArgs(w http.ResponseWriter, r *http.Request) (apiStatsRequest, error) {
+	args := apiStatsRequest{Limit: 20}
+	err := h.auth(r, w, goatcounter.APIPermStats)
+	if err != nil {
+		return args, err
+	}
+	if _, err := h.dec.Decode(r, &args); err != nil {
+		return args, err
+	}
+	if h.apiMax > 0 && args.Limit > h.apiMax {
+		args.Limit = h.apiMax
+	}
+	if args.Limit < 1 {
+		args.Limit = 1
+	}
+	if args.Start.IsZero() {
+		args.Start = ztime.AddPeriod(ztime.Now(), -7, ztime.Day)
+	}
+	if args.End.IsZero() {
+		args.End = ztime.Now()
+	}
+	return args, nil
+}
+
+// GET /api/v0/stats/goals stats
+// Get conversion statistics for all goals.
+//
+// The conversion rate is the percentage of unique visitors that reached the
+// goal in the date range; this requires sessions to be collected.
+//
+// Query: apiCountTotalRequest
+// Response 200: goatcounter.GoalStats
+func (h api) goals(w http.ResponseWriter, r *http.Request) error {
+	m := metrics.Start("/api/v0/stats/*")
+	defer m.Done()
+
+	err := h.auth(r, w, goatcounter.APIPermStats)
+	if err != nil {
+		return err
+	}
+
+	var args apiCountTotalRequest
+	if _, err := h.dec.Decode(r, &args); err != nil {
+		return err
+	}
+	if args.Start.IsZero() {
+		args.Start = ztime.AddPeriod(ztime.Now(), -7, ztime.Day)
+	}
+	if args.End.IsZero() {
+		args.End = ztime.Now()
+	}
+
+	var gs goatcounter.GoalStats
+	err = gs.List(r.Context(), ztime.NewRange(args.Start).To(args.End), args.IncludePaths)
+	if err != nil {
+		return err
+	}
+	return zhttp.JSON(w, gs)
+}
+
+type apiFunnelsResponse struct {
+	Funnels []goatcounter.FunnelStats `json:"funnels"`
+}
+
+// GET /api/v0/stats/funnels stats
+// GET /api/v0/stats/funnels/{id} stats
+// Get funnel statistics.
+//
+// Get the statistics for all funnels, or just one funnel if the ID is given.
+// For every step this lists the number of sessions that reached the step, and
+// how many of those dropped off before the next step. This requires sessions to
+// be collected.
+//
+// Query: apiCountTotalRequest
+// Response 200: apiFunnelsResponse
+func (h api) funnels(w http.ResponseWriter, r *http.Request) error {
+	m := metrics.Start("/api/v0/stats/*")
+	defer m.Done()
+
+	err := h.auth(r, w, goatcounter.APIPermStats)
+	if err != nil {
+		return err
+	}
+
+	var args apiCountTotalRequest
+	if _, err := h.dec.Decode(r, &args); err != nil {
+		return err
+	}
+	if args.Start.IsZero() {
+		args.Start = ztime.AddPeriod(ztime.Now(), -7, ztime.Day)
+	}
+	if args.End.IsZero() {
+		args.End = ztime.Now()
+	}
+
+	var funnels goatcounter.Funnels
+	if id := chi.URLParam(r, "id"); id != "" {
+		v := goatcounter.NewValidate(r.Context())
+		funnelID := v.Integer("id", id)
+		if v.HasErrors() {
+			return v
+		}
+
+		var f goatcounter.Funnel
+		err = f.ByID(r.Context(), funnelID)
+		funnels = goatcounter.Funnels{f}
+	} else {
+		err = funnels.List(r.Context())
+	}
+	if err != nil {
+		return err
+	}
+
+	rng := ztime.NewRange(args.Start).To(args.End)
+	resp := apiFunnelsResponse{Funnels: make([]goatcounter.FunnelStats, 0, len(funnels))}
+	for _, f := range funnels {
+		var fs goatcounter.FunnelStats
+		err := fs.Get(r.Context(), f, rng)
+		if err != nil {
+			return err
+		}
+		resp.Funnels = append(resp.Funnels, fs)
+	}
+	return zhttp.JSON(w, resp)
+}
+
+// GET /api/v0/live stats
+// Stream the visitors currently on the site.
+//
+// This is a Server-Sent Events (text/event-stream) stream; a "live" event with
+// the current data is sent after connecting, after new pageviews are processed,
+// and every 30 seconds. A session is live if it was seen in the last five
+// minutes; this requires sessions to be collected.
+//
+// Response 200: goatcounter.Live
+func (h api) live(w http.ResponseWriter, r *http.Request) error {
+	err := h.auth(r, w, goatcounter.APIPermStats)
+	if err != nil {
+		return err
+	}
+
+	// Don't time out the stream; the write timeout is for regular requests.
+	rc := http.NewResponseController(w)
+	_ = rc.SetWriteDeadline(time.Time{})
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(200)
+
+	var (
+		siteID         = Site(r.Context()).ID
+		update, cancel = goatcounter.Memstore.LiveSubscribe(siteID)
+		tick           = time.NewTicker(30 * time.Second)
+	)
+	defer cancel()
+	defer tick.Stop()
+	for {
+		j, err := json.Marshal(goatcounter.Memstore.Live(siteID))
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintf(w, "event: live\ndata: %s\n\n", j)
+		if err != nil {
+			return nil // Client went away.
+		}
+		err = rc.Flush()
+		if err != nil {
+			return nil
+		}
+
+		select {
+		case <-r.Context().Done():
+			return nil
+		case <-update:
+		case <-tick.C:
+		}
+	}
+}
+
 type (
 	apiStatsRequest struct {
 		// Start time, should be rounded to the hour {datetime, default: one week ago}.
@@ -1050,19 +1802,42 @@ type (
 
 		// Offset for pagination.
 		Offset int `json:"offset" query:"offset"`
+
+		// Compare with another period, and set "prev" on every row to the
+		// count in that period {enum: previous year}.
+		//
+		// "previous" is the period of the same length before start, and "year"
+		// is the same period last year.
+		Compare string `json:"compare" query:"compare"`
 	}
 	apiStatsResponse struct {
-		// Sorted list of paths with their visitor and pageview count.
+		// Sorted list of paths with their visitor and pageview count; "prev"
+		// is set if compare is given.
 		Stats []goatcounter.HitStat `json:"stats"`
 		More  bool                  `json:"more"`
 	}
 )
 
+// list gets the stats with list, and the counts for the period to compare with
+// if Compare is set.
+func (a apiStatsRequest) list(ctx context.Context, stats *goatcounter.HitStats, list goatcounter.HitStatsList) error {
+	rng := ztime.NewRange(a.Start).To(a.End)
+	err := list(stats, ctx, rng, a.IncludePaths, a.Limit, a.Offset)
+	if err != nil || a.Compare == "" || len(stats.Stats) == 0 {
+		return err
+	}
+	return stats.Compare(ctx, list, goatcounter.CompareRange(rng, a.Compare), a.IncludePaths)
+}
+
 // GET /api/v0/stats/{page} stats
 // Get browser/system/etc. stats.
 //
 // Page can be: browsers, systems, locations, languages, sizes, campaigns,
-// toprefs.
+// props, toprefs, entry, exit.
+//
+// The entry and exit pages are the first and last pageview of every session in
+// the date range; the path filter applies to the entry or exit page. This
+// requires sessions to be collected.
 //
 // Query: apiStatsRequest
 // Response 200: apiStatsResponse
@@ -1072,7 +1847,7 @@ func (h api) stats(w http.ResponseWriter, r *http.Request) error {
 
 	v := goatcounter.NewValidate(r.Context())
 	page := v.Include("page", chi.URLParam(r, "page"), []string{
-		"browsers", "systems", "locations", "languages", "sizes", "campaigns", "toprefs"})
+		"browsers", "systems", "locations", "languages", "sizes", "campaigns", "props", "toprefs", "entry", "exit"})
 	if v.HasErrors() {
 		return v
 	}
@@ -1098,30 +1873,40 @@ func (h api) stats(w http.ResponseWriter, r *http.Request) error {
 	if args.End.IsZero() {
 		args.End = ztime.Now()
 	}
+	if args.Compare != "" {
+		v.Include("compare", args.Compare, []string{goatcounter.ComparePrevious, goatcounter.CompareYear})
+		if v.HasErrors() {
+			return v
+		}
+	}
 
-	var (
-		stats goatcounter.HitStats
-		f     func(ctx context.Context, rng ztime.Range, pathFilter []int64, limit, offset int) error
-	)
+	var list goatcounter.HitStatsList
 	switch page {
 	case "browsers":
-		f = stats.ListBrowsers
+		list = (*goatcounter.HitStats).ListBrowsers
 	case "systems":
-		f = stats.ListSystems
+		list = (*goatcounter.HitStats).ListSystems
 	case "locations":
-		f = stats.ListLocations
+		list = (*goatcounter.HitStats).ListLocations
 	case "languages":
-		f = stats.ListLanguages
+		list = (*goatcounter.HitStats).ListLanguages
 	case "sizes":
-		f = func(ctx context.Context, rng ztime.Range, pathFilter []int64, _, _ int) error {
-			return stats.ListSizes(ctx, rng, pathFilter)
+		list = func(h *goatcounter.HitStats, ctx context.Context, rng ztime.Range, pathFilter []int64, _, _ int) error {
+			return h.ListSizes(ctx, rng, pathFilter)
 		}
 	case "campaigns":
-		f = stats.ListCampaigns
+		list = (*goatcounter.HitStats).ListCampaigns
+	case "props":
+		list = (*goatcounter.HitStats).ListProps
 	case "toprefs":
-		f = stats.ListTopRefs
-	}
-	err = f(r.Context(), ztime.NewRange(args.Start).To(args.End), args.IncludePaths, args.Limit, args.Offset)
+		list = (*goatcounter.HitStats).ListTopRefs
+	case "entry":
+		list = (*goatcounter.HitStats).ListEntries
+	case "exit":
+		list = (*goatcounter.HitStats).ListExits
+	}
+	var stats goatcounter.HitStats
+	err = args.list(r.Context(), &stats, list)
 	if err != nil {
 		return err
 	}
@@ -1143,7 +1928,10 @@ func (h api) stats(w http.ResponseWriter, r *http.Request) error {
 // GET /api/v0/stats/{page}/{id} stats
 // Get detailed stats for an ID.
 //
-// Page can be: browsers, systems, locations, sizes, campaigns, toprefs.
+// Page can be: browsers, systems, locations, sizes, campaigns, props, toprefs.
+//
+// For props the ID is the property key, and the values for that key are
+// returned.
 //
 // Query: apiStatsRequest
 // Response 200: apiStatsResponse
@@ -1153,7 +1941,7 @@ func (h api) statsDetail(w http.ResponseWriter, r *http.Request) error {
 
 	v := goatcounter.NewValidate(r.Context())
 	page := v.Include("page", chi.URLParam(r, "page"), []string{
-		"browsers", "systems", "locations", "sizes", "campaigns", "toprefs"})
+		"browsers", "systems", "locations", "sizes", "campaigns", "props", "toprefs"})
 	if v.HasErrors() {
 		return v
 	}
@@ -1179,33 +1967,43 @@ func (h api) statsDetail(w http.ResponseWriter, r *http.Request) error {
 	if args.End.IsZero() {
 		args.End = ztime.Now()
 	}
+	if args.Compare != "" {
+		v.Include("compare", args.Compare, []string{goatcounter.ComparePrevious, goatcounter.CompareYear})
+		if v.HasErrors() {
+			return v
+		}
+	}
 
-	var (
-		stats goatcounter.HitStats
-		f     func(ctx context.Context, id string, rng ztime.Range, pathFilter []int64, limit, offset int) error
-	)
+	var f func(h *goatcounter.HitStats, ctx context.Context, id string, rng ztime.Range, pathFilter []int64, limit, offset int) error
 	switch page {
 	case "browsers":
-		f = stats.ListBrowser
+		f = (*goatcounter.HitStats).ListBrowser
 	case "systems":
-		f = stats.ListSystem
+		f = (*goatcounter.HitStats).ListSystem
 	case "locations":
-		f = stats.ListLocation
+		f = (*goatcounter.HitStats).ListLocation
 	case "sizes":
-		f = stats.ListSize
+		f = (*goatcounter.HitStats).ListSize
 	case "toprefs":
-		f = stats.ListTopRef
+		f = (*goatcounter.HitStats).ListTopRef
 	case "campaigns":
-		f = func(ctx context.Context, id string, rng ztime.Range, pathFilter []int64, limit, offset int) error {
+		f = func(h *goatcounter.HitStats, ctx context.Context, id string, rng ztime.Range, pathFilter []int64, limit, offset int) error {
 			n, err := strconv.ParseInt(id, 0, 64)
 			if err != nil {
 				return err
 			}
-			return stats.ListCampaign(ctx, n, rng, pathFilter, limit, offset)
+			return h.ListCampaign(ctx, n, rng, pathFilter, limit, offset)
 		}
+	case "props":
+		f = (*goatcounter.HitStats).ListProp
 	}
-	err = f(r.Context(), chi.URLParam(r, "id"), ztime.NewRange(args.Start).To(args.End),
-		args.IncludePaths, args.Limit, args.Offset)
+	var (
+		stats goatcounter.HitStats
+		id    = chi.URLParam(r, "id")
+	)
+	err = args.list(r.Context(), &stats, func(h *goatcounter.HitStats, ctx context.Context, rng ztime.Range, pathFilter []int64, limit, offset int) error {
+		return f(h, ctx, id, rng, pathFilter, limit, offset)
+	})
 	if err != nil {
 		return err
 	}
@@ -6,16 +6,19 @@ package handlers
 
 import (
 	"bytes"
+	"compress/gzip"
 	"context"
 	"fmt"
 	"io"
 	"net/http"
 	"net/http/httptest"
+	"os"
 	"strconv"
 	"strings"
 	"testing"
 	"time"
 
+	"zgo.at/bgrun"
 	"zgo.at/goatcounter/v2"
 	"zgo.at/goatcounter/v2/gctest"
 	"zgo.at/json"
@@ -286,6 +289,17 @@ func TestAPICount(t *testing.T) {
 			`,
 		},
 
+		// Browser and system without User-Agent.
+		{
+			APICountRequest{NoSessions: true, Hits: []APICountRequestHit{
+				{Path: "/foo", Browser: "Firefox", BrowserVersion: "120", System: "Linux"},
+			}},
+			202, respOK, `
+			hit_id  site_id  path  title  event  browser      system  session                           bot  ref  ref_s  size  loc  first  created_at
+			1       1        /foo         0      Firefox 120  Linux   00112233445566778899aabbccddef01  0         NULL   NULL       1      2020-06-18 14:42:00
+			`,
+		},
+
 		// Event
 		{
 			APICountRequest{NoSessions: true, Hits: []APICountRequestHit{
@@ -365,6 +379,14 @@ func TestAPICount(t *testing.T) {
 			}},
 			202, respOK, ``,
 		},
+		{
+			APICountRequest{NoSessions: true, Hits: []APICountRequestHit{
+				{Path: "/foo", IP: "10.1.2.3"},
+				{Path: "/Admin/users"},
+				{Path: "/foo", UserAgent: "Mozilla/5.0 HeadlessChrome/120.0"},
+			}},
+			202, respOK, ``,
+		},
 		{
 			APICountRequest{NoSessions: true, Filter: []string{}, Hits: []APICountRequestHit{
 				{Path: "/foo", IP: "1.2.3.4"},
@@ -383,7 +405,9 @@ func TestAPICount(t *testing.T) {
 		t.Run("", func(t *testing.T) {
 			ctx := gctest.DB(t)
 			site := Site(ctx)
-			site.Settings.IgnoreIPs = []string{"1.1.1.1"}
+			site.Settings.IgnoreIPs = []string{"1.1.1.1", "10.0.0.0/8"}
+			site.Settings.IgnoreUserAgents = []string{"HeadlessChrome"}
+			site.Settings.IgnorePaths = []string{"/admin/*"}
 			err := site.Update(ctx)
 			if err != nil {
 				t.Fatal(err)
@@ -439,6 +463,46 @@ func TestAPICount(t *testing.T) {
 	}
 }
 
+func TestAPICountImportHash(t *testing.T) {
+	ztime.SetNow(t, "2020-06-18 14:42:00")
+	ctx := gctest.DB(t)
+
+	send := func(hits ...APICountRequestHit) string {
+		t.Helper()
+		r, rr := newAPITest(ctx, t, "POST", "/api/v0/count",
+			bytes.NewReader(zjson.MustMarshal(APICountRequest{NoSessions: true, Hits: hits})),
+			goatcounter.APIPermCount)
+		newBackend(zdb.MustGetDB(ctx)).ServeHTTP(rr, r)
+		ztest.Code(t, rr, 202)
+		return rr.Header().Get("X-Goatcounter-Duplicates")
+	}
+
+	if have := send(APICountRequestHit{Path: "/a", ImportHash: "a"}, APICountRequestHit{Path: "/b", ImportHash: "b"},
+		APICountRequestHit{Path: "/b", ImportHash: "b"}); have != "2" {
+		t.Errorf("wrong header on first send: %q", have)
+	}
+	// Not persisted yet.
+	have := send(APICountRequestHit{Path: "/c", ImportHash: "c"}, APICountRequestHit{Path: "/a", ImportHash: "a"},
+		APICountRequestHit{Path: "/b", ImportHash: "b"}, APICountRequestHit{Path: "/d"})
+	if have != "1, 2" {
+		t.Errorf("wrong header: %q", have)
+	}
+	gctest.StoreHits(ctx, t, false)
+	if have := send(APICountRequestHit{Path: "/a", ImportHash: "a"}); have != "0" {
+		t.Errorf("wrong header after persist: %q", have)
+	}
+	gctest.StoreHits(ctx, t, false)
+
+	var n int
+	err := zdb.Get(ctx, &n, `select count(*) from hits`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 4 {
+		t.Errorf("%d hits", n)
+	}
+}
+
 func TestAPISitesCreate(t *testing.T) {
 	ztime.SetNow(t, "2020-06-18 12:13:14")
 	now := ztime.Now()
@@ -512,6 +576,35 @@ func TestAPISitesCreate(t *testing.T) {
 	}
 }
 
+func TestAPISitesCreateWebhook(t *testing.T) {
+	ctx := gctest.DB(t)
+	goatcounter.Config(ctx).AllowPrivateURLs = true // httptest server is on localhost.
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer srv.Close()
+
+	hook := goatcounter.Webhook{URL: srv.URL, Events: goatcounter.WebhookEvents{goatcounter.WebhookSiteCreated}}
+	err := hook.Insert(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r, rr := newAPITest(ctx, t, "PUT", "/api/v0/sites", strings.NewReader(`{"code":"apitest"}`), goatcounter.APIPermSiteCreate)
+	newBackend(zdb.MustGetDB(ctx)).ServeHTTP(rr, r)
+	ztest.Code(t, rr, 200)
+	bgrun.Wait("")
+
+	var log goatcounter.WebhookDeliveries
+	err = log.List(ctx, hook.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(log) != 1 || log[0].Event != "site.created" || log[0].DeliveredAt == nil ||
+		!strings.Contains(log[0].Payload, `"code":"apitest"`) {
+		t.Errorf("wrong delivery log: %#v", log)
+	}
+}
+
 func TestAPISitesUpdate(t *testing.T) {
 	ztime.SetNow(t, "2020-06-18 12:13:14")
 
@@ -968,6 +1061,18 @@ func TestAPIStats(t *testing.T) {
 					{"count": 15, "id": "Chrome", "name": "Chrome"}
 				]
 			}`},
+
+		{"compare", "browsers", "compare=previous", 200,
+			func(ctx context.Context, t *testing.T) { many(ctx, t) },
+			`{
+				"more": false,
+				"stats": [
+					{"count": 35, "id": "Firefox", "name": "Firefox", "prev": 0},
+					{"count": 15, "id": "Chrome", "name": "Chrome", "prev": 0}
+				]
+			}`},
+		{"compare invalid", "browsers", "compare=xxx", 400, nil,
+			`{"errors": {"compare": ["must be one of ‘previous, year’"]}}`},
 	}
 
 	perm := goatcounter.APIPermStats
@@ -1032,6 +1137,10 @@ func TestAPIStatsDetail(t *testing.T) {
 					{"count": 1, "name": "Firefox 10"}
 				]
 			}`},
+
+		// entry and exit have no per-path detail view.
+		{"entry", "entry/1", "", 400, nil, `{"errors": {"page": ["must be one of ‘browsers, systems, locations, sizes, campaigns, props, toprefs’"]}}`},
+		{"exit", "exit/1", "", 400, nil, `{"errors": {"page": ["must be one of ‘browsers, systems, locations, sizes, campaigns, props, toprefs’"]}}`},
 	}
 
 	perm := goatcounter.APIPermStats
@@ -1052,3 +1161,194 @@ func TestAPIStatsDetail(t *testing.T) {
 		})
 	}
 }
+
+func TestAPILive(t *testing.T) {
+	ztime.SetNow(t, "2020-06-18 12:13:14")
+	ctx := gctest.DB(t)
+	gctest.StoreHits(ctx, t, false, goatcounter.Hit{Path: "/a"})
+
+	r, rr := newAPITest(ctx, t, "GET", "/api/v0/live", nil, goatcounter.APIPermStats)
+	rctx, cancel := context.WithTimeout(r.Context(), 50*time.Millisecond)
+	defer cancel()
+	newBackend(zdb.MustGetDB(ctx)).ServeHTTP(rr, r.WithContext(rctx))
+	ztest.Code(t, rr, 200)
+
+	if h := rr.Header().Get("Content-Type"); h != "text/event-stream" {
+		t.Errorf("Content-Type: %q", h)
+	}
+	want := `event: live
+data: {"sessions":1,"pages":[{"path":"/a","sessions":1}],"hits":[{"path":"/a","title":"","event":false,"location":"","created_at":"2020-06-18T12:13:14Z"}]}
+
+`
+	if d := ztest.Diff(rr.Body.String(), want); d != "" {
+		t.Error(d)
+	}
+}
+
+func TestAPIAnnotations(t *testing.T) {
+	ztime.SetNow(t, "2020-06-18 12:13:14")
+	ctx := gctest.DB(t)
+
+	run := func(method, path, body string, perm zint.Bitflag64, wantCode int, want string) {
+		t.Helper()
+		r, rr := newAPITest(ctx, t, method, path, strings.NewReader(body), perm)
+		newBackend(zdb.MustGetDB(ctx)).ServeHTTP(rr, r)
+		ztest.Code(t, rr, wantCode)
+		if d := ztest.Diff(rr.Body.String(), want, ztest.DiffJSON); d != "" {
+			t.Error(d)
+		}
+	}
+
+	perm := goatcounter.APIPermAnnotations
+	run("PUT", "/api/v0/annotations", `{"text": "v2.3 deployed"}`, goatcounter.APIPermStats, 403,
+		`{"error": "requires 'annotations' permissions"}`)
+	run("PUT", "/api/v0/annotations", `{}`, perm, 400,
+		`{"errors": {"text": ["must be set"]}}`)
+
+	run("PUT", "/api/v0/annotations", `{"text": "v2.3 deployed"}`, perm, 200, `{
+		"id": 1, "site_id": 1, "at": "2020-06-18T12:13:14Z", "text": "v2.3 deployed", "path": "",
+		"created_at": "2020-06-18T12:13:14Z"
+	}`)
+	run("PUT", "/api/v0/annotations", `{"text": "HN post", "path": "/blog/*", "at": "2020-06-01T08:00:00Z"}`, perm, 200, `{
+		"id": 2, "site_id": 1, "at": "2020-06-01T08:00:00Z", "text": "HN post", "path": "/blog/*",
+		"created_at": "2020-06-18T12:13:14Z"
+	}`)
+
+	run("PATCH", "/api/v0/annotations/1", `{"path": "/x"}`, perm, 200, `{
+		"id": 1, "site_id": 1, "at": "2020-06-18T12:13:14Z", "text": "v2.3 deployed", "path": "/x",
+		"created_at": "2020-06-18T12:13:14Z"
+	}`)
+	run("GET", "/api/v0/annotations?start=2020-06-10T00:00:00Z", ``, perm, 200, `{"annotations": [{
+		"id": 1, "site_id": 1, "at": "2020-06-18T12:13:14Z", "text": "v2.3 deployed", "path": "/x",
+		"created_at": "2020-06-18T12:13:14Z"
+	}]}`)
+
+	run("DELETE", "/api/v0/annotations/1", ``, perm, 200, `{
+		"id": 1, "site_id": 1, "at": "2020-06-18T12:13:14Z", "text": "v2.3 deployed", "path": "/x",
+		"created_at": "2020-06-18T12:13:14Z"
+	}`)
+	run("GET", "/api/v0/annotations/1", ``, perm, 404, `{"error": "not found"}`)
+	run("GET", "/api/v0/annotations", ``, perm, 200, `{"annotations": [{
+		"id": 2, "site_id": 1, "at": "2020-06-01T08:00:00Z", "text": "HN post", "path": "/blog/*",
+		"created_at": "2020-06-18T12:13:14Z"
+	}]}`)
+}
+
+func TestAPIAlerts(t *testing.T) {
+	ztime.SetNow(t, "2020-06-18 12:13:14")
+	ctx := gctest.DB(t)
+
+	run := func(method, path, body string, perm zint.Bitflag64, wantCode int, want string) {
+		t.Helper()
+		r, rr := newAPITest(ctx, t, method, path, strings.NewReader(body), perm)
+		newBackend(zdb.MustGetDB(ctx)).ServeHTTP(rr, r)
+		ztest.Code(t, rr, wantCode)
+		if d := ztest.Diff(rr.Body.String(), want, ztest.DiffJSON); d != "" {
+			t.Error(d)
+		}
+	}
+
+	perm := goatcounter.APIPermAlerts
+	run("PUT", "/api/v0/alerts", `{"name": "x"}`, goatcounter.APIPermAnnotations, 403,
+		`{"error": "requires 'alerts' permissions"}`)
+	run("PUT", "/api/v0/alerts", `{"name": "Drop", "kind": "drop", "threshold": 200}`, perm, 400,
+		`{"errors": {"email": ["must set email or webhook_url"], "threshold": ["must be 100 or lower"]}}`)
+
+	run("PUT", "/api/v0/alerts", `{"name": "Drop", "kind": "drop", "threshold": 50, "email": "a@example.com"}`, perm, 200, `{
+		"id": 1, "site_id": 1, "name": "Drop", "kind": "drop", "path": "", "threshold": 50, "cooldown": 360,
+		"email": "a@example.com", "webhook_url": "", "webhook_secret": "", "last_fired_at": null,
+		"created_at": "2020-06-18T12:13:14Z"
+	}`)
+	run("PATCH", "/api/v0/alerts/1", `{"kind": "path", "path": "/blog/*", "threshold": 100, "cooldown": 60}`, perm, 200, `{
+		"id": 1, "site_id": 1, "name": "Drop", "kind": "path", "path": "/blog/*", "threshold": 100, "cooldown": 60,
+		"email": "a@example.com", "webhook_url": "", "webhook_secret": "", "last_fired_at": null,
+		"created_at": "2020-06-18T12:13:14Z"
+	}`)
+	run("GET", "/api/v0/alerts", ``, perm, 200, `{"alerts": [{
+		"id": 1, "site_id": 1, "name": "Drop", "kind": "path", "path": "/blog/*", "threshold": 100, "cooldown": 60,
+		"email": "a@example.com", "webhook_url": "", "webhook_secret": "", "last_fired_at": null,
+		"created_at": "2020-06-18T12:13:14Z"
+	}]}`)
+
+	run("DELETE", "/api/v0/alerts/1", ``, perm, 200, `{
+		"id": 1, "site_id": 1, "name": "Drop", "kind": "path", "path": "/blog/*", "threshold": 100, "cooldown": 60,
+		"email": "a@example.com", "webhook_url": "", "webhook_secret": "", "last_fired_at": null,
+		"created_at": "2020-06-18T12:13:14Z"
+	}`)
+	run("GET", "/api/v0/alerts/1", ``, perm, 404, `{"error": "not found"}`)
+}
+
+func TestAPIExport(t *testing.T) {
+	ctx := gctest.DB(t)
+	gctest.StoreHits(ctx, t, false, goatcounter.Hit{Path: "/a", CreatedAt: ztime.Now()})
+
+	r, rr := newAPITest(ctx, t, "POST", "/api/v0/export", strings.NewReader(`{"format": "xml"}`), goatcounter.APIPermExport)
+	newBackend(zdb.MustGetDB(ctx)).ServeHTTP(rr, r)
+	ztest.Code(t, rr, 400)
+	if d := ztest.Diff(rr.Body.String(), `{"errors": {"format": ["must be one of ‘csv, jsonl, sqlite’"]}}`, ztest.DiffJSON); d != "" {
+		t.Error(d)
+	}
+
+	r, rr = newAPITest(ctx, t, "POST", "/api/v0/export", strings.NewReader(`{"format": "jsonl"}`), goatcounter.APIPermExport)
+	newBackend(zdb.MustGetDB(ctx)).ServeHTTP(rr, r)
+	ztest.Code(t, rr, 202)
+	var export goatcounter.Export
+	zjson.MustUnmarshal(rr.Body.Bytes(), &export)
+	if export.Format != "jsonl" || !strings.HasSuffix(export.Path, ".jsonl.gz") {
+		t.Fatalf("wrong export: %#v", export)
+	}
+	bgrun.Wait("")
+	defer os.Remove(export.Path)
+
+	r, rr = newAPITest(ctx, t, "GET", fmt.Sprintf("/api/v0/export/%d/download", export.ID), nil, goatcounter.APIPermExport)
+	newBackend(zdb.MustGetDB(ctx)).ServeHTTP(rr, r)
+	ztest.Code(t, rr, 200)
+	if ct := rr.Header().Get("Content-Type"); ct != "application/gzip" {
+		t.Errorf("Content-Type: %s", ct)
+	}
+	gz, err := gzip.NewReader(rr.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, _ := io.ReadAll(gz)
+	if !bytes.Contains(b, []byte(`"path":"/a"`)) {
+		t.Errorf("wrong body: %s", b)
+	}
+}
+
+func TestAPIImportAggregates(t *testing.T) {
+	ctx := gctest.DB(t)
+
+	r, rr := newAPITest(ctx, t, "POST", "/api/v0/import/aggregates", strings.NewReader(`{"rows": [
+		{"table":"hit_counts","path":"/a","title":"A","hour":"2019-06-18T14:00:00Z","count":3},
+		{"table":"browser_stats","path":"/a","title":"A","day":"2019-06-18","name":"Firefox","version":"80","count":3}
+	]}`), goatcounter.APIPermCount)
+	newBackend(zdb.MustGetDB(ctx)).ServeHTTP(rr, r)
+	ztest.Code(t, rr, 200)
+	if d := ztest.Diff(rr.Body.String(), `{"rows": 2}`, ztest.DiffJSON); d != "" {
+		t.Error(d)
+	}
+
+	have := zdb.DumpString(ctx, `select hour, total from hit_counts`) +
+		zdb.DumpString(ctx, `select day, count from browser_stats`)
+	want := "hour                 total\n2019-06-18 14:00:00  3\n" + "day                  count\n2019-06-18 00:00:00  3\n"
+	if d := ztest.Diff(have, want); d != "" {
+		t.Error(d)
+	}
+
+	r, rr = newAPITest(ctx, t, "POST", "/api/v0/import/aggregates", strings.NewReader(`{"rows": [
+		{"table":"hit_counts","path":"/a","count":3}
+	]}`), goatcounter.APIPermCount)
+	newBackend(zdb.MustGetDB(ctx)).ServeHTTP(rr, r)
+	ztest.Code(t, rr, 400)
+	if !strings.Contains(rr.Body.String(), "hour: must be set") {
+		t.Error(rr.Body.String())
+	}
+
+	r, rr = newAPITest(ctx, t, "POST", "/api/v0/export", strings.NewReader(`{"mode": "aggregates", "format": "csv"}`), goatcounter.APIPermExport)
+	newBackend(zdb.MustGetDB(ctx)).ServeHTTP(rr, r)
+	ztest.Code(t, rr, 400)
+	if !strings.Contains(rr.Body.String(), "aggregates can only be exported as jsonl") {
+		t.Error(rr.Body.String())
+	}
+}
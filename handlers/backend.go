@@ -127,6 +127,7 @@ func (h backend) Mount(r chi.Router, db zdb.DB, dev bool, domainStatic string, d
 
 		a := r.With(mware.Headers(headers), keyAuth, addz18n())
 		user{}.mount(a)
+		a.Get("/ignore-me", zhttp.Wrap(h.ignoreMe))
 		{
 			ap := a.With(loggedInOrPublic, addz18n())
 			ap.Get("/", zhttp.Wrap(h.dashboard))
@@ -7,11 +7,15 @@ package handlers
 import (
 	"fmt"
 	"net/http"
+	"strconv"
+	"time"
 
 	"github.com/monoculum/formam/v3"
 	"golang.org/x/text/language"
 	"zgo.at/goatcounter/v2"
+	"zgo.at/goatcounter/v2/cron"
 	"zgo.at/goatcounter/v2/metrics"
+	"zgo.at/guru"
 	"zgo.at/isbot"
 	"zgo.at/zhttp"
 	"zgo.at/zstd/ztime"
@@ -23,6 +27,17 @@ var gif = []byte{0x47, 0x49, 0x46, 0x38, 0x39, 0x61, 0x1, 0x0, 0x1, 0x0, 0x80,
 	0x1, 0x0, 0x2c, 0x0, 0x0, 0x0, 0x0, 0x1, 0x0, 0x1, 0x0, 0x0, 0x2, 0x2, 0x4c,
 	0x1, 0x0, 0x3b}
 
+// Cookie set by /ignore-me; pageviews from browsers with this cookie are never
+// counted.
+const ignoreMeCookie = "goatcounter-ignore"
+
+// retryAfter gets the Retry-After header for when the memstore is full, which
+// is the next time the pageviews are persisted.
+func retryAfter() string {
+	s := int((cron.PersistInterval() + time.Second - 1) / time.Second)
+	return strconv.Itoa(max(s, 1))
+}
+
 func (h backend) count(w http.ResponseWriter, r *http.Request) error {
 	m := metrics.Start("/count")
 	defer m.Done()
@@ -48,14 +63,26 @@ func (h backend) count(w http.ResponseWriter, r *http.Request) error {
 		return zhttp.Bytes(w, gif)
 	}
 
+	if goatcounter.Memstore.Full() {
+		w.Header().Set("Retry-After", retryAfter())
+		w.Header().Add("X-Goatcounter", "too many pageviews waiting to be stored; try again later")
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return zhttp.Bytes(w, gif)
+	}
+
 	site := Site(r.Context())
-	for _, ip := range site.Settings.IgnoreIPs {
-		if ip == r.RemoteAddr {
-			w.Header().Add("X-Goatcounter", fmt.Sprintf("ignored because %q is in the IP ignore list", ip))
+	if site.Settings.AllowIgnoreMe {
+		if c, err := r.Cookie(ignoreMeCookie); err == nil && c.Value == "1" {
+			w.Header().Add("X-Goatcounter", "ignored")
 			w.WriteHeader(http.StatusAccepted)
 			return zhttp.Bytes(w, gif)
 		}
 	}
+	if reason := site.Settings.Ignored(r.RemoteAddr, r.UserAgent(), ""); reason != "" {
+		w.Header().Add("X-Goatcounter", reason)
+		w.WriteHeader(http.StatusAccepted)
+		return zhttp.Bytes(w, gif)
+	}
 
 	hit := goatcounter.Hit{
 		Site:            site.ID,
@@ -99,6 +126,11 @@ func (h backend) count(w http.ResponseWriter, r *http.Request) error {
 		w.WriteHeader(http.StatusRequestURITooLong)
 		return zhttp.Bytes(w, gif)
 	}
+	if reason := site.Settings.Ignored("", "", hit.Path); reason != "" {
+		w.Header().Add("X-Goatcounter", reason)
+		w.WriteHeader(http.StatusAccepted)
+		return zhttp.Bytes(w, gif)
+	}
 
 	if isbot.Is(bot) { // Prefer the backend detection.
 		hit.Bot = int(bot)
@@ -114,3 +146,39 @@ func (h backend) count(w http.ResponseWriter, r *http.Request) error {
 	goatcounter.Memstore.Append(hit)
 	return zhttp.Bytes(w, gif)
 }
+
+// ignoreMe sets a cookie to never count pageviews from this browser, or removes
+// it with ?undo=1.
+func (h backend) ignoreMe(w http.ResponseWriter, r *http.Request) error {
+	site := Site(r.Context())
+	if !site.Settings.AllowIgnoreMe {
+		return guru.New(404, T(r.Context(), "error/not-found|Not Found"))
+	}
+
+	// The cookie needs to be sent from the site's domain to /count, which
+	// browsers only do with SameSite=None; this requires Secure.
+	sameSite := zhttp.CookieSameSite
+	if zhttp.CookieSecure {
+		sameSite = http.SameSiteNoneMode
+	}
+
+	undo := r.URL.Query().Get("undo") != ""
+	c := &http.Cookie{
+		Name:     ignoreMeCookie,
+		Value:    "1",
+		Path:     "/",
+		MaxAge:   10 * 365 * 86400,
+		HttpOnly: true,
+		Secure:   zhttp.CookieSecure,
+		SameSite: sameSite,
+	}
+	if undo {
+		c.Value, c.MaxAge = "", -1
+	}
+	http.SetCookie(w, c)
+
+	return zhttp.Template(w, "ignore_me.gohtml", struct {
+		Globals
+		Undo bool
+	}{newGlobals(w, r), undo})
+}
@@ -6,6 +6,7 @@ package handlers
 
 import (
 	"context"
+	"fmt"
 	"net/http"
 	"net/url"
 	"sort"
@@ -116,6 +117,18 @@ func TestBackendCount(t *testing.T) {
 			Path: "/" + strings.Repeat("a", 2047),
 		}},
 		{"too long", url.Values{"p": []string{"/" + strings.Repeat("a", 2048)}}, nil, 414, goatcounter.Hit{}},
+
+		{"props", url.Values{"p": {"/a"}, "props[plan]": {"pro"}, "props[theme]": {"dark"}}, nil, 200, goatcounter.Hit{
+			Path:  "/a",
+			Props: goatcounter.Props{"plan": "pro", "theme": "dark"},
+		}},
+		{"too many props", func() url.Values {
+			v := url.Values{"p": {"/a"}}
+			for i := 0; i <= goatcounter.MaxPropsPerHit; i++ {
+				v.Set(fmt.Sprintf("props[k%d]", i), "v")
+			}
+			return v
+		}(), nil, 400, goatcounter.Hit{}},
 	}
 
 	for _, tt := range tests {
@@ -144,11 +157,20 @@ func TestBackendCount(t *testing.T) {
 				return
 			}
 
-			_, err := goatcounter.Memstore.Persist(ctx)
+			persisted, err := goatcounter.Memstore.Persist(ctx)
 			if err != nil {
 				t.Fatal(err)
 			}
 
+			// Props aren't stored in the hits table, so check what was parsed.
+			if len(persisted) != 1 {
+				t.Fatalf("len(persisted) = %d", len(persisted))
+			}
+			if d := ztest.Diff(persisted[0].Props.String(), tt.hit.Props.String()); d != "" {
+				t.Errorf("props:\n%s", d)
+			}
+			tt.hit.Props = nil
+
 			var hits goatcounter.Hits
 			err = hits.TestList(ctx, false)
 			if err != nil {
@@ -326,3 +348,99 @@ func TestBackendCountSessions(t *testing.T) {
 	want = []int{1, 1, 2, 3, 3, 1, 2, 1, 3, 4, 5}
 	checkSess(append(hits1, hits2...), want)
 }
+
+func TestBackendCountIgnore(t *testing.T) {
+	tests := []struct {
+		name     string
+		settings goatcounter.SiteSettings
+		path     string
+		want     string
+	}{
+		{"none", goatcounter.SiteSettings{}, "/a", ""},
+		{"ip range", goatcounter.SiteSettings{IgnoreIPs: []string{"192.0.2.0/24"}}, "/a", `"192.0.2.0/24" is in the IP ignore list`},
+		{"ip range no match", goatcounter.SiteSettings{IgnoreIPs: []string{"192.0.3.0/24"}}, "/a", ""},
+		{"user agent", goatcounter.SiteSettings{IgnoreUserAgents: []string{`test runner/\d`}}, "/a", `User-Agent matches "test runner/\\d"`},
+		{"path", goatcounter.SiteSettings{IgnorePaths: []string{"/admin*"}}, "/admin/x", `path matches "/admin*"`},
+		{"path no match", goatcounter.SiteSettings{IgnorePaths: []string{"/admin*"}}, "/a", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := gctest.DB(t)
+
+			site := goatcounter.Site{Settings: tt.settings}
+			ctx = gctest.Site(ctx, t, &site, nil)
+
+			before := goatcounter.Memstore.Len()
+			r, rr := newTest(ctx, "GET", "/count?p="+tt.path, nil)
+			r.Host = site.Code + "." + goatcounter.Config(ctx).Domain
+			newBackend(zdb.MustGetDB(ctx)).ServeHTTP(rr, r)
+
+			h := rr.Header().Get("X-Goatcounter")
+			if tt.want == "" {
+				ztest.Code(t, rr, 200)
+				if h != "" {
+					t.Errorf("X-Goatcounter: %s", h)
+				}
+				if l := goatcounter.Memstore.Len() - before; l != 1 {
+					t.Errorf("added %d pageviews", l)
+				}
+				return
+			}
+
+			ztest.Code(t, rr, 202)
+			if !strings.Contains(h, tt.want) {
+				t.Errorf("X-Goatcounter: %s\nwant: %s", h, tt.want)
+			}
+			if l := goatcounter.Memstore.Len() - before; l != 0 {
+				t.Errorf("added %d pageviews", l)
+			}
+		})
+	}
+}
+
+func TestBackendIgnoreMe(t *testing.T) {
+	for _, allow := range []bool{false, true} {
+		t.Run(fmt.Sprintf("%t", allow), func(t *testing.T) {
+			ctx := gctest.DB(t)
+
+			site := goatcounter.Site{Settings: goatcounter.SiteSettings{AllowIgnoreMe: allow}}
+			ctx = gctest.Site(ctx, t, &site, nil)
+			host := site.Code + "." + goatcounter.Config(ctx).Domain
+
+			r, rr := newTest(ctx, "GET", "/ignore-me", nil)
+			r.Host = host
+			newBackend(zdb.MustGetDB(ctx)).ServeHTTP(rr, r)
+			if !allow {
+				ztest.Code(t, rr, 404)
+				return
+			}
+			ztest.Code(t, rr, 200)
+			cookies := rr.Result().Cookies()
+			if len(cookies) != 1 || cookies[0].Name != "goatcounter-ignore" || cookies[0].Value != "1" {
+				t.Fatalf("cookies: %v", cookies)
+			}
+
+			before := goatcounter.Memstore.Len()
+			r, rr = newTest(ctx, "GET", "/count?p=/a", nil)
+			r.Host = host
+			r.AddCookie(cookies[0])
+			newBackend(zdb.MustGetDB(ctx)).ServeHTTP(rr, r)
+			ztest.Code(t, rr, 202)
+			if h := rr.Header().Get("X-Goatcounter"); h != "ignored" {
+				t.Errorf("X-Goatcounter: %s", h)
+			}
+			if l := goatcounter.Memstore.Len() - before; l != 0 {
+				t.Errorf("added %d pageviews", l)
+			}
+
+			r, rr = newTest(ctx, "GET", "/ignore-me?undo=1", nil)
+			r.Host = host
+			newBackend(zdb.MustGetDB(ctx)).ServeHTTP(rr, r)
+			ztest.Code(t, rr, 200)
+			if c := rr.Result().Cookies(); len(c) != 1 || c[0].MaxAge != -1 {
+				t.Fatalf("cookies: %v", c)
+			}
+		})
+	}
+}
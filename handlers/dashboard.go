@@ -72,6 +72,9 @@ func (h backend) dashboard(w http.ResponseWriter, r *http.Request) error {
 	if _, ok := q["daily"]; ok {
 		view.Daily = q.Get("daily") == "on" || q.Get("daily") == "true"
 	}
+	if _, ok := q["compare"]; ok {
+		view.Compare = q.Get("compare")
+	}
 	_, forcedDaily := getDaily(r, rng)
 	if forcedDaily {
 		view.Daily = true
@@ -118,6 +121,7 @@ func (h backend) dashboard(w http.ResponseWriter, r *http.Request) error {
 
 	args := widgets.Args{
 		Rng:         rng,
+		Compare:     goatcounter.CompareRange(rng, view.Compare),
 		Daily:       view.Daily,
 		ForcedDaily: forcedDaily,
 		ShowRefs:    showRefs,
@@ -313,6 +317,7 @@ func (h backend) loadWidget(w http.ResponseWriter, r *http.Request) error {
 		RowsOnly: key != "" || offset > 0,
 		Args: widgets.Args{
 			Rng:        rng,
+			Compare:    goatcounter.CompareRange(rng, r.URL.Query().Get("compare")),
 			PathFilter: pathFilter,
 			Offset:     offset,
 		},
@@ -5,10 +5,14 @@
 package handlers
 
 import (
+	"context"
 	"testing"
 	"time"
 
+	"zgo.at/goatcounter/v2"
+	"zgo.at/goatcounter/v2/gctest"
 	"zgo.at/zstd/ztime"
+	"zgo.at/zstd/ztype"
 )
 
 func TestDashboard(t *testing.T) {
@@ -20,6 +24,64 @@ func TestDashboard(t *testing.T) {
 			wantCode: 200,
 			wantBody: "<strong>No data received</strong>",
 		},
+		{
+			name: "heatmap",
+			setup: func(ctx context.Context, t *testing.T) {
+				gctest.StoreHits(ctx, t, false, goatcounter.Hit{FirstVisit: true})
+				user := goatcounter.MustGetUser(ctx)
+				user.Settings.Widgets = goatcounter.Widgets{{"n": "heatmap"}, {"n": "live"}}
+				err := user.Update(ctx, false)
+				if err != nil {
+					t.Fatal(err)
+				}
+			},
+			router:   newBackend,
+			auth:     true,
+			wantCode: 200,
+			wantBody: `<span style="opacity: 100%"></span>`,
+		},
+		{
+			name: "errors",
+			setup: func(ctx context.Context, t *testing.T) {
+				gctest.StoreHits(ctx, t, false, goatcounter.Hit{FirstVisit: true, Status: 404, ResponseTime: ztype.Ptr(int64(1500))})
+				user := goatcounter.MustGetUser(ctx)
+				user.Settings.Widgets = goatcounter.Widgets{{"n": "errors"}, {"n": "timing"}}
+				err := user.Update(ctx, false)
+				if err != nil {
+					t.Fatal(err)
+				}
+			},
+			router:   newBackend,
+			auth:     true,
+			wantCode: 200,
+			wantBody: `<td class="status-4xx">404</td>`,
+		},
+		{
+			name: "compare",
+			setup: func(ctx context.Context, t *testing.T) {
+				gctest.StoreHits(ctx, t, false, goatcounter.Hit{FirstVisit: true, Location: "NL"})
+			},
+			router:   newBackend,
+			path:     "/?compare=previous",
+			auth:     true,
+			wantCode: 200,
+			wantBody: `<small class="col-count-diff plus"`,
+		},
+		{
+			name: "annotations",
+			setup: func(ctx context.Context, t *testing.T) {
+				gctest.StoreHits(ctx, t, false, goatcounter.Hit{FirstVisit: true})
+				a := goatcounter.Annotation{Text: "v2.3 deployed"}
+				err := a.Insert(ctx)
+				if err != nil {
+					t.Fatal(err)
+				}
+			},
+			router:   newBackend,
+			auth:     true,
+			wantCode: 200,
+			wantBody: `&#34;text&#34;:&#34;v2.3 deployed&#34;`,
+		},
 	}
 
 	for _, tt := range tests {
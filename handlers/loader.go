@@ -12,10 +12,13 @@ import (
 	"time"
 
 	"github.com/gorilla/websocket"
+	"zgo.at/goatcounter/v2"
+	"zgo.at/goatcounter/v2/widgets"
 	"zgo.at/json"
 	zcache2 "zgo.at/zcache/v2"
 	"zgo.at/zlog"
 	"zgo.at/zstd/zint"
+	"zgo.at/ztpl"
 )
 
 // On dashboard view we generate a unique ID we send to the frontend, and
@@ -152,10 +155,12 @@ func (h backend) loader(w http.ResponseWriter, r *http.Request) error {
 	}
 
 	loader.connect(r, id, c)
+	stopLive := loader.live(r, id)
 
 	// Read messages.
 	go func() {
 		defer zlog.Recover()
+		defer stopLive()
 		for {
 			t, m, err := c.ReadMessage()
 			if err != nil {
@@ -168,3 +173,52 @@ func (h backend) loader(w http.ResponseWriter, r *http.Request) error {
 
 	return nil
 }
+
+// live sends updates for the "live" widgets over the connection as new
+// pageviews come in, until the returned function is called.
+func (l *loaderT) live(r *http.Request, id zint.Uint128) func() {
+	var wids []int
+	for i, w := range User(r.Context()).Settings.Widgets {
+		if w.Name() == "live" {
+			wids = append(wids, i)
+		}
+	}
+	if len(wids) == 0 {
+		return func() {}
+	}
+
+	var (
+		ctx            = r.Context()
+		update, cancel = goatcounter.Memstore.LiveSubscribe(Site(ctx).ID)
+		done           = make(chan struct{})
+		// Also send an update periodically so that sessions that are no longer
+		// live are removed.
+		tick = time.NewTicker(goatcounter.LivePeriod / 10)
+	)
+	go func() {
+		defer zlog.Recover()
+		defer tick.Stop()
+		defer cancel()
+		for {
+			select {
+			case <-done:
+				return
+			case <-update:
+			case <-tick.C:
+			}
+
+			for _, wid := range wids {
+				w := widgets.NewWidget("live", wid)
+				w.GetData(ctx, widgets.Args{})
+				tplName, tplData := w.RenderHTML(ctx, widgets.SharedData{RowsOnly: true})
+				html, err := ztpl.ExecuteString(tplName, tplData)
+				if err != nil {
+					zlog.Module("dashboard").FieldsRequest(r).Error(err)
+					return
+				}
+				l.sendJSON(r, id, map[string]any{"live": wid, "html": html})
+			}
+		}
+	}()
+	return func() { close(done) }
+}
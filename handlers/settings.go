@@ -5,6 +5,7 @@
 package handlers
 
 import (
+	"bufio"
 	"compress/gzip"
 	"context"
 	"fmt"
@@ -70,6 +71,7 @@ func (h settings) mount(r chi.Router) {
 		}))
 		set.Post("/settings/main", zhttp.Wrap(h.mainSave))
 		set.Get("/settings/main/ip", zhttp.Wrap(h.ip))
+		set.Get("/settings/main/ignore-test", zhttp.Wrap(h.ignoreTest))
 		set.Get("/settings/change-code", zhttp.Wrap(h.changeCode))
 		set.Post("/settings/change-code", zhttp.Wrap(h.changeCode))
 
@@ -77,10 +79,40 @@ func (h settings) mount(r chi.Router) {
 		set.Post("/settings/purge", zhttp.Wrap(h.purgeDo))
 		set.Post("/settings/merge", zhttp.Wrap(h.merge))
 
+		set.Get("/settings/goals", zhttp.Wrap(func(w http.ResponseWriter, r *http.Request) error {
+			return h.goals(nil)(w, r)
+		}))
+		set.Post("/settings/goals/add", zhttp.Wrap(h.goalsAdd))
+		set.Post("/settings/goals/remove/{id}", zhttp.Wrap(h.goalsRemove))
+		set.Post("/settings/funnels/add", zhttp.Wrap(h.funnelsAdd))
+		set.Post("/settings/funnels/remove/{id}", zhttp.Wrap(h.funnelsRemove))
+
+		set.Get("/settings/annotations", zhttp.Wrap(func(w http.ResponseWriter, r *http.Request) error {
+			return h.annotations(nil)(w, r)
+		}))
+		set.Post("/settings/annotations/add", zhttp.Wrap(h.annotationsAdd))
+		set.Post("/settings/annotations/{id}", zhttp.Wrap(h.annotationsUpdate))
+		set.Post("/settings/annotations/remove/{id}", zhttp.Wrap(h.annotationsRemove))
+
+		set.Get("/settings/alerts", zhttp.Wrap(func(w http.ResponseWriter, r *http.Request) error {
+			return h.alerts(nil)(w, r)
+		}))
+		set.Post("/settings/alerts/add", zhttp.Wrap(h.alertsAdd))
+		set.Post("/settings/alerts/remove/{id}", zhttp.Wrap(h.alertsRemove))
+
+		set.Get("/settings/webhooks", zhttp.Wrap(func(w http.ResponseWriter, r *http.Request) error {
+			return h.webhooks(nil)(w, r)
+		}))
+		set.Get("/settings/webhooks/{id}", zhttp.Wrap(h.webhooksLog))
+		set.Post("/settings/webhooks/add", zhttp.Wrap(h.webhooksAdd))
+		set.Post("/settings/webhooks/remove/{id}", zhttp.Wrap(h.webhooksRemove))
+
 		set.Get("/settings/export", zhttp.Wrap(func(w http.ResponseWriter, r *http.Request) error {
 			return h.export(nil)(w, r)
 		}))
 		set.Get("/settings/export/{id}", zhttp.Wrap(h.exportDownload))
+		set.Post("/settings/export/schedules/add", zhttp.Wrap(h.exportSchedulesAdd))
+		set.Post("/settings/export/schedules/remove/{id}", zhttp.Wrap(h.exportSchedulesRemove))
 		set.Post("/settings/export/import", zhttp.Wrap(h.exportImport))
 		set.With(mware.Ratelimit(mware.RatelimitOptions{
 			Client: mware.RatelimitIP,
@@ -143,6 +175,32 @@ func (h settings) ip(w http.ResponseWriter, r *http.Request) error {
 	return zhttp.String(w, r.RemoteAddr)
 }
 
+// ignoreTest reports if a request would be ignored with the ignore rules in the
+// parameters, so they can be tested before saving.
+func (h settings) ignoreTest(w http.ResponseWriter, r *http.Request) error {
+	var args struct {
+		IP        string                   `json:"ip"`
+		UserAgent string                   `json:"user_agent"`
+		Path      string                   `json:"path"`
+		Settings  goatcounter.SiteSettings `json:"settings"`
+	}
+	_, err := zhttp.Decode(r, &args)
+	if err != nil {
+		return err
+	}
+
+	v := goatcounter.NewValidate(r.Context())
+	args.Settings.ValidateIgnore(&v)
+	if v.HasErrors() {
+		return zhttp.String(w, v.Error())
+	}
+
+	if reason := args.Settings.Ignored(args.IP, args.UserAgent, args.Path); reason != "" {
+		return zhttp.String(w, reason)
+	}
+	return zhttp.String(w, T(r.Context(), "notify/not-ignored|Not ignored; this request would be counted."))
+}
+
 func (h settings) mainSave(w http.ResponseWriter, r *http.Request) error {
 	v := goatcounter.NewValidate(r.Context())
 
@@ -319,6 +377,7 @@ func (h settings) sitesAdd(w http.ResponseWriter, r *http.Request) error {
 		zhttp.FlashError(w, err.Error())
 		return zhttp.SeeOther(w, "/settings/sites")
 	}
+	goatcounter.SendWebhook(goatcounter.WithSite(r.Context(), account), goatcounter.WebhookSiteCreated, newSite)
 
 	zhttp.Flash(w, T(r.Context(), "notify/site-added|Site ‘%(url)’ added.", newSite.URL(r.Context())))
 	return zhttp.SeeOther(w, "/settings/sites")
@@ -523,11 +582,19 @@ func (h settings) export(verr *zvalidate.Validator) zhttp.HandlerFunc {
 			return err
 		}
 
+		var scheds goatcounter.ExportSchedules
+		err = scheds.List(r.Context())
+		if err != nil {
+			return err
+		}
+
 		return zhttp.Template(w, "settings_export.gohtml", struct {
 			Globals
-			Validate *zvalidate.Validator
-			Exports  goatcounter.Exports
-		}{newGlobals(w, r), verr, exports})
+			Validate  *zvalidate.Validator
+			Exports   goatcounter.Exports
+			Schedules goatcounter.ExportSchedules
+			ExportDir string
+		}{newGlobals(w, r), verr, exports, scheds, goatcounter.Config(r.Context()).ExportDir})
 	}
 }
 
@@ -563,7 +630,7 @@ func (h settings) exportDownload(w http.ResponseWriter, r *http.Request) error {
 		return err
 	}
 
-	w.Header().Set("Content-Type", "application/gzip")
+	w.Header().Set("Content-Type", export.ContentType())
 	return zhttp.Stream(w, fp)
 }
 
@@ -580,6 +647,7 @@ func (h settings) exportImport(w http.ResponseWriter, r *http.Request) error {
 	}
 	defer file.Close()
 
+	format := goatcounter.ExportFormatFromName(head.Filename)
 	var fp io.ReadCloser = file
 	if strings.HasSuffix(head.Filename, ".gz") {
 		fp, err = gzip.NewReader(file)
@@ -591,9 +659,25 @@ func (h settings) exportImport(w http.ResponseWriter, r *http.Request) error {
 
 	user := User(r.Context())
 	ctx := goatcounter.CopyContextValues(r.Context())
+
+	if format == goatcounter.ExportJSONL {
+		buf := bufio.NewReader(fp)
+		if goatcounter.IsAggregatesExport(buf) {
+			bgrun.RunFunction(fmt.Sprintf("import:%d", Site(ctx).ID), func() {
+				err := goatcounter.ImportAggregates(ctx, buf, replace, true)
+				if err != nil {
+					importErrorEmail(ctx, user, err)
+				}
+			})
+			zhttp.Flash(w, T(r.Context(), "notify/import-started-in-background|Import started in the background; you’ll get an email when it’s done."))
+			return zhttp.SeeOther(w, "/settings/export")
+		}
+		fp = io.NopCloser(buf)
+	}
+
 	n := 0
 	bgrun.RunFunction(fmt.Sprintf("import:%d", Site(ctx).ID), func() {
-		firstHitAt, err := goatcounter.Import(ctx, fp, replace, true, func(hit goatcounter.Hit, final bool) {
+		firstHitAt, err := goatcounter.Import(ctx, fp, format, replace, true, func(hit goatcounter.Hit, final bool) {
 			if final {
 				return
 			}
@@ -611,17 +695,7 @@ func (h settings) exportImport(w http.ResponseWriter, r *http.Request) error {
 			}
 		})
 		if err != nil {
-			if e, ok := err.(*errors.StackErr); ok {
-				err = e.Unwrap()
-			}
-
-			sendErr := blackmail.Send("GoatCounter import error",
-				blackmail.From("GoatCounter import", goatcounter.Config(r.Context()).EmailFrom),
-				blackmail.To(user.Email),
-				blackmail.BodyMustText(goatcounter.TplEmailImportError{r.Context(), err}.Render))
-			if sendErr != nil {
-				zlog.Error(sendErr)
-			}
+			importErrorEmail(ctx, user, err)
 		}
 
 		if firstHitAt != nil && !firstHitAt.IsZero() {
@@ -636,16 +710,41 @@ func (h settings) exportImport(w http.ResponseWriter, r *http.Request) error {
 	return zhttp.SeeOther(w, "/settings/export")
 }
 
+func importErrorEmail(ctx context.Context, user *goatcounter.User, err error) {
+	if e, ok := err.(*errors.StackErr); ok {
+		err = e.Unwrap()
+	}
+
+	sendErr := blackmail.Send("GoatCounter import error",
+		blackmail.From("GoatCounter import", goatcounter.Config(ctx).EmailFrom),
+		blackmail.To(user.Email),
+		blackmail.BodyMustText(goatcounter.TplEmailImportError{ctx, err}.Render))
+	if sendErr != nil {
+		zlog.Error(sendErr)
+	}
+}
+
 func (h settings) exportStart(w http.ResponseWriter, r *http.Request) error {
 	r.ParseForm()
 
 	v := goatcounter.NewValidate(r.Context())
 	startFrom := v.Integer("startFrom", r.Form.Get("startFrom"))
+	export := goatcounter.Export{Format: r.Form.Get("format")}
+	if export.Format == goatcounter.ExportModeAggregates {
+		export.Mode, export.Format, startFrom = goatcounter.ExportModeAggregates, goatcounter.ExportJSONL, 0
+		if d := r.Form.Get("rangeStart"); d != "" {
+			t := v.Date("rangeStart", d, "2006-01-02")
+			export.RangeStart = &t
+		}
+		if d := r.Form.Get("rangeEnd"); d != "" {
+			t := v.Date("rangeEnd", d, "2006-01-02")
+			export.RangeEnd = &t
+		}
+	}
 	if v.HasErrors() {
 		return v
 	}
 
-	var export goatcounter.Export
 	fp, err := export.Create(r.Context(), startFrom)
 	if err != nil {
 		return err
@@ -815,6 +914,7 @@ func (h settings) usersAdd(w http.ResponseWriter, r *http.Request) error {
 	if err != nil {
 		return h.usersForm(&newUser, err)(w, r)
 	}
+	goatcounter.SendWebhook(goatcounter.WithSite(r.Context(), account), goatcounter.WebhookUserAdded, newUser)
 
 	ctx := goatcounter.CopyContextValues(r.Context())
 	bgrun.RunFunction(fmt.Sprintf("adduser:%d", newUser.ID), func() {
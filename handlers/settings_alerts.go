@@ -0,0 +1,73 @@
+// Copyright © Martin Tournoij – This file is part of GoatCounter and published
+// under the terms of a slightly modified EUPL v1.2 license, which can be found
+// in the LICENSE file or at https://license.goatcounter.com
+
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"zgo.at/errors"
+	"zgo.at/goatcounter/v2"
+	"zgo.at/zhttp"
+	"zgo.at/zvalidate"
+)
+
+func (h settings) alerts(verr *zvalidate.Validator) zhttp.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) error {
+		var rules goatcounter.AlertRules
+		err := rules.List(r.Context())
+		if err != nil {
+			return err
+		}
+
+		return zhttp.Template(w, "settings_alerts.gohtml", struct {
+			Globals
+			Rules    goatcounter.AlertRules
+			Validate *zvalidate.Validator
+		}{newGlobals(w, r), rules, verr})
+	}
+}
+
+func (h settings) alertsAdd(w http.ResponseWriter, r *http.Request) error {
+	var rule goatcounter.AlertRule
+	_, err := zhttp.Decode(r, &rule)
+	if err != nil {
+		return err
+	}
+
+	err = rule.Insert(r.Context())
+	if err != nil {
+		var vErr *zvalidate.Validator
+		if !errors.As(err, &vErr) {
+			return err
+		}
+		return h.alerts(vErr)(w, r)
+	}
+
+	zhttp.Flash(w, T(r.Context(), "notify/alert-added|Alert ‘%(name)’ added.", rule.Name))
+	return zhttp.SeeOther(w, "/settings/alerts")
+}
+
+func (h settings) alertsRemove(w http.ResponseWriter, r *http.Request) error {
+	v := goatcounter.NewValidate(r.Context())
+	id := v.Integer("id", chi.URLParam(r, "id"))
+	if v.HasErrors() {
+		return v
+	}
+
+	var rule goatcounter.AlertRule
+	err := rule.ByID(r.Context(), id)
+	if err != nil {
+		return err
+	}
+
+	err = rule.Delete(r.Context())
+	if err != nil {
+		return err
+	}
+
+	zhttp.Flash(w, T(r.Context(), "notify/alert-removed|Alert ‘%(name)’ removed.", rule.Name))
+	return zhttp.SeeOther(w, "/settings/alerts")
+}
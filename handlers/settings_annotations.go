@@ -0,0 +1,146 @@
+// Copyright © Martin Tournoij – This file is part of GoatCounter and published
+// under the terms of a slightly modified EUPL v1.2 license, which can be found
+// in the LICENSE file or at https://license.goatcounter.com
+
+package handlers
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"zgo.at/errors"
+	"zgo.at/goatcounter/v2"
+	"zgo.at/zhttp"
+	"zgo.at/zstd/ztime"
+	"zgo.at/zvalidate"
+)
+
+func (h settings) annotations(verr *zvalidate.Validator) zhttp.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) error {
+		var annotations goatcounter.Annotations
+		err := annotations.List(r.Context())
+		if err != nil {
+			return err
+		}
+
+		// Edit an existing annotation in the list, rather than adding a new one.
+		var (
+			edit int64
+			form = goatcounter.Annotation{At: ztime.Now()}
+		)
+		if e := r.URL.Query().Get("edit"); e != "" {
+			v := goatcounter.NewValidate(r.Context())
+			edit = v.Integer("edit", e)
+			if v.HasErrors() {
+				return v
+			}
+			err := form.ByID(r.Context(), edit)
+			if err != nil {
+				return err
+			}
+		}
+
+		return zhttp.Template(w, "settings_annotations.gohtml", struct {
+			Globals
+			Annotations goatcounter.Annotations
+			Edit        int64
+			Form        goatcounter.Annotation
+			Validate    *zvalidate.Validator
+		}{newGlobals(w, r), annotations, edit, form, verr})
+	}
+}
+
+// annotationFromForm reads the annotation from the form; the time is in the
+// user's timezone, as sent by <input type="datetime-local">.
+func annotationFromForm(r *http.Request, a *goatcounter.Annotation) error {
+	var args struct {
+		At   string `json:"at"`
+		Text string `json:"text"`
+		Path string `json:"path"`
+	}
+	_, err := zhttp.Decode(r, &args)
+	if err != nil {
+		return err
+	}
+
+	v := goatcounter.NewValidate(r.Context())
+	at := v.Date("at", args.At, "2006-01-02T15:04")
+	if v.HasErrors() {
+		return &v
+	}
+
+	a.At = time.Date(at.Year(), at.Month(), at.Day(), at.Hour(), at.Minute(), 0, 0,
+		User(r.Context()).Settings.Timezone.Loc())
+	a.Text, a.Path = args.Text, args.Path
+	return nil
+}
+
+func (h settings) annotationsAdd(w http.ResponseWriter, r *http.Request) error {
+	var a goatcounter.Annotation
+	err := annotationFromForm(r, &a)
+	if err == nil {
+		err = a.Insert(r.Context())
+	}
+	if err != nil {
+		var vErr *zvalidate.Validator
+		if !errors.As(err, &vErr) {
+			return err
+		}
+		return h.annotations(vErr)(w, r)
+	}
+
+	zhttp.Flash(w, T(r.Context(), "notify/annotation-added|Annotation ‘%(text)’ added.", a.Text))
+	return zhttp.SeeOther(w, "/settings/annotations")
+}
+
+func (h settings) annotationsUpdate(w http.ResponseWriter, r *http.Request) error {
+	v := goatcounter.NewValidate(r.Context())
+	id := v.Integer("id", chi.URLParam(r, "id"))
+	if v.HasErrors() {
+		return v
+	}
+
+	var a goatcounter.Annotation
+	err := a.ByID(r.Context(), id)
+	if err != nil {
+		return err
+	}
+
+	err = annotationFromForm(r, &a)
+	if err == nil {
+		err = a.Update(r.Context())
+	}
+	if err != nil {
+		var vErr *zvalidate.Validator
+		if !errors.As(err, &vErr) {
+			return err
+		}
+		return h.annotations(vErr)(w, r)
+	}
+
+	zhttp.Flash(w, T(r.Context(), "notify/annotation-updated|Annotation ‘%(text)’ updated.", a.Text))
+	return zhttp.SeeOther(w, "/settings/annotations")
+}
+
+func (h settings) annotationsRemove(w http.ResponseWriter, r *http.Request) error {
+	v := goatcounter.NewValidate(r.Context())
+	id := v.Integer("id", chi.URLParam(r, "id"))
+	if v.HasErrors() {
+		return v
+	}
+
+	var a goatcounter.Annotation
+	err := a.ByID(r.Context(), id)
+	if err != nil {
+		return err
+	}
+
+	err = a.Delete(r.Context())
+	if err != nil {
+		return err
+	}
+
+	zhttp.Flash(w, T(r.Context(), "notify/annotation-removed|Annotation ‘%(text)’ removed.", a.Text))
+	return zhttp.SeeOther(w, "/settings/annotations")
+}
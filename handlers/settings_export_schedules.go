@@ -0,0 +1,57 @@
+// Copyright © Martin Tournoij – This file is part of GoatCounter and published
+// under the terms of a slightly modified EUPL v1.2 license, which can be found
+// in the LICENSE file or at https://license.goatcounter.com
+
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"zgo.at/errors"
+	"zgo.at/goatcounter/v2"
+	"zgo.at/zhttp"
+	"zgo.at/zvalidate"
+)
+
+func (h settings) exportSchedulesAdd(w http.ResponseWriter, r *http.Request) error {
+	var sched goatcounter.ExportSchedule
+	_, err := zhttp.Decode(r, &sched)
+	if err != nil {
+		return err
+	}
+
+	err = sched.Insert(r.Context())
+	if err != nil {
+		var vErr *zvalidate.Validator
+		if !errors.As(err, &vErr) {
+			return err
+		}
+		return h.export(vErr)(w, r)
+	}
+
+	zhttp.Flash(w, T(r.Context(), "notify/export-schedule-added|Scheduled export to %(dest) added.", sched.Display()))
+	return zhttp.SeeOther(w, "/settings/export")
+}
+
+func (h settings) exportSchedulesRemove(w http.ResponseWriter, r *http.Request) error {
+	v := goatcounter.NewValidate(r.Context())
+	id := v.Integer("id", chi.URLParam(r, "id"))
+	if v.HasErrors() {
+		return v
+	}
+
+	var sched goatcounter.ExportSchedule
+	err := sched.ByID(r.Context(), id)
+	if err != nil {
+		return err
+	}
+
+	err = sched.Delete(r.Context())
+	if err != nil {
+		return err
+	}
+
+	zhttp.Flash(w, T(r.Context(), "notify/export-schedule-removed|Scheduled export to %(dest) removed.", sched.Display()))
+	return zhttp.SeeOther(w, "/settings/export")
+}
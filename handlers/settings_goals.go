@@ -0,0 +1,123 @@
+// Copyright © Martin Tournoij – This file is part of GoatCounter and published
+// under the terms of a slightly modified EUPL v1.2 license, which can be found
+// in the LICENSE file or at https://license.goatcounter.com
+
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"zgo.at/errors"
+	"zgo.at/goatcounter/v2"
+	"zgo.at/zhttp"
+	"zgo.at/zvalidate"
+)
+
+func (h settings) goals(verr *zvalidate.Validator) zhttp.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) error {
+		var goals goatcounter.Goals
+		err := goals.List(r.Context())
+		if err != nil {
+			return err
+		}
+		var funnels goatcounter.Funnels
+		err = funnels.List(r.Context())
+		if err != nil {
+			return err
+		}
+
+		return zhttp.Template(w, "settings_goals.gohtml", struct {
+			Globals
+			Goals    goatcounter.Goals
+			Funnels  goatcounter.Funnels
+			Validate *zvalidate.Validator
+		}{newGlobals(w, r), goals, funnels, verr})
+	}
+}
+
+func (h settings) goalsAdd(w http.ResponseWriter, r *http.Request) error {
+	var goal goatcounter.Goal
+	_, err := zhttp.Decode(r, &goal)
+	if err != nil {
+		return err
+	}
+
+	err = goal.Insert(r.Context())
+	if err != nil {
+		var vErr *zvalidate.Validator
+		if !errors.As(err, &vErr) {
+			return err
+		}
+		return h.goals(vErr)(w, r)
+	}
+
+	zhttp.Flash(w, T(r.Context(), "notify/goal-added|Goal ‘%(name)’ added.", goal.Name))
+	return zhttp.SeeOther(w, "/settings/goals")
+}
+
+func (h settings) goalsRemove(w http.ResponseWriter, r *http.Request) error {
+	v := goatcounter.NewValidate(r.Context())
+	id := v.Integer("id", chi.URLParam(r, "id"))
+	if v.HasErrors() {
+		return v
+	}
+
+	var goal goatcounter.Goal
+	err := goal.ByID(r.Context(), id)
+	if err != nil {
+		return err
+	}
+
+	err = goal.Delete(r.Context())
+	if err != nil {
+		return err
+	}
+
+	zhttp.Flash(w, T(r.Context(), "notify/goal-removed|Goal ‘%(name)’ removed.", goal.Name))
+	return zhttp.SeeOther(w, "/settings/goals")
+}
+
+func (h settings) funnelsAdd(w http.ResponseWriter, r *http.Request) error {
+	var funnel goatcounter.Funnel
+	_, err := zhttp.Decode(r, &funnel)
+	if err != nil {
+		return err
+	}
+
+	err = funnel.Insert(r.Context())
+	if err != nil {
+		var vErr *zvalidate.Validator
+		if !errors.As(err, &vErr) {
+			return err
+		}
+		v := goatcounter.NewValidate(r.Context())
+		v.Sub("funnel", "", vErr)
+		return h.goals(&v)(w, r)
+	}
+
+	zhttp.Flash(w, T(r.Context(), "notify/funnel-added|Funnel ‘%(name)’ added.", funnel.Name))
+	return zhttp.SeeOther(w, "/settings/goals")
+}
+
+func (h settings) funnelsRemove(w http.ResponseWriter, r *http.Request) error {
+	v := goatcounter.NewValidate(r.Context())
+	id := v.Integer("id", chi.URLParam(r, "id"))
+	if v.HasErrors() {
+		return v
+	}
+
+	var funnel goatcounter.Funnel
+	err := funnel.ByID(r.Context(), id)
+	if err != nil {
+		return err
+	}
+
+	err = funnel.Delete(r.Context())
+	if err != nil {
+		return err
+	}
+
+	zhttp.Flash(w, T(r.Context(), "notify/funnel-removed|Funnel ‘%(name)’ removed.", funnel.Name))
+	return zhttp.SeeOther(w, "/settings/goals")
+}
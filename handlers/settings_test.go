@@ -8,6 +8,9 @@ import (
 	"context"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
 	"testing"
 	"time"
 
@@ -15,6 +18,7 @@ import (
 	"zgo.at/goatcounter/v2"
 	"zgo.at/goatcounter/v2/gctest"
 	"zgo.at/zdb"
+	"zgo.at/zstd/ztest"
 	"zgo.at/zstd/ztype"
 )
 
@@ -54,6 +58,138 @@ func TestSettingsTpl(t *testing.T) {
 			wantCode: 200,
 			wantBody: "Are you sure you want to remove the site",
 		},
+
+		{
+			setup: func(ctx context.Context, t *testing.T) {
+				g := goatcounter.Goal{Name: "Signed up", Pattern: "/thanks*"}
+				err := g.Insert(ctx)
+				if err != nil {
+					panic(err)
+				}
+			},
+			router:   newBackend,
+			path:     "/settings/goals",
+			auth:     true,
+			wantCode: 200,
+			wantBody: "<td><code>/thanks*</code></td>",
+		},
+		{
+			setup: func(ctx context.Context, t *testing.T) {
+				f := goatcounter.Funnel{Name: "Signup", Steps: goatcounter.FunnelSteps{"/pricing", "/signup"}}
+				err := f.Insert(ctx)
+				if err != nil {
+					panic(err)
+				}
+			},
+			router:   newBackend,
+			path:     "/settings/goals",
+			auth:     true,
+			wantCode: 200,
+			wantBody: "<td><code>/pricing</code> → <code>/signup</code></td>",
+		},
+		{
+			setup: func(ctx context.Context, t *testing.T) {
+				a := goatcounter.Annotation{Text: "v2.3 deployed", Path: "/blog/*"}
+				err := a.Insert(ctx)
+				if err != nil {
+					panic(err)
+				}
+			},
+			router:   newBackend,
+			path:     "/settings/annotations?edit=1",
+			auth:     true,
+			wantCode: 200,
+			wantBody: `name="path" form="edit-annotation" value="/blog/*"`,
+		},
+		{
+			setup: func(ctx context.Context, t *testing.T) {
+				a := goatcounter.AlertRule{Name: "Blog", Kind: "path", Path: "/blog/*", Threshold: 100, Email: "a@example.com"}
+				err := a.Insert(ctx)
+				if err != nil {
+					panic(err)
+				}
+			},
+			router:   newBackend,
+			path:     "/settings/alerts",
+			auth:     true,
+			wantCode: 200,
+			wantBody: "<td>Paths matching /blog/* get more than 100 visitors in an hour</td>",
+		},
+		{
+			setup: func(ctx context.Context, t *testing.T) {
+				w := goatcounter.Webhook{URL: "https://example.com/hook", Events: goatcounter.WebhookEvents{"export.done", "user.added"}}
+				err := w.Insert(ctx)
+				if err != nil {
+					panic(err)
+				}
+			},
+			router:   newBackend,
+			path:     "/settings/webhooks",
+			auth:     true,
+			wantCode: 200,
+			wantBody: "<td><code>export.done</code>, <code>user.added</code></td>",
+		},
+		{
+			setup: func(ctx context.Context, t *testing.T) {
+				w := goatcounter.Webhook{URL: "https://example.com/hook", Events: goatcounter.WebhookEvents{"export.done"}}
+				err := w.Insert(ctx)
+				if err != nil {
+					panic(err)
+				}
+				d := goatcounter.WebhookDelivery{WebhookID: w.ID, SiteID: w.SiteID, Event: "export.done", Payload: `{"event":"export.done"}`}
+				err = d.Insert(ctx)
+				if err != nil {
+					panic(err)
+				}
+			},
+			router:   newBackend,
+			path:     "/settings/webhooks/1",
+			auth:     true,
+			wantCode: 200,
+			wantBody: "<pre>{&#34;event&#34;:&#34;export.done&#34;}</pre>",
+		},
+		{
+			setup: func(ctx context.Context, t *testing.T) {
+				e := goatcounter.ExportSchedule{Dest: "s3://key:secret@minio:9000/exports?http=1", KeepDays: 30}
+				err := e.Insert(ctx)
+				if err != nil {
+					panic(err)
+				}
+			},
+			router:   newBackend,
+			path:     "/settings/export",
+			auth:     true,
+			wantCode: 200,
+			wantBody: "<td>s3://minio:9000/exports?http=1</td>",
+		},
+		{
+			router:   newBackend,
+			path:     "/settings/main/ignore-test?ip=10.1.2.3&settings.ignore_ips=1.1.1.1,10.0.0.0/8",
+			auth:     true,
+			wantCode: 200,
+			wantBody: `ignored because "10.0.0.0/8" is in the IP ignore list`,
+		},
+		{
+			router:   newBackend,
+			path:     "/settings/main/ignore-test?path=/Admin/x&settings.ignore_paths=/blog/*%0A/admin/*",
+			auth:     true,
+			wantCode: 200,
+			wantBody: `ignored because the path matches "/admin/*" in the path ignore list`,
+		},
+		{
+			router:   newBackend,
+			path:     "/settings/main/ignore-test?path=/a&settings.ignore_user_agents=(x",
+			auth:     true,
+			wantCode: 200,
+			wantBody: `not a valid regular expression`,
+		},
+		{
+			router:   newBackend,
+			path:     "/settings/main/ignore-test?path=/a&settings.ignore_paths=/admin/*",
+			auth:     true,
+			wantCode: 200,
+			wantBody: `Not ignored`,
+		},
 	}
 
 	for _, tt := range tests {
@@ -97,6 +233,68 @@ func TestSettingsPurge(t *testing.T) {
 	}
 }
 
+func TestSettingsWebhooksAdd(t *testing.T) {
+	ctx := gctest.DB(t)
+
+	form := url.Values{"url": {"https://example.com/hook"}, "events": {"site.created", "user.added"}}.Encode()
+	r, rr := newTest(ctx, "POST", "/settings/webhooks/add", strings.NewReader(form))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	login(t, r)
+
+	newBackend(zdb.MustGetDB(ctx)).ServeHTTP(rr, r)
+	ztest.Code(t, rr, 303)
+
+	var hooks goatcounter.Webhooks
+	err := hooks.List(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(hooks) != 1 || strings.Join(hooks[0].Events, " ") != "site.created user.added" {
+		t.Errorf("wrong webhooks: %#v", hooks)
+	}
+}
+
+func TestSettingsExportSchedulesAdd(t *testing.T) {
+	tests := []handlerTest{
+		{
+			name:         "add",
+			router:       newBackend,
+			path:         "/settings/export/schedules/add",
+			body:         map[string]string{"dest": "s3://key:secret@minio:9000/exports", "period": "weekly", "keep_days": "14"},
+			method:       "POST",
+			auth:         true,
+			wantFormCode: 303,
+		},
+		{
+			name:         "local dir",
+			router:       newBackend,
+			path:         "/settings/export/schedules/add",
+			body:         map[string]string{"dest": "/var/exports", "period": "weekly"},
+			method:       "POST",
+			auth:         true,
+			wantFormCode: 200,
+			wantFormBody: "must be an s3:// URL",
+		},
+	}
+
+	for _, tt := range tests {
+		runTest(t, tt, func(t *testing.T, rr *httptest.ResponseRecorder, r *http.Request) {
+			var scheds goatcounter.ExportSchedules
+			err := scheds.List(r.Context())
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := 0
+			if rr.Code == 303 {
+				want = 1
+			}
+			if len(scheds) != want {
+				t.Errorf("wrong schedules: %#v", scheds)
+			}
+		})
+	}
+}
+
 func TestSettingsSitesAdd(t *testing.T) {
 	t.Skip()
 
@@ -306,3 +504,32 @@ func TestSettingsSitesRemove(t *testing.T) {
 		})
 	}
 }
+
+func TestSettingsExportAggregates(t *testing.T) {
+	tt := handlerTest{
+		router:       newBackend,
+		path:         "/settings/export",
+		body:         map[string]string{"format": "aggregates", "rangeStart": "2019-06-18", "rangeEnd": "2019-06-20"},
+		method:       "POST",
+		auth:         true,
+		wantFormCode: 303,
+	}
+	runTest(t, tt, func(t *testing.T, rr *httptest.ResponseRecorder, r *http.Request) {
+		bgrun.Wait("")
+
+		var exports goatcounter.Exports
+		err := exports.List(r.Context())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(exports) != 1 {
+			t.Fatalf("wrong exports: %#v", exports)
+		}
+		defer os.Remove(exports[0].Path)
+		e := exports[0]
+		if e.Mode != "aggregates" || e.Format != "jsonl" || e.RangeStart == nil ||
+			e.RangeStart.Format("2006-01-02") != "2019-06-18" || e.RangeEnd.Format("2006-01-02") != "2019-06-20" {
+			t.Errorf("wrong export: %#v", e)
+		}
+	})
+}
@@ -0,0 +1,101 @@
+// Copyright © Martin Tournoij – This file is part of GoatCounter and published
+// under the terms of a slightly modified EUPL v1.2 license, which can be found
+// in the LICENSE file or at https://license.goatcounter.com
+
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"zgo.at/errors"
+	"zgo.at/goatcounter/v2"
+	"zgo.at/zhttp"
+	"zgo.at/zvalidate"
+)
+
+func (h settings) webhooks(verr *zvalidate.Validator) zhttp.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) error {
+		var hooks goatcounter.Webhooks
+		err := hooks.List(r.Context())
+		if err != nil {
+			return err
+		}
+
+		return zhttp.Template(w, "settings_webhooks.gohtml", struct {
+			Globals
+			Webhooks goatcounter.Webhooks
+			Events   []string
+			Validate *zvalidate.Validator
+		}{newGlobals(w, r), hooks, goatcounter.WebhookEventList, verr})
+	}
+}
+
+func (h settings) webhooksAdd(w http.ResponseWriter, r *http.Request) error {
+	var hook goatcounter.Webhook
+	_, err := zhttp.Decode(r, &hook)
+	if err != nil {
+		return err
+	}
+
+	err = hook.Insert(r.Context())
+	if err != nil {
+		var vErr *zvalidate.Validator
+		if !errors.As(err, &vErr) {
+			return err
+		}
+		return h.webhooks(vErr)(w, r)
+	}
+
+	zhttp.Flash(w, T(r.Context(), "notify/webhook-added|Webhook ‘%(url)’ added.", hook.URL))
+	return zhttp.SeeOther(w, "/settings/webhooks")
+}
+
+func (h settings) webhookFind(r *http.Request) (*goatcounter.Webhook, error) {
+	v := goatcounter.NewValidate(r.Context())
+	id := v.Integer("id", chi.URLParam(r, "id"))
+	if v.HasErrors() {
+		return nil, v
+	}
+
+	var hook goatcounter.Webhook
+	err := hook.ByID(r.Context(), id)
+	if err != nil {
+		return nil, err
+	}
+	return &hook, nil
+}
+
+func (h settings) webhooksLog(w http.ResponseWriter, r *http.Request) error {
+	hook, err := h.webhookFind(r)
+	if err != nil {
+		return err
+	}
+
+	var log goatcounter.WebhookDeliveries
+	err = log.List(r.Context(), hook.ID)
+	if err != nil {
+		return err
+	}
+
+	return zhttp.Template(w, "settings_webhooks_log.gohtml", struct {
+		Globals
+		Webhook    goatcounter.Webhook
+		Deliveries goatcounter.WebhookDeliveries
+	}{newGlobals(w, r), *hook, log})
+}
+
+func (h settings) webhooksRemove(w http.ResponseWriter, r *http.Request) error {
+	hook, err := h.webhookFind(r)
+	if err != nil {
+		return err
+	}
+
+	err = hook.Delete(r.Context())
+	if err != nil {
+		return err
+	}
+
+	zhttp.Flash(w, T(r.Context(), "notify/webhook-removed|Webhook ‘%(url)’ removed.", hook.URL))
+	return zhttp.SeeOther(w, "/settings/webhooks")
+}
@@ -0,0 +1,101 @@
+// Copyright © Martin Tournoij – This file is part of GoatCounter and published
+// under the terms of a slightly modified EUPL v1.2 license, which can be found
+// in the LICENSE file or at https://license.goatcounter.com
+
+package goatcounter
+
+import (
+	"context"
+	"time"
+
+	"zgo.at/errors"
+	"zgo.at/zdb"
+	"zgo.at/zstd/zjson"
+	"zgo.at/zstd/ztime"
+)
+
+type (
+	// Heatmap is the number of visitors by day of the week and hour of the day.
+	Heatmap struct {
+		Max   int `json:"max"`   // Highest number of visitors in a single hour.
+		Total int `json:"total"` // Total number of visitors.
+
+		// Days of the week; this starts on Sunday or Monday depending on the
+		// user's settings.
+		Days []HeatmapDay `json:"days"`
+	}
+
+	HeatmapDay struct {
+		Weekday time.Weekday `json:"weekday"` // Day of the week; 0 is Sunday.
+		Name    string       `json:"name"`    // English name of the day.
+		Hourly  []int        `json:"hourly"`  // Visitors per hour.
+		Total   int          `json:"total"`   // Visitors for this day of the week.
+	}
+)
+
+// Get the heatmap for the given time period.
+//
+// The hourly statistics are converted to the user's timezone before grouping,
+// so that hours that fall on a different day locally are counted for the
+// correct day.
+func (h *Heatmap) Get(ctx context.Context, rng ztime.Range, pathFilter []int64) error {
+	var (
+		user  = MustGetUser(ctx)
+		loc   = user.Settings.Timezone.Loc()
+		first = time.Monday
+	)
+	if user.Settings.SundayStartsWeek {
+		first = time.Sunday
+	}
+
+	h.Max, h.Total = 0, 0
+	h.Days = make([]HeatmapDay, 7)
+	for i := range h.Days {
+		d := (first + time.Weekday(i)) % 7
+		h.Days[i] = HeatmapDay{Weekday: d, Name: d.String(), Hourly: make([]int, 24)}
+	}
+
+	var st []struct {
+		Day   time.Time `db:"day"`
+		Stats []byte    `db:"stats"`
+	}
+	start, end := rng.Start.UTC(), rng.End.UTC()
+	err := zdb.Select(ctx, &st, "load:hit_stats.Heatmap", zdb.P{
+		"site":   MustGetSite(ctx).ID,
+		"start":  start.Format("2006-01-02"),
+		"end":    end.Format("2006-01-02"),
+		"filter": pathFilter,
+	})
+	if err != nil {
+		return errors.Wrap(err, "Heatmap.Get")
+	}
+
+	for _, s := range st {
+		var hourly []int
+		zjson.MustUnmarshal(s.Stats, &hourly)
+
+		day := time.Date(s.Day.Year(), s.Day.Month(), s.Day.Day(), 0, 0, 0, 0, time.UTC)
+		for hour, n := range hourly {
+			if n == 0 {
+				continue
+			}
+			t := day.Add(time.Duration(hour) * time.Hour)
+			if t.Add(time.Hour).Before(start) || t.After(end) {
+				continue
+			}
+
+			t = t.In(loc)
+			d := &h.Days[(int(t.Weekday())-int(first)+7)%7]
+			d.Hourly[t.Hour()] += n
+			d.Total += n
+			h.Total += n
+		}
+	}
+
+	for _, d := range h.Days {
+		for _, n := range d.Hourly {
+			h.Max = max(h.Max, n)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,72 @@
+// Copyright © Martin Tournoij – This file is part of GoatCounter and published
+// under the terms of a slightly modified EUPL v1.2 license, which can be found
+// in the LICENSE file or at https://license.goatcounter.com
+
+package goatcounter_test
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	. "zgo.at/goatcounter/v2"
+	"zgo.at/goatcounter/v2/gctest"
+	"zgo.at/tz"
+	"zgo.at/zstd/ztime"
+)
+
+func TestHeatmap(t *testing.T) {
+	ctx := gctest.DB(t)
+
+	// Saturday and Sunday in UTC.
+	sat := time.Date(2019, 8, 31, 14, 42, 0, 0, time.UTC)
+	sun := time.Date(2019, 9, 1, 22, 10, 0, 0, time.UTC)
+	gctest.StoreHits(ctx, t, false,
+		Hit{CreatedAt: sat, FirstVisit: true},
+		Hit{CreatedAt: sat, FirstVisit: true},
+		Hit{CreatedAt: sun, FirstVisit: true},
+	)
+	rng := ztime.NewRange(sat.Add(-24 * time.Hour)).To(sun.Add(24 * time.Hour))
+
+	tests := []struct {
+		zone       *tz.Zone
+		sundayWeek bool
+		want       string
+	}{
+		{tz.UTC, false, "first=Monday Saturday:14=2 Sunday:22=1"},
+		{tz.UTC, true, "first=Sunday Sunday:22=1 Saturday:14=2"},
+		// UTC+8; Sunday 22:00 becomes Monday 06:00.
+		{tz.MustNew("", "Asia/Makassar"), false, "first=Monday Monday:6=1 Saturday:22=2"},
+	}
+
+	for _, tt := range tests {
+		t.Run("", func(t *testing.T) {
+			user := MustGetUser(ctx)
+			user.Settings.Timezone = tt.zone
+			user.Settings.SundayStartsWeek = tt.sundayWeek
+
+			var hm Heatmap
+			err := hm.Get(ctx, rng, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// Only list the first day and any non-zero hours, to keep the test
+			// readable.
+			have := fmt.Sprintf("first=%s", hm.Days[0].Weekday)
+			for _, d := range hm.Days {
+				for h, n := range d.Hourly {
+					if n > 0 {
+						have += fmt.Sprintf(" %s:%d=%d", d.Weekday, h, n)
+					}
+				}
+			}
+			if hm.Max != 2 || hm.Total != 3 {
+				t.Errorf("max=%d total=%d", hm.Max, hm.Total)
+			}
+			if have != tt.want {
+				t.Errorf("\nhave: %s\nwant: %s", have, tt.want)
+			}
+		})
+	}
+}
@@ -7,6 +7,7 @@ package goatcounter
 import (
 	"context"
 	"fmt"
+	"math"
 	"net/url"
 	"slices"
 	"strings"
@@ -37,6 +38,7 @@ type Hit struct {
 	Size  Floats     `db:"-" json:"s,omitempty"`
 	Query string     `db:"-" json:"q,omitempty"`
 	Bot   int        `db:"bot" json:"b,omitempty"`
+	Props Props      `db:"-" json:"props,omitempty"`
 
 	RefScheme       *string    `db:"ref_scheme" json:"-"`
 	UserAgentHeader string     `db:"-" json:"-"`
@@ -45,6 +47,11 @@ type Hit struct {
 	FirstVisit      zbool.Bool `db:"first_visit" json:"-"`
 	CreatedAt       time.Time  `db:"created_at" json:"-"`
 
+	// HTTP status and response time in microseconds; only set for pageviews
+	// imported from logfiles.
+	Status       int    `db:"status" json:"-"`
+	ResponseTime *int64 `db:"response_time" json:"-"`
+
 	RefURL *url.URL `db:"-" json:"-"`   // Parsed Ref
 	Random string   `db:"-" json:"rnd"` // Browser cache buster, as they don't always listen to Cache-Control
 
@@ -52,6 +59,15 @@ type Hit struct {
 	RemoteAddr    string `db:"-" json:"-"`
 	UserSessionID string `db:"-" json:"-"`
 
+	// Set for imported pageviews, to skip them if they're imported again.
+	ImportHash string `db:"-" json:"-"`
+
+	// Browser and system for imported pageviews without a User-Agent header.
+	BrowserName    string `db:"-" json:"-"`
+	BrowserVersion string `db:"-" json:"-"`
+	SystemName     string `db:"-" json:"-"`
+	SystemVersion  string `db:"-" json:"-"`
+
 	// Don't process in memstore; for merging paths.
 	noProcess bool `db:"-" json:"-"`
 }
@@ -276,13 +292,29 @@ func (h *Hit) Defaults(ctx context.Context, initial bool) error {
 
 	// Get or insert browser and system.
 	if site.Settings.Collect.Has(CollectUserAgent) {
-		ua := UserAgent{UserAgent: h.UserAgentHeader}
-		err = ua.GetOrInsert(ctx)
-		if err != nil {
-			return errors.Wrap(err, "Hit.Defaults")
+		if h.UserAgentHeader == "" && (h.BrowserName != "" || h.SystemName != "") {
+			var (
+				browser Browser
+				system  System
+			)
+			err = browser.GetOrInsert(ctx, h.BrowserName, h.BrowserVersion)
+			if err == nil {
+				err = system.GetOrInsert(ctx, h.SystemName, h.SystemVersion)
+			}
+			if err != nil {
+				return errors.Wrap(err, "Hit.Defaults")
+			}
+			h.BrowserID = browser.ID
+			h.SystemID = system.ID
+		} else {
+			ua := UserAgent{UserAgent: h.UserAgentHeader}
+			err = ua.GetOrInsert(ctx)
+			if err != nil {
+				return errors.Wrap(err, "Hit.Defaults")
+			}
+			h.BrowserID = ua.BrowserID
+			h.SystemID = ua.SystemID
 		}
-		h.BrowserID = ua.BrowserID
-		h.SystemID = ua.SystemID
 	}
 
 	return nil
@@ -311,6 +343,23 @@ func (h *Hit) Validate(ctx context.Context, initial bool) error {
 		v.Len("path", h.Path, 1, 2048)
 		v.Len("title", h.Title, 0, 1024)
 		v.Len("user_agent_header", h.UserAgentHeader, 0, 512)
+
+		if h.Status != 0 {
+			v.Range("status", int64(h.Status), 100, 599)
+		}
+		if h.ResponseTime != nil {
+			v.Range("response_time", *h.ResponseTime, 0, math.MaxInt32)
+		}
+
+		if len(h.Props) > MaxPropsPerHit {
+			v.Append("props", fmt.Sprintf("more than %d properties", MaxPropsPerHit))
+		}
+		for k, val := range h.Props {
+			v.UTF8("props", k)
+			v.UTF8("props", val)
+			v.Len("props", k, 1, 64)
+			v.Len("props", val, 0, 256)
+		}
 	} else {
 		v.Required("path_id", h.PathID)
 
@@ -387,7 +436,7 @@ func (h *Hits) Purge(ctx context.Context, pathIDs []int64) error {
 	return zdb.TX(ctx, func(ctx context.Context) error {
 		site := MustGetSite(ctx).ID
 
-		for _, t := range append(statTables, "hit_counts", "ref_counts", "hits", "paths") {
+		for _, t := range append(statTables, "prop_stats", "hit_counts", "ref_counts", "hits", "paths") {
 			err := zdb.Exec(ctx, fmt.Sprintf(query, t), site, pathIDs)
 			if err != nil {
 				return errors.Wrapf(err, "Hits.Purge %s", t)
@@ -423,7 +472,25 @@ func (h *Hits) Merge(ctx context.Context, dst int64, pathIDs []int64) error {
 		hh[i].noProcess = true
 	}
 
-	err = errors.Wrap(h.Purge(ctx, pathIDs), "Hits.Merge")
+	err = zdb.TX(ctx, func(ctx context.Context) error {
+		// Properties aren't stored on the hits, so they can't be re-created from
+		// them; move them to the new path directly.
+		conflict := `on conflict(site_id, path_id, day, name, value)`
+		if zdb.SQLDialect(ctx) == zdb.DialectPostgreSQL {
+			conflict = `on conflict on constraint "prop_stats#site_id#path_id#day#name#value"`
+		}
+		err := zdb.Exec(ctx, `/* Hits.Merge */
+			insert into prop_stats (site_id, path_id, day, name, value, count)
+				select site_id, ?, day, name, value, sum(count) from prop_stats
+				where site_id=? and path_id in (?)
+				group by site_id, day, name, value
+			`+conflict+` do update set count = prop_stats.count + excluded.count`,
+			dst, site, pathIDs)
+		if err != nil {
+			return err
+		}
+		return h.Purge(ctx, pathIDs)
+	})
 	if err != nil {
 		return errors.Wrap(err, "Hits.Merge")
 	}
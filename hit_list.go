@@ -446,7 +446,7 @@ func GetTotalCount(ctx context.Context, rng ztime.Range, pathFilter []int64, noE
 // Diff gets the difference in percentage of all paths in this HitList.
 //
 // e.g. if called with start=2020-01-20; end=2020-01-2020-01-27, then it will
-// compare this to start=2020-01-12; end=2020-01-19
+// compare this to start=2020-01-12; end=2020-01-19 if prev is zero.
 //
 // The return value is in the same order as paths.
 func (h HitLists) Diff(ctx context.Context, rng, prev ztime.Range) ([]float64, error) {
@@ -454,8 +454,10 @@ func (h HitLists) Diff(ctx context.Context, rng, prev ztime.Range) ([]float64, e
 		return nil, nil
 	}
 
-	d := -rng.End.Sub(rng.Start)
-	prev = ztime.NewRange(rng.Start.Add(d)).To(rng.End.Add(d))
+	if prev.IsZero() {
+		d := -rng.End.Sub(rng.Start)
+		prev = ztime.NewRange(rng.Start.Add(d)).To(rng.End.Add(d))
+	}
 
 	paths := make([]int64, 0, len(h))
 	for _, hh := range h {
@@ -13,6 +13,7 @@ import (
 	"zgo.at/errors"
 	"zgo.at/z18n"
 	"zgo.at/zdb"
+	"zgo.at/zstd/zint"
 	"zgo.at/zstd/ztime"
 )
 
@@ -30,6 +31,10 @@ type HitStat struct {
 	//  c   Campaign (via query parameter)
 	//  o   Other
 	RefScheme *string `db:"ref_scheme" json:"ref_scheme,omitempty"`
+
+	// Number of visitors in the period this is compared to; only set when
+	// comparing.
+	Prev *int `db:"-" json:"prev,omitempty"`
 }
 
 type HitStats struct {
@@ -37,6 +42,62 @@ type HitStats struct {
 	Stats []HitStat `json:"stats"`
 }
 
+// Periods to compare with.
+const (
+	ComparePrevious = "previous" // The period before, with the same length.
+	CompareYear     = "year"     // The same period last year.
+)
+
+// CompareRange gets the range to compare rng with.
+//
+// This returns a zero range if compare is empty or not a known value.
+func CompareRange(rng ztime.Range, compare string) ztime.Range {
+	switch compare {
+	case ComparePrevious:
+		d := rng.End.Sub(rng.Start) + time.Second
+		return ztime.NewRange(rng.Start.Add(-d)).To(rng.End.Add(-d))
+	case CompareYear:
+		return ztime.NewRange(rng.Start.AddDate(-1, 0, 0)).To(rng.End.AddDate(-1, 0, 0))
+	}
+	return ztime.Range{}
+}
+
+// HitStatsList lists stats; this is the signature of the HitStats.List*
+// methods, and used for comparing.
+type HitStatsList func(h *HitStats, ctx context.Context, rng ztime.Range, pathFilter []int64, limit, offset int) error
+
+// Maximum number of rows to get for the previous period when comparing.
+const compareLimit = 1000
+
+// Compare sets Prev on all stats to the count for the range prev.
+//
+// The same stats for the previous period are retrieved with list and matched on
+// the ID or name. Only the first 1,000 rows are retrieved, and anything beyond
+// that will be reported as 0.
+func (h *HitStats) Compare(ctx context.Context, list HitStatsList, prev ztime.Range, pathFilter []int64) error {
+	var p HitStats
+	err := list(&p, ctx, prev, pathFilter, compareLimit, 0)
+	if err != nil {
+		return errors.Wrap(err, "HitStats.Compare")
+	}
+
+	key := func(s HitStat) string {
+		if s.ID != "" {
+			return s.ID
+		}
+		return s.Name
+	}
+	counts := make(map[string]int, len(p.Stats))
+	for _, s := range p.Stats {
+		counts[key(s)] += s.Count
+	}
+	for i := range h.Stats {
+		n := counts[key(h.Stats[i])]
+		h.Stats[i].Prev = &n
+	}
+	return nil
+}
+
 func asUTCDate(u *User, t time.Time) string {
 	return t.In(u.Settings.Timezone.Location).Format("2006-01-02")
 }
@@ -355,3 +416,98 @@ func (h *HitStats) ListCampaign(ctx context.Context, campaign int64, rng ztime.R
 	}
 	return errors.Wrap(err, "HitStats.ListCampaign")
 }
+
+// ListEntries lists the pages that sessions started on for the given time
+// period.
+//
+// The entry page is the first pageview in a session in this time period; if
+// pathFilter is given then only sessions that started on one of those paths are
+// included. This requires session collection to be enabled.
+func (h *HitStats) ListEntries(ctx context.Context, rng ztime.Range, pathFilter []int64, limit, offset int) error {
+	return errors.Wrap(h.listEntryExit(ctx, false, rng, pathFilter, limit, offset), "HitStats.ListEntries")
+}
+
+// ListExits lists the pages that sessions ended on for the given time period.
+//
+// This is the same as ListEntries, but for the last pageview in a session.
+func (h *HitStats) ListExits(ctx context.Context, rng ztime.Range, pathFilter []int64, limit, offset int) error {
+	return errors.Wrap(h.listEntryExit(ctx, true, rng, pathFilter, limit, offset), "HitStats.ListExits")
+}
+
+func (h *HitStats) listEntryExit(ctx context.Context, exit bool, rng ztime.Range, pathFilter []int64, limit, offset int) error {
+	err := zdb.Select(ctx, &h.Stats, "load:hit_stats.ListEntryExit", zdb.P{
+		"site":      MustGetSite(ctx).ID,
+		"start":     rng.Start,
+		"end":       rng.End,
+		"filter":    pathFilter,
+		"exit":      exit,
+		"nosession": zint.Uint128{},
+		"limit":     limit + 1,
+		"offset":    offset,
+	})
+	if err != nil {
+		return err
+	}
+	if len(h.Stats) > limit {
+		h.More = true
+		h.Stats = h.Stats[:len(h.Stats)-1]
+	}
+	return nil
+}
+
+// SessionCount gets the number of sessions with at least one pageview in the
+// given time period; this is the total for ListEntries and ListExits.
+func SessionCount(ctx context.Context, rng ztime.Range) (int, error) {
+	var n int
+	err := zdb.Get(ctx, &n, `/* SessionCount */
+		select count(distinct hits.session) from hits
+		join paths on paths.path_id = hits.path_id
+		where
+			hits.site_id = :site and hits.bot = 0 and hits.session != :nosession and
+			paths.event = 0 and
+			hits.created_at >= :start and hits.created_at <= :end`,
+		zdb.P{
+			"site":      MustGetSite(ctx).ID,
+			"start":     rng.Start,
+			"end":       rng.End,
+			"nosession": zint.Uint128{},
+		})
+	return n, errors.Wrap(err, "SessionCount")
+}
+
+// ListProps lists all custom property keys for the given time period.
+func (h *HitStats) ListProps(ctx context.Context, rng ztime.Range, pathFilter []int64, limit, offset int) error {
+	user := MustGetUser(ctx)
+	err := zdb.Select(ctx, &h.Stats, "load:hit_stats.ListProps", zdb.P{
+		"site":   MustGetSite(ctx).ID,
+		"start":  asUTCDate(user, rng.Start),
+		"end":    asUTCDate(user, rng.End),
+		"filter": pathFilter,
+		"limit":  limit + 1,
+		"offset": offset,
+	})
+	if len(h.Stats) > limit {
+		h.More = true
+		h.Stats = h.Stats[:len(h.Stats)-1]
+	}
+	return errors.Wrap(err, "HitStats.ListProps")
+}
+
+// ListProp lists all values for a custom property key.
+func (h *HitStats) ListProp(ctx context.Context, name string, rng ztime.Range, pathFilter []int64, limit, offset int) error {
+	user := MustGetUser(ctx)
+	err := zdb.Select(ctx, &h.Stats, "load:hit_stats.ListProp", zdb.P{
+		"site":   MustGetSite(ctx).ID,
+		"start":  asUTCDate(user, rng.Start),
+		"end":    asUTCDate(user, rng.End),
+		"filter": pathFilter,
+		"name":   name,
+		"limit":  limit + 1,
+		"offset": offset,
+	})
+	if len(h.Stats) > limit {
+		h.More = true
+		h.Stats = h.Stats[:len(h.Stats)-1]
+	}
+	return errors.Wrap(err, "HitStats.ListProp")
+}
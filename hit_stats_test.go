@@ -12,6 +12,7 @@ import (
 	. "zgo.at/goatcounter/v2"
 	"zgo.at/goatcounter/v2/gctest"
 	"zgo.at/zdb"
+	"zgo.at/zstd/zint"
 	"zgo.at/zstd/zjson"
 	"zgo.at/zstd/ztest"
 	"zgo.at/zstd/ztime"
@@ -424,3 +425,136 @@ func TestStatsByRef(t *testing.T) {
 		t.Error(d)
 	}
 }
+
+func TestListEntryExit(t *testing.T) {
+	ctx := gctest.DB(t)
+
+	var (
+		now = time.Date(2019, 8, 31, 14, 42, 0, 0, time.UTC)
+		s1  = zint.Uint128{1, 1}
+		s2  = zint.Uint128{1, 2}
+		s3  = zint.Uint128{1, 3}
+	)
+	gctest.StoreHits(ctx, t, false,
+		Hit{CreatedAt: now, Session: s1, Path: "/a"},
+		Hit{CreatedAt: now.Add(time.Minute), Session: s1, Path: "/b"},
+		Hit{CreatedAt: now.Add(2 * time.Minute), Session: s1, Path: "/c"},
+		Hit{CreatedAt: now, Session: s2, Path: "/a"},
+		Hit{CreatedAt: now.Add(time.Minute), Session: s2, Path: "/b"},
+		Hit{CreatedAt: now.Add(2 * time.Minute), Session: s2, Path: "event", Event: true},
+		Hit{CreatedAt: now, Session: s3, Path: "/b"},
+		Hit{CreatedAt: now, Path: "/d"},
+		Hit{CreatedAt: now.Add(time.Minute), Path: "/d"},
+	)
+	// Sites that don't collect sessions store all hits with the zero session.
+	err := zdb.Exec(ctx, `update hits set session=? where path_id=(select path_id from paths where path='/d')`,
+		zint.Uint128{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rng := ztime.NewRange(now).To(now.Add(time.Hour))
+	tests := []struct {
+		exit   bool
+		filter []int64
+		want   string
+	}{
+		{false, nil, `{"more": false, "stats": [
+			{"id": "1", "name": "/a", "count": 2},
+			{"id": "2", "name": "/b", "count": 1}
+		]}`},
+		{true, nil, `{"more": false, "stats": [
+			{"id": "2", "name": "/b", "count": 2},
+			{"id": "3", "name": "/c", "count": 1}
+		]}`},
+		{false, []int64{2}, `{"more": false, "stats": [
+			{"id": "2", "name": "/b", "count": 1}
+		]}`},
+	}
+
+	for _, tt := range tests {
+		t.Run("", func(t *testing.T) {
+			var (
+				have HitStats
+				err  error
+			)
+			if tt.exit {
+				err = have.ListExits(ctx, rng, tt.filter, 10, 0)
+			} else {
+				err = have.ListEntries(ctx, rng, tt.filter, 10, 0)
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if d := ztest.Diff(zjson.MustMarshalString(have), tt.want, ztest.DiffJSON); d != "" {
+				t.Error(d)
+			}
+		})
+	}
+
+	n, err := SessionCount(ctx, rng)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 3 {
+		t.Errorf("SessionCount: %d", n)
+	}
+}
+
+func TestCompareRange(t *testing.T) {
+	rng := ztime.NewRange(time.Date(2020, 6, 8, 0, 0, 0, 0, time.UTC)).
+		To(time.Date(2020, 6, 14, 23, 59, 59, 0, time.UTC))
+
+	tests := []struct {
+		compare, want string
+	}{
+		{"", "0001-01-01 00:00:00 → 0001-01-01 00:00:00"},
+		{"unknown", "0001-01-01 00:00:00 → 0001-01-01 00:00:00"},
+		{ComparePrevious, "2020-06-01 00:00:00 → 2020-06-07 23:59:59"},
+		{CompareYear, "2019-06-08 00:00:00 → 2019-06-14 23:59:59"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.compare, func(t *testing.T) {
+			have := CompareRange(rng, tt.compare)
+			s := have.Start.Format("2006-01-02 15:04:05") + " → " + have.End.Format("2006-01-02 15:04:05")
+			if s != tt.want {
+				t.Errorf("\nhave: %s\nwant: %s", s, tt.want)
+			}
+		})
+	}
+}
+
+func TestHitStatsCompare(t *testing.T) {
+	ctx := gctest.DB(t)
+
+	now := time.Date(2019, 8, 31, 14, 42, 0, 0, time.UTC)
+	prev := now.Add(-7 * 24 * time.Hour)
+	gctest.StoreHits(ctx, t, false,
+		Hit{CreatedAt: now, Location: "NL", FirstVisit: true},
+		Hit{CreatedAt: now, Location: "NL", FirstVisit: true},
+		Hit{CreatedAt: now, Location: "NL", FirstVisit: true},
+		Hit{CreatedAt: now, Location: "ID", FirstVisit: true},
+		Hit{CreatedAt: prev, Location: "NL", FirstVisit: true},
+		Hit{CreatedAt: prev, Location: "EE", FirstVisit: true},
+	)
+
+	rng := ztime.NewRange(now.Add(-24 * time.Hour)).To(now)
+	var have HitStats
+	err := have.ListLocations(ctx, rng, nil, 10, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = have.Compare(ctx, (*HitStats).ListLocations, ztime.NewRange(prev.Add(-24*time.Hour)).To(prev), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := `{"more": false, "stats": [
+		{"id": "NL", "name": "The Netherlands", "count": 3, "prev": 1},
+		{"id": "ID", "name": "Indonesia", "count": 1, "prev": 0}
+	]}`
+	if d := ztest.Diff(zjson.MustMarshalString(have), want, ztest.DiffJSON); d != "" {
+		t.Error(d)
+	}
+}
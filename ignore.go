@@ -0,0 +1,120 @@
+// Copyright © Martin Tournoij – This file is part of GoatCounter and published
+// under the terms of a slightly modified EUPL v1.2 license, which can be found
+// in the LICENSE file or at https://license.goatcounter.com
+
+package goatcounter
+
+import (
+	"fmt"
+	"net"
+	"regexp"
+	"strings"
+	"sync"
+
+	"zgo.at/zvalidate"
+)
+
+// Compiled regexps for IgnoreUserAgents; the settings are loaded for every
+// pageview and we don't want to compile them every time.
+//
+// The settings tester can send any pattern, so the cache is cleared once it has
+// ignoreReMax entries to ensure it doesn't grow forever.
+var (
+	ignoreReMu sync.Mutex
+	ignoreRe   = make(map[string]*regexp.Regexp)
+)
+
+const ignoreReMax = 1000
+
+func ignoreRegexp(pattern string) (*regexp.Regexp, error) {
+	ignoreReMu.Lock()
+	defer ignoreReMu.Unlock()
+
+	if re, ok := ignoreRe[pattern]; ok {
+		return re, nil
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, err
+	}
+	if len(ignoreRe) >= ignoreReMax {
+		clear(ignoreRe)
+	}
+	ignoreRe[pattern] = re
+	return re, nil
+}
+
+// Ignored reports why a pageview with this IP, User-Agent header, and path
+// should be ignored, or "" if it shouldn't be ignored.
+//
+// IgnoreIPs can contain IP addresses or CIDR ranges, IgnoreUserAgents regular
+// expressions, and IgnorePaths patterns where * matches any number of
+// characters; paths are matched case-insensitive. The IP, User-Agent, and path
+// are only compared if they're not "".
+func (ss SiteSettings) Ignored(ip, userAgent, path string) string {
+	if ip != "" && len(ss.IgnoreIPs) > 0 {
+		if r := ss.ignoreIP(ip); r != "" {
+			return fmt.Sprintf("ignored because %q is in the IP ignore list", r)
+		}
+	}
+	if userAgent != "" {
+		for _, p := range ss.IgnoreUserAgents {
+			re, err := ignoreRegexp(p)
+			if err == nil && re.MatchString(userAgent) {
+				return fmt.Sprintf("ignored because the User-Agent matches %q in the User-Agent ignore list", p)
+			}
+		}
+	}
+	if path != "" {
+		lp := strings.ToLower(path)
+		for _, p := range ss.IgnorePaths {
+			if matchGlob(strings.ToLower(p), lp) {
+				return fmt.Sprintf("ignored because the path matches %q in the path ignore list", p)
+			}
+		}
+	}
+	return ""
+}
+
+// ignoreIP gets the entry in IgnoreIPs this IP matches, or "" if it doesn't
+// match anything.
+func (ss SiteSettings) ignoreIP(ip string) string {
+	addr := net.ParseIP(ip)
+	for _, r := range ss.IgnoreIPs {
+		if addr == nil { // Shouldn't happen, but compare as string just in case.
+			if r == ip {
+				return r
+			}
+			continue
+		}
+		if strings.Contains(r, "/") {
+			_, n, err := net.ParseCIDR(r)
+			if err == nil && n.Contains(addr) {
+				return r
+			}
+		} else if addr.Equal(net.ParseIP(r)) {
+			return r
+		}
+	}
+	return ""
+}
+
+// ValidateIgnore validates the ignore rules.
+func (ss SiteSettings) ValidateIgnore(v *zvalidate.Validator) {
+	for _, ip := range ss.IgnoreIPs {
+		if strings.Contains(ip, "/") {
+			if _, _, err := net.ParseCIDR(ip); err != nil {
+				v.Append("ignore_ips", fmt.Sprintf("not a valid IP range: %q", ip))
+			}
+		} else {
+			v.IP("ignore_ips", ip)
+		}
+	}
+	for _, p := range ss.IgnoreUserAgents {
+		if _, err := regexp.Compile(p); err != nil {
+			v.Append("ignore_user_agents", fmt.Sprintf("not a valid regular expression: %q: %s", p, err))
+		}
+	}
+	v.Len("ignore_user_agents", ss.IgnoreUserAgents.String(), 0, 4096)
+	v.Len("ignore_paths", ss.IgnorePaths.String(), 0, 4096)
+}
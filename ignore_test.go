@@ -0,0 +1,91 @@
+// Copyright © Martin Tournoij – This file is part of GoatCounter and published
+// under the terms of a slightly modified EUPL v1.2 license, which can be found
+// in the LICENSE file or at https://license.goatcounter.com
+
+package goatcounter_test
+
+import (
+	"strings"
+	"testing"
+
+	. "zgo.at/goatcounter/v2"
+	"zgo.at/goatcounter/v2/gctest"
+)
+
+func TestSiteSettingsIgnored(t *testing.T) {
+	ss := SiteSettings{
+		IgnoreIPs:        Strings{"1.2.3.4", "10.0.0.0/8", "2001:db8::/64", "::1"},
+		IgnoreUserAgents: Lines{"HeadlessChrome", "(?i)uptime-?robot"},
+		IgnorePaths:      Lines{"/admin/*", "*.php", "/exact"},
+	}
+
+	tests := []struct {
+		ip, ua, path string
+		want         string
+	}{
+		{"", "", "", ""},
+		{"1.2.3.4", "", "", `"1.2.3.4"`},
+		{"1.2.3.5", "", "", ""},
+		{"10.255.0.1", "", "", `"10.0.0.0/8"`},
+		{"11.0.0.1", "", "", ""},
+		{"2001:db8::42", "", "", `"2001:db8::/64"`},
+		{"2001:db8:0:1::42", "", "", ""},
+		{"0:0:0:0:0:0:0:1", "", "", `"::1"`},
+
+		{"", "Mozilla/5.0 HeadlessChrome/120.0", "", `"HeadlessChrome"`},
+		{"", "UptimeRobot/2.0", "", `"(?i)uptime-?robot"`},
+		{"", "Mozilla/5.0 Firefox/120.0", "", ""},
+
+		{"", "", "/admin/users", `"/admin/*"`},
+		{"", "", "/ADMIN/users", `"/admin/*"`},
+		{"", "", "/admin", ""},
+		{"", "", "/wp-login.php", `"*.php"`},
+		{"", "", "/exact", `"/exact"`},
+		{"", "", "/exact/not", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run("", func(t *testing.T) {
+			have := ss.Ignored(tt.ip, tt.ua, tt.path)
+			if tt.want == "" {
+				if have != "" {
+					t.Errorf("%q %q %q: %s", tt.ip, tt.ua, tt.path, have)
+				}
+				return
+			}
+			if !strings.Contains(have, tt.want) {
+				t.Errorf("%q %q %q\nhave: %s\nwant: %s", tt.ip, tt.ua, tt.path, have, tt.want)
+			}
+		})
+	}
+}
+
+func TestSiteSettingsValidateIgnore(t *testing.T) {
+	ctx := gctest.DB(t)
+
+	tests := []struct {
+		ss   SiteSettings
+		want string
+	}{
+		{SiteSettings{IgnoreIPs: Strings{"1.2.3.4", "10.0.0.0/8", "::1"}}, ""},
+		{SiteSettings{IgnoreIPs: Strings{"1.2.3"}}, "ignore_ips"},
+		{SiteSettings{IgnoreIPs: Strings{"10.0.0.0/33"}}, "not a valid IP range"},
+		{SiteSettings{IgnoreUserAgents: Lines{"(x"}}, "not a valid regular expression"},
+	}
+
+	for _, tt := range tests {
+		t.Run("", func(t *testing.T) {
+			tt.ss.Defaults(ctx)
+			err := tt.ss.Validate(ctx)
+			if tt.want == "" {
+				if err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("have: %v\nwant: %s", err, tt.want)
+			}
+		})
+	}
+}
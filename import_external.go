@@ -0,0 +1,549 @@
+// Copyright © Martin Tournoij – This file is part of GoatCounter and published
+// under the terms of a slightly modified EUPL v1.2 license, which can be found
+// in the LICENSE file or at https://license.goatcounter.com
+
+package goatcounter
+
+import (
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/csv"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"zgo.at/errors"
+	"zgo.at/json"
+	"zgo.at/zstd/zbool"
+	"zgo.at/zstd/zint"
+	"zgo.at/zstd/zjson"
+)
+
+// Import formats for exports from other analytics services.
+const (
+	ImportPlausible = "plausible" // Plausible CSV export; zip file or a single CSV file.
+	ImportMatomo    = "matomo"    // Matomo visits log (Live.getLastVisitsDetails) as JSON.
+	ImportGA4       = "ga4"       // Google Analytics 4 BigQuery export as JSON.
+)
+
+var ImportExternalFormats = []string{ImportPlausible, ImportMatomo, ImportGA4}
+
+// ImportExternal reads an export from another analytics service.
+//
+// Matomo and Google Analytics export individual pageviews, and hit() is called
+// for every pageview; these have ImportHash set so that importing the same file
+// twice is safe. Plausible only exports daily totals, and agg() is called for
+// every row for the stats tables instead.
+//
+// This doesn't need a database; InitGeoDB() must be called for ImportGA4, as
+// that uses country names rather than codes.
+func ImportExternal(fp io.Reader, format string, hit func(Hit) error, agg func(ExportAggregate) error) error {
+	var err error
+	switch format {
+	case ImportPlausible:
+		err = importPlausible(fp, agg)
+	case ImportMatomo:
+		err = importMatomo(fp, hit)
+	case ImportGA4:
+		err = importGA4(fp, hit)
+	default:
+		err = fmt.Errorf("unknown format: %q", format)
+	}
+	return errors.Wrap(err, "goatcounter.ImportExternal")
+}
+
+// externalSession gets a session for a visit or session ID from the export.
+func externalSession(format, id string) zint.Uint128 {
+	h := sha256.Sum256([]byte(format + "\x00" + id))
+	s, _ := zint.NewUint128(h[:16])
+	return s
+}
+
+func externalHash(parts ...string) string {
+	h := sha256.New()
+	for _, p := range parts {
+		h.Write([]byte(p))
+		h.Write([]byte{0})
+	}
+	return hex.EncodeToString(h.Sum(nil)[:16])
+}
+
+// splitURL gets the host, path, and query from an URL.
+func splitURL(u string) (string, string, string) {
+	p, err := url.Parse(u)
+	if err != nil {
+		return "", u, ""
+	}
+	if p.Path == "" {
+		p.Path = "/"
+	}
+	return p.Host, p.Path, p.RawQuery
+}
+
+// notSet returns an empty string for placeholder values used for unknown
+// values.
+func notSet(s string) string {
+	switch strings.ToLower(s) {
+	case "(not set)", "unknown", "unk", "xx":
+		return ""
+	}
+	return s
+}
+
+// readJSON calls f for every value in fp, which can be a JSON array or a stream
+// of JSON values such as JSON Lines.
+func readJSON(fp io.Reader, f func(json.RawMessage) error) error {
+	r := bufio.NewReader(fp)
+	for {
+		b, err := r.Peek(1)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if b[0] != ' ' && b[0] != '\t' && b[0] != '\r' && b[0] != '\n' {
+			break
+		}
+		r.ReadByte()
+	}
+
+	dec := json.NewDecoder(r)
+	if b, _ := r.Peek(1); b[0] == '[' {
+		_, err := dec.Token()
+		if err != nil {
+			return err
+		}
+	}
+	for dec.More() {
+		var v json.RawMessage
+		err := dec.Decode(&v)
+		if err != nil {
+			return err
+		}
+		err = f(v)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type matomoVisit struct {
+	IDVisit        json.RawMessage `json:"idVisit"`
+	CountryCode    string          `json:"countryCode"`
+	RegionCode     string          `json:"regionCode"`
+	Resolution     string          `json:"resolution"`
+	BrowserName    string          `json:"browserName"`
+	BrowserVersion string          `json:"browserVersion"`
+	SystemName     string          `json:"operatingSystemName"`
+	SystemVersion  string          `json:"operatingSystemVersion"`
+	ReferrerType   string          `json:"referrerType"`
+	ReferrerName   string          `json:"referrerName"`
+	ReferrerURL    string          `json:"referrerUrl"`
+	Actions        []struct {
+		Type          string `json:"type"`
+		URL           string `json:"url"`
+		PageTitle     string `json:"pageTitle"`
+		Timestamp     int64  `json:"timestamp"`
+		EventCategory string `json:"eventCategory"`
+		EventAction   string `json:"eventAction"`
+		EventName     string `json:"eventName"`
+	} `json:"actionDetails"`
+}
+
+func importMatomo(fp io.Reader, hit func(Hit) error) error {
+	return readJSON(fp, func(raw json.RawMessage) error {
+		var v matomoVisit
+		err := json.Unmarshal(raw, &v)
+		if err != nil {
+			return fmt.Errorf("matomo: %w", err)
+		}
+
+		var (
+			id       = strings.Trim(string(v.IDVisit), `"`)
+			session  = externalSession(ImportMatomo, id)
+			location = strings.ToUpper(notSet(v.CountryCode))
+			size     Floats
+			first    = true
+		)
+		if location != "" && notSet(v.RegionCode) != "" {
+			location += "-" + strings.ToUpper(v.RegionCode)
+		}
+		if x, y, ok := strings.Cut(v.Resolution, "x"); ok {
+			w, err1 := strconv.ParseFloat(x, 64)
+			h, err2 := strconv.ParseFloat(y, 64)
+			if err1 == nil && err2 == nil {
+				size = Floats{w, h, 1}
+			}
+		}
+
+		for i, a := range v.Actions {
+			if a.Timestamp == 0 || (a.Type != "action" && a.Type != "event") {
+				continue
+			}
+
+			_, path, query := splitURL(a.URL)
+			h := Hit{
+				Path:           path,
+				Title:          a.PageTitle,
+				Query:          query,
+				Location:       location,
+				Size:           size,
+				CreatedAt:      time.Unix(a.Timestamp, 0).UTC(),
+				Session:        session,
+				FirstVisit:     zbool.Bool(first),
+				BrowserName:    notSet(v.BrowserName),
+				BrowserVersion: notSet(v.BrowserVersion),
+				SystemName:     notSet(v.SystemName),
+				SystemVersion:  notSet(v.SystemVersion),
+				ImportHash:     externalHash(ImportMatomo, id, strconv.Itoa(i)),
+			}
+			if a.Type == "event" {
+				h.Event = true
+				h.Path = strings.Trim(a.EventCategory+"/"+a.EventAction, "/")
+				h.Title = a.EventName
+				if h.Path == "" {
+					continue
+				}
+			}
+
+			// Only the first pageview in the visit has the referrer.
+			if first && v.ReferrerType != "direct" {
+				h.Ref = v.ReferrerURL
+				switch {
+				case v.ReferrerType == "campaign":
+					h.Query = url.Values{"utm_campaign": {v.ReferrerName}}.Encode()
+				case h.Ref == "":
+					h.Ref = v.ReferrerName
+				}
+			}
+			first = false
+
+			err := hit(h)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+type ga4Event struct {
+	EventName      string          `json:"event_name"`
+	EventTimestamp json.RawMessage `json:"event_timestamp"` // Microseconds.
+	UserPseudoID   string          `json:"user_pseudo_id"`
+	EventParams    []struct {
+		Key   string `json:"key"`
+		Value struct {
+			StringValue *string         `json:"string_value"`
+			IntValue    json.RawMessage `json:"int_value"`
+		} `json:"value"`
+	} `json:"event_params"`
+	Device struct {
+		OperatingSystem        string `json:"operating_system"`
+		OperatingSystemVersion string `json:"operating_system_version"`
+		WebInfo                struct {
+			Browser        string `json:"browser"`
+			BrowserVersion string `json:"browser_version"`
+		} `json:"web_info"`
+	} `json:"device"`
+	Geo struct {
+		Country string `json:"country"`
+	} `json:"geo"`
+}
+
+func (e ga4Event) param(key string) string {
+	for _, p := range e.EventParams {
+		if p.Key != key {
+			continue
+		}
+		if p.Value.StringValue != nil {
+			return *p.Value.StringValue
+		}
+		if len(p.Value.IntValue) > 0 && string(p.Value.IntValue) != "null" {
+			return strings.Trim(string(p.Value.IntValue), `"`)
+		}
+	}
+	return ""
+}
+
+func importGA4(fp io.Reader, hit func(Hit) error) error {
+	var (
+		seen     = make(map[string]int)
+		sessions = make(map[zint.Uint128]struct{})
+	)
+	return readJSON(fp, func(raw json.RawMessage) error {
+		var e ga4Event
+		err := json.Unmarshal(raw, &e)
+		if err != nil {
+			return fmt.Errorf("ga4: %w", err)
+		}
+		if e.EventName != "page_view" {
+			return nil
+		}
+
+		ts, err := strconv.ParseInt(strings.Trim(string(e.EventTimestamp), `"`), 10, 64)
+		if err != nil {
+			return fmt.Errorf("ga4: event_timestamp: %w", err)
+		}
+
+		host, path, query := splitURL(e.param("page_location"))
+		h := Hit{
+			Path:           path,
+			Title:          e.param("page_title"),
+			Query:          query,
+			Location:       CountryCode(notSet(e.Geo.Country)),
+			CreatedAt:      time.UnixMicro(ts).UTC(),
+			Session:        externalSession(ImportGA4, e.UserPseudoID+"."+e.param("ga_session_id")),
+			BrowserName:    notSet(e.Device.WebInfo.Browser),
+			BrowserVersion: notSet(e.Device.WebInfo.BrowserVersion),
+			SystemName:     notSet(e.Device.OperatingSystem),
+			SystemVersion: notSet(strings.TrimPrefix(e.Device.OperatingSystemVersion,
+				e.Device.OperatingSystem+" ")),
+		}
+
+		// The referrer is also set for navigation inside the site.
+		if ref := e.param("page_referrer"); ref != "" {
+			if refHost, _, _ := splitURL(ref); refHost != host {
+				h.Ref = ref
+			}
+		}
+
+		// GA sets "entrances" on the first pageview in a session; this isn't
+		// always present, so fall back to the first one we see.
+		if ent := e.param("entrances"); ent != "" {
+			h.FirstVisit = ent == "1"
+		} else {
+			_, ok := sessions[h.Session]
+			h.FirstVisit = zbool.Bool(!ok)
+		}
+		sessions[h.Session] = struct{}{}
+
+		k := externalHash(ImportGA4, string(raw))
+		h.ImportHash = externalHash(k, strconv.Itoa(seen[k]))
+		seen[k]++
+
+		return hit(h)
+	})
+}
+
+// Plausible only has statistics for the entire site rather than per path for
+// anything other than pages, so store those on a separate event.
+const plausiblePath = "plausible-import"
+
+// plausibleSum sums all rows from a Plausible export, as there can be several
+// rows for the same path and day (e.g. for different hostnames).
+type plausibleSum map[string]*ExportAggregate
+
+func (s plausibleSum) add(row ExportAggregate) {
+	k := strings.Join([]string{row.Table, row.Path, strconv.FormatBool(bool(row.Event)),
+		row.Hour, row.Day, row.Ref, row.RefScheme, row.Name, row.Version,
+		row.Location, row.Campaign}, "\x00")
+	if r, ok := s[k]; ok {
+		r.Count += row.Count
+		return
+	}
+	s[k] = &row
+}
+
+func (s plausibleSum) read(name string, fp io.Reader) error {
+	c := csv.NewReader(fp)
+	header, err := c.Read()
+	if err == io.EOF {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("plausible: %s: %w", name, err)
+	}
+	cols := make(map[string]int)
+	for i, h := range header {
+		cols[strings.TrimSpace(h)] = i
+	}
+	has := func(names ...string) bool {
+		for _, n := range names {
+			if _, ok := cols[n]; !ok {
+				return false
+			}
+		}
+		return true
+	}
+
+	var table string
+	switch {
+	case has("date", "page", "visitors"):
+		table = "pages"
+	case has("date", "name", "visitors", "events"):
+		table = "custom_events"
+	case has("date", "source", "visitors"):
+		table = "sources"
+	case has("date", "browser", "visitors"):
+		table = "browsers"
+	case has("date", "operating_system", "visitors"):
+		table = "operating_systems"
+	case has("date", "country", "visitors"):
+		table = "locations"
+	default:
+		return nil // Not something we can import, such as imported_devices.
+	}
+
+	for {
+		line, err := c.Read()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("plausible: %s: %w", name, err)
+		}
+		lineNo, _ := c.FieldPos(0)
+
+		get := func(col string) string {
+			i, ok := cols[col]
+			if !ok || i >= len(line) {
+				return ""
+			}
+			return notSet(strings.TrimSpace(line[i]))
+		}
+		day, err := time.Parse("2006-01-02", get("date"))
+		if err != nil {
+			return fmt.Errorf("plausible: %s line %d: %w", name, lineNo, err)
+		}
+		num := func(col string) (int, error) {
+			n, err := strconv.Atoi(get(col))
+			if err != nil {
+				return 0, fmt.Errorf("plausible: %s line %d: %w", name, lineNo, err)
+			}
+			return n, nil
+		}
+		// Prefer the number of visits, which is closest to how we count
+		// things, but older exports only have the number of visitors.
+		col := "visitors"
+		if has("visits") {
+			col = "visits"
+		}
+		count, err := num(col)
+		if err != nil {
+			return err
+		}
+		visitors, err := num("visitors")
+		if err != nil {
+			return err
+		}
+
+		var (
+			d    = day.Format("2006-01-02")
+			hour = day.Format(time.RFC3339)
+		)
+		switch table {
+		case "pages":
+			_, path, _ := splitURL(get("page"))
+			if has("pageviews") {
+				if count, err = num("pageviews"); err != nil {
+					return err
+				}
+			}
+			s.add(ExportAggregate{Table: "hit_counts", Path: path, Hour: hour, Count: count})
+			s.add(ExportAggregate{Table: "hit_stats", Path: path, Day: d, Count: visitors})
+		case "custom_events":
+			if count, err = num("events"); err != nil {
+				return err
+			}
+			s.add(ExportAggregate{Table: "hit_counts", Path: get("name"), Event: true, Hour: hour, Count: count})
+			s.add(ExportAggregate{Table: "hit_stats", Path: get("name"), Event: true, Day: d, Count: visitors})
+		case "sources":
+			if ref := get("referrer"); ref != "" {
+				ref = strings.TrimRight(strings.TrimPrefix(strings.TrimPrefix(ref, "https://"), "http://"), "/")
+				s.add(ExportAggregate{Table: "ref_counts", Path: plausiblePath, Event: true, Hour: hour,
+					Ref: ref, RefScheme: *RefSchemeHTTP, Count: count})
+			} else if src := get("source"); src != "" && src != "Direct / None" {
+				s.add(ExportAggregate{Table: "ref_counts", Path: plausiblePath, Event: true, Hour: hour,
+					Ref: src, RefScheme: *RefSchemeGenerated, Count: count})
+			}
+			if camp := get("utm_campaign"); camp != "" {
+				s.add(ExportAggregate{Table: "campaign_stats", Path: plausiblePath, Event: true, Day: d,
+					Campaign: camp, Ref: get("utm_source"), Count: count})
+			}
+		case "browsers":
+			s.add(ExportAggregate{Table: "browser_stats", Path: plausiblePath, Event: true, Day: d,
+				Name: get("browser"), Version: get("browser_version"), Count: count})
+		case "operating_systems":
+			s.add(ExportAggregate{Table: "system_stats", Path: plausiblePath, Event: true, Day: d,
+				Name: get("operating_system"), Version: get("operating_system_version"), Count: count})
+		case "locations":
+			loc := strings.ToUpper(get("country"))
+			if r := strings.ToUpper(get("region")); strings.HasPrefix(r, loc+"-") {
+				loc = r
+			}
+			s.add(ExportAggregate{Table: "location_stats", Path: plausiblePath, Event: true, Day: d,
+				Location: loc, Count: count})
+		}
+	}
+}
+
+// emit all rows, sorted by table and date. The hit_counts have the number of
+// pageviews and the hit_stats the number of visitors; Plausible only has daily
+// totals, so everything is recorded at midnight.
+func (s plausibleSum) emit(agg func(ExportAggregate) error) error {
+	keys := make([]string, 0, len(s))
+	for k := range s {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		row := *s[k]
+		if row.Table == "hit_stats" {
+			stats := make([]int, 24)
+			stats[0] = row.Count
+			row.Stats, row.Count = zjson.MustMarshal(stats), 0
+		}
+		err := agg(row)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func importPlausible(fp io.Reader, agg func(ExportAggregate) error) error {
+	data, err := io.ReadAll(fp)
+	if err != nil {
+		return err
+	}
+
+	sum := make(plausibleSum)
+	if !bytes.HasPrefix(data, []byte("PK\x03\x04")) {
+		err := sum.read("", bytes.NewReader(data))
+		if err != nil {
+			return err
+		}
+		return sum.emit(agg)
+	}
+
+	z, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return fmt.Errorf("plausible: %w", err)
+	}
+	for _, f := range z.File {
+		if !strings.HasSuffix(strings.ToLower(f.Name), ".csv") {
+			continue
+		}
+		fp, err := f.Open()
+		if err != nil {
+			return fmt.Errorf("plausible: %w", err)
+		}
+		err = sum.read(f.Name, fp)
+		fp.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return sum.emit(agg)
+}
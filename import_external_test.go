@@ -0,0 +1,198 @@
+// Copyright © Martin Tournoij – This file is part of GoatCounter and published
+// under the terms of a slightly modified EUPL v1.2 license, which can be found
+// in the LICENSE file or at https://license.goatcounter.com
+
+package goatcounter_test
+
+import (
+	"archive/zip"
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+
+	"zgo.at/errors"
+	"zgo.at/goatcounter/v2"
+	"zgo.at/goatcounter/v2/gctest"
+	"zgo.at/zdb"
+	"zgo.at/zstd/ztest"
+)
+
+func TestImportExternal(t *testing.T) {
+	hits := func(t *testing.T, format, data string) string {
+		t.Helper()
+		var (
+			b        strings.Builder
+			hashes   = make(map[string]struct{})
+			sessions = make(map[string]int)
+		)
+		err := goatcounter.ImportExternal(strings.NewReader(data), format, func(h goatcounter.Hit) error {
+			if _, ok := hashes[h.ImportHash]; ok || h.ImportHash == "" {
+				t.Errorf("wrong ImportHash: %q", h.ImportHash)
+			}
+			hashes[h.ImportHash] = struct{}{}
+			if _, ok := sessions[h.Session.String()]; !ok {
+				sessions[h.Session.String()] = len(sessions) + 1
+			}
+			fmt.Fprintf(&b, "%s %q %t %q %q %s %s %s/%s %s/%s s%d %t %s\n",
+				h.Path, h.Title, h.Event, h.Ref, h.Query, h.Location, h.Size,
+				h.BrowserName, h.BrowserVersion, h.SystemName, h.SystemVersion,
+				sessions[h.Session.String()], h.FirstVisit, h.CreatedAt.Format("2006-01-02T15:04:05Z"))
+			return nil
+		}, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return b.String()
+	}
+
+	t.Run("matomo", func(t *testing.T) {
+		have := hits(t, goatcounter.ImportMatomo, `[
+			{"idVisit": 1, "countryCode": "us", "regionCode": "TX", "resolution": "1920x1080",
+			 "browserName": "Firefox", "browserVersion": "120.0", "operatingSystemName": "Windows", "operatingSystemVersion": "10",
+			 "referrerType": "website", "referrerUrl": "https://example.com/link",
+			 "actionDetails": [
+				{"type": "action", "url": "https://example.org/a?x=y", "pageTitle": "A", "timestamp": 1560816000},
+				{"type": "download", "url": "https://example.org/file.zip", "timestamp": 1560816010},
+				{"type": "event", "eventCategory": "video", "eventAction": "play", "eventName": "Intro", "timestamp": 1560816020},
+				{"type": "action", "url": "https://example.org/b", "pageTitle": "B", "timestamp": 1560816030}
+			 ]},
+			{"idVisit": "2", "countryCode": "unk", "resolution": "unknown",
+			 "browserName": "Unknown", "operatingSystemName": "Unknown",
+			 "referrerType": "campaign", "referrerName": "spring",
+			 "actionDetails": [
+				{"type": "action", "url": "https://example.org/", "pageTitle": "Home", "timestamp": 1560902400}
+			 ]}
+		]`)
+		want := `
+/a "A" false "https://example.com/link" "x=y" US-TX 1920, 1080, 1 Firefox/120.0 Windows/10 s1 true 2019-06-18T00:00:00Z
+video/play "Intro" true "" "" US-TX 1920, 1080, 1 Firefox/120.0 Windows/10 s1 false 2019-06-18T00:00:20Z
+/b "B" false "" "" US-TX 1920, 1080, 1 Firefox/120.0 Windows/10 s1 false 2019-06-18T00:00:30Z
+/ "Home" false "" "utm_campaign=spring"   / / s2 true 2019-06-19T00:00:00Z
+`
+		if d := ztest.Diff(have, strings.TrimLeft(want, "\n")); d != "" {
+			t.Error(d)
+		}
+	})
+
+	t.Run("ga4", func(t *testing.T) {
+		goatcounter.InitGeoDB("")
+		line := func(name, ts, params string) string {
+			return `{"event_name": "` + name + `", "event_timestamp": "` + ts + `", "user_pseudo_id": "123.456",
+				"event_params": [` + params + `, {"key": "ga_session_id", "value": {"int_value": "789"}}],
+				"device": {"operating_system": "Windows", "operating_system_version": "Windows 10",
+				           "web_info": {"browser": "Chrome", "browser_version": "120.0.1"}},
+				"geo": {"country": "Netherlands"}}`
+		}
+		have := hits(t, goatcounter.ImportGA4, strings.Join([]string{
+			line("session_start", "1560816000000000", `{"key": "page_location", "value": {"string_value": "https://example.org/"}}`),
+			line("page_view", "1560816000000000", `{"key": "page_location", "value": {"string_value": "https://example.org/?utm_campaign=x"}},
+				{"key": "page_title", "value": {"string_value": "Home"}},
+				{"key": "page_referrer", "value": {"string_value": "https://example.com/"}},
+				{"key": "entrances", "value": {"int_value": 1}}`),
+			line("page_view", "1560816060000000", `{"key": "page_location", "value": {"string_value": "https://example.org/a"}},
+				{"key": "page_referrer", "value": {"string_value": "https://example.org/"}}`),
+			line("page_view", "1560816060000000", `{"key": "page_location", "value": {"string_value": "https://example.org/a"}},
+				{"key": "page_referrer", "value": {"string_value": "https://example.org/"}}`),
+		}, "\n"))
+		want := `
+/ "Home" false "https://example.com/" "utm_campaign=x" NL  Chrome/120.0.1 Windows/10 s1 true 2019-06-18T00:00:00Z
+/a "" false "" "" NL  Chrome/120.0.1 Windows/10 s1 false 2019-06-18T00:01:00Z
+/a "" false "" "" NL  Chrome/120.0.1 Windows/10 s1 false 2019-06-18T00:01:00Z
+`
+		if d := ztest.Diff(have, strings.TrimLeft(want, "\n")); d != "" {
+			t.Error(d)
+		}
+	})
+
+	t.Run("plausible", func(t *testing.T) {
+		ctx := gctest.DB(t)
+
+		buf := new(bytes.Buffer)
+		z := zip.NewWriter(buf)
+		for name, data := range map[string]string{
+			"imported_visitors_20190618_20190619.csv": "date,visitors,pageviews,bounces,visits,visit_duration\n" +
+				"2019-06-18,10,20,1,12,100\n",
+			"imported_pages_20190618_20190619.csv": "date,hostname,page,visits,visitors,pageviews,exits,time_on_page\n" +
+				"2019-06-18,example.org,/a,3,2,5,1,10\n" +
+				"2019-06-18,www.example.org,/a,1,1,1,1,10\n" +
+				"2019-06-19,example.org,/b,2,2,4,1,10\n",
+			"imported_browsers_20190618_20190619.csv": "date,browser,browser_version,visitors,visits,visit_duration,bounces,pageviews\n" +
+				"2019-06-18,Firefox,120.0,2,3,0,0,5\n" +
+				"2019-06-18,(not set),(not set),1,1,0,0,1\n",
+			"imported_sources_20190618_20190619.csv": "date,source,referrer,utm_source,utm_medium,utm_campaign,utm_content,utm_term,pageviews,visitors,visits,visit_duration,bounces\n" +
+				"2019-06-18,Direct / None,,,,,,,1,1,1,0,0\n" +
+				"2019-06-18,Hacker News,news.ycombinator.com/item,,,,,,1,1,2,0,0\n" +
+				"2019-06-18,Twitter,,twitter,social,launch,,,1,1,1,0,0\n",
+			"imported_locations_20190618_20190619.csv": "date,country,region,city,visitors,visits,visit_duration,bounces,pageviews\n" +
+				"2019-06-18,US,US-TX,0,1,1,0,0,1\n" +
+				"2019-06-18,NL,,0,1,1,0,0,1\n",
+		} {
+			w, err := z.Create(name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			w.Write([]byte(data))
+		}
+		if err := z.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		var rows goatcounter.ExportAggregates
+		err := goatcounter.ImportExternal(buf, goatcounter.ImportPlausible, nil, func(row goatcounter.ExportAggregate) error {
+			rows = append(rows, row)
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(rows) != 11 {
+			t.Errorf("wrong number of rows: %d", len(rows))
+		}
+
+		errs := errors.NewGroup(50)
+		n, err := rows.Import(ctx, errs)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if errs.Len() > 0 || n != len(rows) {
+			t.Fatalf("imported %d: %s", n, errs)
+		}
+
+		have := zdb.DumpString(ctx, `
+			select paths.path, hit_counts.hour, hit_counts.total from hit_counts join paths using (path_id)
+			order by hour, path`)
+		have += zdb.DumpString(ctx, `select stats from hit_stats order by day`)
+		have += zdb.DumpString(ctx, `
+			select browsers.name, browsers.version, browser_stats.count from browser_stats join browsers using (browser_id)
+			order by name`)
+		have += zdb.DumpString(ctx, `
+			select refs.ref, refs.ref_scheme, ref_counts.total from ref_counts join refs using (ref_id)
+			order by ref`)
+		have += zdb.DumpString(ctx, `
+			select campaigns.name, campaign_stats.ref, campaign_stats.count from campaign_stats join campaigns using (campaign_id)`)
+		have += zdb.DumpString(ctx, `select location, count from location_stats order by location`)
+
+		want := `
+			path  hour                 total
+			/a    2019-06-18 00:00:00  6
+			/b    2019-06-19 00:00:00  4
+			stats
+			[3,0,0,0,0,0,0,0,0,0,0,0,0,0,0,0,0,0,0,0,0,0,0,0]
+			[2,0,0,0,0,0,0,0,0,0,0,0,0,0,0,0,0,0,0,0,0,0,0,0]
+			name     version  count
+			                  1
+			Firefox  120.0    3
+			ref                        ref_scheme  total
+			Twitter                    g           1
+			news.ycombinator.com/item  h           2
+			name    ref      count
+			launch  twitter  1
+			location  count
+			NL        1
+			US-TX     1`
+		if d := ztest.Diff(have, want, ztest.DiffNormalizeWhitespace); d != "" {
+			t.Error(d)
+		}
+	})
+}
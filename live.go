@@ -0,0 +1,182 @@
+// Copyright © Martin Tournoij – This file is part of GoatCounter and published
+// under the terms of a slightly modified EUPL v1.2 license, which can be found
+// in the LICENSE file or at https://license.goatcounter.com
+
+package goatcounter
+
+import (
+	"slices"
+	"strings"
+	"time"
+
+	"zgo.at/zstd/zbool"
+	"zgo.at/zstd/zint"
+	"zgo.at/zstd/ztime"
+)
+
+// LivePeriod is the period in which a session is considered "live".
+const LivePeriod = 5 * time.Minute
+
+// Maximum number of recent hits to keep for every site.
+const liveMaxHits = 20
+
+type (
+	// Live is a snapshot of the visitors currently on a site.
+	Live struct {
+		Sessions int        `json:"sessions"` // Number of sessions seen in the last five minutes.
+		Pages    []LivePage `json:"pages"`    // Pages the current sessions are on.
+		Hits     []LiveHit  `json:"hits"`     // Most recent pageviews, newest first.
+	}
+
+	LivePage struct {
+		Path     string `json:"path"`
+		Sessions int    `json:"sessions"` // Number of sessions whose last pageview was this path.
+	}
+
+	LiveHit struct {
+		Path      string     `json:"path"`
+		Title     string     `json:"title"`
+		Event     zbool.Bool `json:"event"`
+		Location  string     `json:"location"`
+		CreatedAt time.Time  `json:"created_at"`
+	}
+
+	liveSite struct {
+		sessions map[zint.Uint128]liveSession
+		hits     []LiveHit
+	}
+	liveSession struct {
+		path string
+		seen time.Time
+	}
+)
+
+// addLive records the hit for the live view, and notifies any subscribers.
+func (m *ms) addLive(h Hit) {
+	if h.Bot > 0 || h.CreatedAt.Before(ztime.Now().Add(-LivePeriod)) {
+		return
+	}
+
+	m.liveMu.Lock()
+	defer m.liveMu.Unlock()
+
+	l, ok := m.live[h.Site]
+	if !ok {
+		l = &liveSite{sessions: make(map[zint.Uint128]liveSession)}
+		m.live[h.Site] = l
+	}
+	if !h.Session.IsZero() && !bool(h.Event) {
+		l.sessions[h.Session] = liveSession{path: h.Path, seen: h.CreatedAt}
+	}
+	l.hits = append(l.hits, LiveHit{
+		Path:      h.Path,
+		Title:     h.Title,
+		Event:     h.Event,
+		Location:  h.Location,
+		CreatedAt: h.CreatedAt,
+	})
+	if len(l.hits) > liveMaxHits {
+		l.hits = l.hits[len(l.hits)-liveMaxHits:]
+	}
+
+	for ch := range m.liveSubs[h.Site] {
+		select {
+		case ch <- struct{}{}:
+		default: // Already has a pending notification.
+		}
+	}
+}
+
+// Live gets a snapshot of the visitors currently on the site.
+//
+// This only includes pageviews processed by this instance, and requires
+// session collection to be enabled for the session and page counts.
+func (m *ms) Live(siteID int64) Live {
+	m.liveMu.Lock()
+	defer m.liveMu.Unlock()
+
+	live := Live{Pages: []LivePage{}, Hits: []LiveHit{}}
+	l, ok := m.live[siteID]
+	if !ok {
+		return live
+	}
+
+	cutoff := ztime.Now().Add(-LivePeriod)
+	pages := make(map[string]int)
+	for id, s := range l.sessions {
+		if s.seen.Before(cutoff) {
+			delete(l.sessions, id)
+			continue
+		}
+		live.Sessions++
+		pages[s.path]++
+	}
+	for p, n := range pages {
+		live.Pages = append(live.Pages, LivePage{Path: p, Sessions: n})
+	}
+	slices.SortFunc(live.Pages, func(a, b LivePage) int {
+		if a.Sessions != b.Sessions {
+			return b.Sessions - a.Sessions
+		}
+		return strings.Compare(a.Path, b.Path)
+	})
+
+	for i := len(l.hits) - 1; i >= 0; i-- {
+		if l.hits[i].CreatedAt.Before(cutoff) {
+			break
+		}
+		live.Hits = append(live.Hits, l.hits[i])
+	}
+	return live
+}
+
+// LiveSubscribe gets notified when there are new hits for this site.
+//
+// The channel receives a value after new hits are processed; call Live() to get
+// the new data. The returned function must be called to unsubscribe.
+func (m *ms) LiveSubscribe(siteID int64) (<-chan struct{}, func()) {
+	m.liveMu.Lock()
+	defer m.liveMu.Unlock()
+
+	ch := make(chan struct{}, 1)
+	if m.liveSubs[siteID] == nil {
+		m.liveSubs[siteID] = make(map[chan struct{}]struct{})
+	}
+	m.liveSubs[siteID][ch] = struct{}{}
+
+	return ch, func() {
+		m.liveMu.Lock()
+		defer m.liveMu.Unlock()
+		delete(m.liveSubs[siteID], ch)
+		if len(m.liveSubs[siteID]) == 0 {
+			delete(m.liveSubs, siteID)
+		}
+	}
+}
+
+// evictLive removes sites without any live sessions or recent hits.
+func (m *ms) evictLive() {
+	m.liveMu.Lock()
+	defer m.liveMu.Unlock()
+
+	cutoff := ztime.Now().Add(-LivePeriod)
+	for siteID, l := range m.live {
+		for id, s := range l.sessions {
+			if s.seen.Before(cutoff) {
+				delete(l.sessions, id)
+			}
+		}
+		if len(l.sessions) == 0 && (len(l.hits) == 0 || l.hits[len(l.hits)-1].CreatedAt.Before(cutoff)) {
+			delete(m.live, siteID)
+		}
+	}
+}
+
+// HitStats gets the pages as HitStats, for display in horizontal_chart.
+func (l Live) HitStats() HitStats {
+	h := HitStats{Stats: make([]HitStat, 0, len(l.Pages))}
+	for _, p := range l.Pages {
+		h.Stats = append(h.Stats, HitStat{Name: p.Path, Count: p.Sessions})
+	}
+	return h
+}
@@ -0,0 +1,58 @@
+// Copyright © Martin Tournoij – This file is part of GoatCounter and published
+// under the terms of a slightly modified EUPL v1.2 license, which can be found
+// in the LICENSE file or at https://license.goatcounter.com
+
+package goatcounter_test
+
+import (
+	"testing"
+	"time"
+
+	. "zgo.at/goatcounter/v2"
+	"zgo.at/goatcounter/v2/gctest"
+	"zgo.at/zstd/zint"
+	"zgo.at/zstd/zjson"
+	"zgo.at/zstd/ztest"
+	"zgo.at/zstd/ztime"
+)
+
+func TestLive(t *testing.T) {
+	ztime.SetNow(t, "2020-06-18 12:13:14")
+	ctx := gctest.DB(t)
+
+	var (
+		now = ztime.Now()
+		s1  = zint.Uint128{1, 1}
+		s2  = zint.Uint128{1, 2}
+		s3  = zint.Uint128{1, 3}
+	)
+	gctest.StoreHits(ctx, t, false,
+		Hit{CreatedAt: now.Add(-10 * time.Minute), Session: s3, Path: "/old"},
+		Hit{CreatedAt: now.Add(-2 * time.Minute), Session: s1, Path: "/a"},
+		Hit{CreatedAt: now.Add(-1 * time.Minute), Session: s1, Path: "/b"},
+		Hit{CreatedAt: now.Add(-1 * time.Minute), Session: s2, Path: "/b"},
+		Hit{CreatedAt: now, Session: s2, Path: "click", Event: true},
+	)
+
+	have := Memstore.Live(MustGetSite(ctx).ID)
+	want := `{
+		"sessions": 2,
+		"pages": [{"path": "/b", "sessions": 2}],
+		"hits": [
+			{"path": "click", "title": "", "event": true,  "location": "", "created_at": "2020-06-18T12:13:14Z"},
+			{"path": "/b",    "title": "", "event": false, "location": "", "created_at": "2020-06-18T12:12:14Z"},
+			{"path": "/b",    "title": "", "event": false, "location": "", "created_at": "2020-06-18T12:12:14Z"},
+			{"path": "/a",    "title": "", "event": false, "location": "", "created_at": "2020-06-18T12:11:14Z"}
+		]
+	}`
+	if d := ztest.Diff(zjson.MustMarshalString(have), want, ztest.DiffJSON); d != "" {
+		t.Error(d)
+	}
+
+	ztime.SetNow(t, "2020-06-18 12:20:00")
+	have = Memstore.Live(MustGetSite(ctx).ID)
+	want = `{"sessions": 0, "pages": [], "hits": []}`
+	if d := ztest.Diff(zjson.MustMarshalString(have), want, ztest.DiffJSON); d != "" {
+		t.Error(d)
+	}
+}
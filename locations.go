@@ -11,6 +11,7 @@ import (
 	"io"
 	"net"
 	"strings"
+	"sync"
 
 	"github.com/oschwald/geoip2-golang"
 	"zgo.at/errors"
@@ -204,3 +205,39 @@ func findGeoName(country, region string) (string, string) {
 	}
 	return "", ""
 }
+
+var (
+	countryCodesOnce sync.Once
+	countryCodes     map[string]string
+)
+
+// CountryCode gets the ISO-3166-1 alpha2 code for an English country name (e.g.
+// "Netherlands" → "NL"), or an empty string if it's not found.
+//
+// InitGeoDB() must be called before this.
+func CountryCode(name string) string {
+	countryCodesOnce.Do(func() {
+		countryCodes = make(map[string]string)
+		iter := geodb.DB().Data()
+		for iter.Next() {
+			var r struct {
+				Country struct {
+					ISOCode string            `maxminddb:"iso_code"`
+					Names   map[string]string `maxminddb:"names"`
+				} `maxminddb:"country"`
+			}
+			err := iter.Data(&r)
+			if err != nil {
+				zlog.Error(err)
+				return
+			}
+			if r.Country.ISOCode != "" {
+				// Also allow e.g. "Netherlands" for "The Netherlands".
+				n := strings.ToLower(r.Country.Names["en"])
+				countryCodes[n] = r.Country.ISOCode
+				countryCodes[strings.TrimPrefix(n, "the ")] = r.Country.ISOCode
+			}
+		}
+	})
+	return countryCodes[strings.ToLower(name)]
+}
@@ -0,0 +1,156 @@
+// Copyright © Martin Tournoij – This file is part of GoatCounter and published
+// under the terms of a slightly modified EUPL v1.2 license, which can be found
+// in the LICENSE file or at https://license.goatcounter.com
+
+package logscan
+
+import (
+	"bytes"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+
+	"zgo.at/json"
+)
+
+// jsonPath is a path to a value in a JSON object; every element is either a
+// string for an object key or an int for an array index.
+type jsonPath []any
+
+// processJSONFormat processes a JSON log format, which is a comma-separated
+// list of field=path, for example:
+//
+//	path=request.uri,user_agent=request.headers.User-Agent[0]
+func processJSONFormat(format string) (map[string]jsonPath, error) {
+	paths := make(map[string]jsonPath)
+	for _, f := range strings.Split(format, ",") {
+		f = strings.TrimSpace(f)
+		if f == "" {
+			continue
+		}
+		field, path, ok := strings.Cut(f, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid -format value: no path for %q", f)
+		}
+		if !slices.Contains(fields, field) || field == "ignore" {
+			return nil, fmt.Errorf("invalid -format value: unknown field %q", field)
+		}
+		p, err := parseJSONPath(path)
+		if err != nil {
+			return nil, fmt.Errorf("invalid -format value: %s: %w", field, err)
+		}
+		paths[field] = p
+	}
+	if _, ok := paths["path"]; !ok {
+		return nil, fmt.Errorf("invalid -format value: path field is required")
+	}
+	return paths, nil
+}
+
+func parseJSONPath(p string) (jsonPath, error) {
+	var path jsonPath
+	for _, part := range strings.Split(p, ".") {
+		key, rest, _ := strings.Cut(part, "[")
+		if key == "" && rest == "" {
+			return nil, fmt.Errorf("empty key in %q", p)
+		}
+		if key != "" {
+			path = append(path, key)
+		}
+		for rest != "" {
+			n, r, ok := strings.Cut(rest, "]")
+			i, err := strconv.Atoi(n)
+			if !ok || err != nil || i < 0 {
+				return nil, fmt.Errorf("invalid array index in %q", p)
+			}
+			path = append(path, i)
+
+			if r != "" && r[0] != '[' {
+				return nil, fmt.Errorf("invalid array index in %q", p)
+			}
+			rest = strings.TrimPrefix(r, "[")
+		}
+	}
+	return path, nil
+}
+
+// get the value for this path, and true if it exists. Object keys are matched
+// case-insensitive if there is no exact match, as the case of header names
+// isn't always consistent.
+func (p jsonPath) get(v any) (any, bool) {
+	for _, e := range p {
+		switch e := e.(type) {
+		case string:
+			m, ok := v.(map[string]any)
+			if !ok {
+				return nil, false
+			}
+			vv, ok := m[e]
+			if !ok {
+				for k := range m {
+					if strings.EqualFold(k, e) {
+						vv, ok = m[k], true
+						break
+					}
+				}
+			}
+			if !ok {
+				return nil, false
+			}
+			v = vv
+		case int:
+			a, ok := v.([]any)
+			if !ok || e >= len(a) {
+				return nil, false
+			}
+			v = a[e]
+		}
+	}
+	return v, true
+}
+
+// jsonLine parses a line as JSON. This returns false if the line isn't a JSON
+// object or doesn't have the path, such as other log entries mixed in with the
+// access log.
+func (s *Scanner) jsonLine(line string) (Line, bool) {
+	var obj map[string]any
+	dec := json.NewDecoder(strings.NewReader(line))
+	dec.UseNumber()
+	if err := dec.Decode(&obj); err != nil {
+		return nil, false
+	}
+
+	parsed := make(Line, len(s.json)+2)
+	parsed["_line"] = line
+	parsed["_lineno"] = strconv.FormatUint(s.lineno, 10)
+	for field, path := range s.json {
+		v, ok := path.get(obj)
+		if !ok {
+			continue
+		}
+
+		var str string
+		switch vv := v.(type) {
+		case nil:
+		case string:
+			str = vv
+		case json.Number:
+			str = vv.String()
+		case bool:
+			str = strconv.FormatBool(vv)
+		default:
+			b, _ := json.Marshal(vv)
+			str = string(bytes.TrimSpace(b))
+		}
+		if str == "-" { // Using - is common to indicate a blank value.
+			str = ""
+		}
+		parsed[field] = str
+	}
+
+	if _, ok := parsed["path"]; !ok {
+		return nil, false
+	}
+	return parsed, true
+}
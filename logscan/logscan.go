@@ -9,6 +9,7 @@ import (
 	"context"
 	"fmt"
 	"io"
+	"math"
 	"regexp"
 	"slices"
 	"strconv"
@@ -172,6 +173,33 @@ func processExcludes(exclude []string) ([]excludePattern, error) {
 	return patterns, nil
 }
 
+// JSON log formats; see processJSONFormat().
+const (
+	// Caddy's access log.
+	Caddy = `json:datetime=ts,remote_addr=request.remote_ip,host=request.host,` +
+		`method=request.method,path=request.uri,http=request.proto,status=status,` +
+		`size=size,referrer=request.headers.Referer[0],` +
+		`user_agent=request.headers.User-Agent[0],` +
+		`content_type=resp_headers.Content-Type[0],timing_sec=duration`
+
+	// Traefik's access log with format=json; the User-Agent and Referer
+	// headers are only included if fields.headers.names is set to keep them.
+	Traefik = `json:datetime=StartUTC,remote_addr=ClientHost,host=RequestHost,` +
+		`method=RequestMethod,path=RequestPath,http=RequestProtocol,` +
+		`status=DownstreamStatus,size=DownstreamContentSize,` +
+		`referrer=request_Referer,user_agent=request_User-Agent,` +
+		`content_type=downstream_Content-Type`
+
+	// Cloudflare Logpush for the HTTP requests dataset. The default
+	// timestamp format is unixnano; use -datetime=rfc3339 if it's set to
+	// rfc3339.
+	Cloudflare = `json:datetime=EdgeStartTimestamp,remote_addr=ClientIP,` +
+		`host=ClientRequestHost,method=ClientRequestMethod,path=ClientRequestURI,` +
+		`http=ClientRequestProtocol,status=EdgeResponseStatus,` +
+		`size=EdgeResponseBytes,referrer=ClientRequestReferer,` +
+		`user_agent=ClientRequestUserAgent,content_type=EdgeResponseContentType`
+)
+
 const (
 	// Combined format; used by default in Apache, nginx.
 	//
@@ -192,8 +220,20 @@ func getFormat(format, date, time, datetime string) (string, string, string, str
 	if strings.HasPrefix(format, "log:") {
 		return format[4:], date, time, datetime
 	}
+	if strings.HasPrefix(format, "json:") {
+		return format, date, time, datetime
+	}
 
 	switch strings.ToLower(format) {
+	case "caddy":
+		return Caddy, "", "", "unix_sec"
+	case "traefik":
+		return Traefik, "", "", "rfc3339nano"
+	case "cloudflare":
+		if datetime == "" {
+			datetime = "unix_nano"
+		}
+		return Cloudflare, "", "", datetime
 	case "combined":
 		return Combined, "", "", "02/Jan/2006:15:04:05 -0700"
 	case "combined-vhost":
@@ -211,6 +251,8 @@ type Scanner struct {
 	read   chan follow.Data
 	re     *regexp.Regexp
 	names  []string
+	json   map[string]jsonPath // Field → path, for JSON logs.
+	w3c    []string            // Fields from the last #Fields: header, for W3C logs.
 	lineno uint64
 
 	date, time, datetime string
@@ -257,25 +299,67 @@ func NewFollow(ctx context.Context, file, format, date, tyme, datetime string, e
 }
 
 func makeNew(format, date, tyme, datetime string, exclude []string) (*Scanner, error) {
-	re, date, tyme, datetime, err := processFormat(format, date, tyme, datetime)
+	excludePatt, err := processExcludes(exclude)
 	if err != nil {
 		return nil, err
 	}
-	excludePatt, err := processExcludes(exclude)
+
+	if strings.ToLower(format) == "cloudfront" {
+		return &Scanner{
+			w3c:      []string{},
+			datetime: "2006-01-02 15:04:05",
+			exclude:  excludePatt,
+		}, nil
+	}
+	if f, d, t, dt := getFormat(format, date, tyme, datetime); strings.HasPrefix(f, "json:") {
+		js, err := processJSONFormat(f[5:])
+		if err != nil {
+			return nil, err
+		}
+		return &Scanner{
+			json:     js,
+			date:     layout(d),
+			time:     layout(t),
+			datetime: layout(dt),
+			exclude:  excludePatt,
+		}, nil
+	}
+
+	re, date, tyme, datetime, err := processFormat(format, date, tyme, datetime)
 	if err != nil {
 		return nil, err
 	}
-
 	return &Scanner{
 		re:       re,
 		names:    re.SubexpNames(),
-		date:     date,
-		time:     tyme,
-		datetime: datetime,
+		date:     layout(date),
+		time:     layout(tyme),
+		datetime: layout(datetime),
 		exclude:  excludePatt,
 	}, nil
 }
 
+// Predefined date and time layouts, in addition to the ones from the time
+// package: unix_sec, unix_milli, and unix_nano are for Unix timestamps.
+var layouts = map[string]string{
+	"ansic":       time.ANSIC,
+	"unix":        time.UnixDate,
+	"rfc822":      time.RFC822,
+	"rfc822z":     time.RFC822Z,
+	"rfc850":      time.RFC850,
+	"rfc1123":     time.RFC1123,
+	"rfc1123z":    time.RFC1123Z,
+	"rfc3339":     time.RFC3339,
+	"rfc3339nano": time.RFC3339Nano,
+}
+
+func layout(l string) string {
+	if ll, ok := layouts[strings.ToLower(l)]; ok {
+		return ll
+	}
+	return l
+}
+
 func (s Scanner) DateFormats() (date, time, datetime string) {
 	return s.date, s.time, s.datetime
 }
@@ -295,6 +379,22 @@ start:
 		s.lineno++
 	}
 
+	if s.json != nil || s.w3c != nil {
+		var (
+			parsed Line
+			ok     bool
+		)
+		if s.json != nil {
+			parsed, ok = s.jsonLine(line)
+		} else {
+			parsed, ok = s.w3cLine(line)
+		}
+		if !ok || s.MatchExcludes(parsed) {
+			goto start
+		}
+		return parsed, nil
+	}
+
 	parsed := make(Line, len(s.names)+2)
 	parsed["_line"] = line
 	parsed["_lineno"] = strconv.FormatUint(s.lineno, 10)
@@ -376,10 +476,16 @@ func (l Line) ContentType() string   { return l["content_type"] }
 func (l Line) Status() int           { return toI(l["status"]) }
 func (l Line) Size() int             { return toI(l["size"]) }
 
+// HasTiming reports if the time to serve the request is in the line.
+func (l Line) HasTiming() bool {
+	return l["timing_sec"] != "" || l["timing_milli"] != "" || l["timing_micro"] != ""
+}
+
 func (l Line) Timing() time.Duration {
 	s, ok := l["timing_sec"]
 	if ok {
-		return time.Duration(toI(s)) * time.Second
+		n, _ := strconv.ParseFloat(s, 64)
+		return time.Duration(n * float64(time.Second))
 	}
 	s, ok = l["timing_milli"]
 	if ok {
@@ -397,18 +503,32 @@ func (l Line) Datetime(scan *Scanner) (time.Time, error) {
 
 	s, ok := l["date"]
 	if ok {
-		t, err := time.Parse(date, s)
-		return t.UTC(), err
+		return parseTime(date, s)
 	}
 	s, ok = l["time"]
 	if ok {
-		t, err := time.Parse(tyme, s)
-		return t.UTC(), err
+		return parseTime(tyme, s)
 	}
 	s, ok = l["datetime"]
 	if ok {
-		t, err := time.Parse(datetime, s)
-		return t.UTC(), err
+		return parseTime(datetime, s)
 	}
 	return time.Time{}, nil
 }
+
+func parseTime(layout, s string) (time.Time, error) {
+	switch layout {
+	case "unix_sec":
+		n, err := strconv.ParseFloat(s, 64)
+		sec, frac := math.Modf(n)
+		return time.Unix(int64(sec), int64(frac*1e9)).UTC(), err
+	case "unix_milli":
+		n, err := strconv.ParseInt(s, 10, 64)
+		return time.UnixMilli(n).UTC(), err
+	case "unix_nano":
+		n, err := strconv.ParseInt(s, 10, 64)
+		return time.Unix(0, n).UTC(), err
+	}
+	t, err := time.Parse(layout, s)
+	return t.UTC(), err
+}
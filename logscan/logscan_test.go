@@ -418,3 +418,135 @@ func TestExclude(t *testing.T) {
 		})
 	}
 }
+
+func TestJSON(t *testing.T) {
+	tests := []struct {
+		format, datetime, line string
+		want                   Line
+		wantTime               string
+	}{
+		{"caddy", "",
+			`{"level":"info","ts":1646861401.5241024,"logger":"http.log.access","msg":"handled request","request":{"remote_ip":"127.0.0.1","remote_port":"41342","proto":"HTTP/2.0","method":"GET","host":"example.com","uri":"/a?b=c","headers":{"User-Agent":["curl/7.82.0"],"Accept":["*/*"]}},"duration":0.5,"size":10900,"status":200,"resp_headers":{"Content-Type":["text/html; charset=utf-8"]}}`,
+			Line{"_lineno": "1", "datetime": "1646861401.5241024", "remote_addr": "127.0.0.1",
+				"host": "example.com", "method": "GET", "path": "/a?b=c", "http": "HTTP/2.0",
+				"status": "200", "size": "10900", "user_agent": "curl/7.82.0",
+				"content_type": "text/html; charset=utf-8", "timing_sec": "0.5"},
+			"2022-03-09 21:30:01.524102449"},
+		{"traefik", "",
+			`{"ClientAddr":"1.2.3.4:5678","ClientHost":"1.2.3.4","DownstreamContentSize":12,"DownstreamStatus":404,"RequestHost":"example.com","RequestMethod":"POST","RequestPath":"/x","RequestProtocol":"HTTP/1.1","StartUTC":"2023-05-15T00:00:54.123456789Z","request_User-Agent":"Mozilla/5.0","request_Referer":"-"}`,
+			Line{"_lineno": "1", "datetime": "2023-05-15T00:00:54.123456789Z", "remote_addr": "1.2.3.4",
+				"host": "example.com", "method": "POST", "path": "/x", "http": "HTTP/1.1",
+				"status": "404", "size": "12", "user_agent": "Mozilla/5.0", "referrer": ""},
+			"2023-05-15 00:00:54.123456789"},
+		{"cloudflare", "",
+			`{"ClientIP":"1.2.3.4","ClientRequestHost":"example.com","ClientRequestMethod":"GET","ClientRequestURI":"/a?b=c","ClientRequestProtocol":"HTTP/2","ClientRequestReferer":"https://example.org/","ClientRequestUserAgent":"Mozilla/5.0","EdgeResponseBytes":1234,"EdgeResponseContentType":"text/html","EdgeResponseStatus":200,"EdgeStartTimestamp":1684108854123456789}`,
+			Line{"_lineno": "1", "datetime": "1684108854123456789", "remote_addr": "1.2.3.4",
+				"host": "example.com", "method": "GET", "path": "/a?b=c", "http": "HTTP/2",
+				"status": "200", "size": "1234", "referrer": "https://example.org/",
+				"user_agent": "Mozilla/5.0", "content_type": "text/html"},
+			"2023-05-15 00:00:54.123456789"},
+		{"cloudflare", "rfc3339",
+			`{"ClientRequestURI":"/a","EdgeStartTimestamp":"2023-05-15T00:00:54Z"}`,
+			Line{"_lineno": "1", "datetime": "2023-05-15T00:00:54Z", "path": "/a"},
+			"2023-05-15 00:00:54"},
+		{`json:path=uri,user_agent=headers.user-agent[1],datetime=time`, "unix_milli",
+			`{"uri":"/x","time":1684108854000,"headers":{"User-Agent":["a","b"]}}`,
+			Line{"_lineno": "1", "path": "/x", "user_agent": "b", "datetime": "1684108854000"},
+			"2023-05-15 00:00:54"},
+
+		// Not JSON or no path: skipped.
+		{"caddy", "", `not json`, nil, ""},
+		{"caddy", "", `{"level":"info","msg":"server running"}`, nil, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.format, func(t *testing.T) {
+			scan, err := New(strings.NewReader(tt.line), tt.format, "", "", tt.datetime, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			data, err := scan.Line(context.Background())
+			if tt.want == nil {
+				if err != io.EOF {
+					t.Fatalf("not skipped: %v %v", data, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			delete(data, "_line")
+			if !reflect.DeepEqual(data, tt.want) {
+				t.Errorf("\nwant: %v\ngot:  %v", tt.want, data)
+			}
+
+			dt, err := data.Datetime(scan)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if have := dt.Format("2006-01-02 15:04:05.999999999"); have != tt.wantTime {
+				t.Errorf("datetime: %s", have)
+			}
+		})
+	}
+
+	t.Run("errors", func(t *testing.T) {
+		for _, tt := range [][2]string{
+			{"json:user_agent=a", "path field is required"},
+			{"json:path", "no path for"},
+			{"json:xxx=a,path=b", `unknown field "xxx"`},
+			{"json:path=a..b", "empty key"},
+			{"json:path=a[x]", "invalid array index"},
+			{"json:path=a[0]b", "invalid array index"},
+		} {
+			_, err := New(strings.NewReader(""), tt[0], "", "", "", nil)
+			if !ztest.ErrorContains(err, tt[1]) {
+				t.Errorf("%s: %v", tt[0], err)
+			}
+		}
+	})
+}
+
+func TestCloudFront(t *testing.T) {
+	in := "#Version: 1.0\n" +
+		"#Fields: date time x-edge-location sc-bytes c-ip cs-method cs(Host) cs-uri-stem sc-status cs(Referer) cs(User-Agent) cs-uri-query cs(Cookie) x-edge-result-type x-edge-request-id x-host-header cs-protocol sc-content-type time-taken\n" +
+		"2019-12-04\t21:02:31\tLAX1\t392\t192.0.2.100\tGET\td111111abcdef8.cloudfront.net\t/index.html\t200\thttps://example.org/\tMozilla/5.0%20(Windows%20NT%2010.0)\ta=b\t-\tHit\tSOX4xwn4XV6Q4rgb7XiVGOHms_BGlTAC4KyHmureZmBNrjGdRLiNIQ==\texample.com\thttps\ttext/html\t0.001\n" +
+		"not\tenough\tfields\n" +
+		"#Fields: date time cs-uri-stem cs(Host)\n" +
+		"2019-12-04\t21:02:32\t/other\td111111abcdef8.cloudfront.net\n"
+
+	scan, err := New(strings.NewReader(in), "cloudfront", "", "", "", []string{"path:/other"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := scan.Line(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	delete(data, "_line")
+	want := Line{"_lineno": "3", "datetime": "2019-12-04 21:02:31", "size": "392",
+		"remote_addr": "192.0.2.100", "method": "GET", "host": "example.com",
+		"path": "/index.html", "status": "200", "referrer": "https://example.org/",
+		"user_agent": "Mozilla/5.0 (Windows NT 10.0)", "query": "a=b",
+		"content_type": "text/html", "timing_sec": "0.001"}
+	if !reflect.DeepEqual(data, want) {
+		t.Errorf("\nwant: %v\ngot:  %v", want, data)
+	}
+	dt, err := data.Datetime(scan)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if have := dt.Format("2006-01-02 15:04:05"); have != "2019-12-04 21:02:31" {
+		t.Errorf("datetime: %s", have)
+	}
+	if data.Timing() != time.Millisecond {
+		t.Errorf("timing: %s", data.Timing())
+	}
+
+	// Excluded.
+	data, err = scan.Line(context.Background())
+	if err != io.EOF {
+		t.Fatalf("not skipped: %v %v", data, err)
+	}
+}
@@ -0,0 +1,250 @@
+// Copyright © Martin Tournoij – This file is part of GoatCounter and published
+// under the terms of a slightly modified EUPL v1.2 license, which can be found
+// in the LICENSE file or at https://license.goatcounter.com
+
+package logscan
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strconv"
+	"time"
+
+	"zgo.at/errors"
+	"zgo.at/follow"
+	"zgo.at/zlog"
+)
+
+// NewSyslog listens for syslog messages and processes the message payloads.
+//
+// The address is given as udp://host:port or tcp://host:port. Both RFC 3164
+// and RFC 5424 messages are accepted; for TCP messages can be separated by
+// newlines or use octet counting (RFC 6587).
+func NewSyslog(ctx context.Context, addr, format, date, tyme, datetime string, exclude []string) (*Scanner, error) {
+	s, err := makeNew(format, date, tyme, datetime, exclude)
+	if err != nil {
+		return nil, fmt.Errorf("logscan.NewSyslog: %w", err)
+	}
+
+	u, err := url.Parse(addr)
+	if err != nil {
+		return nil, fmt.Errorf("logscan.NewSyslog: %w", err)
+	}
+
+	data := make(chan follow.Data)
+	switch u.Scheme {
+	case "udp":
+		conn, err := net.ListenPacket("udp", u.Host)
+		if err != nil {
+			return nil, fmt.Errorf("logscan.NewSyslog: %w", err)
+		}
+		go func() {
+			<-ctx.Done()
+			conn.Close()
+		}()
+		go readUDP(conn, data)
+	case "tcp":
+		l, err := net.Listen("tcp", u.Host)
+		if err != nil {
+			return nil, fmt.Errorf("logscan.NewSyslog: %w", err)
+		}
+		go func() {
+			<-ctx.Done()
+			l.Close()
+		}()
+		go readTCP(l, data)
+	default:
+		return nil, fmt.Errorf("logscan.NewSyslog: unsupported scheme %q in %q; must be udp:// or tcp://", u.Scheme, addr)
+	}
+	s.read = data
+	return s, nil
+}
+
+func readUDP(conn net.PacketConn, data chan<- follow.Data) {
+	buf := make([]byte, 65536)
+	for {
+		n, _, err := conn.ReadFrom(buf)
+		if err != nil {
+			if !errors.Is(err, net.ErrClosed) {
+				zlog.Error(errors.Errorf("logscan.NewSyslog: %w", err))
+			}
+			data <- follow.Data{Err: io.EOF}
+			return
+		}
+		if msg, ok := parseSyslog(buf[:n]); ok {
+			data <- follow.Data{Bytes: append([]byte(nil), msg...)}
+		}
+	}
+}
+
+func readTCP(l net.Listener, data chan<- follow.Data) {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			if !errors.Is(err, net.ErrClosed) {
+				zlog.Error(errors.Errorf("logscan.NewSyslog: %w", err))
+			}
+			data <- follow.Data{Err: io.EOF}
+			return
+		}
+
+		go func() {
+			defer conn.Close()
+			r := bufio.NewReader(conn)
+			for {
+				frame, err := readFrame(r)
+				if err != nil {
+					if err != io.EOF && !errors.Is(err, net.ErrClosed) {
+						zlog.Error(errors.Errorf("logscan.NewSyslog: %s: %w", conn.RemoteAddr(), err))
+					}
+					return
+				}
+				if msg, ok := parseSyslog(frame); ok {
+					data <- follow.Data{Bytes: append([]byte(nil), msg...)}
+				}
+			}
+		}()
+	}
+}
+
+// readFrame reads one message from a TCP stream, which is either prefixed with
+// the length ("octet counting") or ends with a newline.
+func readFrame(r *bufio.Reader) ([]byte, error) {
+	b, err := r.Peek(1)
+	if err != nil {
+		return nil, err
+	}
+	if b[0] < '1' || b[0] > '9' {
+		line, err := r.ReadBytes('\n')
+		if err == io.EOF && len(line) > 0 {
+			err = nil
+		}
+		return line, err
+	}
+
+	l, err := r.ReadString(' ')
+	if err != nil {
+		return nil, err
+	}
+	n, err := strconv.Atoi(l[:len(l)-1])
+	if err != nil || n > 1024*1024 {
+		return nil, fmt.Errorf("invalid frame length %q", l)
+	}
+	frame := make([]byte, n)
+	_, err = io.ReadFull(r, frame)
+	return frame, err
+}
+
+// parseSyslog gets the message from a syslog RFC 3164 or RFC 5424 message.
+//
+// This returns false if this doesn't look like a syslog message.
+func parseSyslog(msg []byte) ([]byte, bool) {
+	msg = bytes.TrimRight(msg, "\r\n\x00")
+
+	// <PRI>
+	if len(msg) < 3 || msg[0] != '<' {
+		return nil, false
+	}
+	end := bytes.IndexByte(msg, '>')
+	if end < 2 || end > 4 {
+		return nil, false
+	}
+	if _, err := strconv.ParseUint(string(msg[1:end]), 10, 8); err != nil {
+		return nil, false
+	}
+	msg = msg[end+1:]
+
+	// RFC 5424: VERSION TIMESTAMP HOSTNAME APP-NAME PROCID MSGID SD MSG
+	if len(msg) > 2 && msg[0] >= '1' && msg[0] <= '9' && msg[1] == ' ' {
+		msg = msg[2:]
+		for i := 0; i < 5; i++ {
+			var ok bool
+			_, msg, ok = bytes.Cut(msg, []byte(" "))
+			if !ok {
+				return nil, false
+			}
+		}
+		msg, ok := skipStructuredData(msg)
+		if !ok {
+			return nil, false
+		}
+		msg = bytes.TrimPrefix(msg, []byte(" "))
+		return bytes.TrimPrefix(msg, []byte("\xef\xbb\xbf")), true // BOM
+	}
+
+	// RFC 3164: TIMESTAMP [HOSTNAME] TAG: MSG
+	//
+	// The timestamp is "Jan _2 15:04:05", but some send a RFC 3339 timestamp
+	// instead. The hostname is optional, and the tag is usually followed by a
+	// ":", but not always.
+	if len(msg) >= 16 && msg[15] == ' ' {
+		if _, err := time.Parse(time.Stamp, string(msg[:15])); err == nil {
+			msg = msg[16:]
+		}
+	} else if ts, rest, ok := bytes.Cut(msg, []byte(" ")); ok {
+		if _, err := time.Parse(time.RFC3339Nano, string(ts)); err == nil {
+			msg = rest
+		}
+	}
+	for i := 0; i < 2; i++ {
+		word, rest, ok := bytes.Cut(msg, []byte(" "))
+		if !ok {
+			break
+		}
+		if bytes.HasSuffix(word, []byte(":")) {
+			return rest, true
+		}
+		if i == 0 && isTag(word) {
+			msg = rest // Hostname
+			continue
+		}
+		break
+	}
+	return msg, true
+}
+
+// skipStructuredData skips over the RFC 5424 STRUCTURED-DATA, which is either
+// "-" or one or more [id param="value"] elements.
+func skipStructuredData(msg []byte) ([]byte, bool) {
+	if len(msg) > 0 && msg[0] == '-' {
+		return msg[1:], true
+	}
+	for len(msg) > 0 && msg[0] == '[' {
+		end, quoted := -1, false
+		for i := 1; i < len(msg) && end == -1; i++ {
+			switch {
+			case msg[i] == '\\' && quoted:
+				i++
+			case msg[i] == '"':
+				quoted = !quoted
+			case msg[i] == ']' && !quoted:
+				end = i
+			}
+		}
+		if end == -1 {
+			return nil, false
+		}
+		msg = msg[end+1:]
+	}
+	return msg, true
+}
+
+// isTag reports if this looks like a hostname or tag, rather than part of the
+// message.
+func isTag(b []byte) bool {
+	if len(b) == 0 {
+		return false
+	}
+	for _, c := range b {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' ||
+			c == '-' || c == '.' || c == '_' || c == '[' || c == ']' || c == '/') {
+			return false
+		}
+	}
+	return true
+}
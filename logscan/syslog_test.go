@@ -0,0 +1,99 @@
+// Copyright © Martin Tournoij – This file is part of GoatCounter and published
+// under the terms of a slightly modified EUPL v1.2 license, which can be found
+// in the LICENSE file or at https://license.goatcounter.com
+
+package logscan
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestParseSyslog(t *testing.T) {
+	tests := []struct {
+		in, want string
+		wantOK   bool
+	}{
+		// RFC 3164
+		{`<190>Oct 17 10:00:00 web1 nginx: 1.2.3.4 - - "GET / HTTP/1.1"`, `1.2.3.4 - - "GET / HTTP/1.1"`, true},
+		{`<190>Oct  7 10:00:00 web1 nginx: msg` + "\n", `msg`, true},
+		{`<134>Oct 17 10:00:00 haproxy[1234]: 1.2.3.4:5678 msg`, `1.2.3.4:5678 msg`, true},
+		{`<134>2026-10-17T10:00:00.123+02:00 web1 haproxy[1234]: msg`, `msg`, true},
+		{`<134>Oct 17 10:00:00 web1 msg without tag`, `msg without tag`, true},
+
+		// RFC 5424
+		{`<165>1 2026-10-17T10:00:00.003Z web1 nginx 123 - - msg`, `msg`, true},
+		{`<165>1 2026-10-17T10:00:00.003Z web1 nginx - ID47 [exampleSDID@32473 iut="3" eventSource="App\"]lication"][x@1 a="b"] ` + "\xef\xbb\xbf" + `msg`, `msg`, true},
+		{`<165>1 - - - - - -`, ``, true},
+
+		// Invalid
+		{`no pri`, ``, false},
+		{`<abc>Oct 17 10:00:00 x: y`, ``, false},
+		{`<1234567>Oct 17 10:00:00 x: y`, ``, false},
+		{`<165>1 2026-10-17T10:00:00.003Z web1`, ``, false},
+		{`<165>1 2026-10-17T10:00:00.003Z web1 nginx - - [x a="b" msg`, ``, false},
+	}
+
+	for _, tt := range tests {
+		t.Run("", func(t *testing.T) {
+			have, ok := parseSyslog([]byte(tt.in))
+			if ok != tt.wantOK || string(have) != tt.want {
+				t.Errorf("\nhave: %q %t\nwant: %q %t", have, ok, tt.want, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestNewSyslog(t *testing.T) {
+	for _, proto := range []string{"udp", "tcp"} {
+		t.Run(proto, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			// Get a free port.
+			l, err := net.Listen("tcp", "127.0.0.1:0")
+			if err != nil {
+				t.Fatal(err)
+			}
+			addr := l.Addr().String()
+			l.Close()
+
+			scan, err := NewSyslog(ctx, proto+"://"+addr, "combined", "", "", "", nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			conn, err := net.Dial(proto, addr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer conn.Close()
+
+			line := `1.2.3.4 - - [17/Oct/2026:10:00:00 +0000] "GET /path HTTP/1.1" 200 5 "-" "Mozilla/5.0"`
+			if proto == "tcp" {
+				// One with octet counting, and one with newline.
+				msg := "<190>Oct 17 10:00:00 web1 nginx: " + line
+				fmt.Fprintf(conn, "%d %s", len(msg), msg)
+				fmt.Fprintf(conn, "<190>Oct 17 10:00:00 web1 nginx: %s\n", line)
+			} else {
+				fmt.Fprintf(conn, "<190>Oct 17 10:00:00 web1 nginx: %s", line)
+				fmt.Fprintf(conn, "<190>Oct 17 10:00:00 web1 nginx: %s", line)
+			}
+
+			for i := 0; i < 2; i++ {
+				ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+				data, err := scan.Line(ctx)
+				cancel()
+				if err != nil {
+					t.Fatal(err)
+				}
+				if data.Path() != "/path" || data.RemoteAddr() != "1.2.3.4" || data.Line() != line {
+					t.Errorf("wrong data: %v", data)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,97 @@
+// Copyright © Martin Tournoij – This file is part of GoatCounter and published
+// under the terms of a slightly modified EUPL v1.2 license, which can be found
+// in the LICENSE file or at https://license.goatcounter.com
+
+package logscan
+
+import (
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// w3cFields maps the fields in the W3C extended log format as used by
+// CloudFront to our field names. The "date" and "time" fields are combined in to
+// "datetime".
+var w3cFields = map[string]string{
+	"c-ip":                "remote_addr",
+	"x-forwarded-for":     "xff",
+	"cs(host)":            "host",
+	"x-host-header":       "host",
+	"cs-method":           "method",
+	"cs-uri-stem":         "path",
+	"cs-uri-query":        "query",
+	"cs-protocol-version": "http",
+	"sc-status":           "status",
+	"sc-bytes":            "size",
+	"cs(referer)":         "referrer",
+	"cs(user-agent)":      "user_agent",
+	"sc-content-type":     "content_type",
+	"time-taken":          "timing_sec",
+}
+
+// w3cLine parses a line in the W3C extended log format.
+//
+// The fields are read from the "#Fields:" directive, which can appear more than
+// once (e.g. when files are concatenated). Other directives are skipped, as are
+// lines before the first "#Fields:" directive.
+func (s *Scanner) w3cLine(line string) (Line, bool) {
+	if strings.HasPrefix(line, "#") {
+		if f, ok := strings.CutPrefix(line, "#Fields:"); ok {
+			s.w3c = strings.Fields(f)
+		}
+		return nil, false
+	}
+	if len(s.w3c) == 0 {
+		return nil, false
+	}
+
+	values := strings.Split(line, "\t")
+	if len(values) != len(s.w3c) {
+		return nil, false
+	}
+
+	parsed := make(Line, len(values)+2)
+	parsed["_line"] = line
+	parsed["_lineno"] = strconv.FormatUint(s.lineno, 10)
+	var date, tyme string
+	for i, name := range s.w3c {
+		v := values[i]
+		if v == "-" {
+			v = ""
+		}
+		name = strings.ToLower(name)
+		switch name {
+		case "date":
+			date = v
+			continue
+		case "time":
+			tyme = v
+			continue
+		}
+
+		field, ok := w3cFields[name]
+		if !ok {
+			continue
+		}
+		if _, ok := parsed[field]; ok && v == "" { // Don't overwrite cs(Host) with empty x-host-header.
+			continue
+		}
+		switch field {
+		case "user_agent", "referrer":
+			// CloudFront URL-encodes spaces and some other characters; it also
+			// double-encodes % in some cases, but we don't undo that.
+			if u, err := url.PathUnescape(v); err == nil {
+				v = u
+			}
+		}
+		parsed[field] = v
+	}
+	if _, ok := parsed["path"]; !ok {
+		return nil, false
+	}
+	if date != "" && tyme != "" {
+		parsed["datetime"] = date + " " + tyme
+	}
+	return parsed, true
+}
@@ -12,6 +12,7 @@ import (
 	"errors"
 	"fmt"
 	"net/url"
+	"os"
 	"slices"
 	"strconv"
 	"strings"
@@ -58,8 +59,12 @@ func (h *hash) UnmarshalText(v []byte) error {
 }
 
 type ms struct {
-	hitMu sync.RWMutex
-	hits  []Hit
+	hitMu   sync.RWMutex
+	hits    []Hit
+	wal     *os.File // Write-ahead log for hits; may be nil.
+	walPath string
+	maxHits int
+	fails   int // Number of failed Persist() attempts in a row.
 
 	sessionMu     sync.RWMutex
 	sessions      map[hash]zint.Uint128               // Hash → sessionID
@@ -69,6 +74,12 @@ type ms struct {
 	curSalt       []byte
 	prevSalt      []byte
 	saltRotated   time.Time
+	shared        bool   // Store sessions and salts in the database.
+	db            zdb.DB // Only set with shared.
+
+	liveMu   sync.Mutex
+	live     map[int64]*liveSite                  // SiteID → live sessions and hits
+	liveSubs map[int64]map[chan struct{}]struct{} // SiteID → subscribers
 
 	testHook bool
 }
@@ -96,6 +107,10 @@ func (m *ms) Reset() {
 	m.curSalt = []byte(zcrypto.Secret256())
 	m.prevSalt = []byte(zcrypto.Secret256())
 	m.saltRotated = ztime.Now()
+	m.liveMu.Lock()
+	m.live = make(map[int64]*liveSite)
+	m.liveSubs = make(map[int64]map[chan struct{}]struct{})
+	m.liveMu.Unlock()
 	TestSeqSession = zint.Uint128{TestSession[0], TestSession[1] + 1}
 }
 
@@ -111,8 +126,18 @@ func (m *ms) Init(db zdb.DB) error {
 	defer m.hitMu.Unlock()
 
 	m.Reset()
+	err := m.openWAL()
+	if err != nil {
+		return err
+	}
+
 	m.sessionMu.Lock()
 	defer m.sessionMu.Unlock()
+	if m.shared {
+		m.db = db
+		_, err := m.loadSalt(zdb.WithDB(context.Background(), db))
+		return err
+	}
 	defer func() {
 		err := db.Exec(context.Background(), `delete from store where key='session'`)
 		if err != nil {
@@ -121,7 +146,7 @@ func (m *ms) Init(db zdb.DB) error {
 	}()
 
 	var s []byte
-	err := db.Get(context.Background(), &s, `select value from store where key='session'`)
+	err = db.Get(context.Background(), &s, `select value from store where key='session'`)
 	if err != nil {
 		if zdb.ErrNoRows(err) {
 			return nil
@@ -165,6 +190,9 @@ func (m *ms) Init(db zdb.DB) error {
 func (m *ms) StoreSessions(db zdb.DB) {
 	m.sessionMu.Lock()
 	defer m.sessionMu.Unlock()
+	if m.shared {
+		return
+	}
 
 	d, err := json.Marshal(storedSession{
 		Sessions:    m.sessions,
@@ -189,8 +217,12 @@ func (m *ms) StoreSessions(db zdb.DB) {
 
 func (m *ms) Append(hits ...Hit) {
 	m.hitMu.Lock()
+	defer m.hitMu.Unlock()
+	err := m.writeWAL(hits)
+	if err != nil {
+		zlog.Error(err)
+	}
 	m.hits = append(m.hits, hits...)
-	m.hitMu.Unlock()
 }
 
 func (m *ms) SessionsLen() int {
@@ -241,31 +273,95 @@ func (m *ms) Persist(ctx context.Context) ([]Hit, error) {
 	m.hits = make([]Hit, 0, 16)
 	m.hitMu.Unlock()
 
-	newHits := make([]Hit, 0, len(hits))
-	ins := zdb.NewBulkInsert(ctx, "hits", []string{"site_id", "path_id", "ref_id",
-		"browser_id", "system_id", "size_id", "location", "language", "created_at", "bot",
-		"session", "first_visit"})
+	var (
+		newHits  = make([]Hit, 0, len(hits))
+		retry    []Hit
+		retryErr error
+	)
 	for _, h := range hits {
-		if m.processHit(ctx, &h) {
-			// Don't return hits that failed validation; otherwise cron will try to
-			// insert them.
+		orig := h
+		ok, err := m.processHit(ctx, &h)
+		if err != nil {
+			retry, retryErr = append(retry, orig), err
+			continue
+		}
+		// Don't return hits that failed validation; otherwise cron will try to
+		// insert them.
+		if ok {
 			newHits = append(newHits, h)
+		}
+	}
+
+	// processHit() stores the path, ref, etc. IDs in the cache and records the
+	// session, which can't be rolled back, so only the insert is in the
+	// transaction.
+	var err error
+	if len(newHits) > 0 {
+		err = zdb.TX(ctx, func(ctx context.Context) error {
+			ins := zdb.NewBulkInsert(ctx, "hits", []string{"site_id", "path_id", "ref_id",
+				"browser_id", "system_id", "size_id", "location", "language", "created_at", "bot",
+				"session", "first_visit", "status", "response_time"})
+			for _, h := range newHits {
+				ins.Values(h.Site, h.PathID, h.RefID, h.BrowserID, h.SystemID, h.SizeID,
+					h.Location, h.Language, h.CreatedAt.Round(time.Second), h.Bot, h.Session, h.FirstVisit,
+					h.Status, h.ResponseTime)
+			}
+			return ins.Finish()
+		})
+		if err != nil {
+			// Don't process them again on the next attempt, as that would see
+			// the sessions as already visited.
+			for i := range newHits {
+				newHits[i].noProcess = true
+			}
+			retry, retryErr, newHits = append(newHits, retry...), err, nil
+		}
+	}
 
-			ins.Values(h.Site, h.PathID, h.RefID, h.BrowserID, h.SystemID, h.SizeID,
-				h.Location, h.Language, h.CreatedAt.Round(time.Second), h.Bot, h.Session, h.FirstVisit)
+	m.hitMu.Lock()
+	defer m.hitMu.Unlock()
+	if len(retry) == 0 {
+		m.fails = 0
+	} else if m.wal != nil {
+		// Add them back to try again on the next run. Give up after a few
+		// attempts, as a pageview the database keeps rejecting would be tried
+		// forever.
+		m.fails++
+		if m.fails < persistRetries {
+			m.hits = append(retry, m.hits...)
+		} else {
+			m.fails = 0
+			m.failWAL(retry, retryErr)
 		}
 	}
 
-	return newHits, ins.Finish()
+	// Don't return an error if some pageviews are in the database, as the
+	// caller should still update the stats for them; the worst that can happen
+	// if the WAL isn't rewritten is that they're inserted again after a restart.
+	if err := m.rewriteWAL(); err != nil {
+		zlog.Module("memstore").Error(err)
+	}
+	if retryErr != nil {
+		if len(newHits) == 0 {
+			return nil, retryErr
+		}
+		zlog.Module("memstore").Error(retryErr)
+	}
+	return newHits, nil
 }
 
-func (m *ms) processHit(ctx context.Context, h *Hit) bool {
+// processHit sets all the fields and IDs in h; the return value reports if it
+// should be stored.
+//
+// An error is returned if it couldn't be processed because of a database error,
+// in which case it can be tried again later.
+func (m *ms) processHit(ctx context.Context, h *Hit) (bool, error) {
 	defer zlog.Recover(func(l zlog.Log) zlog.Log { return l.Field("hit", fmt.Sprintf("%#v", h)) })
 
 	l := zlog.Module("memstore")
 
 	if h.noProcess {
-		return true
+		return true, nil
 	}
 
 	// Ignore spammers.
@@ -273,18 +369,28 @@ func (m *ms) processHit(ctx context.Context, h *Hit) bool {
 	if h.RefURL != nil {
 		if isRefspam(h.RefURL.Host) {
 			l.Debugf("refspam ignored: %q", h.RefURL.Host)
-			return false
+			return false, nil
 		}
 	}
 
 	var site Site
 	err := site.ByID(ctx, h.Site)
 	if err != nil {
+		if !zdb.ErrNoRows(err) {
+			return false, err
+		}
 		l.Field("hit", fmt.Sprintf("%#v", h)).Error(err)
-		return false
+		return false, nil
 	}
 	ctx = WithSite(ctx, &site)
 
+	// /count and /api/v0/count already check this so they can report it, but
+	// pageviews from imports only get checked here.
+	if reason := site.Settings.Ignored(h.RemoteAddr, h.UserAgentHeader, h.Path); reason != "" {
+		l.Debugf("%s: %s", reason, h.Path)
+		return false, nil
+	}
+
 	if !site.Settings.Collect.Has(CollectReferrer) {
 		h.Query = ""
 		h.Ref = ""
@@ -294,12 +400,15 @@ func (m *ms) processHit(ctx context.Context, h *Hit) bool {
 
 	err = h.Defaults(ctx, false)
 	if err != nil {
-		if errors.As(err, ztype.Ptr(&zvalidate.Validator{})) {
+		switch {
+		case errors.As(err, ztype.Ptr(&zvalidate.Validator{})):
 			l.Field("hit", fmt.Sprintf("%#v", h)).Error(err)
-		} else {
+		case errors.As(err, ztype.Ptr(&url.Error{})):
 			l.Field("hit", fmt.Sprintf("%#v", h)).Debug(err)
+		default:
+			return false, err
 		}
-		return false
+		return false, nil
 	}
 
 	if h.Session.IsZero() && site.Settings.Collect.Has(CollectSession) {
@@ -341,16 +450,17 @@ func (m *ms) processHit(ctx context.Context, h *Hit) bool {
 	}
 
 	if h.Ignore() {
-		return false
+		return false, nil
 	}
 
 	err = h.Validate(ctx, false)
 	if err != nil {
 		l.Field("hit", fmt.Sprintf("%#v", h)).Error(err)
-		return false
+		return false, nil
 	}
 
-	return true
+	m.addLive(*h)
+	return true, nil
 }
 
 func (m *ms) GetSalt() (cur []byte, prev []byte) {
@@ -363,21 +473,40 @@ func (m *ms) RefreshSalt() {
 	m.sessionMu.Lock()
 	defer m.sessionMu.Unlock()
 
+	if m.shared {
+		err := m.rotateSharedSalt()
+		if err != nil {
+			zlog.Error(err)
+		}
+		return
+	}
+
 	if m.saltRotated.Add(4 * time.Hour).After(ztime.Now()) {
 		return
 	}
 
 	m.prevSalt = m.curSalt[:]
 	m.curSalt = []byte(zcrypto.Secret256())
+	m.saltRotated = ztime.Now()
 }
 
 // For 10k sessions this takes about 5ms on my laptop; that's a small enough
 // delay to not overly worry about (there are rarely more than a few hundred
 // sessions at a time).
 func (m *ms) EvictSessions() {
+	m.evictLive()
+
 	m.sessionMu.Lock()
 	defer m.sessionMu.Unlock()
 
+	if m.shared {
+		err := m.evictShared()
+		if err != nil {
+			zlog.Error(err)
+		}
+		return
+	}
+
 	ev := ztime.Now().Add(-4 * time.Hour).Unix()
 	for sID, seen := range m.sessionSeen {
 		if seen > ev {
@@ -401,23 +530,33 @@ func (m *ms) SessionID() zint.Uint128 {
 	return UUID()
 }
 
-func (m *ms) session(ctx context.Context, siteID, pathID int64, userSessionID, ua, remoteAddr string) (zint.Uint128, zbool.Bool) {
-	sessionHash := hash{userSessionID}
+// saltedHash gets the session hash; this doesn't modify salt, so it's safe to
+// call without holding sessionMu.
+func saltedHash(salt []byte, siteID int64, ua, remoteAddr string) hash {
+	h := sha256.New()
+	h.Write(salt)
+	h.Write([]byte(ua))
+	h.Write([]byte(remoteAddr))
+	h.Write([]byte(strconv.FormatInt(siteID, 10)))
+	return hash{string(h.Sum(nil))}
+}
 
-	if userSessionID == "" {
-		h := sha256.New()
-		h.Write(append(append(append(m.curSalt, ua...), remoteAddr...), strconv.FormatInt(siteID, 10)...))
-		sessionHash = hash{string(h.Sum(nil))}
+func (m *ms) session(ctx context.Context, siteID, pathID int64, userSessionID, ua, remoteAddr string) (zint.Uint128, zbool.Bool) {
+	if m.Shared() {
+		return m.sharedSessionID(ctx, siteID, pathID, userSessionID, ua, remoteAddr)
 	}
 
 	m.sessionMu.Lock()
 	defer m.sessionMu.Unlock()
 
+	sessionHash := hash{userSessionID}
+	if userSessionID == "" {
+		sessionHash = saltedHash(m.curSalt, siteID, ua, remoteAddr)
+	}
+
 	id, ok := m.sessions[sessionHash]
 	if !ok && userSessionID == "" { // Try previous hash
-		h := sha256.New()
-		h.Write(append(append(append(m.prevSalt, ua...), remoteAddr...), strconv.FormatInt(siteID, 10)...))
-		prev := hash{string(h.Sum(nil))}
+		prev := saltedHash(m.prevSalt, siteID, ua, remoteAddr)
 		id, ok = m.sessions[prev]
 		if ok {
 			sessionHash = prev
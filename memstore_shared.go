@@ -0,0 +1,182 @@
+// Copyright © Martin Tournoij – This file is part of GoatCounter and published
+// under the terms of a slightly modified EUPL v1.2 license, which can be found
+// in the LICENSE file or at https://license.goatcounter.com
+
+package goatcounter
+
+import (
+	"context"
+	"time"
+
+	"zgo.at/errors"
+	"zgo.at/json"
+	"zgo.at/zdb"
+	"zgo.at/zlog"
+	"zgo.at/zstd/zbool"
+	"zgo.at/zstd/zcrypto"
+	"zgo.at/zstd/zint"
+	"zgo.at/zstd/ztime"
+)
+
+// storedSalt is the salt as stored in the database with SetShared().
+type storedSalt struct {
+	Cur     []byte    `json:"cur"`
+	Prev    []byte    `json:"prev"`
+	Rotated time.Time `json:"rotated"`
+}
+
+// SetShared stores the sessions and salts in the database rather than in
+// memory, so that several processes using the same database will all get the
+// same sessions.
+//
+// This needs to be called before Init().
+func (m *ms) SetShared(shared bool) {
+	m.sessionMu.Lock()
+	defer m.sessionMu.Unlock()
+	m.shared = shared
+}
+
+// Shared reports if sessions are stored in the database.
+func (m *ms) Shared() bool {
+	m.sessionMu.RLock()
+	defer m.sessionMu.RUnlock()
+	return m.shared
+}
+
+// loadSalt loads the salts from the database, storing the current salts if
+// there aren't any yet. It returns the value as stored in the database.
+//
+// Must hold sessionMu.
+func (m *ms) loadSalt(ctx context.Context) (string, error) {
+	j, err := json.Marshal(storedSalt{Cur: m.curSalt, Prev: m.prevSalt, Rotated: m.saltRotated})
+	if err != nil {
+		return "", errors.Wrap(err, "Memstore.loadSalt")
+	}
+	err = zdb.Exec(ctx, `insert into store (key, value) values ('salt', :v) on conflict (key) do nothing`,
+		zdb.P{"v": string(j)})
+	if err != nil {
+		return "", errors.Wrap(err, "Memstore.loadSalt")
+	}
+
+	var v string
+	err = zdb.Get(ctx, &v, `select value from store where key='salt'`)
+	if err != nil {
+		return "", errors.Wrap(err, "Memstore.loadSalt")
+	}
+	var s storedSalt
+	err = json.Unmarshal([]byte(v), &s)
+	if err != nil {
+		return "", errors.Wrap(err, "Memstore.loadSalt")
+	}
+	m.curSalt, m.prevSalt, m.saltRotated = s.Cur, s.Prev, s.Rotated
+	return v, nil
+}
+
+// rotateSharedSalt is like RefreshSalt(), but rotates the salt in the database.
+// This also loads salts rotated by other processes.
+//
+// Must hold sessionMu.
+func (m *ms) rotateSharedSalt() error {
+	ctx := zdb.WithDB(context.Background(), m.db)
+	old, err := m.loadSalt(ctx)
+	if err != nil {
+		return err
+	}
+	if m.saltRotated.Add(4 * time.Hour).After(ztime.Now()) {
+		return nil
+	}
+
+	j, err := json.Marshal(storedSalt{
+		Cur:     []byte(zcrypto.Secret256()),
+		Prev:    m.curSalt,
+		Rotated: ztime.Now(),
+	})
+	if err != nil {
+		return errors.Wrap(err, "Memstore.rotateSharedSalt")
+	}
+	// Don't overwrite the salt if another process rotated it since we loaded
+	// it; we'll just use that one.
+	err = zdb.Exec(ctx, `update store set value=:new where key='salt' and value=:old`,
+		zdb.P{"new": string(j), "old": old})
+	if err != nil {
+		return errors.Wrap(err, "Memstore.rotateSharedSalt")
+	}
+	_, err = m.loadSalt(ctx)
+	return err
+}
+
+// sharedSessionID is like session(), but gets the session from the database.
+//
+// This only holds sessionMu to read the salts, so pageviews can be processed
+// concurrently.
+func (m *ms) sharedSessionID(ctx context.Context, siteID, pathID int64, userSessionID, ua, remoteAddr string) (zint.Uint128, zbool.Bool) {
+	m.sessionMu.RLock()
+	curSalt, prevSalt := m.curSalt, m.prevSalt
+	m.sessionMu.RUnlock()
+
+	cur, prev := hash{userSessionID}, hash{}
+	if userSessionID == "" {
+		cur = saltedHash(curSalt, siteID, ua, remoteAddr)
+		prev = saltedHash(prevSalt, siteID, ua, remoteAddr)
+	}
+	id, first, err := m.sharedSession(ctx, cur, prev, pathID)
+	if err != nil {
+		zlog.Error(err)
+		return m.SessionID(), true
+	}
+	return id, first
+}
+
+func (m *ms) sharedSession(ctx context.Context, cur, prev hash, pathID int64) (zint.Uint128, zbool.Bool, error) {
+	var (
+		id  zint.Uint128
+		now = ztime.Now().Round(time.Second)
+		h   = []byte(cur.v)
+	)
+	err := zdb.Get(ctx, &id, `select session from sessions where hash=:h`, zdb.P{"h": h})
+	if zdb.ErrNoRows(err) && prev.v != "" { // Try previous hash
+		h = []byte(prev.v)
+		err = zdb.Get(ctx, &id, `select session from sessions where hash=:h`, zdb.P{"h": h})
+	}
+	if zdb.ErrNoRows(err) { // New session
+		// Another process may have created the same session since we looked;
+		// always use the one that was inserted first.
+		h = []byte(cur.v)
+		err = zdb.Exec(ctx, `insert into sessions (hash, session, last_seen) values (:h, :s, :now)
+			on conflict (hash) do nothing`,
+			zdb.P{"h": h, "s": m.SessionID(), "now": now})
+		if err == nil {
+			err = zdb.Get(ctx, &id, `select session from sessions where hash=:h`, zdb.P{"h": h})
+		}
+	}
+	if err != nil {
+		return id, false, errors.Wrap(err, "Memstore.sharedSession")
+	}
+
+	err = zdb.Exec(ctx, `update sessions set last_seen=:now where hash=:h`, zdb.P{"h": h, "now": now})
+	if err != nil {
+		return id, false, errors.Wrap(err, "Memstore.sharedSession")
+	}
+	n, err := zdb.NumRows(ctx, `insert into session_paths (session, path_id) values (:s, :p)
+		on conflict (session, path_id) do nothing`,
+		zdb.P{"s": id, "p": pathID})
+	if err != nil {
+		return id, false, errors.Wrap(err, "Memstore.sharedSession")
+	}
+	return id, n > 0, nil
+}
+
+// evictShared is like EvictSessions(), but removes the sessions from the
+// database.
+func (m *ms) evictShared() error {
+	ev := ztime.Now().Add(-4 * time.Hour).Round(time.Second)
+	err := zdb.TX(zdb.WithDB(context.Background(), m.db), func(ctx context.Context) error {
+		err := zdb.Exec(ctx, `delete from session_paths where session in (
+			select session from sessions where last_seen < :ev)`, zdb.P{"ev": ev})
+		if err != nil {
+			return err
+		}
+		return zdb.Exec(ctx, `delete from sessions where last_seen < :ev`, zdb.P{"ev": ev})
+	})
+	return errors.Wrap(err, "Memstore.evictShared")
+}
@@ -5,7 +5,10 @@
 package goatcounter_test
 
 import (
+	"bytes"
 	"context"
+	"os"
+	"path/filepath"
 	"testing"
 
 	. "zgo.at/goatcounter/v2"
@@ -160,3 +163,315 @@ func TestMemstoreCollect(t *testing.T) {
 		})
 	}
 }
+
+func TestMemstoreIgnore(t *testing.T) {
+	ctx := gctest.DB(t)
+
+	site := Site{Settings: SiteSettings{
+		IgnoreIPs:        Strings{"10.0.0.0/8"},
+		IgnoreUserAgents: Lines{"HeadlessChrome"},
+		IgnorePaths:      Lines{"/admin/*"},
+	}}
+	ctx = gctest.Site(ctx, t, &site, nil)
+
+	gctest.StoreHits(ctx, t, false,
+		Hit{Site: site.ID, Path: "/ip", RemoteAddr: "10.1.2.3"},
+		Hit{Site: site.ID, Path: "/ua", UserAgentHeader: "Mozilla/5.0 HeadlessChrome/120.0"},
+		Hit{Site: site.ID, Path: "/admin/users"},
+		Hit{Site: site.ID, Path: "/keep", RemoteAddr: "11.1.2.3"},
+	)
+
+	have := zdb.DumpString(ctx, `select paths.path from hits join paths using (path_id)`)
+	want := `
+		path
+		/keep`
+	if d := zdb.Diff(have, want); d != "" {
+		t.Error(d)
+	}
+}
+
+func TestMemstoreRefreshSalt(t *testing.T) {
+	ztime.SetNow(t, "2020-06-18 12:00:00")
+	gctest.DB(t)
+
+	cur, _ := Memstore.GetSalt()
+	Memstore.RefreshSalt()
+	if c, _ := Memstore.GetSalt(); string(c) != string(cur) {
+		t.Fatal("salt rotated right after init")
+	}
+
+	// Rotated once 4 hours have passed, and not again until 4 hours after that.
+	ztime.SetNow(t, "2020-06-18 16:00:01")
+	Memstore.RefreshSalt()
+	cur2, prev2 := Memstore.GetSalt()
+	if string(prev2) != string(cur) || string(cur2) == string(cur) {
+		t.Fatal("salt not rotated after 4 hours")
+	}
+
+	ztime.SetNow(t, "2020-06-18 16:30:00")
+	Memstore.RefreshSalt()
+	if cur3, _ := Memstore.GetSalt(); string(cur3) != string(cur2) {
+		t.Error("salt rotated again 30 minutes after last rotation")
+	}
+
+	ztime.SetNow(t, "2020-06-18 20:00:02")
+	Memstore.RefreshSalt()
+	if cur4, prev4 := Memstore.GetSalt(); string(cur4) == string(cur2) || string(prev4) != string(cur2) {
+		t.Error("salt not rotated 4 hours after last rotation")
+	}
+}
+
+func TestMemstoreWAL(t *testing.T) {
+	ctx := gctest.DB(t)
+	db := zdb.MustGetDB(ctx)
+
+	path := filepath.Join(t.TempDir(), "queue")
+	Memstore.SetQueue(path, 3)
+	t.Cleanup(func() {
+		Memstore.SetQueue("", 0)
+		Memstore.TestInit(db)
+	})
+	err := Memstore.TestInit(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	lines := func(path string) int {
+		t.Helper()
+		b, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return bytes.Count(b, []byte("\n"))
+	}
+
+	Memstore.Append(gen(ctx), gen(ctx))
+	if l := lines(path); l != 2 {
+		t.Fatalf("lines in WAL: %d", l)
+	}
+	if Memstore.Full() {
+		t.Fatal("full with 2 hits")
+	}
+	Memstore.Append(gen(ctx))
+	if !Memstore.Full() {
+		t.Fatal("not full with 3 hits")
+	}
+
+	// Restart with an incomplete last line.
+	fp, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fp.WriteString(`{"site":1,"pa`)
+	fp.Close()
+	err = Memstore.TestInit(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if l := Memstore.Len(); l != 3 {
+		t.Fatalf("Len() after restart: %d", l)
+	}
+	if l := lines(path); l != 3 {
+		t.Fatalf("lines in WAL after restart: %d", l)
+	}
+
+	// Failing to persist keeps everything.
+	cctx, cancel := context.WithCancel(ctx)
+	cancel()
+	_, err = Memstore.Persist(cctx)
+	if err == nil {
+		t.Fatal("no error")
+	}
+	if l := Memstore.Len(); l != 3 {
+		t.Fatalf("Len() after failed persist: %d", l)
+	}
+
+	hits, err := Memstore.Persist(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(hits) != 3 {
+		t.Errorf("persisted %d hits", len(hits))
+	}
+	if l := lines(path); l != 0 {
+		t.Errorf("lines in WAL after persist: %d", l)
+	}
+	if Memstore.Full() {
+		t.Error("full after persist")
+	}
+
+	var count int
+	err = zdb.Get(ctx, &count, `select count(*) from hits`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 3 {
+		t.Errorf("count in DB: %d", count)
+	}
+
+	// Give up after persistRetries attempts.
+	Memstore.Append(gen(ctx), gen(ctx))
+	for i := 0; i < 5; i++ {
+		_, err = Memstore.Persist(cctx)
+		if err == nil {
+			t.Fatal("no error")
+		}
+	}
+	if l := Memstore.Len(); l != 0 {
+		t.Errorf("Len() after giving up: %d", l)
+	}
+	if l := lines(path); l != 0 {
+		t.Errorf("lines in WAL after giving up: %d", l)
+	}
+	if l := lines(path + ".failed"); l != 2 {
+		t.Errorf("lines in failed file: %d", l)
+	}
+}
+
+func TestMemstoreRetry(t *testing.T) {
+	ctx := gctest.DB(t)
+	db := zdb.MustGetDB(ctx)
+
+	Memstore.SetQueue(filepath.Join(t.TempDir(), "queue"), 0)
+	t.Cleanup(func() {
+		Memstore.SetQueue("", 0)
+		Memstore.TestInit(db)
+	})
+	err := Memstore.TestInit(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Fail the insert after the pageview is processed; retrying it shouldn't
+	// process it again.
+	Memstore.Append(Hit{Site: MustGetSite(ctx).ID, Path: "/retry", UserAgentHeader: "test", RemoteAddr: "1.2.3.4"})
+	err = zdb.Exec(ctx, `alter table hits rename to hits_tmp`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = Memstore.Persist(ctx)
+	if err == nil {
+		t.Fatal("no error")
+	}
+	err = zdb.Exec(ctx, `alter table hits_tmp rename to hits`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if l := Memstore.Len(); l != 1 {
+		t.Fatalf("Len() after failed persist: %d", l)
+	}
+
+	_, err = Memstore.Persist(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	have := zdb.DumpString(ctx, `select paths.path, first_visit from hits join paths using (path_id)`)
+	want := `
+		path    first_visit
+		/retry  1`
+	if d := zdb.Diff(have, want); d != "" {
+		t.Error(d)
+	}
+}
+
+func TestMemstoreShared(t *testing.T) {
+	ctx := gctest.DB(t)
+	db := zdb.MustGetDB(ctx)
+	ztime.SetNow(t, "2020-06-18 12:00:00")
+
+	Memstore.SetShared(true)
+	t.Cleanup(func() {
+		Memstore.SetShared(false)
+		Memstore.TestInit(db)
+	})
+	err := Memstore.TestInit(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	send := func(path string) Hit {
+		t.Helper()
+		Memstore.Append(Hit{
+			Site:            MustGetSite(ctx).ID,
+			Path:            path,
+			UserAgentHeader: "Mozilla/5.0 (X11; Linux x86_64; rv:79.0) Gecko/20100101 Firefox/79.0",
+			RemoteAddr:      "127.0.0.1",
+			CreatedAt:       ztime.Now(),
+		})
+		hits, err := Memstore.Persist(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(hits) != 1 {
+			t.Fatalf("persisted %d hits", len(hits))
+		}
+		return hits[0]
+	}
+	count := func(table string) int {
+		t.Helper()
+		var n int
+		err := zdb.Get(ctx, &n, `select count(*) from `+table)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return n
+	}
+
+	first := send("/a")
+	if !first.FirstVisit {
+		t.Error("FirstVisit false for first pageview")
+	}
+
+	// Restart, which should be the same as another process: the salt and
+	// session are loaded from the DB.
+	Memstore.Reset()
+	err = Memstore.TestInit(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := send("/a")
+	if h.Session != first.Session {
+		t.Errorf("different session after restart:\nfirst: %s\nhave:  %s", first.Session, h.Session)
+	}
+	if h.FirstVisit {
+		t.Error("FirstVisit true for second pageview")
+	}
+	if h := send("/b"); !h.FirstVisit {
+		t.Error("FirstVisit false for new path")
+	}
+	if c := count("sessions"); c != 1 {
+		t.Errorf("sessions: %d", c)
+	}
+	if c := count("session_paths"); c != 2 {
+		t.Errorf("session_paths: %d", c)
+	}
+
+	// Rotate the salt; the session should still be found with the previous
+	// salt.
+	cur, _ := Memstore.GetSalt()
+	ztime.SetNow(t, "2020-06-18 17:00:00")
+	Memstore.RefreshSalt()
+	_, prev := Memstore.GetSalt()
+	if string(prev) != string(cur) {
+		t.Fatal("salt not rotated")
+	}
+	if h := send("/a"); h.Session != first.Session {
+		t.Errorf("different session after rotating salt:\nfirst: %s\nhave:  %s", first.Session, h.Session)
+	}
+
+	// Rotating again shouldn't do anything.
+	Memstore.RefreshSalt()
+	if _, p := Memstore.GetSalt(); string(p) != string(prev) {
+		t.Error("salt rotated twice")
+	}
+
+	ztime.SetNow(t, "2020-06-18 22:00:00")
+	Memstore.EvictSessions()
+	if c := count("sessions"); c != 0 {
+		t.Errorf("sessions after evict: %d", c)
+	}
+	if c := count("session_paths"); c != 0 {
+		t.Errorf("session_paths after evict: %d", c)
+	}
+}
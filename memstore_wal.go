@@ -0,0 +1,292 @@
+// Copyright © Martin Tournoij – This file is part of GoatCounter and published
+// under the terms of a slightly modified EUPL v1.2 license, which can be found
+// in the LICENSE file or at https://license.goatcounter.com
+
+package goatcounter
+
+import (
+	"bufio"
+	"bytes"
+	"os"
+	"time"
+
+	"zgo.at/errors"
+	"zgo.at/json"
+	"zgo.at/zlog"
+	"zgo.at/zstd/zbool"
+	"zgo.at/zstd/zint"
+)
+
+// Number of times to try persisting pageviews from the write-ahead log before
+// giving up and moving them to the ".failed" file.
+const persistRetries = 5
+
+// walHit is a Hit as stored in the write-ahead log; the JSON tags on Hit are
+// for the /count endpoint and don't include everything we need.
+type walHit struct {
+	Site       int64        `json:"site"`
+	PathID     int64        `json:"path_id,omitempty"`
+	RefID      int64        `json:"ref_id,omitempty"`
+	SizeID     *int64       `json:"size_id,omitempty"`
+	BrowserID  int64        `json:"browser_id,omitempty"`
+	SystemID   int64        `json:"system_id,omitempty"`
+	CampaignID *int64       `json:"campaign_id,omitempty"`
+	Session    zint.Uint128 `json:"session,omitempty"`
+
+	Path            string     `json:"path,omitempty"`
+	Title           string     `json:"title,omitempty"`
+	Ref             string     `json:"ref,omitempty"`
+	RefScheme       *string    `json:"ref_scheme,omitempty"`
+	Event           zbool.Bool `json:"event,omitempty"`
+	Size            Floats     `json:"size,omitempty"`
+	Query           string     `json:"query,omitempty"`
+	Bot             int        `json:"bot,omitempty"`
+	Props           Props      `json:"props,omitempty"`
+	UserAgentHeader string     `json:"user_agent,omitempty"`
+	Location        string     `json:"location,omitempty"`
+	Language        *string    `json:"language,omitempty"`
+	FirstVisit      zbool.Bool `json:"first_visit,omitempty"`
+	CreatedAt       time.Time  `json:"created_at"`
+	Status          int        `json:"status,omitempty"`
+	ResponseTime    *int64     `json:"response_time,omitempty"`
+
+	RemoteAddr     string `json:"remote_addr,omitempty"`
+	UserSessionID  string `json:"user_session_id,omitempty"`
+	ImportHash     string `json:"import_hash,omitempty"`
+	BrowserName    string `json:"browser_name,omitempty"`
+	BrowserVersion string `json:"browser_version,omitempty"`
+	SystemName     string `json:"system_name,omitempty"`
+	SystemVersion  string `json:"system_version,omitempty"`
+	NoProcess      bool   `json:"no_process,omitempty"`
+}
+
+func toWAL(h Hit) walHit {
+	return walHit{
+		Site: h.Site, PathID: h.PathID, RefID: h.RefID, SizeID: h.SizeID,
+		BrowserID: h.BrowserID, SystemID: h.SystemID, CampaignID: h.CampaignID,
+		Session: h.Session, Path: h.Path, Title: h.Title, Ref: h.Ref,
+		RefScheme: h.RefScheme, Event: h.Event, Size: h.Size, Query: h.Query,
+		Bot: h.Bot, Props: h.Props, UserAgentHeader: h.UserAgentHeader,
+		Location: h.Location, Language: h.Language, FirstVisit: h.FirstVisit,
+		CreatedAt: h.CreatedAt, Status: h.Status, ResponseTime: h.ResponseTime,
+		RemoteAddr: h.RemoteAddr, UserSessionID: h.UserSessionID,
+		ImportHash: h.ImportHash, BrowserName: h.BrowserName,
+		BrowserVersion: h.BrowserVersion, SystemName: h.SystemName,
+		SystemVersion: h.SystemVersion, NoProcess: h.noProcess,
+	}
+}
+
+func (w walHit) hit() Hit {
+	return Hit{
+		Site: w.Site, PathID: w.PathID, RefID: w.RefID, SizeID: w.SizeID,
+		BrowserID: w.BrowserID, SystemID: w.SystemID, CampaignID: w.CampaignID,
+		Session: w.Session, Path: w.Path, Title: w.Title, Ref: w.Ref,
+		RefScheme: w.RefScheme, Event: w.Event, Size: w.Size, Query: w.Query,
+		Bot: w.Bot, Props: w.Props, UserAgentHeader: w.UserAgentHeader,
+		Location: w.Location, Language: w.Language, FirstVisit: w.FirstVisit,
+		CreatedAt: w.CreatedAt, Status: w.Status, ResponseTime: w.ResponseTime,
+		RemoteAddr: w.RemoteAddr, UserSessionID: w.UserSessionID,
+		ImportHash: w.ImportHash, BrowserName: w.BrowserName,
+		BrowserVersion: w.BrowserVersion, SystemName: w.SystemName,
+		SystemVersion: w.SystemVersion, noProcess: w.NoProcess,
+	}
+}
+
+// SetQueue sets the write-ahead log for pageviews that aren't persisted to the
+// database yet, and the maximum number of pageviews to queue.
+//
+// Every pageview is appended to the file before it's added to the memstore,
+// and the file is truncated once the pageviews are persisted; on startup the
+// pageviews in the file are loaded back in the memstore. The WAL isn't used if
+// path is "".
+//
+// Full() reports true once there are max pageviews queued; there is no maximum
+// if max is 0.
+//
+// This needs to be called before Init().
+func (m *ms) SetQueue(path string, max int) {
+	m.hitMu.Lock()
+	defer m.hitMu.Unlock()
+	m.walPath, m.maxHits = path, max
+}
+
+// Full reports if the maximum number of pageviews are queued; new pageviews
+// should be rejected until they're persisted.
+func (m *ms) Full() bool {
+	m.hitMu.RLock()
+	defer m.hitMu.RUnlock()
+	return m.maxHits > 0 && len(m.hits) >= m.maxHits
+}
+
+// openWAL opens the write-ahead log and loads all pageviews in it.
+//
+// Must hold hitMu.
+func (m *ms) openWAL() error {
+	if m.wal != nil {
+		m.wal.Close()
+		m.wal = nil
+	}
+	if m.walPath == "" {
+		return nil
+	}
+
+	fp, err := os.OpenFile(m.walPath, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0o600)
+	if err != nil {
+		return errors.Errorf("Memstore.openWAL: %w", err)
+	}
+
+	// Everything in the memstore is also in the WAL.
+	m.hits = make([]Hit, 0, 16)
+
+	var (
+		scan    = bufio.NewScanner(fp)
+		lineno  int
+		invalid int
+	)
+	scan.Buffer(make([]byte, 0, 64*1024), 4*1024*1024)
+	for scan.Scan() {
+		lineno++
+		var h walHit
+		err := json.Unmarshal(scan.Bytes(), &h)
+		if err != nil {
+			// Most likely an incomplete line from a crash while writing.
+			invalid++
+			zlog.Errorf("Memstore.openWAL: %s line %d: %w", m.walPath, lineno, err)
+			continue
+		}
+		m.hits = append(m.hits, h.hit())
+	}
+	if err := scan.Err(); err != nil {
+		fp.Close()
+		return errors.Errorf("Memstore.openWAL: %w", err)
+	}
+	if len(m.hits) > 0 {
+		zlog.Module("memstore").Printf("loaded %d pageviews from %s", len(m.hits), m.walPath)
+	}
+
+	m.wal = fp
+	if invalid > 0 { // Don't keep the invalid lines.
+		return m.rewriteWAL()
+	}
+	return nil
+}
+
+// writeWAL appends the hits to the write-ahead log.
+//
+// Must hold hitMu.
+func (m *ms) writeWAL(hits []Hit) error {
+	if m.wal == nil {
+		return nil
+	}
+	b, err := encodeWAL(hits)
+	if err != nil {
+		return errors.Errorf("Memstore.writeWAL: %w", err)
+	}
+	// Write everything at once, so that a crash will write either all or
+	// nothing in most cases.
+	_, err = m.wal.Write(b)
+	if err != nil {
+		return errors.Errorf("Memstore.writeWAL: %w", err)
+	}
+	return nil
+}
+
+func encodeWAL(hits []Hit) ([]byte, error) {
+	var buf bytes.Buffer
+	for _, h := range hits {
+		j, err := json.Marshal(toWAL(h))
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(j)
+		buf.WriteByte('\n')
+	}
+	return buf.Bytes(), nil
+}
+
+// failWAL appends hits that couldn't be persisted to the queue path with
+// ".failed" appended. The caller should rewrite the write-ahead log afterwards.
+//
+// The file uses the same format as the write-ahead log, so the pageviews can be
+// retried by appending it to the queue while GoatCounter isn't running.
+//
+// Must hold hitMu.
+func (m *ms) failWAL(hits []Hit, persistErr error) {
+	l := zlog.Module("memstore").Field("error", persistErr.Error())
+	path := m.walPath + ".failed"
+
+	err := func() error {
+		b, err := encodeWAL(hits)
+		if err != nil {
+			return err
+		}
+		fp, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o600)
+		if err != nil {
+			return err
+		}
+		_, err = fp.Write(b)
+		if err2 := fp.Close(); err == nil {
+			err = err2
+		}
+		return err
+	}()
+	if err != nil {
+		l.Errorf("dropped %d pageviews after %d failed attempts to store them; writing them to %s failed: %s",
+			len(hits), persistRetries, path, err)
+	} else {
+		l.Errorf("moved %d pageviews to %s after %d failed attempts to store them",
+			len(hits), path, persistRetries)
+	}
+}
+
+// rewriteWAL replaces the write-ahead log with the hits that are currently in
+// the memstore.
+//
+// Must hold hitMu.
+func (m *ms) rewriteWAL() error {
+	if m.wal == nil {
+		return nil
+	}
+	if len(m.hits) == 0 {
+		err := m.wal.Truncate(0)
+		if err != nil {
+			return errors.Errorf("Memstore.rewriteWAL: %w", err)
+		}
+		return nil
+	}
+
+	// Write to a new file and rename it, so we never lose the pageviews that
+	// aren't persisted yet.
+	tmp := m.walPath + ".tmp"
+	fp, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC|os.O_APPEND, 0o600)
+	if err != nil {
+		return errors.Errorf("Memstore.rewriteWAL: %w", err)
+	}
+	old := m.wal
+	m.wal = fp
+	err = m.writeWAL(m.hits)
+	if err2 := fp.Close(); err == nil {
+		err = err2
+	}
+	m.wal = old
+	if err != nil {
+		os.Remove(tmp)
+		return errors.Errorf("Memstore.rewriteWAL: %w", err)
+	}
+
+	old.Close()
+	m.wal = nil
+	err = os.Rename(tmp, m.walPath)
+	if err != nil {
+		os.Remove(tmp)
+	}
+	fp, err2 := os.OpenFile(m.walPath, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0o600)
+	if err2 != nil {
+		return errors.Errorf("Memstore.rewriteWAL: %w", err2)
+	}
+	m.wal = fp
+	if err != nil {
+		return errors.Errorf("Memstore.rewriteWAL: %w", err)
+	}
+	return nil
+}
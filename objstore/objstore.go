@@ -0,0 +1,165 @@
+// Copyright © Martin Tournoij – This file is part of GoatCounter and published
+// under the terms of a slightly modified EUPL v1.2 license, which can be found
+// in the LICENSE file or at https://license.goatcounter.com
+
+// Package objstore stores files in a local directory or an S3-compatible
+// bucket.
+package objstore
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Store is a place to store files.
+type Store interface {
+	// Put a new file, overwriting any existing file with the same name.
+	Put(ctx context.Context, name string, fp io.Reader, size int64) error
+
+	// List all files starting with prefix, sorted by name.
+	List(ctx context.Context, prefix string) ([]Object, error)
+
+	// Delete a file.
+	Delete(ctx context.Context, name string) error
+
+	// String gets a description, without any credentials.
+	String() string
+}
+
+// Object is a stored file.
+type Object struct {
+	Name     string
+	Size     int64
+	Modified time.Time
+}
+
+// New creates a new store from a destination, which is either a local
+// directory or an URL in the form of:
+//
+//	s3://[access-key:secret-key@]host[:port]/bucket[/prefix][?region=..&http=1]
+//
+// The access key and secret key must be in the URL; they're never read from the
+// environment, as the destination may be set by someone other than the
+// operator. The region defaults to "us-east-1", and https is used unless http=1
+// is set. Path-style requests are
+// used, which works for AWS and most compatible stores such as MinIO.
+func New(dest string) (Store, error) {
+	if !strings.HasPrefix(dest, "s3://") {
+		if !filepath.IsAbs(dest) {
+			return nil, fmt.Errorf("objstore.New: not an absolute path: %q", dest)
+		}
+		return Dir(dest), nil
+	}
+
+	u, err := url.Parse(dest)
+	if err != nil {
+		return nil, fmt.Errorf("objstore.New: %w", err)
+	}
+	bucket, prefix, _ := strings.Cut(strings.Trim(u.Path, "/"), "/")
+	if u.Host == "" || bucket == "" {
+		return nil, fmt.Errorf("objstore.New: need host and bucket in %q", dest)
+	}
+
+	s := S3{
+		Endpoint: "https://" + u.Host,
+		Bucket:   bucket,
+		Prefix:   prefix,
+		Region:   u.Query().Get("region"),
+	}
+	if s.Prefix != "" {
+		s.Prefix += "/"
+	}
+	if s.Region == "" {
+		s.Region = "us-east-1"
+	}
+	if u.Query().Get("http") == "1" {
+		s.Endpoint = "http://" + u.Host
+	}
+	if u.User != nil {
+		s.AccessKey = u.User.Username()
+		s.SecretKey, _ = u.User.Password()
+	}
+	if s.AccessKey == "" || s.SecretKey == "" {
+		return nil, fmt.Errorf("objstore.New: no access key or secret key for %q", s.String())
+	}
+	return &s, nil
+}
+
+// Dir stores files in a local directory.
+type Dir string
+
+func (d Dir) String() string { return string(d) }
+
+func (d Dir) Put(ctx context.Context, name string, fp io.Reader, size int64) error {
+	if strings.ContainsAny(name, `/\`) {
+		return fmt.Errorf("objstore.Dir.Put: invalid name: %q", name)
+	}
+	err := os.MkdirAll(string(d), 0o755)
+	if err != nil {
+		return fmt.Errorf("objstore.Dir.Put: %w", err)
+	}
+
+	// Write to a temporary file first, so there's never a partial file with
+	// the final name.
+	tmp, err := os.CreateTemp(string(d), ".tmp-"+name)
+	if err != nil {
+		return fmt.Errorf("objstore.Dir.Put: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	_, err = io.Copy(tmp, fp)
+	if err == nil {
+		err = tmp.Close()
+	} else {
+		tmp.Close()
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), filepath.Join(string(d), name))
+	}
+	if err != nil {
+		return fmt.Errorf("objstore.Dir.Put: %w", err)
+	}
+	return nil
+}
+
+func (d Dir) List(ctx context.Context, prefix string) ([]Object, error) {
+	ls, err := os.ReadDir(string(d))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("objstore.Dir.List: %w", err)
+	}
+
+	objs := make([]Object, 0, len(ls))
+	for _, f := range ls {
+		if f.IsDir() || !strings.HasPrefix(f.Name(), prefix) {
+			continue
+		}
+		st, err := f.Info()
+		if err != nil {
+			return nil, fmt.Errorf("objstore.Dir.List: %w", err)
+		}
+		objs = append(objs, Object{Name: f.Name(), Size: st.Size(), Modified: st.ModTime()})
+	}
+	sort.Slice(objs, func(i, j int) bool { return objs[i].Name < objs[j].Name })
+	return objs, nil
+}
+
+func (d Dir) Delete(ctx context.Context, name string) error {
+	if strings.ContainsAny(name, `/\`) {
+		return fmt.Errorf("objstore.Dir.Delete: invalid name: %q", name)
+	}
+	err := os.Remove(filepath.Join(string(d), name))
+	if err != nil {
+		return fmt.Errorf("objstore.Dir.Delete: %w", err)
+	}
+	return nil
+}
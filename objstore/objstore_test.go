@@ -0,0 +1,222 @@
+// Copyright © Martin Tournoij – This file is part of GoatCounter and published
+// under the terms of a slightly modified EUPL v1.2 license, which can be found
+// in the LICENSE file or at https://license.goatcounter.com
+
+package objstore
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestNew(t *testing.T) {
+	// Never use the operator's credentials.
+	t.Setenv("AWS_ACCESS_KEY_ID", "key")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "secret")
+
+	tests := []struct {
+		in, want, wantErr string
+	}{
+		{"/tmp/exports", "/tmp/exports", ""},
+		{"exports", "", "not an absolute path"},
+		{"s3://k:s@minio:9000/bucket?http=1", "s3://minio:9000/bucket/", ""},
+		{"s3://k:s@s3.amazonaws.com/bucket/some/prefix/", "s3://s3.amazonaws.com/bucket/some/prefix/", ""},
+		{"s3://k:s@minio:9000", "", "need host and bucket"},
+		{"s3://minio:9000/bucket", "", "no access key"},
+		{"s3://k@minio:9000/bucket", "", "no access key"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			s, err := New(tt.in)
+			if !errContains(err, tt.wantErr) {
+				t.Fatalf("wrong error\nhave: %v\nwant: %s", err, tt.wantErr)
+			}
+			if err == nil && s.String() != tt.want {
+				t.Errorf("\nhave: %s\nwant: %s", s, tt.want)
+			}
+		})
+	}
+
+	s, err := New("s3://k:s@minio:9000/bucket?http=1&region=eu-west-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s3 := s.(*S3); s3.Endpoint != "http://minio:9000" || s3.Region != "eu-west-1" ||
+		s3.AccessKey != "k" || s3.SecretKey != "s" {
+		t.Errorf("%#v", s3)
+	}
+}
+
+func TestDir(t *testing.T) {
+	d := Dir(t.TempDir() + "/sub")
+	testStore(t, d)
+
+	err := d.Put(context.Background(), "../x", strings.NewReader(""), 0)
+	if !errContains(err, "invalid name") {
+		t.Errorf("wrong error: %v", err)
+	}
+}
+
+func TestS3(t *testing.T) {
+	srv := newFakeS3(t, "key", "secret")
+	s, err := New("s3://key:secret@" + strings.TrimPrefix(srv.URL, "http://") + "/bucket/prefix?http=1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	testStore(t, s)
+
+	t.Run("wrong key", func(t *testing.T) {
+		s, err := New("s3://key:wrong@" + strings.TrimPrefix(srv.URL, "http://") + "/bucket?http=1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = s.Put(context.Background(), "x", strings.NewReader("x"), 1)
+		if !errContains(err, "403 Forbidden") {
+			t.Fatalf("wrong error: %v", err)
+		}
+	})
+}
+
+// TestMinIO runs against a real S3-compatible server, for example:
+//
+//	docker run -p 9000:9000 minio/minio server /data
+//	mc mb local/goatcounter-test
+//	GC_TEST_S3=s3://minioadmin:minioadmin@localhost:9000/goatcounter-test?http=1 go test ./objstore
+func TestMinIO(t *testing.T) {
+	dest := os.Getenv("GC_TEST_S3")
+	if dest == "" {
+		t.Skip("GC_TEST_S3 not set")
+	}
+	s, err := New(dest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.(*S3).Prefix += fmt.Sprintf("test-%d/", time.Now().UnixNano())
+	testStore(t, s)
+}
+
+func testStore(t *testing.T, s Store) {
+	t.Helper()
+	ctx := context.Background()
+
+	ls, err := s.List(ctx, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ls) != 0 {
+		t.Fatalf("not empty: %v", ls)
+	}
+
+	for _, n := range []string{"export-b.csv.gz", "export-a.csv.gz", "other.txt"} {
+		err := s.Put(ctx, n, strings.NewReader("data "+n), int64(len("data "+n)))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	ls, err = s.List(ctx, "export-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if have := names(ls); have != "export-a.csv.gz export-b.csv.gz" {
+		t.Errorf("wrong list: %s", have)
+	}
+	if ls[0].Size != 20 || ls[0].Modified.IsZero() {
+		t.Errorf("wrong object: %#v", ls[0])
+	}
+
+	err = s.Delete(ctx, "export-a.csv.gz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ls, err = s.List(ctx, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if have := names(ls); have != "export-b.csv.gz other.txt" {
+		t.Errorf("wrong list after delete: %s", have)
+	}
+}
+
+func names(objs []Object) string {
+	n := make([]string, 0, len(objs))
+	for _, o := range objs {
+		n = append(n, o.Name)
+	}
+	return strings.Join(n, " ")
+}
+
+func errContains(err error, s string) bool {
+	if err == nil {
+		return s == ""
+	}
+	return s != "" && strings.Contains(err.Error(), s)
+}
+
+// newFakeS3 starts a server that implements just enough of the S3 API for the
+// tests, including verifying the signature.
+func newFakeS3(t *testing.T, key, secret string) *httptest.Server {
+	var (
+		mu    sync.Mutex
+		files = make(map[string][]byte)
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Re-sign the request with the known secret, and check it matches.
+		check, _ := http.NewRequest(r.Method, "http://"+r.Host+r.URL.RequestURI(), nil)
+		date, _ := time.Parse("20060102T150405Z", r.Header.Get("X-Amz-Date"))
+		s := S3{Region: "us-east-1", AccessKey: key, SecretKey: secret, now: func() time.Time { return date }}
+		s.sign(check, r.Header.Get("X-Amz-Content-Sha256") == "UNSIGNED-PAYLOAD")
+		if check.Header.Get("Authorization") != r.Header.Get("Authorization") {
+			w.WriteHeader(403)
+			fmt.Fprint(w, "<Error><Code>SignatureDoesNotMatch</Code></Error>")
+			return
+		}
+
+		mu.Lock()
+		defer mu.Unlock()
+		bucket, obj, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+		if bucket != "bucket" {
+			w.WriteHeader(404)
+			return
+		}
+
+		switch r.Method {
+		case "PUT":
+			b, _ := io.ReadAll(r.Body)
+			files[obj] = b
+		case "DELETE":
+			delete(files, obj)
+			w.WriteHeader(204)
+		case "GET":
+			type content struct {
+				Key          string
+				Size         int
+				LastModified time.Time
+			}
+			var res struct {
+				XMLName  xml.Name `xml:"ListBucketResult"`
+				Contents []content
+			}
+			for k, v := range files {
+				if strings.HasPrefix(k, r.URL.Query().Get("prefix")) {
+					res.Contents = append(res.Contents, content{k, len(v), time.Now().UTC()})
+				}
+			}
+			sort.Slice(res.Contents, func(i, j int) bool { return res.Contents[i].Key < res.Contents[j].Key })
+			xml.NewEncoder(w).Encode(res)
+		}
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
@@ -0,0 +1,244 @@
+// Copyright © Martin Tournoij – This file is part of GoatCounter and published
+// under the terms of a slightly modified EUPL v1.2 license, which can be found
+// in the LICENSE file or at https://license.goatcounter.com
+
+package objstore
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Hash of an empty payload.
+const emptyHash = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+
+var s3Client = http.Client{Timeout: 10 * time.Minute}
+
+// S3 stores files in an S3-compatible bucket.
+//
+// This only implements the small subset of the API we need, signed with AWS
+// Signature Version 4.
+type S3 struct {
+	Endpoint  string // https://host[:port]
+	Bucket    string
+	Prefix    string // Prepended to all names; should end with a "/".
+	Region    string
+	AccessKey string
+	SecretKey string
+
+	Client *http.Client // HTTP client to use; nil uses a default client.
+
+	now func() time.Time // For tests.
+}
+
+func (s S3) String() string {
+	return fmt.Sprintf("s3://%s/%s/%s", strings.SplitN(s.Endpoint, "://", 2)[1], s.Bucket, s.Prefix)
+}
+
+func (s *S3) Put(ctx context.Context, name string, fp io.Reader, size int64) error {
+	resp, err := s.do(ctx, "PUT", s.Prefix+name, nil, fp, size)
+	if err != nil {
+		return fmt.Errorf("objstore.S3.Put: %w", err)
+	}
+	resp.Body.Close()
+	return nil
+}
+
+func (s *S3) Delete(ctx context.Context, name string) error {
+	resp, err := s.do(ctx, "DELETE", s.Prefix+name, nil, nil, 0)
+	if err != nil {
+		return fmt.Errorf("objstore.S3.Delete: %w", err)
+	}
+	resp.Body.Close()
+	return nil
+}
+
+func (s *S3) List(ctx context.Context, prefix string) ([]Object, error) {
+	var (
+		objs []Object
+		cont string
+	)
+	for {
+		q := url.Values{"list-type": {"2"}, "prefix": {s.Prefix + prefix}}
+		if cont != "" {
+			q.Set("continuation-token", cont)
+		}
+		resp, err := s.do(ctx, "GET", "", q, nil, 0)
+		if err != nil {
+			return nil, fmt.Errorf("objstore.S3.List: %w", err)
+		}
+
+		var list struct {
+			Contents []struct {
+				Key          string    `xml:"Key"`
+				Size         int64     `xml:"Size"`
+				LastModified time.Time `xml:"LastModified"`
+			} `xml:"Contents"`
+			IsTruncated           bool   `xml:"IsTruncated"`
+			NextContinuationToken string `xml:"NextContinuationToken"`
+		}
+		err = xml.NewDecoder(resp.Body).Decode(&list)
+		resp.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("objstore.S3.List: %w", err)
+		}
+
+		for _, c := range list.Contents {
+			name := strings.TrimPrefix(c.Key, s.Prefix)
+			if strings.Contains(name, "/") {
+				continue
+			}
+			objs = append(objs, Object{Name: name, Size: c.Size, Modified: c.LastModified})
+		}
+		if !list.IsTruncated || list.NextContinuationToken == "" {
+			break
+		}
+		cont = list.NextContinuationToken
+	}
+
+	sort.Slice(objs, func(i, j int) bool { return objs[i].Name < objs[j].Name })
+	return objs, nil
+}
+
+func (s *S3) do(ctx context.Context, method, key string, q url.Values, body io.Reader, size int64) (*http.Response, error) {
+	path := "/" + s.Bucket
+	if key != "" {
+		path += "/" + key
+	}
+	u := s.Endpoint + uriEncode(path, false)
+	if len(q) > 0 {
+		u += "?" + canonicalQuery(q)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, u, body)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.ContentLength = size
+	}
+	s.sign(req, body != nil)
+
+	c := s.Client
+	if c == nil {
+		c = &s3Client
+	}
+	resp, err := c.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= 300 {
+		defer resp.Body.Close()
+		b, _ := io.ReadAll(io.LimitReader(resp.Body, 2048))
+		return nil, fmt.Errorf("%s %s: %s: %s", method, path, resp.Status, strings.TrimSpace(string(b)))
+	}
+	return resp, nil
+}
+
+// sign the request with AWS Signature Version 4; the payload isn't signed for
+// uploads, so we don't need to read the entire file in memory.
+func (s *S3) sign(req *http.Request, unsignedPayload bool) {
+	now := time.Now
+	if s.now != nil {
+		now = s.now
+	}
+	var (
+		t       = now().UTC()
+		amzDate = t.Format("20060102T150405Z")
+		day     = t.Format("20060102")
+		scope   = day + "/" + s.Region + "/s3/aws4_request"
+		payload = emptyHash
+	)
+	if unsignedPayload {
+		payload = "UNSIGNED-PAYLOAD"
+	}
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payload)
+
+	canonical := strings.Join([]string{
+		req.Method,
+		uriEncode(req.URL.Path, false),
+		req.URL.RawQuery,
+		"host:" + req.URL.Host + "\n" +
+			"x-amz-content-sha256:" + payload + "\n" +
+			"x-amz-date:" + amzDate + "\n",
+		"host;x-amz-content-sha256;x-amz-date",
+		payload,
+	}, "\n")
+
+	toSign := strings.Join([]string{
+		"AWS4-HMAC-SHA256",
+		amzDate,
+		scope,
+		hexSHA256([]byte(canonical)),
+	}, "\n")
+
+	key := hmacSHA256([]byte("AWS4"+s.SecretKey), day)
+	key = hmacSHA256(key, s.Region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+
+	req.Header.Set("Authorization", fmt.Sprintf(
+		"AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=host;x-amz-content-sha256;x-amz-date, Signature=%s",
+		s.AccessKey, scope, hex.EncodeToString(hmacSHA256(key, toSign))))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	m := hmac.New(sha256.New, key)
+	m.Write([]byte(data))
+	return m.Sum(nil)
+}
+
+func hexSHA256(data []byte) string {
+	h := sha256.Sum256(data)
+	return hex.EncodeToString(h[:])
+}
+
+// canonicalQuery encodes the query string as required by the signature:
+// sorted by key, and with spaces encoded as %20 rather than +.
+func canonicalQuery(q url.Values) string {
+	keys := make([]string, 0, len(q))
+	for k := range q {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var b strings.Builder
+	for _, k := range keys {
+		for _, v := range q[k] {
+			if b.Len() > 0 {
+				b.WriteByte('&')
+			}
+			b.WriteString(uriEncode(k, true))
+			b.WriteByte('=')
+			b.WriteString(uriEncode(v, true))
+		}
+	}
+	return b.String()
+}
+
+// uriEncode encodes everything except unreserved characters; "/" is only
+// encoded if encodeSlash is set.
+func uriEncode(s string, encodeSlash bool) string {
+	var b strings.Builder
+	for _, c := range []byte(s) {
+		switch {
+		case c >= 'A' && c <= 'Z', c >= 'a' && c <= 'z', c >= '0' && c <= '9',
+			c == '-', c == '_', c == '.', c == '~', c == '/' && !encodeSlash:
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
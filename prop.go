@@ -0,0 +1,91 @@
+// Copyright © Martin Tournoij – This file is part of GoatCounter and published
+// under the terms of a slightly modified EUPL v1.2 license, which can be found
+// in the LICENSE file or at https://license.goatcounter.com
+
+package goatcounter
+
+import (
+	"context"
+	"slices"
+	"strings"
+
+	"zgo.at/errors"
+	"zgo.at/zdb"
+)
+
+// MaxPropsPerHit is the maximum number of custom properties that can be sent
+// with a single pageview or event.
+const MaxPropsPerHit = 10
+
+// PropOther is the value used for property values that exceed the configured
+// cardinality limit.
+const PropOther = "(other)"
+
+// Props are custom key/value properties for a pageview or event, sent as
+// props[key]=value to /count or as "props" to the API.
+type Props map[string]string
+
+func (p Props) String() string {
+	keys := make([]string, 0, len(p))
+	for k := range p {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+
+	var b strings.Builder
+	for i, k := range keys {
+		if i > 0 {
+			b.WriteString(", ")
+		}
+		b.WriteString(k)
+		b.WriteByte('=')
+		b.WriteString(p[k])
+	}
+	return b.String()
+}
+
+// PropValues are all known property values for a site, grouped by key.
+type PropValues map[string]map[string]struct{}
+
+// List all known property keys and values for this site.
+func (p *PropValues) List(ctx context.Context) error {
+	var rows []struct {
+		Name  string `db:"name"`
+		Value string `db:"value"`
+	}
+	err := zdb.Select(ctx, &rows, `select distinct name, value from prop_stats where site_id = :site`,
+		zdb.P{"site": MustGetSite(ctx).ID})
+	if err != nil {
+		return errors.Wrap(err, "PropValues.List")
+	}
+
+	*p = make(PropValues)
+	for _, r := range rows {
+		p.add(r.Name, r.Value)
+	}
+	return nil
+}
+
+func (p PropValues) add(k, v string) {
+	if p[k] == nil {
+		p[k] = make(map[string]struct{})
+	}
+	p[k][v] = struct{}{}
+}
+
+// Limit the key and value to the site's cardinality limits.
+//
+// The key is returned as an empty string if the site already has the maximum
+// number of keys; the value is returned as PropOther if the key already has
+// the maximum number of values. The new key and value are recorded.
+func (p PropValues) Limit(ss SiteSettings, k, v string) (string, string) {
+	vals, ok := p[k]
+	if !ok && len(p) >= ss.PropKeys {
+		return "", ""
+	}
+	if _, ok := vals[v]; !ok && len(vals) >= ss.PropValues {
+		v = PropOther
+	}
+	p.add(k, v)
+	return k, v
+}
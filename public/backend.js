@@ -122,6 +122,26 @@
 			})
 		})
 
+		// Test ignore rules with the current (unsaved) values.
+		$('#ignore-test button').on('click', function(e) {
+			e.preventDefault()
+
+			jQuery.ajax({
+				url:  '/settings/main/ignore-test',
+				data: {
+					'ip':                          $('#ignore-test-ip').val(),
+					'user_agent':                  $('#ignore-test-ua').val(),
+					'path':                        $('#ignore-test-path').val(),
+					'settings.ignore_ips':         $('[name="settings.ignore_ips"]').val(),
+					'settings.ignore_user_agents': $('[name="settings.ignore_user_agents"]').val(),
+					'settings.ignore_paths':       $('[name="settings.ignore_paths"]').val(),
+				},
+				success: function(data) {
+					$('#ignore-test output').text(data)
+				},
+			})
+		})
+
 		// Generate random token.
 		$('#rnd-secret').on('click', function(e) {
 			e.preventDefault()
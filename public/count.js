@@ -14,7 +14,7 @@
 		try         { var set = JSON.parse(s.dataset.goatcounterSettings) }
 		catch (err) { console.error('invalid JSON in data-goatcounter-settings: ' + err) }
 		for (var k in set)
-			if (['no_onload', 'no_events', 'allow_local', 'allow_frame', 'path', 'title', 'referrer', 'event'].indexOf(k) > -1)
+			if (['no_onload', 'no_events', 'allow_local', 'allow_frame', 'path', 'title', 'referrer', 'event', 'props'].indexOf(k) > -1)
 				window.goatcounter[k] = set[k]
 	}
 
@@ -32,6 +32,11 @@
 			q: location.search,
 		}
 
+		var props = (vars.props === undefined ? goatcounter.props : vars.props)
+		if (props && typeof(props) === 'object')
+			for (var k in props)
+				data['props[' + k + ']'] = props[k]
+
 		var rcb, pcb, tcb  // Save callbacks to apply later.
 		if (typeof(data.r) === 'function') rcb = data.r
 		if (typeof(data.t) === 'function') tcb = data.t
@@ -28,8 +28,11 @@
 		let cid  = $('#js-connect-id').text()
 		window.WEBSOCKET = new WebSocket((location.protocol === 'https:' ? 'wss://' : 'ws://') + document.location.host + '/loader?id=' + cid)
 		window.WEBSOCKET.onmessage = function(e) {
-			let msg = JSON.parse(e.data),
-				wid = $(`#dash-widgets div[data-widget=${msg.id}]`)
+			let msg = JSON.parse(e.data)
+			if (msg.live !== undefined)
+				return $(`#dash-widgets .live[data-widget=${msg.live}] .live-rows`).html(msg.html)
+
+			let wid = $(`#dash-widgets div[data-widget=${msg.id}]`)
 			wid.html(msg.html)
 			draw_all_charts()
 
@@ -137,6 +140,7 @@
 		data['period-start'] = $('#period-start').val()
 		data['period-end']   = $('#period-end').val()
 		data['filter']       = $('#filter-paths').val()
+		data['compare']      = $('#compare').val()
 		return data
 	}
 
@@ -166,11 +170,15 @@
 
 	// Fill in start/end periods from buttons.
 	var hdr_select_period = function() {
-		// Reload dashboard when clicking a checkbox.
+		// Reload dashboard when clicking a checkbox or changing the comparison.
 		$('#dash-main input[type="checkbox"]').on('click', function(e) {
 			$('#hl-period').attr('disabled', false)
 			$('#dash-form').trigger('submit')
 		})
+		$('#compare').on('change', function(e) {
+			$('#hl-period').attr('disabled', false)
+			$('#dash-form').trigger('submit')
+		})
 
 		$('#dash-select-period').on('click', 'button', function(e) {
 			e.preventDefault()
@@ -330,6 +338,7 @@
 						name:      'default',
 						filter:    $('#filter-paths').val(),
 						daily:     $('#daily').is(':checked'),
+						compare:   $('#compare').val(),
 						period:    p,
 					},
 					success: () => {
@@ -488,6 +497,16 @@
 		if (isPages && scale)
 			max = scale
 
+		// Annotations, by position in the chart.
+		let annotations = {}
+		JSON.parse(c.dataset.annotations || '[]').forEach((a) => {
+			let d = stats.findIndex((s) => s.day === a.day)
+			if (d === -1)
+				return
+			let i = daily ? d : d*24 + a.hour
+			annotations[i] = (annotations[i] || []).concat(a.text)
+		})
+
 		var data
 		if (daily)
 			data = stats.map((s) => [s.daily]).reduce((a, b) => a.concat(b))
@@ -517,6 +536,21 @@
 					ctx.beginPath()
 					ctx.fillRect(futureFrom, (chart.pad()-1), width, canvas.height/dpr - chart.pad()*2 + 2)
 				}
+
+				// Draw annotations as a dashed vertical line.
+				let dpr = Math.max(1, window.devicePixelRatio || 1)
+				ctx.save()
+				ctx.strokeStyle = style('chart-annotation')
+				ctx.lineWidth   = 1
+				ctx.setLineDash([2, 2])
+				for (let i of Object.keys(annotations)) {
+					let x = Math.round(chart.barWidth() * i + chart.pad() + chart.barWidth() / 2) + .5
+					ctx.beginPath()
+					ctx.moveTo(x, chart.pad())
+					ctx.lineTo(x, canvas.height/dpr - chart.pad())
+					ctx.stroke()
+				}
+				ctx.restore()
 			},
 		})
 		charts.push(chart)
@@ -549,6 +583,8 @@
 				title = `${format_date(day.day)} ${un24(start)} – ${un24(end)}`
 			if (future)
 				title += '; ' + T('dashboard/future')
+			if (annotations[i])
+				title += '<br>' + annotations[i].map((a) => $('<span class="annotation">').text(a)[0].outerHTML).join('<br>')
 			if (!future && !USER_SETTINGS.fewer_numbers) {
 				if (isEvent) {
 					title += '; ' + T('dashboard/tooltip-event', {
@@ -0,0 +1,69 @@
+// Copyright © Martin Tournoij – This file is part of GoatCounter and published
+// under the terms of a slightly modified EUPL v1.2 license, which can be found
+// in the LICENSE file or at https://license.goatcounter.com
+
+package goatcounter
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"syscall"
+	"time"
+
+	"zgo.at/zvalidate"
+)
+
+// PublicTransport is a http.Transport for requests to user-supplied URLs, such
+// as webhooks and alerts.
+//
+// It refuses to connect to loopback, private, and link-local addresses unless
+// AllowPrivateURLs is set in the config on the request context. This is checked
+// for the resolved address, so it also works for hostnames that resolve to an
+// internal address.
+var PublicTransport http.RoundTripper = func() *http.Transport {
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	t.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		d := net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second}
+		if !Config(ctx).AllowPrivateURLs {
+			d.Control = func(network, address string, _ syscall.RawConn) error {
+				host, _, _ := net.SplitHostPort(address)
+				if ip := net.ParseIP(host); ip == nil || !publicIP(ip) {
+					return fmt.Errorf("not allowed to connect to %s", host)
+				}
+				return nil
+			}
+		}
+		return d.DialContext(ctx, network, addr)
+	}
+	return t
+}()
+
+// publicIP reports if this IP is a public internet address.
+func publicIP(ip net.IP) bool {
+	return ip.IsGlobalUnicast() && !ip.IsPrivate()
+}
+
+// validatePublicURL adds an error if the URL's host is a loopback, private, or
+// link-local address, unless AllowPrivateURLs is set.
+//
+// This only checks IP addresses and "localhost"; hostnames are checked when
+// connecting by PublicTransport.
+func validatePublicURL(ctx context.Context, v *zvalidate.Validator, key, u string) {
+	if Config(ctx).AllowPrivateURLs {
+		return
+	}
+	p, err := url.Parse(u)
+	if err != nil {
+		return
+	}
+
+	host := strings.ToLower(p.Hostname())
+	if ip := net.ParseIP(host); (ip != nil && !publicIP(ip)) ||
+		host == "localhost" || strings.HasSuffix(host, ".localhost") {
+		v.Append(key, "must not be a loopback, private, or link-local address")
+	}
+}
@@ -0,0 +1,97 @@
+// Copyright © Martin Tournoij – This file is part of GoatCounter and published
+// under the terms of a slightly modified EUPL v1.2 license, which can be found
+// in the LICENSE file or at https://license.goatcounter.com
+
+package goatcounter
+
+import (
+	"context"
+
+	"zgo.at/errors"
+	"zgo.at/zdb"
+	"zgo.at/zstd/ztime"
+)
+
+// The server response time and status are only known for pageviews imported
+// from logfiles; pageviews sent from the browser don't have them and are never
+// included here.
+
+type (
+	// ResponseTimes is the server response time per path, slowest first.
+	ResponseTimes struct {
+		More  bool           `json:"more"`
+		Stats []ResponseTime `json:"stats"`
+	}
+
+	ResponseTime struct {
+		PathID int64   `db:"path_id" json:"path_id"`
+		Path   string  `db:"path" json:"path"`
+		Count  int     `db:"count" json:"count"` // Number of pageviews with a response time.
+		P50    float64 `db:"-" json:"p50"`       // Median response time in milliseconds.
+		P95    float64 `db:"-" json:"p95"`       // 95th percentile response time in milliseconds.
+
+		P50Micro int64 `db:"p50" json:"-"`
+		P95Micro int64 `db:"p95" json:"-"`
+	}
+
+	// ErrorPages is the number of 4xx and 5xx responses per path and status,
+	// most frequent first.
+	ErrorPages struct {
+		More  bool        `json:"more"`
+		Stats []ErrorPage `json:"stats"`
+	}
+
+	ErrorPage struct {
+		PathID int64  `db:"path_id" json:"path_id"`
+		Path   string `db:"path" json:"path"`
+		Status int    `db:"status" json:"status"`
+		Count  int    `db:"count" json:"count"`
+	}
+)
+
+// List the response times for the given time period.
+//
+// The percentiles are calculated with the nearest-rank method from all
+// pageviews with a response time. Paths are ordered by the 95th percentile.
+func (r *ResponseTimes) List(ctx context.Context, rng ztime.Range, pathFilter []int64, limit, offset int) error {
+	err := zdb.Select(ctx, &r.Stats, "load:response.Times", zdb.P{
+		"site":   MustGetSite(ctx).ID,
+		"start":  rng.Start,
+		"end":    rng.End,
+		"filter": pathFilter,
+		"limit":  limit + 1,
+		"offset": offset,
+	})
+	if err != nil {
+		return errors.Wrap(err, "ResponseTimes.List")
+	}
+	if len(r.Stats) > limit {
+		r.More = true
+		r.Stats = r.Stats[:len(r.Stats)-1]
+	}
+	for i := range r.Stats {
+		r.Stats[i].P50 = float64(r.Stats[i].P50Micro) / 1000
+		r.Stats[i].P95 = float64(r.Stats[i].P95Micro) / 1000
+	}
+	return nil
+}
+
+// List the paths that had a 4xx or 5xx response in the given time period.
+func (e *ErrorPages) List(ctx context.Context, rng ztime.Range, pathFilter []int64, limit, offset int) error {
+	err := zdb.Select(ctx, &e.Stats, "load:response.Errors", zdb.P{
+		"site":   MustGetSite(ctx).ID,
+		"start":  rng.Start,
+		"end":    rng.End,
+		"filter": pathFilter,
+		"limit":  limit + 1,
+		"offset": offset,
+	})
+	if err != nil {
+		return errors.Wrap(err, "ErrorPages.List")
+	}
+	if len(e.Stats) > limit {
+		e.More = true
+		e.Stats = e.Stats[:len(e.Stats)-1]
+	}
+	return nil
+}
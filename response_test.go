@@ -0,0 +1,81 @@
+// Copyright © Martin Tournoij – This file is part of GoatCounter and published
+// under the terms of a slightly modified EUPL v1.2 license, which can be found
+// in the LICENSE file or at https://license.goatcounter.com
+
+package goatcounter_test
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	. "zgo.at/goatcounter/v2"
+	"zgo.at/goatcounter/v2/gctest"
+	"zgo.at/zstd/ztime"
+	"zgo.at/zstd/ztype"
+)
+
+func TestResponseTimes(t *testing.T) {
+	ctx := gctest.DB(t)
+
+	now := time.Date(2019, 8, 31, 14, 42, 0, 0, time.UTC)
+	var hits []Hit
+	for i := 1; i <= 20; i++ {
+		hits = append(hits,
+			Hit{Path: "/a", CreatedAt: now, ResponseTime: ztype.Ptr(int64(i * 1000))},
+			Hit{Path: "/b", CreatedAt: now, ResponseTime: ztype.Ptr(int64(i * 10))})
+	}
+	hits = append(hits,
+		Hit{Path: "/c", CreatedAt: now, ResponseTime: ztype.Ptr(int64(0))},
+		Hit{Path: "/d", CreatedAt: now}, // No response time.
+		Hit{Path: "/a", CreatedAt: now, Bot: 3, ResponseTime: ztype.Ptr(int64(100_000))})
+	gctest.StoreHits(ctx, t, false, hits...)
+
+	rng := ztime.NewRange(now.Add(-time.Hour)).To(now.Add(time.Hour))
+	list := func(limit int) string {
+		var rt ResponseTimes
+		err := rt.List(ctx, rng, nil, limit, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		have := fmt.Sprintf("more=%t", rt.More)
+		for _, s := range rt.Stats {
+			have += fmt.Sprintf(" %s:%d:%g:%g", s.Path, s.Count, s.P50, s.P95)
+		}
+		return have
+	}
+
+	if have, want := list(5), "more=false /a:20:10:19 /b:20:0.1:0.19 /c:1:0:0"; have != want {
+		t.Errorf("\nhave: %s\nwant: %s", have, want)
+	}
+	if have, want := list(1), "more=true /a:20:10:19"; have != want {
+		t.Errorf("\nhave: %s\nwant: %s", have, want)
+	}
+}
+
+func TestErrorPages(t *testing.T) {
+	ctx := gctest.DB(t)
+
+	now := time.Date(2019, 8, 31, 14, 42, 0, 0, time.UTC)
+	gctest.StoreHits(ctx, t, false,
+		Hit{Path: "/a", CreatedAt: now, Status: 200},
+		Hit{Path: "/a", CreatedAt: now, Status: 500},
+		Hit{Path: "/a", CreatedAt: now, Status: 404},
+		Hit{Path: "/a", CreatedAt: now, Status: 404},
+		Hit{Path: "/b", CreatedAt: now, Status: 404},
+		Hit{Path: "/c", CreatedAt: now},
+	)
+
+	var ep ErrorPages
+	err := ep.List(ctx, ztime.NewRange(now.Add(-time.Hour)).To(now.Add(time.Hour)), nil, 10, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	have := fmt.Sprintf("more=%t", ep.More)
+	for _, s := range ep.Stats {
+		have += fmt.Sprintf(" %s:%d=%d", s.Path, s.Status, s.Count)
+	}
+	if want := "more=false /a:404=2 /a:500=1 /b:404=1"; have != want {
+		t.Errorf("\nhave: %s\nwant: %s", have, want)
+	}
+}
@@ -18,6 +18,7 @@ import (
 	"zgo.at/json"
 	"zgo.at/tz"
 	"zgo.at/z18n"
+	"zgo.at/zlog"
 	"zgo.at/zstd/zint"
 	"zgo.at/zstd/zjson"
 	"zgo.at/zvalidate"
@@ -60,16 +61,21 @@ type (
 	//
 	// This is stored as JSON in the database.
 	SiteSettings struct {
-		Public         string         `json:"public"`
-		Secret         string         `json:"secret"`
-		AllowCounter   bool           `json:"allow_counter"`
-		AllowBosmang   bool           `json:"allow_bosmang"`
-		DataRetention  int            `json:"data_retention"`
-		Campaigns      Strings        `json:"-"`
-		IgnoreIPs      Strings        `json:"ignore_ips"`
-		Collect        zint.Bitflag16 `json:"collect"`
-		CollectRegions Strings        `json:"collect_regions"`
-		AllowEmbed     Strings        `json:"allow_embed"`
+		Public           string         `json:"public"`
+		Secret           string         `json:"secret"`
+		AllowCounter     bool           `json:"allow_counter"`
+		AllowBosmang     bool           `json:"allow_bosmang"`
+		AllowIgnoreMe    bool           `json:"allow_ignore_me"`
+		DataRetention    int            `json:"data_retention"`
+		Campaigns        Strings        `json:"-"`
+		IgnoreIPs        Strings        `json:"ignore_ips"`
+		IgnoreUserAgents Lines          `json:"ignore_user_agents"`
+		IgnorePaths      Lines          `json:"ignore_paths"`
+		Collect          zint.Bitflag16 `json:"collect"`
+		CollectRegions   Strings        `json:"collect_regions"`
+		AllowEmbed       Strings        `json:"allow_embed"`
+		PropKeys         int            `json:"prop_keys"`
+		PropValues       int            `json:"prop_values"`
 	}
 
 	// UserSettings are all user preferences.
@@ -108,10 +114,11 @@ type (
 	// configurable in the yellow box at the top.
 	Views []View
 	View  struct {
-		Name   string `json:"name"`
-		Filter string `json:"filter"`
-		Daily  bool   `json:"daily"`
-		Period string `json:"period"` // "week", "week-cur", or n days: "8"
+		Name    string `json:"name"`
+		Filter  string `json:"filter"`
+		Daily   bool   `json:"daily"`
+		Period  string `json:"period"`  // "week", "week-cur", or n days: "8"
+		Compare string `json:"compare"` // "", "previous", or "year"
 	}
 )
 
@@ -298,6 +305,87 @@ func defaultWidgetSettings(ctx context.Context) map[string]WidgetSettings {
 			},
 			"key": WidgetSetting{Hidden: true},
 		},
+		"props": map[string]WidgetSetting{
+			"limit": WidgetSetting{
+				Type:  "number",
+				Label: z18n.T(ctx, "widget-setting/label/page-size|Page size"),
+				Help:  z18n.T(ctx, "widget-setting/help/page-size|Number of pages to load"),
+				Value: float64(6),
+				Validate: func(v *zvalidate.Validator, val any) {
+					v.Range("limit", int64(val.(float64)), 1, 20)
+				},
+			},
+			"key": WidgetSetting{Hidden: true},
+		},
+		"goals":   map[string]WidgetSetting{},
+		"live":    map[string]WidgetSetting{},
+		"heatmap": map[string]WidgetSetting{},
+		"entry": map[string]WidgetSetting{
+			"limit": WidgetSetting{
+				Type:  "number",
+				Label: z18n.T(ctx, "widget-setting/label/page-size|Page size"),
+				Help:  z18n.T(ctx, "widget-setting/help/page-size|Number of pages to load"),
+				Value: float64(6),
+				Validate: func(v *zvalidate.Validator, val any) {
+					v.Range("limit", int64(val.(float64)), 1, 20)
+				},
+			},
+		},
+		"exit": map[string]WidgetSetting{
+			"limit": WidgetSetting{
+				Type:  "number",
+				Label: z18n.T(ctx, "widget-setting/label/page-size|Page size"),
+				Help:  z18n.T(ctx, "widget-setting/help/page-size|Number of pages to load"),
+				Value: float64(6),
+				Validate: func(v *zvalidate.Validator, val any) {
+					v.Range("limit", int64(val.(float64)), 1, 20)
+				},
+			},
+		},
+		"timing": map[string]WidgetSetting{
+			"limit": WidgetSetting{
+				Type:  "number",
+				Label: z18n.T(ctx, "widget-setting/label/page-size|Page size"),
+				Help:  z18n.T(ctx, "widget-setting/help/page-size|Number of pages to load"),
+				Value: float64(6),
+				Validate: func(v *zvalidate.Validator, val any) {
+					v.Range("limit", int64(val.(float64)), 1, 20)
+				},
+			},
+		},
+		"errors": map[string]WidgetSetting{
+			"limit": WidgetSetting{
+				Type:  "number",
+				Label: z18n.T(ctx, "widget-setting/label/page-size|Page size"),
+				Help:  z18n.T(ctx, "widget-setting/help/page-size|Number of pages to load"),
+				Value: float64(6),
+				Validate: func(v *zvalidate.Validator, val any) {
+					v.Range("limit", int64(val.(float64)), 1, 20)
+				},
+			},
+		},
+		"funnels": map[string]WidgetSetting{
+			"funnel": WidgetSetting{
+				Type:  "select",
+				Label: z18n.T(ctx, "widget-setting/label/funnel|Funnel"),
+				Help:  z18n.T(ctx, "widget-setting/help/funnel|Funnel to show; add funnels in the site settings"),
+				Value: "",
+				OptionsFunc: func(ctx context.Context) [][2]string {
+					var f Funnels
+					err := f.List(ctx)
+					if err != nil {
+						zlog.Module("settings").Error(err)
+						return nil
+					}
+					funnels := make([][2]string, 0, len(f)+1)
+					funnels = append(funnels, [2]string{"", ""})
+					for _, ff := range f {
+						funnels = append(funnels, [2]string{strconv.FormatInt(ff.ID, 10), ff.Name})
+					}
+					return funnels
+				},
+			},
+		},
 	}
 }
 
@@ -354,6 +442,12 @@ func (ss *SiteSettings) Defaults(ctx context.Context) {
 	if ss.CollectRegions == nil {
 		ss.CollectRegions = []string{"US", "RU", "CN"}
 	}
+	if ss.PropKeys == 0 {
+		ss.PropKeys = 20
+	}
+	if ss.PropValues == 0 {
+		ss.PropValues = 100
+	}
 }
 
 func (ss *SiteSettings) Validate(ctx context.Context) error {
@@ -369,11 +463,10 @@ func (ss *SiteSettings) Validate(ctx context.Context) error {
 		v.Range("data_retention", int64(ss.DataRetention), 31, 0)
 	}
 
-	if len(ss.IgnoreIPs) > 0 {
-		for _, ip := range ss.IgnoreIPs {
-			v.IP("ignore_ips", ip)
-		}
-	}
+	ss.ValidateIgnore(&v)
+	v.Range("prop_keys", int64(ss.PropKeys), 1, 100)
+	v.Range("prop_values", int64(ss.PropValues), 1, 1000)
+
 	if len(ss.AllowEmbed) > 0 {
 		for _, d := range ss.AllowEmbed {
 			if d == "*" {
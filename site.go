@@ -535,7 +535,7 @@ func (s Site) IDOrParent() int64 {
 // user intact.
 func (s Site) DeleteAll(ctx context.Context) error {
 	return zdb.TX(ctx, func(ctx context.Context) error {
-		for _, t := range append(statTables, "campaign_stats", "hit_counts", "ref_counts", "hits", "paths") {
+		for _, t := range append(statTables, "campaign_stats", "prop_stats", "hit_counts", "ref_counts", "hits", "paths", "import_hashes") {
 			err := zdb.Exec(ctx, `delete from `+t+` where site_id=:id`, zdb.P{"id": s.ID})
 			if err != nil {
 				return errors.Wrap(err, "Site.DeleteAll: delete "+t)
@@ -562,7 +562,7 @@ func (s Site) DeleteOlderThan(ctx context.Context, days int) error {
 			return errors.Wrap(err, "Site.DeleteOlderThan: get paths")
 		}
 
-		for _, t := range append(statTables, "campaign_stats") {
+		for _, t := range append(statTables, "campaign_stats", "prop_stats") {
 			err := zdb.Exec(ctx, `delete from `+t+` where site_id=$1 and day < `+ival, s.ID)
 			if err != nil {
 				return errors.Wrap(err, "Site.DeleteOlderThan: delete "+t)
@@ -582,6 +582,10 @@ func (s Site) DeleteOlderThan(ctx context.Context, days int) error {
 		if err != nil {
 			return errors.Wrap(err, "Site.DeleteOlderThan: delete hits")
 		}
+		err = zdb.Exec(ctx, `delete from import_hashes where site_id=$1 and created_at < `+ival, s.ID)
+		if err != nil {
+			return errors.Wrap(err, "Site.DeleteOlderThan: delete import_hashes")
+		}
 
 		if len(pathIDs) > 0 {
 			var remainPath []int64
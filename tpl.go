@@ -489,6 +489,10 @@ func HorizontalChart(ctx context.Context, stats HitStats, total int, link, pagin
 			ncol = tplfunc.Number(s.Count, user.Settings.NumberFormat)
 		}
 
+		if !user.Settings.FewerNumbers && s.Prev != nil {
+			ncol += compareCol(ctx, s.Count, *s.Prev, user.Settings.NumberFormat)
+		}
+
 		id := s.ID
 		if id == "" {
 			id = name
@@ -516,6 +520,30 @@ func HorizontalChart(ctx context.Context, stats HitStats, total int, link, pagin
 	return template.HTML(b.String())
 }
 
+// compareCol gets the change from prev to n and the previous value, for display
+// below the count in horizontal_chart.
+func compareCol(ctx context.Context, n, prev int, nformat rune) string {
+	var (
+		class, diff string
+		title       = z18n.T(ctx, "tooltip/change-compare|Change and number in the period compared to")
+	)
+	switch {
+	case n == prev:
+		diff = "0%"
+	case prev == 0:
+		class, diff = "plus", "<i>"+z18n.T(ctx, "new-paren|(new)")+"</i>"
+	default:
+		d := float64(n-prev) / float64(prev) * 100
+		class, diff = "plus", "+"
+		if d < 0 {
+			class, diff = "minus", "–"
+		}
+		diff += fmt.Sprintf("%.0f%%", max(math.Round(math.Abs(d)), 1))
+	}
+	return fmt.Sprintf(`<br><small class="col-count-diff %s" title="%s">%s<br>%s</small>`,
+		class, template.HTMLEscapeString(title), diff, tplfunc.Number(prev, nformat))
+}
+
 type (
 	TplEmailWelcome struct {
 		Context     context.Context
@@ -555,10 +583,12 @@ type (
 		Export  Export
 	}
 	TplEmailImportDone struct {
-		Context context.Context
-		Site    Site
-		Rows    int
-		Errors  *errors.Group
+		Context    context.Context
+		Site       Site
+		Rows       int
+		Errors     *errors.Group
+		Duplicates int
+		Aggregates bool
 	}
 )
 
@@ -48,8 +48,10 @@ func TestTpl(t *testing.T) {
 		{TplEmailPasswordReset{ctx, site, user}},
 		{TplEmailVerify{ctx, site, user}},
 		{TplEmailImportError{ctx, errors.Unwrap(errors.New("oh noes"))}},
-		{TplEmailImportDone{ctx, site, 42, errors.NewGroup(10)}},
-		{TplEmailImportDone{ctx, site, 42, errs}},
+		{TplEmailImportDone{ctx, site, 42, errors.NewGroup(10), 0, false}},
+		{TplEmailImportDone{ctx, site, 42, errs, 0, false}},
+		{TplEmailImportDone{ctx, site, 42, errs, 3, false}},
+		{TplEmailImportDone{ctx, site, 42, errs, 0, true}},
 		{TplEmailAddUser{ctx, site, user, "foo@example.com"}},
 
 		{TplEmailExportDone{ctx, site, user, Export{
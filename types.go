@@ -113,3 +113,34 @@ func (l Strings) MarshalText() ([]byte, error) {
 	v, err := l.Value()
 	return []byte(fmt.Sprintf("%s", v)), err
 }
+
+// Lines stores a slice of []string as a newline-separated string, for values
+// that may contain commas or spaces.
+type Lines []string
+
+func (l Lines) String() string                { return strings.Join(l, "\n") }
+func (l Lines) Value() (driver.Value, error)  { return l.String(), nil }
+func (l *Lines) UnmarshalText(v []byte) error { return l.Scan(v) }
+
+func (l *Lines) Scan(v any) error {
+	if v == nil {
+		return nil
+	}
+
+	split := strings.Split(fmt.Sprintf("%s", v), "\n")
+	strs := make([]string, 0, len(split))
+	for _, s := range split {
+		s = strings.TrimSpace(s)
+		if s == "" {
+			continue
+		}
+		strs = append(strs, s)
+	}
+	*l = strs
+	return nil
+}
+
+func (l Lines) MarshalText() ([]byte, error) {
+	v, err := l.Value()
+	return []byte(fmt.Sprintf("%s", v)), err
+}
@@ -244,3 +244,40 @@ func TestStrings(t *testing.T) {
 		}
 	})
 }
+
+func TestLines(t *testing.T) {
+	cases := []struct {
+		in   string
+		want Lines
+	}{
+		{"", Lines{}},
+		{"a b, c", Lines{"a b, c"}},
+		{"a\n\n b \r\nc\n", Lines{"a", "b", "c"}},
+	}
+
+	for _, tc := range cases {
+		t.Run("", func(t *testing.T) {
+			out := Lines{}
+			err := out.Scan(tc.in)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(out, tc.want) {
+				t.Errorf("\nout:  %#v\nwant: %#v\n", out, tc.want)
+			}
+
+			v, err := out.MarshalText()
+			if err != nil {
+				t.Fatal(err)
+			}
+			var back Lines
+			err = back.UnmarshalText(v)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(out) > 0 && !reflect.DeepEqual(back, out) {
+				t.Errorf("\nback: %#v\nwant: %#v\n", back, out)
+			}
+		})
+	}
+}
@@ -0,0 +1,340 @@
+// Copyright © Martin Tournoij – This file is part of GoatCounter and published
+// under the terms of a slightly modified EUPL v1.2 license, which can be found
+// in the LICENSE file or at https://license.goatcounter.com
+
+package goatcounter
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql/driver"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"zgo.at/bgrun"
+	"zgo.at/errors"
+	"zgo.at/json"
+	"zgo.at/zdb"
+	"zgo.at/zlog"
+	"zgo.at/zstd/zcrypto"
+	"zgo.at/zstd/zjson"
+	"zgo.at/zstd/ztime"
+)
+
+// Webhook events.
+const (
+	WebhookExportDone  = "export.done"  // Export finished; data is the Export.
+	WebhookImportDone  = "import.done"  // Import finished; data is the number of rows and errors.
+	WebhookSiteCreated = "site.created" // New site was created; data is the Site.
+	WebhookUserAdded   = "user.added"   // New user was added; data is the User.
+)
+
+var WebhookEventList = []string{WebhookExportDone, WebhookImportDone, WebhookSiteCreated, WebhookUserAdded}
+
+// Retry failed deliveries after these delays; the first entry is for the
+// initial attempt, which is made right away but may never happen if the process
+// exits. The delivery is given up after the last retry fails.
+var webhookBackoff = []time.Duration{
+	1 * time.Minute, 5 * time.Minute, 30 * time.Minute, 2 * time.Hour, 12 * time.Hour}
+
+var webhookClient = http.Client{Timeout: 10 * time.Second, Transport: PublicTransport}
+
+// Webhook is an endpoint that gets a POST request when an event happens.
+//
+// The body is signed with Secret; the HMAC-SHA256 is sent in the
+// X-Goatcounter-Signature header (see SignWebhook).
+type Webhook struct {
+	ID     int64 `db:"webhook_id" json:"id,readonly"`
+	SiteID int64 `db:"site_id" json:"site_id,readonly"`
+
+	URL    string        `db:"url" json:"url"`
+	Secret string        `db:"secret" json:"secret,readonly"`
+	Events WebhookEvents `db:"events" json:"events"`
+
+	CreatedAt time.Time `db:"created_at" json:"created_at,readonly"`
+}
+
+// WebhookEvents are the events a webhook is sent for; this is stored as JSON
+// in the database.
+type WebhookEvents []string
+
+func (w WebhookEvents) Value() (driver.Value, error) { return json.Marshal([]string(w)) }
+func (w *WebhookEvents) Scan(v any) error {
+	switch vv := v.(type) {
+	case []byte:
+		return json.Unmarshal(vv, (*[]string)(w))
+	case string:
+		return json.Unmarshal([]byte(vv), (*[]string)(w))
+	default:
+		return fmt.Errorf("WebhookEvents.Scan: unsupported type: %T", v)
+	}
+}
+
+// Defaults sets fields to default values, unless they're already set.
+func (w *Webhook) Defaults(ctx context.Context) {
+	if s := GetSite(ctx); s != nil && s.ID > 0 {
+		w.SiteID = s.ID
+	}
+	if w.CreatedAt.IsZero() {
+		w.CreatedAt = ztime.Now()
+	}
+	if w.Secret == "" {
+		w.Secret = zcrypto.Secret256()
+	}
+	w.URL = strings.TrimSpace(w.URL)
+}
+
+func (w *Webhook) Validate(ctx context.Context) error {
+	v := NewValidate(ctx)
+	v.Required("site_id", w.SiteID)
+	v.Required("url", w.URL)
+	v.URL("url", w.URL)
+	v.Len("url", w.URL, 0, 2048)
+	validatePublicURL(ctx, &v, "url", w.URL)
+	if len(w.Events) == 0 {
+		v.Append("events", "must be set")
+	}
+	for _, e := range w.Events {
+		v.Include("events", e, WebhookEventList)
+	}
+	return v.ErrorOrNil()
+}
+
+// Insert a new row.
+func (w *Webhook) Insert(ctx context.Context) error {
+	if w.ID > 0 {
+		return errors.New("ID > 0")
+	}
+
+	w.Defaults(ctx)
+	err := w.Validate(ctx)
+	if err != nil {
+		return err
+	}
+
+	w.ID, err = zdb.InsertID(ctx, "webhook_id",
+		`insert into webhooks (site_id, url, secret, events, created_at) values (?)`,
+		zdb.L{w.SiteID, w.URL, w.Secret, w.Events, w.CreatedAt})
+	return errors.Wrap(err, "Webhook.Insert")
+}
+
+func (w *Webhook) ByID(ctx context.Context, id int64) error {
+	return errors.Wrapf(zdb.Get(ctx, w, `/* Webhook.ByID */
+		select * from webhooks where webhook_id=$1 and site_id=$2`,
+		id, MustGetSite(ctx).ID), "Webhook.ByID %d", id)
+}
+
+// Delete the webhook and its delivery log.
+func (w *Webhook) Delete(ctx context.Context) error {
+	err := zdb.TX(ctx, func(ctx context.Context) error {
+		err := zdb.Exec(ctx, `/* Webhook.Delete */
+			delete from webhook_deliveries where webhook_id=$1 and site_id=$2`,
+			w.ID, MustGetSite(ctx).ID)
+		if err != nil {
+			return err
+		}
+		return zdb.Exec(ctx, `/* Webhook.Delete */
+			delete from webhooks where webhook_id=$1 and site_id=$2`,
+			w.ID, MustGetSite(ctx).ID)
+	})
+	return errors.Wrapf(err, "Webhook.Delete %d", w.ID)
+}
+
+type Webhooks []Webhook
+
+// List all webhooks for this site.
+func (w *Webhooks) List(ctx context.Context) error {
+	return errors.Wrap(zdb.Select(ctx, w,
+		`select * from webhooks where site_id=$1 order by webhook_id`,
+		MustGetSite(ctx).ID), "Webhooks.List")
+}
+
+// WebhookPayload is the JSON body sent to webhooks.
+type WebhookPayload struct {
+	Event     string    `json:"event"`
+	Site      string    `json:"site"`
+	CreatedAt time.Time `json:"created_at"`
+	Data      any       `json:"data"`
+}
+
+// SendWebhook sends the event to all webhooks for the current site that are
+// registered for it.
+//
+// The deliveries are recorded in the database and sent in the background;
+// errors are logged but not returned, as the event already happened and
+// there's nothing the caller can do about it.
+func SendWebhook(ctx context.Context, event string, data any) {
+	l := zlog.Module("webhook").Field("event", event)
+
+	var hooks Webhooks
+	err := hooks.List(ctx)
+	if err != nil {
+		l.Error(err)
+		return
+	}
+
+	site := MustGetSite(ctx)
+	for _, hook := range hooks {
+		if !slices.Contains(hook.Events, event) {
+			continue
+		}
+
+		d := WebhookDelivery{
+			WebhookID: hook.ID,
+			SiteID:    site.ID,
+			Event:     event,
+			Payload: string(zjson.MustMarshal(WebhookPayload{
+				Event:     event,
+				Site:      site.URL(ctx),
+				CreatedAt: ztime.Now(),
+				Data:      data,
+			})),
+		}
+		err := d.Insert(ctx)
+		if err != nil {
+			l.Field("webhook", hook.ID).Error(err)
+			continue
+		}
+
+		hook := hook
+		ctx := CopyContextValues(ctx)
+		bgrun.RunFunction(fmt.Sprintf("webhook:%d", hook.ID), func() {
+			err := d.Deliver(ctx, hook)
+			if err != nil {
+				l.Field("webhook", hook.ID).Error(err)
+			}
+		})
+	}
+}
+
+// SignWebhook gets the signature for the webhook body, as "sha256=" followed by
+// the hex-encoded HMAC-SHA256 of the body.
+func SignWebhook(secret string, body []byte) string {
+	m := hmac.New(sha256.New, []byte(secret))
+	m.Write(body)
+	return "sha256=" + hex.EncodeToString(m.Sum(nil))
+}
+
+// WebhookDelivery is a webhook request that was sent (or will be sent).
+type WebhookDelivery struct {
+	ID        int64  `db:"webhook_delivery_id" json:"id"`
+	WebhookID int64  `db:"webhook_id" json:"webhook_id"`
+	SiteID    int64  `db:"site_id" json:"site_id"`
+	Event     string `db:"event" json:"event"`
+	Payload   string `db:"payload" json:"payload"`
+
+	// Number of delivery attempts so far.
+	Attempts int `db:"attempts" json:"attempts"`
+
+	// HTTP status code and error of the last attempt.
+	Status int    `db:"status" json:"status"`
+	Error  string `db:"error" json:"error"`
+
+	// Next retry; nil if it was delivered or if we gave up.
+	NextAttemptAt *time.Time `db:"next_attempt_at" json:"next_attempt_at"`
+	DeliveredAt   *time.Time `db:"delivered_at" json:"delivered_at"`
+	CreatedAt     time.Time  `db:"created_at" json:"created_at"`
+}
+
+// Insert a new row.
+func (d *WebhookDelivery) Insert(ctx context.Context) error {
+	if d.ID > 0 {
+		return errors.New("ID > 0")
+	}
+	if d.CreatedAt.IsZero() {
+		d.CreatedAt = ztime.Now()
+	}
+	// The first attempt is made right away; this makes sure it's picked up by
+	// the cron if that never happens, for example because the process exited.
+	next := d.CreatedAt.Add(webhookBackoff[0])
+	d.NextAttemptAt = &next
+
+	var err error
+	d.ID, err = zdb.InsertID(ctx, "webhook_delivery_id",
+		`insert into webhook_deliveries (webhook_id, site_id, event, payload, next_attempt_at, created_at) values (?)`,
+		zdb.L{d.WebhookID, d.SiteID, d.Event, d.Payload, d.NextAttemptAt, d.CreatedAt})
+	return errors.Wrap(err, "WebhookDelivery.Insert")
+}
+
+// Deliver the payload to the webhook, and record the result.
+//
+// A retry is scheduled if this fails, until the backoff is exhausted.
+func (d *WebhookDelivery) Deliver(ctx context.Context, hook Webhook) error {
+	d.Attempts++
+	d.Status, d.Error = 0, ""
+	d.NextAttemptAt, d.DeliveredAt = nil, nil
+
+	sendErr := d.send(ctx, hook)
+	now := ztime.Now()
+	if sendErr == nil {
+		d.DeliveredAt = &now
+	} else {
+		d.Error = sendErr.Error()
+		if d.Attempts < len(webhookBackoff) {
+			next := now.Add(webhookBackoff[d.Attempts])
+			d.NextAttemptAt = &next
+		}
+	}
+
+	err := zdb.Exec(ctx, `update webhook_deliveries set
+			attempts=$1, status=$2, error=$3, next_attempt_at=$4, delivered_at=$5
+		where webhook_delivery_id=$6`,
+		d.Attempts, d.Status, d.Error, d.NextAttemptAt, d.DeliveredAt, d.ID)
+	if err != nil {
+		return errors.Wrap(err, "WebhookDelivery.Deliver")
+	}
+	return errors.Wrap(sendErr, "WebhookDelivery.Deliver")
+}
+
+func (d *WebhookDelivery) send(ctx context.Context, hook Webhook) error {
+	body := []byte(d.Payload)
+	req, err := http.NewRequestWithContext(ctx, "POST", hook.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "GoatCounter")
+	req.Header.Set("X-Goatcounter-Event", d.Event)
+	req.Header.Set("X-Goatcounter-Delivery", strconv.FormatInt(d.ID, 10))
+	req.Header.Set("X-Goatcounter-Signature", SignWebhook(hook.Secret, body))
+
+	resp, err := webhookClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, resp.Body)
+
+	d.Status = resp.StatusCode
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("%s: %s", hook.URL, resp.Status)
+	}
+	return nil
+}
+
+type WebhookDeliveries []WebhookDelivery
+
+// List the 50 most recent deliveries for a webhook.
+func (d *WebhookDeliveries) List(ctx context.Context, webhookID int64) error {
+	return errors.Wrap(zdb.Select(ctx, d, `/* WebhookDeliveries.List */
+		select * from webhook_deliveries where webhook_id=$1 and site_id=$2
+		order by created_at desc, webhook_delivery_id desc limit 50`,
+		webhookID, MustGetSite(ctx).ID), "WebhookDeliveries.List")
+}
+
+// UnscopedListRetry lists all deliveries for all sites that should be retried
+// at or before now.
+func (d *WebhookDeliveries) UnscopedListRetry(ctx context.Context, now time.Time) error {
+	return errors.Wrap(zdb.Select(ctx, d, `/* WebhookDeliveries.UnscopedListRetry */
+		select * from webhook_deliveries where next_attempt_at <= $1
+		order by site_id, webhook_delivery_id`,
+		now), "WebhookDeliveries.UnscopedListRetry")
+}
@@ -0,0 +1,123 @@
+// Copyright © Martin Tournoij – This file is part of GoatCounter and published
+// under the terms of a slightly modified EUPL v1.2 license, which can be found
+// in the LICENSE file or at https://license.goatcounter.com
+
+package goatcounter_test
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"zgo.at/bgrun"
+	. "zgo.at/goatcounter/v2"
+	"zgo.at/goatcounter/v2/gctest"
+	"zgo.at/zstd/ztest"
+	"zgo.at/zstd/ztime"
+)
+
+func TestWebhook(t *testing.T) {
+	ctx := gctest.DB(t)
+	ztime.SetNow(t, "2020-06-18 12:13:14")
+
+	var (
+		status = 200
+		bodies []string
+		header []http.Header
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		bodies = append(bodies, string(b))
+		header = append(header, r.Header)
+		w.WriteHeader(status)
+	}))
+	defer srv.Close()
+
+	{
+		hook := Webhook{URL: srv.URL, Events: WebhookEvents{WebhookExportDone}}
+		err := hook.Insert(ctx)
+		if !ztest.ErrorContains(err, "url: must not be a loopback") {
+			t.Fatalf("wrong error: %v", err)
+		}
+	}
+
+	Config(ctx).AllowPrivateURLs = true // httptest server is on localhost.
+	{
+		hook := Webhook{URL: srv.URL, Events: WebhookEvents{"nope"}}
+		err := hook.Insert(ctx)
+		if !ztest.ErrorContains(err, "events: must be one of") {
+			t.Fatalf("wrong error: %v", err)
+		}
+	}
+
+	hook := Webhook{URL: srv.URL, Events: WebhookEvents{WebhookExportDone, WebhookUserAdded}}
+	err := hook.Insert(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	send := func(event string, data any) {
+		t.Helper()
+		SendWebhook(ctx, event, data)
+		bgrun.Wait("")
+	}
+
+	send(WebhookSiteCreated, map[string]int{"x": 1})
+	if len(bodies) != 0 {
+		t.Fatalf("sent event the webhook isn't registered for: %v", bodies)
+	}
+
+	send(WebhookExportDone, map[string]int{"id": 1})
+	if len(bodies) != 1 {
+		t.Fatalf("len(bodies) = %d", len(bodies))
+	}
+	want := `{"event": "export.done", "site": "https://gctest.test", "created_at": "2020-06-18T12:13:14Z", "data": {"id": 1}}`
+	if d := ztest.Diff(bodies[0], want, ztest.DiffJSON); d != "" {
+		t.Error(d)
+	}
+	if have, want := header[0].Get("X-Goatcounter-Signature"), SignWebhook(hook.Secret, []byte(bodies[0])); have != want {
+		t.Errorf("wrong signature\nhave: %s\nwant: %s", have, want)
+	}
+	if have := header[0].Get("X-Goatcounter-Event"); have != "export.done" {
+		t.Errorf("wrong event header: %q", have)
+	}
+
+	status = 500
+	send(WebhookUserAdded, map[string]int{"id": 2})
+
+	var log WebhookDeliveries
+	err = log.List(ctx, hook.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(log) != 2 {
+		t.Fatalf("len(log) = %d", len(log))
+	}
+
+	failed, ok := log[0], log[1]
+	if failed.Event != WebhookUserAdded {
+		failed, ok = ok, failed
+	}
+	if ok.DeliveredAt == nil || ok.NextAttemptAt != nil || ok.Status != 200 || ok.Attempts != 1 {
+		t.Errorf("wrong delivery: %#v", ok)
+	}
+	if failed.DeliveredAt != nil || failed.Status != 500 || failed.Attempts != 1 ||
+		failed.NextAttemptAt == nil || !failed.NextAttemptAt.Equal(ztime.Now().Add(5*time.Minute)) {
+		t.Errorf("wrong delivery: %#v", failed)
+	}
+
+	// Also refused when connecting, in case a hostname resolves to a private
+	// address.
+	Config(ctx).AllowPrivateURLs = false
+	PublicTransport.(*http.Transport).CloseIdleConnections()
+	status = 200
+	err = failed.Deliver(ctx, hook)
+	if !ztest.ErrorContains(err, "not allowed to connect to 127.0.0.1") {
+		t.Errorf("wrong error: %v", err)
+	}
+	if len(bodies) != 2 {
+		t.Errorf("len(bodies) = %d", len(bodies))
+	}
+}
@@ -11,6 +11,7 @@ import (
 
 	"zgo.at/goatcounter/v2"
 	"zgo.at/z18n"
+	"zgo.at/zstd/ztime"
 )
 
 type Campaigns struct {
@@ -46,11 +47,13 @@ func (w *Campaigns) SetSettings(s goatcounter.WidgetSettings) {
 }
 
 func (w *Campaigns) GetData(ctx context.Context, a Args) (more bool, err error) {
+	list := goatcounter.HitStatsList((*goatcounter.HitStats).ListCampaigns)
 	if w.Campaign > 0 {
-		err = w.Stats.ListCampaign(ctx, w.Campaign, a.Rng, a.PathFilter, w.Limit, a.Offset)
-	} else {
-		err = w.Stats.ListCampaigns(ctx, a.Rng, a.PathFilter, w.Limit, a.Offset)
+		list = func(h *goatcounter.HitStats, ctx context.Context, rng ztime.Range, pathFilter []int64, limit, offset int) error {
+			return h.ListCampaign(ctx, w.Campaign, rng, pathFilter, limit, offset)
+		}
 	}
+	err = listStats(ctx, a, &w.Stats, w.Limit, list)
 	w.loaded = true
 	return w.Stats.More, err
 }
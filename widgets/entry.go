@@ -0,0 +1,68 @@
+// Copyright © Martin Tournoij – This file is part of GoatCounter and published
+// under the terms of a slightly modified EUPL v1.2 license, which can be found
+// in the LICENSE file or at https://license.goatcounter.com
+
+package widgets
+
+import (
+	"context"
+	"html/template"
+
+	"zgo.at/goatcounter/v2"
+	"zgo.at/z18n"
+)
+
+type Entry struct {
+	id     int
+	loaded bool
+	err    error
+	html   template.HTML
+	s      goatcounter.WidgetSettings
+
+	Limit    int
+	Stats    goatcounter.HitStats
+	Sessions int
+}
+
+func (w Entry) Name() string                         { return "entry" }
+func (w Entry) Type() string                         { return "hchart" }
+func (w Entry) Label(ctx context.Context) string     { return z18n.T(ctx, "label/entry-pages|Entry pages") }
+func (w *Entry) SetHTML(h template.HTML)             { w.html = h }
+func (w Entry) HTML() template.HTML                  { return w.html }
+func (w *Entry) SetErr(h error)                      { w.err = h }
+func (w Entry) Err() error                           { return w.err }
+func (w Entry) ID() int                              { return w.id }
+func (w Entry) Settings() goatcounter.WidgetSettings { return w.s }
+
+func (w *Entry) SetSettings(s goatcounter.WidgetSettings) {
+	w.s = s
+	if x := s["limit"].Value; x != nil {
+		w.Limit = int(x.(float64))
+	}
+}
+
+func (w *Entry) GetData(ctx context.Context, a Args) (more bool, err error) {
+	err = listStats(ctx, a, &w.Stats, w.Limit, (*goatcounter.HitStats).ListEntries)
+	if err == nil {
+		w.Sessions, err = goatcounter.SessionCount(ctx, a.Rng)
+	}
+	w.loaded = true
+	return w.Stats.More, err
+}
+
+func (w Entry) RenderHTML(ctx context.Context, shared SharedData) (string, any) {
+	return "_dashboard_hchart.gohtml", struct {
+		Context     context.Context
+		ID          int
+		RowsOnly    bool
+		HasSubMenu  bool
+		Loaded      bool
+		Err         error
+		IsCollected bool
+		Header      string
+		TotalUTC    int
+
+		Stats goatcounter.HitStats
+	}{ctx, w.id, shared.RowsOnly, false, w.loaded, w.err, isCol(ctx, goatcounter.CollectSession), w.Label(ctx),
+		w.Sessions, w.Stats}
+}
@@ -0,0 +1,63 @@
+// Copyright © Martin Tournoij – This file is part of GoatCounter and published
+// under the terms of a slightly modified EUPL v1.2 license, which can be found
+// in the LICENSE file or at https://license.goatcounter.com
+
+package widgets
+
+import (
+	"context"
+	"html/template"
+
+	"zgo.at/goatcounter/v2"
+	"zgo.at/z18n"
+)
+
+// Errors shows the pages that had 4xx or 5xx responses; this is only available
+// for pageviews imported from logfiles.
+type Errors struct {
+	id     int
+	loaded bool
+	err    error
+	html   template.HTML
+	s      goatcounter.WidgetSettings
+
+	Limit int
+	Stats goatcounter.ErrorPages
+}
+
+func (w Errors) Name() string { return "errors" }
+func (w Errors) Type() string { return "hchart" }
+func (w Errors) Label(ctx context.Context) string {
+	return z18n.T(ctx, "label/error-pages|Error pages")
+}
+func (w *Errors) SetHTML(h template.HTML)             { w.html = h }
+func (w Errors) HTML() template.HTML                  { return w.html }
+func (w *Errors) SetErr(h error)                      { w.err = h }
+func (w Errors) Err() error                           { return w.err }
+func (w Errors) ID() int                              { return w.id }
+func (w Errors) Settings() goatcounter.WidgetSettings { return w.s }
+
+func (w *Errors) SetSettings(s goatcounter.WidgetSettings) {
+	w.s = s
+	if x := s["limit"].Value; x != nil {
+		w.Limit = int(x.(float64))
+	}
+}
+
+func (w *Errors) GetData(ctx context.Context, a Args) (more bool, err error) {
+	err = w.Stats.List(ctx, a.Rng, a.PathFilter, w.Limit, 0)
+	w.loaded = true
+	return false, err
+}
+
+func (w Errors) RenderHTML(ctx context.Context, shared SharedData) (string, any) {
+	return "_dashboard_errors.gohtml", struct {
+		Context context.Context
+		ID      int
+		Loaded  bool
+		Err     error
+		Header  string
+
+		Stats goatcounter.ErrorPages
+	}{ctx, w.id, w.loaded, w.err, w.Label(ctx), w.Stats}
+}
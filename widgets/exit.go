@@ -0,0 +1,68 @@
+// Copyright © Martin Tournoij – This file is part of GoatCounter and published
+// under the terms of a slightly modified EUPL v1.2 license, which can be found
+// in the LICENSE file or at https://license.goatcounter.com
+
+package widgets
+
+import (
+	"context"
+	"html/template"
+
+	"zgo.at/goatcounter/v2"
+	"zgo.at/z18n"
+)
+
+type Exit struct {
+	id     int
+	loaded bool
+	err    error
+	html   template.HTML
+	s      goatcounter.WidgetSettings
+
+	Limit    int
+	Stats    goatcounter.HitStats
+	Sessions int
+}
+
+func (w Exit) Name() string                         { return "exit" }
+func (w Exit) Type() string                         { return "hchart" }
+func (w Exit) Label(ctx context.Context) string     { return z18n.T(ctx, "label/exit-pages|Exit pages") }
+func (w *Exit) SetHTML(h template.HTML)             { w.html = h }
+func (w Exit) HTML() template.HTML                  { return w.html }
+func (w *Exit) SetErr(h error)                      { w.err = h }
+func (w Exit) Err() error                           { return w.err }
+func (w Exit) ID() int                              { return w.id }
+func (w Exit) Settings() goatcounter.WidgetSettings { return w.s }
+
+func (w *Exit) SetSettings(s goatcounter.WidgetSettings) {
+	w.s = s
+	if x := s["limit"].Value; x != nil {
+		w.Limit = int(x.(float64))
+	}
+}
+
+func (w *Exit) GetData(ctx context.Context, a Args) (more bool, err error) {
+	err = listStats(ctx, a, &w.Stats, w.Limit, (*goatcounter.HitStats).ListExits)
+	if err == nil {
+		w.Sessions, err = goatcounter.SessionCount(ctx, a.Rng)
+	}
+	w.loaded = true
+	return w.Stats.More, err
+}
+
+func (w Exit) RenderHTML(ctx context.Context, shared SharedData) (string, any) {
+	return "_dashboard_hchart.gohtml", struct {
+		Context     context.Context
+		ID          int
+		RowsOnly    bool
+		HasSubMenu  bool
+		Loaded      bool
+		Err         error
+		IsCollected bool
+		Header      string
+		TotalUTC    int
+
+		Stats goatcounter.HitStats
+	}{ctx, w.id, shared.RowsOnly, false, w.loaded, w.err, isCol(ctx, goatcounter.CollectSession), w.Label(ctx),
+		w.Sessions, w.Stats}
+}
@@ -0,0 +1,97 @@
+// Copyright © Martin Tournoij – This file is part of GoatCounter and published
+// under the terms of a slightly modified EUPL v1.2 license, which can be found
+// in the LICENSE file or at https://license.goatcounter.com
+
+package widgets
+
+import (
+	"context"
+	"html/template"
+	"strconv"
+
+	"zgo.at/goatcounter/v2"
+	"zgo.at/z18n"
+)
+
+type Funnels struct {
+	id     int
+	loaded bool
+	err    error
+	html   template.HTML
+	s      goatcounter.WidgetSettings
+
+	Funnel int64
+	Stats  goatcounter.FunnelStats
+}
+
+func (w Funnels) Name() string                         { return "funnels" }
+func (w Funnels) Type() string                         { return "hchart" }
+func (w Funnels) Label(ctx context.Context) string     { return z18n.T(ctx, "label/funnel|Funnel") }
+func (w *Funnels) SetHTML(h template.HTML)             { w.html = h }
+func (w Funnels) HTML() template.HTML                  { return w.html }
+func (w *Funnels) SetErr(h error)                      { w.err = h }
+func (w Funnels) Err() error                           { return w.err }
+func (w Funnels) ID() int                              { return w.id }
+func (w Funnels) Settings() goatcounter.WidgetSettings { return w.s }
+
+func (w *Funnels) SetSettings(s goatcounter.WidgetSettings) {
+	w.s = s
+	if x := s["funnel"].Value; x != nil {
+		w.Funnel, _ = strconv.ParseInt(x.(string), 10, 64)
+	}
+}
+
+func (w *Funnels) GetData(ctx context.Context, a Args) (more bool, err error) {
+	defer func() { w.loaded = true }()
+
+	var funnel goatcounter.Funnel
+	if w.Funnel > 0 {
+		err = funnel.ByID(ctx, w.Funnel)
+	} else {
+		var funnels goatcounter.Funnels
+		err = funnels.List(ctx)
+		if len(funnels) > 0 {
+			funnel = funnels[0]
+		}
+	}
+	if err != nil {
+		return false, err
+	}
+
+	err = w.Stats.Get(ctx, funnel, a.Rng)
+	if err != nil || a.Compare.IsZero() {
+		return false, err
+	}
+
+	var prev goatcounter.FunnelStats
+	err = prev.Get(ctx, funnel, a.Compare)
+	w.Stats.Compare(prev)
+	return false, err
+}
+
+func (w Funnels) RenderHTML(ctx context.Context, shared SharedData) (string, any) {
+	// The percentage in the chart is relative to the number of sessions that
+	// reached the first step.
+	var total int
+	if len(w.Stats.Steps) > 0 {
+		total = w.Stats.Steps[0].Sessions
+	}
+	header := w.Label(ctx)
+	if w.Stats.Name != "" {
+		header += ": " + w.Stats.Name
+	}
+	return "_dashboard_hchart.gohtml", struct {
+		Context     context.Context
+		ID          int
+		RowsOnly    bool
+		HasSubMenu  bool
+		Loaded      bool
+		Err         error
+		IsCollected bool
+		Header      string
+		TotalUTC    int
+
+		Stats goatcounter.HitStats
+	}{ctx, w.id, shared.RowsOnly, false, w.loaded, w.err, isCol(ctx, goatcounter.CollectSession), header,
+		total, w.Stats.HitStats()}
+}
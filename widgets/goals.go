@@ -0,0 +1,68 @@
+// Copyright © Martin Tournoij – This file is part of GoatCounter and published
+// under the terms of a slightly modified EUPL v1.2 license, which can be found
+// in the LICENSE file or at https://license.goatcounter.com
+
+package widgets
+
+import (
+	"context"
+	"html/template"
+
+	"zgo.at/goatcounter/v2"
+	"zgo.at/z18n"
+)
+
+type Goals struct {
+	id     int
+	loaded bool
+	err    error
+	html   template.HTML
+	s      goatcounter.WidgetSettings
+
+	Stats goatcounter.GoalStats
+}
+
+func (w Goals) Name() string                         { return "goals" }
+func (w Goals) Type() string                         { return "hchart" }
+func (w Goals) Label(ctx context.Context) string     { return z18n.T(ctx, "label/goals|Goals") }
+func (w *Goals) SetHTML(h template.HTML)             { w.html = h }
+func (w Goals) HTML() template.HTML                  { return w.html }
+func (w *Goals) SetErr(h error)                      { w.err = h }
+func (w Goals) Err() error                           { return w.err }
+func (w Goals) ID() int                              { return w.id }
+func (w Goals) Settings() goatcounter.WidgetSettings { return w.s }
+
+func (w *Goals) SetSettings(s goatcounter.WidgetSettings) { w.s = s }
+
+func (w *Goals) GetData(ctx context.Context, a Args) (more bool, err error) {
+	defer func() { w.loaded = true }()
+
+	err = w.Stats.List(ctx, a.Rng, a.PathFilter)
+	if err != nil || a.Compare.IsZero() {
+		return false, err
+	}
+
+	var prev goatcounter.GoalStats
+	err = prev.List(ctx, a.Compare, a.PathFilter)
+	w.Stats.Compare(prev)
+	return false, err
+}
+
+func (w Goals) RenderHTML(ctx context.Context, shared SharedData) (string, any) {
+	// The percentage in the chart is relative to the total number of unique
+	// visitors, which is the conversion rate.
+	return "_dashboard_hchart.gohtml", struct {
+		Context     context.Context
+		ID          int
+		RowsOnly    bool
+		HasSubMenu  bool
+		Loaded      bool
+		Err         error
+		IsCollected bool
+		Header      string
+		TotalUTC    int
+
+		Stats goatcounter.HitStats
+	}{ctx, w.id, shared.RowsOnly, false, w.loaded, w.err, isCol(ctx, goatcounter.CollectSession), w.Label(ctx),
+		w.Stats.Visitors, w.Stats.HitStats()}
+}
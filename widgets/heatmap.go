@@ -0,0 +1,63 @@
+// Copyright © Martin Tournoij – This file is part of GoatCounter and published
+// under the terms of a slightly modified EUPL v1.2 license, which can be found
+// in the LICENSE file or at https://license.goatcounter.com
+
+package widgets
+
+import (
+	"context"
+	"html/template"
+	"time"
+
+	"zgo.at/goatcounter/v2"
+	"zgo.at/z18n"
+)
+
+type Heatmap struct {
+	id     int
+	loaded bool
+	err    error
+	html   template.HTML
+	s      goatcounter.WidgetSettings
+
+	Heatmap goatcounter.Heatmap
+}
+
+func (w Heatmap) Name() string { return "heatmap" }
+func (w Heatmap) Type() string { return "full-width" }
+func (w Heatmap) Label(ctx context.Context) string {
+	return z18n.T(ctx, "label/heatmap|Visitors by weekday and hour")
+}
+func (w *Heatmap) SetHTML(h template.HTML)             { w.html = h }
+func (w Heatmap) HTML() template.HTML                  { return w.html }
+func (w *Heatmap) SetErr(h error)                      { w.err = h }
+func (w Heatmap) Err() error                           { return w.err }
+func (w Heatmap) ID() int                              { return w.id }
+func (w Heatmap) Settings() goatcounter.WidgetSettings { return w.s }
+
+func (w *Heatmap) SetSettings(s goatcounter.WidgetSettings) { w.s = s }
+
+func (w *Heatmap) GetData(ctx context.Context, a Args) (more bool, err error) {
+	err = w.Heatmap.Get(ctx, a.Rng, a.PathFilter)
+	w.loaded = true
+	return false, err
+}
+
+func (w Heatmap) RenderHTML(ctx context.Context, shared SharedData) (string, any) {
+	// Translated short weekday names; 2023-01-01 is a Sunday.
+	days := make([]string, len(w.Heatmap.Days))
+	for i, d := range w.Heatmap.Days {
+		days[i] = z18n.Get(ctx).WeekdayName(time.Date(2023, 1, 1+int(d.Weekday), 0, 0, 0, 0, time.UTC), z18n.TimeFormatShort)
+	}
+
+	return "_dashboard_heatmap.gohtml", struct {
+		Context context.Context
+		ID      int
+		Loaded  bool
+		Err     error
+		Header  string
+
+		Heatmap  goatcounter.Heatmap
+		DayNames []string
+	}{ctx, w.id, w.loaded, w.err, w.Label(ctx), w.Heatmap, days}
+}
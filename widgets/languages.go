@@ -43,7 +43,7 @@ func (w *Languages) SetSettings(s goatcounter.WidgetSettings) {
 }
 
 func (w *Languages) GetData(ctx context.Context, a Args) (more bool, err error) {
-	err = w.Stats.ListLanguages(ctx, a.Rng, a.PathFilter, w.Limit, a.Offset)
+	err = listStats(ctx, a, &w.Stats, w.Limit, (*goatcounter.HitStats).ListLanguages)
 	w.loaded = true
 	return w.Stats.More, err
 }
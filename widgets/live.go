@@ -0,0 +1,61 @@
+// Copyright © Martin Tournoij – This file is part of GoatCounter and published
+// under the terms of a slightly modified EUPL v1.2 license, which can be found
+// in the LICENSE file or at https://license.goatcounter.com
+
+package widgets
+
+import (
+	"context"
+	"html/template"
+	"time"
+
+	"zgo.at/goatcounter/v2"
+	"zgo.at/z18n"
+)
+
+// Live shows the visitors currently on the site; this ignores the selected
+// date range and is updated over the websocket as new pageviews come in.
+type Live struct {
+	id     int
+	loaded bool
+	err    error
+	html   template.HTML
+	s      goatcounter.WidgetSettings
+
+	Live goatcounter.Live
+}
+
+func (w Live) Name() string                         { return "live" }
+func (w Live) Type() string                         { return "hchart" }
+func (w Live) Label(ctx context.Context) string     { return z18n.T(ctx, "label/live|Right now") }
+func (w *Live) SetHTML(h template.HTML)             { w.html = h }
+func (w Live) HTML() template.HTML                  { return w.html }
+func (w *Live) SetErr(h error)                      { w.err = h }
+func (w Live) Err() error                           { return w.err }
+func (w Live) ID() int                              { return w.id }
+func (w Live) Settings() goatcounter.WidgetSettings { return w.s }
+
+func (w *Live) SetSettings(s goatcounter.WidgetSettings) { w.s = s }
+
+func (w *Live) GetData(ctx context.Context, a Args) (more bool, err error) {
+	w.Live = goatcounter.Memstore.Live(goatcounter.MustGetSite(ctx).ID)
+	w.loaded = true
+	return false, nil
+}
+
+func (w Live) RenderHTML(ctx context.Context, shared SharedData) (string, any) {
+	return "_dashboard_live.gohtml", struct {
+		Context     context.Context
+		ID          int
+		RowsOnly    bool
+		Loaded      bool
+		Err         error
+		IsCollected bool
+		Header      string
+		Loc         *time.Location
+
+		Live  goatcounter.Live
+		Stats goatcounter.HitStats
+	}{ctx, w.id, shared.RowsOnly, w.loaded, w.err, isCol(ctx, goatcounter.CollectSession), w.Label(ctx),
+		goatcounter.MustGetUser(ctx).Settings.Timezone.Loc(), w.Live, w.Live.HitStats()}
+}
@@ -34,6 +34,7 @@ type Pages struct {
 	Max              int
 	Exclude          []int64
 	Diff             []float64
+	Annotations      goatcounter.Annotations
 }
 
 func (w Pages) Name() string                         { return "pages" }
@@ -70,7 +71,7 @@ func (w *Pages) GetData(ctx context.Context, a Args) (bool, error) {
 
 	var (
 		wg   sync.WaitGroup
-		errs = errors.NewGroup(2)
+		errs = errors.NewGroup(3)
 	)
 	if a.ShowRefs > 0 {
 		wg.Add(1)
@@ -86,10 +87,12 @@ func (w *Pages) GetData(ctx context.Context, a Args) (bool, error) {
 	errs.Append(err)
 
 	if !goatcounter.MustGetUser(ctx).Settings.FewerNumbers {
-		w.Diff, err = w.Pages.Diff(ctx, a.Rng, a.Rng)
+		w.Diff, err = w.Pages.Diff(ctx, a.Rng, a.Compare)
 		errs.Append(err)
 	}
 
+	errs.Append(w.Annotations.ListRange(ctx, a.Rng))
+
 	wg.Wait()
 
 	for _, p := range w.Pages {
@@ -181,6 +184,8 @@ func (w Pages) RenderHTML(ctx context.Context, shared SharedData) (string, any)
 		Refs     goatcounter.HitStats
 		ShowRefs int64
 		Diff     []float64
+
+		Annotations goatcounter.Annotations
 	}{
 		ctx, shared.Site, shared.User,
 		w.id, w.loaded, w.err, w.Pages, shared.Args.Rng, shared.Args.Daily,
@@ -188,5 +193,6 @@ func (w Pages) RenderHTML(ctx context.Context, shared SharedData) (string, any)
 		w.Display, shared.Total, shared.TotalEvents, w.More,
 		w.Style, w.Refs, shared.Args.ShowRefs,
 		w.Diff,
+		w.Annotations,
 	}
 }
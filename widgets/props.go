@@ -0,0 +1,76 @@
+// Copyright © Martin Tournoij – This file is part of GoatCounter and published
+// under the terms of a slightly modified EUPL v1.2 license, which can be found
+// in the LICENSE file or at https://license.goatcounter.com
+
+package widgets
+
+import (
+	"context"
+	"html/template"
+
+	"zgo.at/goatcounter/v2"
+	"zgo.at/z18n"
+	"zgo.at/zstd/ztime"
+)
+
+type Props struct {
+	id     int
+	loaded bool
+	err    error
+	html   template.HTML
+	s      goatcounter.WidgetSettings
+
+	Limit int
+	Prop  string
+	Stats goatcounter.HitStats
+}
+
+func (w Props) Name() string                         { return "props" }
+func (w Props) Type() string                         { return "hchart" }
+func (w Props) Label(ctx context.Context) string     { return z18n.T(ctx, "label/props|Properties") }
+func (w *Props) SetHTML(h template.HTML)             { w.html = h }
+func (w Props) HTML() template.HTML                  { return w.html }
+func (w *Props) SetErr(h error)                      { w.err = h }
+func (w Props) Err() error                           { return w.err }
+func (w Props) ID() int                              { return w.id }
+func (w Props) Settings() goatcounter.WidgetSettings { return w.s }
+
+func (w *Props) SetSettings(s goatcounter.WidgetSettings) {
+	w.s = s
+	if x := s["limit"].Value; x != nil {
+		w.Limit = int(x.(float64))
+	}
+	if x := s["key"].Value; x != nil {
+		w.Prop = x.(string)
+	}
+}
+
+func (w *Props) GetData(ctx context.Context, a Args) (more bool, err error) {
+	list := goatcounter.HitStatsList((*goatcounter.HitStats).ListProps)
+	if w.Prop != "" {
+		list = func(h *goatcounter.HitStats, ctx context.Context, rng ztime.Range, pathFilter []int64, limit, offset int) error {
+			return h.ListProp(ctx, w.Prop, rng, pathFilter, limit, offset)
+		}
+	}
+	err = listStats(ctx, a, &w.Stats, w.Limit, list)
+	w.loaded = true
+	return w.Stats.More, err
+}
+
+func (w Props) RenderHTML(ctx context.Context, shared SharedData) (string, any) {
+	return "_dashboard_hchart.gohtml", struct {
+		Context     context.Context
+		ID          int
+		RowsOnly    bool
+		HasSubMenu  bool
+		Loaded      bool
+		Err         error
+		IsCollected bool
+		Header      string
+		TotalUTC    int
+
+		Stats goatcounter.HitStats
+		Prop  string
+	}{ctx, w.id, shared.RowsOnly, w.Prop == "", w.loaded, w.err, true, w.Label(ctx),
+		shared.TotalUTC, w.Stats, w.Prop}
+}
@@ -10,6 +10,7 @@ import (
 
 	"zgo.at/goatcounter/v2"
 	"zgo.at/z18n"
+	"zgo.at/zstd/ztime"
 )
 
 type Sizes struct {
@@ -42,11 +43,15 @@ func (w *Sizes) SetSettings(s goatcounter.WidgetSettings) {
 }
 
 func (w *Sizes) GetData(ctx context.Context, a Args) (more bool, err error) {
+	list := goatcounter.HitStatsList(func(h *goatcounter.HitStats, ctx context.Context, rng ztime.Range, pathFilter []int64, _, _ int) error {
+		return h.ListSizes(ctx, rng, pathFilter)
+	})
 	if w.Detail != "" {
-		err = w.Stats.ListSize(ctx, w.Detail, a.Rng, a.PathFilter, 6, a.Offset)
-	} else {
-		err = w.Stats.ListSizes(ctx, a.Rng, a.PathFilter)
+		list = func(h *goatcounter.HitStats, ctx context.Context, rng ztime.Range, pathFilter []int64, limit, offset int) error {
+			return h.ListSize(ctx, w.Detail, rng, pathFilter, limit, offset)
+		}
 	}
+	err = listStats(ctx, a, &w.Stats, 6, list)
 	w.loaded = true
 	return w.Stats.More, err
 }
@@ -10,6 +10,7 @@ import (
 
 	"zgo.at/goatcounter/v2"
 	"zgo.at/z18n"
+	"zgo.at/zstd/ztime"
 )
 
 type Systems struct {
@@ -47,11 +48,13 @@ func (w *Systems) SetSettings(s goatcounter.WidgetSettings) {
 }
 
 func (w *Systems) GetData(ctx context.Context, a Args) (more bool, err error) {
+	list := goatcounter.HitStatsList((*goatcounter.HitStats).ListSystems)
 	if w.Detail != "" {
-		err = w.Stats.ListSystem(ctx, w.Detail, a.Rng, a.PathFilter, w.Limit, a.Offset)
-	} else {
-		err = w.Stats.ListSystems(ctx, a.Rng, a.PathFilter, w.Limit, a.Offset)
+		list = func(h *goatcounter.HitStats, ctx context.Context, rng ztime.Range, pathFilter []int64, limit, offset int) error {
+			return h.ListSystem(ctx, w.Detail, rng, pathFilter, limit, offset)
+		}
 	}
+	err = listStats(ctx, a, &w.Stats, w.Limit, list)
 	w.loaded = true
 	return w.Stats.More, err
 }
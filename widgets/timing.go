@@ -0,0 +1,61 @@
+// Copyright © Martin Tournoij – This file is part of GoatCounter and published
+// under the terms of a slightly modified EUPL v1.2 license, which can be found
+// in the LICENSE file or at https://license.goatcounter.com
+
+package widgets
+
+import (
+	"context"
+	"html/template"
+
+	"zgo.at/goatcounter/v2"
+	"zgo.at/z18n"
+)
+
+// Timing shows the slowest pages by server response time; this is only
+// available for pageviews imported from logfiles.
+type Timing struct {
+	id     int
+	loaded bool
+	err    error
+	html   template.HTML
+	s      goatcounter.WidgetSettings
+
+	Limit int
+	Stats goatcounter.ResponseTimes
+}
+
+func (w Timing) Name() string                         { return "timing" }
+func (w Timing) Type() string                         { return "hchart" }
+func (w Timing) Label(ctx context.Context) string     { return z18n.T(ctx, "label/timing|Response times") }
+func (w *Timing) SetHTML(h template.HTML)             { w.html = h }
+func (w Timing) HTML() template.HTML                  { return w.html }
+func (w *Timing) SetErr(h error)                      { w.err = h }
+func (w Timing) Err() error                           { return w.err }
+func (w Timing) ID() int                              { return w.id }
+func (w Timing) Settings() goatcounter.WidgetSettings { return w.s }
+
+func (w *Timing) SetSettings(s goatcounter.WidgetSettings) {
+	w.s = s
+	if x := s["limit"].Value; x != nil {
+		w.Limit = int(x.(float64))
+	}
+}
+
+func (w *Timing) GetData(ctx context.Context, a Args) (more bool, err error) {
+	err = w.Stats.List(ctx, a.Rng, a.PathFilter, w.Limit, 0)
+	w.loaded = true
+	return false, err
+}
+
+func (w Timing) RenderHTML(ctx context.Context, shared SharedData) (string, any) {
+	return "_dashboard_timing.gohtml", struct {
+		Context context.Context
+		ID      int
+		Loaded  bool
+		Err     error
+		Header  string
+
+		Stats goatcounter.ResponseTimes
+	}{ctx, w.id, w.loaded, w.err, w.Label(ctx), w.Stats}
+}
@@ -10,6 +10,7 @@ import (
 
 	"zgo.at/goatcounter/v2"
 	"zgo.at/z18n"
+	"zgo.at/zstd/ztime"
 )
 
 type TopRefs struct {
@@ -45,11 +46,13 @@ func (w *TopRefs) SetSettings(s goatcounter.WidgetSettings) {
 }
 
 func (w *TopRefs) GetData(ctx context.Context, a Args) (more bool, err error) {
+	list := goatcounter.HitStatsList((*goatcounter.HitStats).ListTopRefs)
 	if w.Ref != "" {
-		err = w.TopRefs.ListTopRef(ctx, w.Ref, a.Rng, a.PathFilter, w.Limit, a.Offset)
-	} else {
-		err = w.TopRefs.ListTopRefs(ctx, a.Rng, a.PathFilter, w.Limit, a.Offset)
+		list = func(h *goatcounter.HitStats, ctx context.Context, rng ztime.Range, pathFilter []int64, limit, offset int) error {
+			return h.ListTopRef(ctx, w.Ref, rng, pathFilter, limit, offset)
+		}
 	}
+	err = listStats(ctx, a, &w.TopRefs, w.Limit, list)
 	w.loaded = true
 	return w.TopRefs.More, err
 }
@@ -24,6 +24,7 @@ type TotalPages struct {
 	Style           string
 	Max             int
 	Total           goatcounter.HitList
+	Annotations     goatcounter.Annotations
 }
 
 func (w TotalPages) Name() string { return "totalpages" }
@@ -53,6 +54,9 @@ func (w *TotalPages) SetSettings(s goatcounter.WidgetSettings) {
 
 func (w *TotalPages) GetData(ctx context.Context, a Args) (more bool, err error) {
 	w.Max, err = w.Total.Totals(ctx, a.Rng, a.PathFilter, a.Daily, w.NoEvents)
+	if err == nil {
+		err = w.Annotations.ListRange(ctx, a.Rng)
+	}
 	w.loaded = true
 	return false, err
 }
@@ -92,10 +96,11 @@ func (w TotalPages) RenderHTML(ctx context.Context, shared SharedData) (string,
 		Total       int
 		TotalEvents int
 
-		Style string
+		Style       string
+		Annotations goatcounter.Annotations
 	}{ctx, shared.Site, shared.User, w.id, w.loaded, w.err,
 		w.Align, w.NoEvents,
 		w.Total, shared.Args.Daily, w.Max,
 		shared.Total, shared.TotalEvents,
-		w.Style}
+		w.Style, w.Annotations.ForPath("")}
 }
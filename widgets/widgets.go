@@ -34,6 +34,7 @@ type (
 
 	Args struct {
 		Rng         ztime.Range
+		Compare     ztime.Range // Range to compare with; zero if not comparing.
 		Offset      int
 		PathFilter  []int64
 		Daily       bool
@@ -139,6 +140,15 @@ func ListAllWidgets() List {
 		NewWidget("systems", 0),
 		NewWidget("toprefs", 0),
 		NewWidget("campaigns", 0),
+		NewWidget("props", 0),
+		NewWidget("goals", 0),
+		NewWidget("funnels", 0),
+		NewWidget("entry", 0),
+		NewWidget("exit", 0),
+		NewWidget("live", 0),
+		NewWidget("heatmap", 0),
+		NewWidget("timing", 0),
+		NewWidget("errors", 0),
 		NewWidget("totalpages", 0),
 	}
 }
@@ -156,6 +166,24 @@ func NewWidget(name string, id int) Widget {
 		return &TopRefs{id: id}
 	case "campaigns":
 		return &Campaigns{id: id}
+	case "props":
+		return &Props{id: id}
+	case "goals":
+		return &Goals{id: id}
+	case "funnels":
+		return &Funnels{id: id}
+	case "entry":
+		return &Entry{id: id}
+	case "exit":
+		return &Exit{id: id}
+	case "live":
+		return &Live{id: id}
+	case "heatmap":
+		return &Heatmap{id: id}
+	case "timing":
+		return &Timing{id: id}
+	case "errors":
+		return &Errors{id: id}
 	case "browsers":
 		return &Browsers{id: id}
 	case "systems":
@@ -174,3 +202,13 @@ func NewWidget(name string, id int) Widget {
 func isCol(ctx context.Context, flag zint.Bitflag16) bool {
 	return goatcounter.MustGetSite(ctx).Settings.Collect.Has(flag)
 }
+
+// listStats gets the stats for a.Rng with list, and sets the counts for the
+// period to compare with if a.Compare is set.
+func listStats(ctx context.Context, a Args, stats *goatcounter.HitStats, limit int, list goatcounter.HitStatsList) error {
+	err := list(stats, ctx, a.Rng, a.PathFilter, limit, a.Offset)
+	if err != nil || a.Compare.IsZero() || len(stats.Stats) == 0 {
+		return err
+	}
+	return stats.Compare(ctx, list, a.Compare, a.PathFilter)
+}